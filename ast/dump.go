@@ -0,0 +1,201 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dump writes the tree rooted at node to w, one node per line, with each
+// node's children indented below it. It's meant for debugging, so the output
+// shows the structure of the tree rather than the script it was parsed from.
+func Dump(w io.Writer, node Node) error {
+	d := &dumper{w: w}
+	d.dump(node, 0)
+	return d.err
+}
+
+type dumper struct {
+	w   io.Writer
+	err error
+}
+
+// line writes one line of the dump, indented for the given depth.
+func (d *dumper) line(depth int, format string, a ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	indent := strings.Repeat("  ", depth)
+	_, d.err = fmt.Fprintf(d.w, indent+format+"\n", a...)
+}
+
+func (d *dumper) dump(node Node, depth int) {
+	if node == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *StmtList:
+		d.line(depth, "StmtList")
+		for _, s := range n.Stmts {
+			d.dump(s, depth+1)
+		}
+	case *Background:
+		d.line(depth, "Background")
+		d.dump(n.Stmt, depth+1)
+	case *Coproc:
+		d.line(depth, "Coproc %s", n.Name)
+		d.dump(n.Stmt, depth+1)
+	case *AndOr:
+		d.line(depth, "AndOr %q", n.Op)
+		d.dump(n.Left, depth+1)
+		d.dump(n.Right, depth+1)
+	case *Not:
+		d.line(depth, "Not")
+		d.dump(n.Stmt, depth+1)
+	case *Time:
+		d.line(depth, "Time")
+		d.dump(n.Stmt, depth+1)
+	case *Pipeline:
+		d.line(depth, "Pipeline")
+		for _, s := range n.Stmts {
+			d.dump(s, depth+1)
+		}
+	case *Cmd:
+		d.line(depth, "Cmd")
+		for _, a := range n.Assignments {
+			d.dump(a, depth+1)
+		}
+		d.dumpExprs(n.Argv, depth+1)
+		d.dumpRedirects(n.Redirects, depth+1)
+	case *Subshell:
+		d.line(depth, "Subshell")
+		d.dump(n.Body, depth+1)
+		d.dumpRedirects(n.Redirects, depth+1)
+	case *Group:
+		d.line(depth, "Group")
+		d.dump(n.Body, depth+1)
+		d.dumpRedirects(n.Redirects, depth+1)
+	case *Case:
+		d.line(depth, "Case")
+		d.dump(n.Word, depth+1)
+		for _, c := range n.Clauses {
+			d.dump(c, depth+1)
+		}
+		d.dumpRedirects(n.Redirects, depth+1)
+	case *Select:
+		d.line(depth, "Select %s", n.Name)
+		if n.Words != nil {
+			d.line(depth+1, "In")
+			d.dumpExprs(n.Words, depth+2)
+		}
+		d.dump(n.Body, depth+1)
+		d.dumpRedirects(n.Redirects, depth+1)
+	case *ArithFor:
+		d.line(depth, "ArithFor")
+		d.dumpExprs([]Expr{n.Init, n.Cond, n.Update}, depth+1)
+		d.dump(n.Body, depth+1)
+		d.dumpRedirects(n.Redirects, depth+1)
+	case *CaseClause:
+		d.line(depth, "Clause %q", n.Terminator)
+		d.dumpExprs(n.Patterns, depth+1)
+		d.dump(n.Body, depth+1)
+	case *CondExpr:
+		d.line(depth, "CondExpr %q", n.Op)
+		if n.X != nil {
+			d.dump(n.X, depth+1)
+		}
+		if n.Y != nil {
+			d.dump(n.Y, depth+1)
+		}
+		d.dumpExprs(n.Args, depth+1)
+	case *Assign:
+		d.line(depth, "Assign %s", n.Name)
+		if n.Index != nil {
+			d.line(depth+1, "Index")
+			d.dump(n.Index, depth+2)
+		}
+		d.dump(n.Value, depth+1)
+		if n.Array != nil {
+			d.dump(n.Array, depth+1)
+		}
+	case *Func:
+		d.line(depth, "Func %s", n.Name)
+		d.dump(n.Body, depth+1)
+	case *Array:
+		d.line(depth, "Array")
+		d.dumpExprs(n.Elems, depth+1)
+	case *Assignment:
+		d.line(depth, "Assignment %s", n.Name)
+		d.dump(n.Value, depth+1)
+	case *Redirect:
+		d.line(depth, "Redirect %d %q", n.Fd, n.Op)
+		d.dump(n.Target, depth+1)
+	case Word:
+		d.line(depth, "Word")
+		d.dumpExprs(n.SubExprs, depth+1)
+	case *Word:
+		d.dump(*n, depth)
+	case Var:
+		d.line(depth, "Var %s", n.Identifier)
+		if n.Index != nil {
+			d.line(depth+1, "Index")
+			d.dump(n.Index, depth+2)
+		}
+		if n.Op != "" {
+			d.line(depth+1, "Op %q", n.Op)
+			d.dumpExprs(n.Args, depth+2)
+		}
+	case *Var:
+		d.dump(*n, depth)
+	case String:
+		d.line(depth, "String %q", n.Text)
+	case *String:
+		d.dump(*n, depth)
+	case Glob:
+		d.line(depth, "Glob %q", n.Text)
+	case *Glob:
+		d.dump(*n, depth)
+	case Tilde:
+		d.line(depth, "Tilde %q", n.Text)
+	case *Tilde:
+		d.dump(*n, depth)
+	case FileContents:
+		d.line(depth, "FileContents")
+		d.dump(n.Path, depth+1)
+	case *FileContents:
+		d.dump(*n, depth)
+	case CmdSubst:
+		d.line(depth, "CmdSubst")
+		d.dump(n.Body, depth+1)
+	case *CmdSubst:
+		d.dump(*n, depth)
+	default:
+		panic(fmt.Sprintf("ast: unexpected node type %T", node))
+	}
+}
+
+func (d *dumper) dumpRedirects(redirects []*Redirect, depth int) {
+	for _, r := range redirects {
+		d.dump(r, depth)
+	}
+}
+
+func (d *dumper) dumpExprs(exprs []Expr, depth int) {
+	for _, e := range exprs {
+		d.dump(e, depth)
+	}
+}
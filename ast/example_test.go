@@ -0,0 +1,135 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+	"github.com/meshshell/mesh/parser"
+)
+
+func ExampleWalk() {
+	script := `
+echo start
+(cd /tmp && ls) | sort
+{ true; false; } || echo failed
+`
+	stmts, err := parser.ParseAll("example", strings.NewReader(script))
+	if err != nil {
+		panic(err)
+	}
+	commands := 0
+	for _, stmt := range stmts {
+		ast.Walk(stmt, func(n ast.Node) bool {
+			if _, ok := n.(*ast.Cmd); ok {
+				commands++
+			}
+			return true
+		})
+	}
+	fmt.Println(commands, "commands")
+	// Output: 7 commands
+}
+
+func ExampleDump() {
+	script := "! a=1 cmd $x[0] <<EOF && x = (~ 'a b')\nhello\nEOF\n"
+	stmts, err := parser.ParseAll("example", strings.NewReader(script))
+	if err != nil {
+		panic(err)
+	}
+	for _, stmt := range stmts {
+		if err := ast.Dump(os.Stdout, stmt); err != nil {
+			panic(err)
+		}
+	}
+	// Output:
+	// AndOr "&&"
+	//   Not
+	//     Pipeline
+	//       Cmd
+	//         Assignment a
+	//           Word
+	//             String "1"
+	//         Word
+	//           String "cmd"
+	//         Word
+	//           Var x
+	//             Index
+	//               Word
+	//                 String "0"
+	//         Redirect 0 "<<"
+	//           Word
+	//             String "hello"
+	//             String "\n"
+	//   Pipeline
+	//     Assign x
+	//       Array
+	//         Word
+	//           Tilde "~"
+	//         Word
+	//           String "a b"
+}
+
+// varNames collects the names of the variables which a script refers to. It
+// only needs to handle the statements and expressions which can contain them,
+// and leaves the rest to the base visitors which it embeds.
+type varNames struct {
+	ast.BaseStmtVisitor
+	ast.BaseExprVisitor
+	names []string
+}
+
+func (v *varNames) VisitPipeline(p *ast.Pipeline) (int, error) {
+	for _, s := range p.Stmts {
+		s.Visit(v)
+	}
+	return 0, nil
+}
+
+func (v *varNames) VisitCmd(c *ast.Cmd) (int, error) {
+	for _, e := range c.Argv {
+		e.Visit(v)
+	}
+	return 0, nil
+}
+
+func (v *varNames) VisitWord(w ast.Word) (string, error) {
+	for _, e := range w.SubExprs {
+		e.Visit(v)
+	}
+	return "", nil
+}
+
+func (v *varNames) VisitVar(x ast.Var) (string, error) {
+	v.names = append(v.names, x.Identifier)
+	return "", nil
+}
+
+func ExampleBaseStmtVisitor() {
+	script := "echo $HOME | grep $USER\ncp $src ${dst%/}/\n"
+	stmts, err := parser.ParseAll("example", strings.NewReader(script))
+	if err != nil {
+		panic(err)
+	}
+	v := &varNames{}
+	for _, stmt := range stmts {
+		stmt.Visit(v)
+	}
+	fmt.Println(v.names)
+	// Output: [HOME USER src dst]
+}
@@ -20,12 +20,49 @@ type Expr interface {
 
 type ExprVisitor interface {
 	VisitString(s String) (string, error)
+	VisitGlob(g Glob) (string, error)
 	VisitTilde(t Tilde) (string, error)
 	VisitVar(v Var) (string, error)
 	VisitWord(w Word) (string, error)
+	VisitFileContents(f FileContents) (string, error)
+	VisitCmdSubst(c CmdSubst) (string, error)
+}
+
+// BaseExprVisitor implements ExprVisitor, with methods which do nothing but
+// return zero values. It's meant to be embedded in a visitor which only needs
+// to handle some kinds of expression, so that it needn't implement the rest.
+type BaseExprVisitor struct{}
+
+func (BaseExprVisitor) VisitString(s String) (string, error) {
+	return "", nil
+}
+
+func (BaseExprVisitor) VisitGlob(g Glob) (string, error) {
+	return "", nil
+}
+
+func (BaseExprVisitor) VisitTilde(t Tilde) (string, error) {
+	return "", nil
+}
+
+func (BaseExprVisitor) VisitVar(v Var) (string, error) {
+	return "", nil
+}
+
+func (BaseExprVisitor) VisitWord(w Word) (string, error) {
+	return "", nil
+}
+
+func (BaseExprVisitor) VisitFileContents(f FileContents) (string, error) {
+	return "", nil
+}
+
+func (BaseExprVisitor) VisitCmdSubst(c CmdSubst) (string, error) {
+	return "", nil
 }
 
 type String struct {
+	Pos  Pos
 	Text string
 }
 
@@ -33,7 +70,22 @@ func (s String) Visit(v ExprVisitor) (string, error) {
 	return v.VisitString(s)
 }
 
+// Glob expands to the paths which match a pattern, such as `*.go`. The Text is
+// the pattern as it was written, so a backslash in it escapes the character
+// after it, which then only matches itself.
+type Glob struct {
+	Pos  Pos
+	Text string
+}
+
+func (g Glob) Visit(v ExprVisitor) (string, error) {
+	return v.VisitGlob(g)
+}
+
+// Tilde expands to the home directory of the user named after the `~`, or of
+// the current user if there's no name.
 type Tilde struct {
+	Pos  Pos
 	Text string
 }
 
@@ -41,8 +93,18 @@ func (t Tilde) Visit(v ExprVisitor) (string, error) {
 	return v.VisitTilde(t)
 }
 
+// Var expands a variable. If Index is set, it expands one element of an
+// array, or every element if the index is "@". If Op is set, the operator is
+// applied to the value along with its arguments, e.g. `${x%.txt}` has the Op
+// "%" and the argument ".txt", and `${x^^}` has the Op "^^" and an empty
+// argument. The operators "-", "=", "+" and "?" depend on whether the variable
+// is set, e.g. `${x-default}`, and with a ":" before them, whether it's empty.
 type Var struct {
+	Pos        Pos
 	Identifier string
+	Index      Expr
+	Op         string
+	Args       []Expr
 }
 
 func (v Var) Visit(visit ExprVisitor) (string, error) {
@@ -50,9 +112,33 @@ func (v Var) Visit(visit ExprVisitor) (string, error) {
 }
 
 type Word struct {
+	Pos      Pos
 	SubExprs []Expr
 }
 
 func (w Word) Visit(v ExprVisitor) (string, error) {
 	return v.VisitWord(w)
 }
+
+// FileContents expands to the contents of a file, without any newlines at the
+// end, as in `$(< file)`. Unlike other command substitutions, it reads the
+// file without running a command.
+type FileContents struct {
+	Pos  Pos
+	Path Expr
+}
+
+func (f FileContents) Visit(v ExprVisitor) (string, error) {
+	return v.VisitFileContents(f)
+}
+
+// CmdSubst expands to the output of the statements in its body, without any
+// newlines at the end, as in `$(date)`.
+type CmdSubst struct {
+	Pos  Pos
+	Body *StmtList
+}
+
+func (c CmdSubst) Visit(v ExprVisitor) (string, error) {
+	return v.VisitCmdSubst(c)
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"fmt"
+)
+
+// Pos is the position in a script at which a node starts, so that tools can
+// map a node back to the source it was parsed from. Line and Col count from
+// one, and Col counts runes, not bytes, as in the parser's errors. The zero
+// Pos is the position of a node which wasn't parsed, e.g. one built by hand.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+// IsValid reports whether the position is known.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String formats the position as `file:line:col`.
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
@@ -12,19 +12,113 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package ast declares the syntax tree of mesh scripts, as produced by the
+// parser package. The tree is evaluated by implementing StmtVisitor and
+// ExprVisitor, embedding BaseStmtVisitor and BaseExprVisitor for the methods
+// that aren't needed, or can be inspected more simply using Walk. Every node
+// records the position it was parsed from in its Pos field.
 package ast
 
+import (
+	"strings"
+)
+
 type Stmt interface {
 	Visit(v StmtVisitor) (int, error)
 }
 
 type StmtVisitor interface {
 	VisitStmtList(s *StmtList) (int, error)
+	VisitBackground(b *Background) (int, error)
+	VisitAndOr(a *AndOr) (int, error)
+	VisitNot(n *Not) (int, error)
+	VisitTime(t *Time) (int, error)
 	VisitPipeline(p *Pipeline) (int, error)
 	VisitCmd(c *Cmd) (int, error)
+	VisitSubshell(s *Subshell) (int, error)
+	VisitGroup(g *Group) (int, error)
+	VisitCase(c *Case) (int, error)
+	VisitSelect(s *Select) (int, error)
+	VisitArithFor(f *ArithFor) (int, error)
+	VisitCondExpr(c *CondExpr) (int, error)
+	VisitAssign(a *Assign) (int, error)
+	VisitFunc(f *Func) (int, error)
+	VisitCoproc(c *Coproc) (int, error)
+}
+
+// BaseStmtVisitor implements StmtVisitor, with methods which do nothing but
+// return zero values. It's meant to be embedded in a visitor which only needs
+// to handle some kinds of statement, so that it needn't implement the rest.
+// Note that its methods don't visit the statements inside those they're
+// given, so a visitor must handle any statement whose children it needs.
+type BaseStmtVisitor struct{}
+
+func (BaseStmtVisitor) VisitStmtList(s *StmtList) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitBackground(b *Background) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitAndOr(a *AndOr) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitNot(n *Not) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitTime(t *Time) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitPipeline(p *Pipeline) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitCmd(c *Cmd) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitSubshell(s *Subshell) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitGroup(g *Group) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitCase(c *Case) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitSelect(s *Select) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitArithFor(f *ArithFor) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitCondExpr(c *CondExpr) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitAssign(a *Assign) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitFunc(f *Func) (int, error) {
+	return 0, nil
+}
+
+func (BaseStmtVisitor) VisitCoproc(c *Coproc) (int, error) {
+	return 0, nil
 }
 
 type StmtList struct {
+	Pos   Pos
 	Stmts []Stmt
 }
 
@@ -32,7 +126,64 @@ func (s *StmtList) Visit(v StmtVisitor) (int, error) {
 	return v.VisitStmtList(s)
 }
 
+type Background struct {
+	Pos  Pos
+	Stmt Stmt
+}
+
+func (b *Background) Visit(v StmtVisitor) (int, error) {
+	return v.VisitBackground(b)
+}
+
+// Coproc runs Stmt in the background as a coprocess, with pipes to its stdin
+// and stdout, e.g. `coproc P { cat; }`. The shell's ends of the pipes are
+// given by the array variable Name, which is "COPROC" unless the coprocess
+// is a group or subshell named before it.
+type Coproc struct {
+	Pos  Pos
+	Name string
+	Stmt Stmt
+}
+
+func (c *Coproc) Visit(v StmtVisitor) (int, error) {
+	return v.VisitCoproc(c)
+}
+
+// AndOr runs Right only if Left succeeds (if Op is "&&") or fails (if Op is
+// "||").
+type AndOr struct {
+	Pos   Pos
+	Left  Stmt
+	Op    string
+	Right Stmt
+}
+
+func (a *AndOr) Visit(v StmtVisitor) (int, error) {
+	return v.VisitAndOr(a)
+}
+
+// Not runs Stmt, and inverts its exit status, e.g. `! grep -q x file`.
+type Not struct {
+	Pos  Pos
+	Stmt Stmt
+}
+
+func (n *Not) Visit(v StmtVisitor) (int, error) {
+	return v.VisitNot(n)
+}
+
+// Time runs Stmt, and then reports how long it took, e.g. `time make`.
+type Time struct {
+	Pos  Pos
+	Stmt Stmt
+}
+
+func (t *Time) Visit(v StmtVisitor) (int, error) {
+	return v.VisitTime(t)
+}
+
 type Pipeline struct {
+	Pos   Pos
 	Stmts []Stmt
 }
 
@@ -40,10 +191,174 @@ func (p *Pipeline) Visit(v StmtVisitor) (int, error) {
 	return v.VisitPipeline(p)
 }
 
+// Cmd runs a command. Any Assignments, e.g. `NAME=value cmd`, are only added
+// to the command's environment, unless there's no command to run, in which
+// case they set the variables in the shell itself.
 type Cmd struct {
-	Argv []Expr
+	Pos         Pos
+	Assignments []*Assignment
+	Argv        []Expr
+	Redirects   []*Redirect
+}
+
+// Assignment sets the variable Name to Value.
+type Assignment struct {
+	Pos   Pos
+	Name  string
+	Value Expr
+}
+
+// Redirect redirects one of a command's file descriptors. Op is the
+// redirection operator, either "<" to read from the file named by Target, or
+// "<<" for a here-doc, in which case Target is the body of the here-doc, or
+// "<<<" to read Target itself, followed by a newline. For
+// "<&" and ">&", Target is the number of the descriptor to copy into Fd. The
+// "&>" and "&>>" operators redirect both stdout and stderr to the same file.
+type Redirect struct {
+	Pos    Pos
+	Fd     int
+	Op     string
+	Target Expr
+}
+
+// DefaultFd returns the file descriptor a redirection operator applies to when
+// none is given, which is 0 for input and 1 for output.
+func DefaultFd(op string) int {
+	if strings.HasPrefix(op, "<") {
+		return 0
+	}
+	return 1
 }
 
 func (c *Cmd) Visit(v StmtVisitor) (int, error) {
 	return v.VisitCmd(c)
 }
+
+type Subshell struct {
+	Pos       Pos
+	Body      *StmtList
+	Redirects []*Redirect
+}
+
+func (s *Subshell) Visit(v StmtVisitor) (int, error) {
+	return v.VisitSubshell(s)
+}
+
+// Group runs a list of statements in the current shell, e.g. so that they
+// can be redirected as a unit.
+type Group struct {
+	Pos       Pos
+	Body      *StmtList
+	Redirects []*Redirect
+}
+
+func (g *Group) Visit(v StmtVisitor) (int, error) {
+	return v.VisitGroup(g)
+}
+
+// Case runs the body of the first clause with a pattern that matches Word,
+// e.g. `case $x in a | b) echo ab;; *) echo other;; esac`.
+type Case struct {
+	Pos       Pos
+	Word      Expr
+	Clauses   []*CaseClause
+	Redirects []*Redirect
+}
+
+func (c *Case) Visit(v StmtVisitor) (int, error) {
+	return v.VisitCase(c)
+}
+
+// CaseClause is one clause of a case statement. Its Terminator says what
+// happens after Body runs: ";;" ends the case statement, ";&" runs the next
+// clause's body as well without testing its patterns, and ";;&" goes on to
+// test the patterns of the clauses after it.
+type CaseClause struct {
+	Pos        Pos
+	Patterns   []Expr
+	Body       *StmtList
+	Terminator string
+}
+
+// Select repeatedly prints a numbered menu of Words, reads a choice and runs
+// Body with the variable Name set to the chosen word, e.g. `select x in a b;
+// do echo $x; done`. If Words is nil, as when `in` is left out, the menu
+// lists the positional parameters instead.
+type Select struct {
+	Pos       Pos
+	Name      string
+	Words     []Expr
+	Body      *StmtList
+	Redirects []*Redirect
+}
+
+func (s *Select) Visit(v StmtVisitor) (int, error) {
+	return v.VisitSelect(s)
+}
+
+// ArithFor is an arithmetic for loop, e.g. `for ((i = 0; i < 3; i++)); do
+// echo $i; done`. It evaluates Init once, then runs Body for as long as Cond
+// is non-zero, evaluating Update after each run. Only variables are expanded
+// in the three expressions, and an empty Cond is always true.
+type ArithFor struct {
+	Pos                Pos
+	Init, Cond, Update Expr
+	Body               *StmtList
+	Redirects          []*Redirect
+}
+
+func (f *ArithFor) Visit(v StmtVisitor) (int, error) {
+	return v.VisitArithFor(f)
+}
+
+// CondExpr is a conditional expression, e.g. `[[ $x == a* && -f $y ]]`, which
+// succeeds if it's true. Unlike the arguments of `test`, its words aren't
+// split into fields or globbed.
+//
+// Op is "&&" or "||" to combine X and Y, or "!" to negate X. Otherwise, Op is
+// applied to Args, which holds one word for a unary operator such as "-f", or
+// two for a binary operator such as "==". An empty Op tests whether its single
+// word is non-empty.
+type CondExpr struct {
+	Pos  Pos
+	Op   string
+	X, Y *CondExpr
+	Args []Expr
+}
+
+func (c *CondExpr) Visit(v StmtVisitor) (int, error) {
+	return v.VisitCondExpr(c)
+}
+
+// Assign is an assignment statement, e.g. `x = value` or `x = (a b c)` for an
+// array. If Index is set, it assigns to one element of an array instead, e.g.
+// `x[1] = value`. Exactly one of Value and Array is set.
+type Assign struct {
+	Pos   Pos
+	Name  string
+	Index Expr
+	Value Expr
+	Array *Array
+}
+
+func (a *Assign) Visit(v StmtVisitor) (int, error) {
+	return v.VisitAssign(a)
+}
+
+// Func defines a function, e.g. `f() { body; }`, which runs Body when called
+// like a command.
+type Func struct {
+	Pos  Pos
+	Name string
+	Body Stmt
+}
+
+func (f *Func) Visit(v StmtVisitor) (int, error) {
+	return v.VisitFunc(f)
+}
+
+// Array is an array literal, e.g. `(a b c)`.
+type Array struct {
+	Pos   Pos
+	Elems []Expr
+}
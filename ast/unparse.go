@@ -0,0 +1,623 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// unquoted lists the characters which can't appear in a string without
+// quoting it. Besides those which the lexer treats specially, `!` is quoted
+// so that history expansion leaves it alone.
+const unquoted = "$&|;<>() \t\n'\"\\!#"
+
+// Unparse returns source code for the tree rooted at node, which parses back
+// into the same tree. Strings are only quoted where they need to be, so the
+// result needn't match what was originally parsed, e.g. `'a'` unparses as
+// `a`. The bodies of any here-docs follow the line which starts them, so the
+// result may span several lines.
+func Unparse(node Node) string {
+	u := &unparser{}
+	u.node(node)
+	u.hereDocBodies()
+	return u.b.String()
+}
+
+type unparser struct {
+	b strings.Builder
+	// hereDocs are the here-docs started on the current line, whose bodies
+	// are written once the line ends.
+	hereDocs []hereDoc
+}
+
+type hereDoc struct {
+	delimiter string
+	body      string
+}
+
+func (u *unparser) write(s string) {
+	u.b.WriteString(s)
+}
+
+func (u *unparser) node(node Node) {
+	switch n := node.(type) {
+	case *StmtList:
+		u.stmts(n.Stmts)
+	case *Background:
+		u.node(n.Stmt)
+		u.write(" &")
+	case *Coproc:
+		u.write("coproc ")
+		switch n.Stmt.(type) {
+		case *Subshell, *Group:
+			// Only these can be named, as otherwise the name
+			// would be the command.
+			u.write(n.Name + " ")
+		}
+		u.node(n.Stmt)
+	case *AndOr:
+		u.node(n.Left)
+		u.write(" " + n.Op + " ")
+		u.node(n.Right)
+	case *Not:
+		u.write("! ")
+		u.node(n.Stmt)
+	case *Time:
+		u.write("time ")
+		u.node(n.Stmt)
+	case *Pipeline:
+		for index, s := range n.Stmts {
+			if index > 0 {
+				u.write(" | ")
+			}
+			u.node(s)
+		}
+	case *Cmd:
+		var words []Node
+		for _, a := range n.Assignments {
+			words = append(words, a)
+		}
+		for _, e := range n.Argv {
+			words = append(words, e)
+		}
+		for _, r := range n.Redirects {
+			words = append(words, r)
+		}
+		for index, w := range words {
+			if index > 0 {
+				u.write(" ")
+			}
+			u.node(w)
+		}
+	case *Subshell:
+		u.write("(")
+		u.stmts(n.Body.Stmts)
+		u.write(")")
+		u.redirects(n.Redirects)
+	case *Group:
+		u.write("{ ")
+		u.stmts(n.Body.Stmts)
+		// The closing brace must follow a separator.
+		if _, ok := lastStmt(n.Body.Stmts).(*Background); ok {
+			u.write(" }")
+		} else {
+			u.write("; }")
+		}
+		u.redirects(n.Redirects)
+	case *Case:
+		u.write("case ")
+		u.node(n.Word)
+		u.write(" in ")
+		for _, c := range n.Clauses {
+			// A pattern of `esac` would end the statement unless
+			// it followed a `(`.
+			if Unparse(c.Patterns[0]) == "esac" {
+				u.write("(")
+			}
+			u.node(c)
+			u.write(" ")
+		}
+		u.write("esac")
+		u.redirects(n.Redirects)
+	case *Select:
+		u.write("select " + n.Name)
+		if n.Words != nil {
+			u.write(" in")
+			for _, w := range n.Words {
+				u.write(" ")
+				u.node(w)
+			}
+		}
+		u.loopBody(n.Body)
+		u.redirects(n.Redirects)
+	case *ArithFor:
+		u.write("for ((")
+		u.varsOnly(n.Init)
+		u.write("; ")
+		u.varsOnly(n.Cond)
+		u.write("; ")
+		u.varsOnly(n.Update)
+		u.write("))")
+		u.loopBody(n.Body)
+		u.redirects(n.Redirects)
+	case *CaseClause:
+		for index, p := range n.Patterns {
+			if index > 0 {
+				u.write(" | ")
+			}
+			u.node(p)
+		}
+		u.write(") ")
+		u.stmts(n.Body.Stmts)
+		u.write(n.Terminator)
+	case *CondExpr:
+		u.write("[[ ")
+		u.cond(n, 0)
+		u.write(" ]]")
+	case *Assign:
+		target := []Expr{String{Text: n.Name}}
+		if n.Index != nil {
+			target = append(target, String{Text: "["})
+			target = append(target, subExprs(n.Index)...)
+			target = append(target, String{Text: "]"})
+		}
+		// The target isn't a pattern, so its brackets needn't be
+		// quoted.
+		target = joinStrings(target)
+		if s, ok := target[0].(String); ok && len(target) == 1 &&
+			!needsQuotes(s.Text) {
+			u.write(s.Text)
+		} else {
+			u.word(target)
+		}
+		u.write(" =")
+		if n.Array != nil {
+			u.write(" ")
+			u.node(n.Array)
+		} else if value := subExprs(n.Value); len(value) > 0 {
+			u.write(" ")
+			u.word(value)
+		}
+	case *Func:
+		u.write(n.Name + "() ")
+		u.node(n.Body)
+	case *Array:
+		u.write("(")
+		for index, e := range n.Elems {
+			if index > 0 {
+				u.write(" ")
+			}
+			u.node(e)
+		}
+		u.write(")")
+	case *Assignment:
+		// The name is part of the word's first string.
+		value := append([]Expr{String{Text: n.Name + "="}},
+			subExprs(n.Value)...)
+		u.word(joinStrings(value))
+	case *Redirect:
+		if n.Fd != DefaultFd(n.Op) {
+			u.write(strconv.Itoa(n.Fd))
+		}
+		if n.Op == "<<" {
+			u.hereDoc(n.Target)
+		} else {
+			u.write(n.Op)
+			u.node(n.Target)
+		}
+	case Word, *Word, Var, *Var, String, *String, Glob, *Glob, Tilde,
+		*Tilde, FileContents, *FileContents, CmdSubst, *CmdSubst:
+		u.word(subExprs(n.(Expr)))
+	default:
+		panic(fmt.Sprintf("ast: unexpected node type %T", node))
+	}
+}
+
+// stmts writes a list of statements, separated by `;`, except after a
+// background statement, where the `&` separates them.
+func (u *unparser) stmts(stmts []Stmt) {
+	for index, s := range stmts {
+		if index > 0 {
+			if _, ok := stmts[index-1].(*Background); ok {
+				u.write(" ")
+			} else {
+				u.write("; ")
+			}
+		}
+		u.node(s)
+	}
+}
+
+// loopBody writes the body of a loop, e.g. `; do a; b; done`.
+func (u *unparser) loopBody(body *StmtList) {
+	u.write("; do ")
+	u.stmts(body.Stmts)
+	// Like a closing brace, `done` must follow a separator.
+	if _, ok := lastStmt(body.Stmts).(*Background); ok {
+		u.write(" done")
+	} else {
+		u.write("; done")
+	}
+}
+
+// cond writes a conditional expression without its brackets, putting it in
+// parentheses if it binds less tightly than the operator it's an operand of.
+func (u *unparser) cond(c *CondExpr, outer int) {
+	inner := condPrecedence(c.Op)
+	if inner < outer {
+		u.write("( ")
+		defer u.write(" )")
+	}
+	switch {
+	case c.Op == "&&" || c.Op == "||":
+		// The operators are left-associative, so only the right
+		// operand needs parentheses for the same operator.
+		u.cond(c.X, inner)
+		u.write(" " + c.Op + " ")
+		u.cond(c.Y, inner+1)
+	case c.Op == "!":
+		u.write("! ")
+		u.cond(c.X, inner)
+	case len(c.Args) == 1 && c.Op != "":
+		u.write(c.Op + " ")
+		u.node(c.Args[0])
+	case len(c.Args) == 1:
+		u.node(c.Args[0])
+	default:
+		u.node(c.Args[0])
+		u.write(" " + c.Op + " ")
+		u.node(c.Args[1])
+	}
+}
+
+func condPrecedence(op string) int {
+	switch op {
+	case "||":
+		return 1
+	case "&&":
+		return 2
+	case "!":
+		return 3
+	default:
+		return 4
+	}
+}
+
+func lastStmt(stmts []Stmt) Stmt {
+	if len(stmts) == 0 {
+		return nil
+	}
+	return stmts[len(stmts)-1]
+}
+
+func (u *unparser) redirects(redirects []*Redirect) {
+	for _, r := range redirects {
+		u.write(" ")
+		u.node(r)
+	}
+}
+
+// word writes the parts of a word. Adjacent strings would run together, as
+// would a string and a tilde following it, unless the string ends in a `:`,
+// so the first of them is quoted. A string next to a pattern is quoted too,
+// since only the pattern can't be, as is a string after a tilde, unless it
+// starts with a `/`, so that it isn't read as a user name. Likewise, a
+// variable name followed by text which would continue it is put in braces.
+func (u *unparser) word(exprs []Expr) {
+	if len(exprs) == 0 {
+		u.write("''")
+		return
+	}
+	for index, e := range exprs {
+		var prev, next Expr
+		if index > 0 {
+			prev = exprs[index-1]
+		}
+		if index+1 < len(exprs) {
+			next = exprs[index+1]
+		}
+		switch e := deref(e).(type) {
+		case String:
+			_, afterTilde := deref(prev).(Tilde)
+			_, beforeTilde := deref(next).(Tilde)
+			_, beforeString := deref(next).(String)
+			_, beforeGlob := deref(next).(Glob)
+			_, afterGlob := deref(prev).(Glob)
+			colon := strings.HasSuffix(e.Text, ":")
+			slash := strings.HasPrefix(e.Text, "/")
+			if beforeString || beforeGlob || afterGlob ||
+				(beforeTilde && !colon) ||
+				(afterTilde && !slash) {
+				u.write(quote(e.Text))
+			} else {
+				u.write(maybeQuote(e.Text))
+			}
+		case Glob:
+			u.write(e.Text)
+		case Tilde:
+			u.write(e.Text)
+		case Var:
+			var s string
+			switch next := deref(next).(type) {
+			case String:
+				s = next.Text
+			case Glob:
+				s = next.Text
+			}
+			if e.Op != "" || continuesVar(e, s) {
+				u.write("${" + e.Identifier)
+				u.index(e.Index)
+				u.paramOp(e)
+				u.write("}")
+			} else {
+				u.write("$" + e.Identifier)
+				u.index(e.Index)
+			}
+		case Word:
+			u.word(e.SubExprs)
+		case FileContents:
+			u.write("$(< ")
+			u.node(e.Path)
+			u.write(")")
+		case CmdSubst:
+			u.write("$(")
+			u.stmts(e.Body.Stmts)
+			u.write(")")
+		default:
+			panic(fmt.Sprintf("ast: unexpected node type %T", e))
+		}
+	}
+}
+
+// continuesVar reports whether text would be read as part of the variable
+// before it if they weren't separated.
+func continuesVar(v Var, text string) bool {
+	if text == "" || v.Index != nil {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(text)
+	return r == '[' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// index writes the index after a variable name, e.g. the `[1]` in `$x[1]`.
+func (u *unparser) index(index Expr) {
+	if index == nil {
+		return
+	}
+	u.write("[")
+	u.varsOnly(index)
+	u.write("]")
+}
+
+// paramOp writes the operator applied to a variable, and its arguments, e.g.
+// the `%.txt` in `${x%.txt}`.
+func (u *unparser) paramOp(v Var) {
+	if v.Op == "" {
+		return
+	}
+	u.write(v.Op)
+	for index, arg := range v.Args {
+		if index == 0 && strings.ContainsAny(v.Op, "-=+?") {
+			// A default value is plain text, like a replacement.
+			u.replacement(arg)
+		} else if index == 0 {
+			u.varsOnly(arg)
+		} else if v.Op == ":" {
+			u.write(":")
+			u.varsOnly(arg)
+		} else {
+			u.write("/")
+			u.replacement(arg)
+		}
+	}
+}
+
+// replacement writes the replacement after a `/` operator, e.g. the `b` in
+// `${x/a/b}`, which is plain text, so any special characters in it are
+// escaped, as is any character which would continue a variable's name.
+func (u *unparser) replacement(expr Expr) {
+	exprs := subExprs(expr)
+	for index, e := range exprs {
+		switch e := deref(e).(type) {
+		case String:
+			text := strings.NewReplacer(`\`, `\\`, `/`, `\/`,
+				`}`, `\}`, `$`, `\$`).Replace(e.Text)
+			if index > 0 {
+				v, ok := deref(exprs[index-1]).(Var)
+				if ok && continuesVar(v, text) {
+					text = `\` + text
+				}
+			}
+			u.write(text)
+		case Var:
+			u.write("$" + e.Identifier)
+		default:
+			panic(fmt.Sprintf("ast: unexpected node type %T", e))
+		}
+	}
+}
+
+// varsOnly writes an expression in which only variables are expanded, such as
+// an index, so its text is written as is.
+func (u *unparser) varsOnly(expr Expr) {
+	for _, e := range subExprs(expr) {
+		switch e := deref(e).(type) {
+		case String:
+			u.write(e.Text)
+		case Var:
+			u.write("$" + e.Identifier)
+		default:
+			panic(fmt.Sprintf("ast: unexpected node type %T", e))
+		}
+	}
+}
+
+// hereDoc writes the start of a here-doc, leaving its body to be written once
+// the line ends. Unless the body expands variables, the delimiter is quoted,
+// so that none of the body needs escaping.
+func (u *unparser) hereDoc(target Expr) {
+	var body strings.Builder
+	quoted := true
+	for _, e := range subExprs(target) {
+		if _, ok := deref(e).(Var); ok {
+			quoted = false
+		}
+	}
+	for _, e := range subExprs(target) {
+		switch e := deref(e).(type) {
+		case String:
+			if quoted {
+				body.WriteString(e.Text)
+			} else {
+				body.WriteString(escapeHereDoc(e.Text))
+			}
+		case Var:
+			body.WriteString("$" + e.Identifier)
+		default:
+			panic(fmt.Sprintf("ast: unexpected node type %T", e))
+		}
+	}
+	text := body.String()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	delimiter := hereDocDelimiter(text)
+	if quoted {
+		u.write("<<'" + delimiter + "'")
+	} else {
+		u.write("<<" + delimiter)
+	}
+	u.hereDocs = append(u.hereDocs, hereDoc{delimiter, text})
+}
+
+// hereDocDelimiter returns a delimiter which isn't a line of the body.
+func hereDocDelimiter(body string) string {
+	lines := strings.Split(body, "\n")
+	for n := 1; ; n++ {
+		delimiter := "EOF"
+		if n > 1 {
+			delimiter += strconv.Itoa(n)
+		}
+		found := false
+		for _, line := range lines {
+			if line == delimiter {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return delimiter
+		}
+	}
+}
+
+func (u *unparser) hereDocBodies() {
+	for _, h := range u.hereDocs {
+		u.write("\n" + h.body + h.delimiter)
+	}
+	u.hereDocs = nil
+}
+
+// escapeHereDoc escapes the characters which are special in the body of a
+// here-doc whose delimiter isn't quoted.
+func escapeHereDoc(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `$`, `\$`).Replace(s)
+}
+
+// maybeQuote quotes a string only if it contains special characters, or
+// would otherwise be read as something other than a string, such as a tilde
+// or a pattern.
+func maybeQuote(s string) string {
+	open := strings.IndexByte(s, '[')
+	if needsQuotes(s) || strings.ContainsAny(s, "*?") ||
+		open >= 0 && strings.Contains(s[open+1:], "]") {
+		return quote(s)
+	}
+	return s
+}
+
+// needsQuotes reports whether a string would be read as something other than
+// a string unless it were quoted, ignoring any characters which would only
+// make it a pattern.
+func needsQuotes(s string) bool {
+	return s == "" || strings.ContainsAny(s, unquoted) ||
+		strings.HasPrefix(s, "~") || strings.Contains(s, ":~")
+}
+
+// quote puts a string in single quotes, escaping any backslashes or single
+// quotes in it.
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func subExprs(e Expr) []Expr {
+	switch w := e.(type) {
+	case nil:
+		return nil
+	case Word:
+		return w.SubExprs
+	case *Word:
+		return w.SubExprs
+	default:
+		return []Expr{e}
+	}
+}
+
+// joinStrings joins any adjacent strings in a word into one, for words which
+// are only recognised if their text is in a single string, such as `x[1]`.
+func joinStrings(exprs []Expr) []Expr {
+	var joined []Expr
+	for _, e := range exprs {
+		s, ok := deref(e).(String)
+		if n := len(joined); ok && n > 0 {
+			if prev, ok := joined[n-1].(String); ok {
+				joined[n-1] = String{Text: prev.Text + s.Text}
+				continue
+			}
+		}
+		if ok {
+			e = s
+		}
+		joined = append(joined, e)
+	}
+	return joined
+}
+
+// deref returns the value of an expression which is a pointer, so that
+// either form of each expression can be handled in the same way.
+func deref(e Expr) Expr {
+	switch e := e.(type) {
+	case *String:
+		return *e
+	case *Glob:
+		return *e
+	case *Tilde:
+		return *e
+	case *Var:
+		return *e
+	case *Word:
+		return *e
+	case *FileContents:
+		return *e
+	case *CmdSubst:
+		return *e
+	default:
+		return e
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/ast"
+	"github.com/meshshell/mesh/parser"
+)
+
+// parseOne parses a script which must contain exactly one statement.
+func parseOne(t *testing.T, script string) ast.Stmt {
+	stmts, err := parser.ParseAll(t.Name(), strings.NewReader(script))
+	require.NoError(t, err, "script: %q", script)
+	require.Len(t, stmts, 1, "script: %q", script)
+	return stmts[0]
+}
+
+// dump dumps a tree, leaving out the positions of its nodes, so that it can be
+// compared with a tree parsed from different source.
+func dump(t *testing.T, node ast.Node) string {
+	var b strings.Builder
+	require.NoError(t, ast.Dump(&b, node))
+	return b.String()
+}
+
+func TestUnparse(t *testing.T) {
+	for _, test := range []struct {
+		script   string
+		unparsed string
+	}{
+		{"echo  a   b", "echo a b"},
+		{"'echo' 'a b' c'd'", `echo 'a b' 'c\'d\''`},
+		{"a &", "a &"},
+		{"a && ! b || time c | d", "a && ! b || time c | d"},
+		{"A=1 B= 'C=x y' cmd", "A=1 B= 'C=x y' cmd"},
+		{
+			"echo $x${y}z $x[1]a $x[$i] ${x}[1]",
+			"echo $x${y}z $x[1]a $x[$i] ${x}[1]",
+		},
+		{"echo ~/a 'a'~ \\$x", "echo ~/a 'a'~ '$x'"},
+		{"PATH = ~/bin:~sam/bin:/bin", "PATH = ~/bin:~sam/bin:/bin"},
+		{"( a; b & c ) >out", "(a; b & c) >out"},
+		{"{ a; b & }", "{ a; b & }"},
+		{"{ a; } <in >|out", "{ a; } <in >|out"},
+		{"x = ( a 'b c' )", "x = (a 'b c')"},
+		{"x[$i] =", "x[$i] ="},
+		{"x[1] = *", "x[1] = *"},
+		{"x = $(<f)", "x = $(< f)"},
+		{"x = $( a |b;c& )", "x = $(a | b; c &)"},
+		{"coproc  P  { a; }", "coproc P { a; }"},
+		{"coproc P x", "coproc P x"},
+		{"coproc = 1", "coproc = 1"},
+		{
+			"select x\nin a b\ndo c &\ndone",
+			"select x in a b; do c & done",
+		},
+		{"select x do c; done", "select x; do c; done"},
+		{
+			"for ((i=0;i<$n;i++))\ndo a\ndone",
+			"for ((i=0; i<$n; i++)); do a; done",
+		},
+		{"echo \\* '[a]' a*b", "echo '*' '[a]' a*b"},
+		{"f() { echo $1; }", "f() { echo $1; }"},
+		{
+			"case $x in\n a|b) echo ab ;&\n *) ;;&\n" +
+				" (esac) c\nesac",
+			"case $x in a | b) echo ab;& *) ;;& (esac) c;; esac",
+		},
+		{
+			"[[ (a||b)&&! (c&&d) || -n $x&&$y<$z ]]",
+			"[[ ( a || b ) && ! ( c && d ) || -n $x && $y < $z ]]",
+		},
+		{"[[ a && ( b && c ) ]]", "[[ a && ( b && c ) ]]"},
+		{"cat <<EOF\na $x\n\\$y\nEOF", "cat <<EOF\na $x\n\\$y\nEOF"},
+		{"cat <<'END'\nEOF\nEND", "cat <<'EOF2'\nEOF\nEOF2"},
+		{
+			"a 2>&1 1>b 0<c 3<&0 4<<EOF\n$x\nEOF",
+			"a 2>&1 >b <c 3<&0 4<<EOF\n$x\nEOF",
+		},
+	} {
+		t.Run(test.script, func(t *testing.T) {
+			stmt := parseOne(t, test.script)
+			assert.Equal(t, test.unparsed, ast.Unparse(stmt))
+		})
+	}
+}
+
+// TestUnparseRoundTrip checks that unparsing a tree gives a script which
+// parses back into the same tree, for trees whose strings need quoting.
+func TestUnparseRoundTrip(t *testing.T) {
+	for _, script := range []string{
+		"echo a b",
+		"'a b' 'c''d' 'e'$f'g' \\'",
+		"echo 'a\\b' '$' '\\$x' '!' '#' ''",
+		"echo 'multi\nline' ~'~' ~x ~'x' ~x/y:~:~z 'a:~b' \\:~",
+		"echo $1${2}3 $# $@ ${x[@]} $x[a$b] $$ $!x $?? ${?}1",
+		"echo $é${é}é ${x}٣",
+		"echo ${x%.txt}${y##*/$z}a ${a[@]#?} ${b%%\\}}",
+		"echo ${x/a\\/b} ${x//a/} ${x/#a/$y\\z\\/\\}\\$\\\\}",
+		"echo ${x:1} ${x: -$n:2} ${x[@]:$i+1:-1}",
+		"echo ${x-a b} ${x:=$y\\\\\\}} ${x[1]:+/} ${x:?} ${1-c}",
+		"echo *.go 'a'* *'b' $x* ${x}? ~/[ab] a\\?b\\\\ '*'",
+		"echo ${x^} ${x^^[$y]} ${x,} ${x[@],,}",
+		"'A=a b' B=$c'd' cmd",
+		"x = 'a;b'",
+		"x['a b'] = 'c d'",
+		"x = (1 '2 3' $y)",
+		"time ! a | b && c || d &",
+		"( a; b ) | { c & d & } >|f <g",
+		"a 2 >f 12>&2 x3<&4",
+		"a &>f &>>g >>h 2>>i",
+		"cat <<<'a b' 3<<<$x",
+		"echo a$(< ~/f)b $(< 'a b') $(<$x)",
+		"echo a$(b; c | d)e $() $(f $(g))",
+		"f() ( g )",
+		"select x in a 'b c' $y; do echo $x; d & done >f",
+		"for ((i = $x; i < 3 && (j *= 2); i++)); do a; done >f",
+		"for ((;;)); do a & done",
+		"coproc a b | c && coproc P ( a ) >f &",
+		"[[ '(' == ?'*' && ! a'b' -nt ~/c || $x =~ '^(a|b)$' ]]",
+		"case 'a b' in 'a'* | ?[b]*) c & ;; (esac) ;& ''|x) esac >f",
+		"cat <<EOF <<-'EOF2' >f\n$x\\$y\\\\\nEOF\n\tEOF\nEOF2",
+	} {
+		t.Run(script, func(t *testing.T) {
+			stmt := parseOne(t, script)
+			unparsed := ast.Unparse(stmt)
+			assert.Equal(t,
+				dump(t, stmt), dump(t, parseOne(t, unparsed)),
+				"unparsed: %q", unparsed)
+		})
+	}
+}
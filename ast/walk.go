@@ -0,0 +1,142 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"fmt"
+)
+
+// Node is any node in the syntax tree: a Stmt, an Expr, an *Assignment, an
+// *Array, a *Redirect or a *CaseClause.
+type Node interface{}
+
+// Walk traverses the tree rooted at node in depth-first order. It calls fn for
+// each node, and then for each of its children if fn returns true.
+//
+// Unlike implementing StmtVisitor or ExprVisitor, this makes it easy to look
+// for just the nodes of interest, e.g. every *Cmd in a script.
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *StmtList:
+		for _, s := range n.Stmts {
+			Walk(s, fn)
+		}
+	case *Background:
+		Walk(n.Stmt, fn)
+	case *Coproc:
+		Walk(n.Stmt, fn)
+	case *AndOr:
+		Walk(n.Left, fn)
+		Walk(n.Right, fn)
+	case *Not:
+		Walk(n.Stmt, fn)
+	case *Time:
+		Walk(n.Stmt, fn)
+	case *Pipeline:
+		for _, s := range n.Stmts {
+			Walk(s, fn)
+		}
+	case *Cmd:
+		for _, a := range n.Assignments {
+			Walk(a, fn)
+		}
+		for _, e := range n.Argv {
+			Walk(e, fn)
+		}
+		walkRedirects(n.Redirects, fn)
+	case *Subshell:
+		Walk(n.Body, fn)
+		walkRedirects(n.Redirects, fn)
+	case *Group:
+		Walk(n.Body, fn)
+		walkRedirects(n.Redirects, fn)
+	case *Case:
+		Walk(n.Word, fn)
+		for _, c := range n.Clauses {
+			Walk(c, fn)
+		}
+		walkRedirects(n.Redirects, fn)
+	case *Select:
+		walkExprs(n.Words, fn)
+		Walk(n.Body, fn)
+		walkRedirects(n.Redirects, fn)
+	case *ArithFor:
+		walkExprs([]Expr{n.Init, n.Cond, n.Update}, fn)
+		Walk(n.Body, fn)
+		walkRedirects(n.Redirects, fn)
+	case *CaseClause:
+		walkExprs(n.Patterns, fn)
+		Walk(n.Body, fn)
+	case *CondExpr:
+		if n.X != nil {
+			Walk(n.X, fn)
+		}
+		if n.Y != nil {
+			Walk(n.Y, fn)
+		}
+		walkExprs(n.Args, fn)
+	case *Assign:
+		Walk(n.Index, fn)
+		Walk(n.Value, fn)
+		if n.Array != nil {
+			Walk(n.Array, fn)
+		}
+	case *Func:
+		Walk(n.Body, fn)
+	case *Array:
+		walkExprs(n.Elems, fn)
+	case *Assignment:
+		Walk(n.Value, fn)
+	case *Redirect:
+		Walk(n.Target, fn)
+	case Word:
+		walkExprs(n.SubExprs, fn)
+	case *Word:
+		walkExprs(n.SubExprs, fn)
+	case Var:
+		Walk(n.Index, fn)
+		walkExprs(n.Args, fn)
+	case *Var:
+		Walk(n.Index, fn)
+		walkExprs(n.Args, fn)
+	case FileContents:
+		Walk(n.Path, fn)
+	case *FileContents:
+		Walk(n.Path, fn)
+	case CmdSubst:
+		Walk(n.Body, fn)
+	case *CmdSubst:
+		Walk(n.Body, fn)
+	case String, *String, Glob, *Glob, Tilde, *Tilde:
+		// These have no children.
+	default:
+		panic(fmt.Sprintf("ast: unexpected node type %T", node))
+	}
+}
+
+func walkRedirects(redirects []*Redirect, fn func(Node) bool) {
+	for _, r := range redirects {
+		Walk(r, fn)
+	}
+}
+
+func walkExprs(exprs []Expr, fn func(Node) bool) {
+	for _, e := range exprs {
+		Walk(e, fn)
+	}
+}
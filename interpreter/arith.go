@@ -0,0 +1,300 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// arithLevels lists the binary arithmetic operators from lowest to highest
+// precedence. Longer operators come first so that e.g. `<=` isn't read as
+// `<`.
+var arithLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// maxArithDepth limits how deeply variables whose values are themselves
+// expressions are evaluated, so that e.g. `a = a` doesn't recurse forever.
+const maxArithDepth = 100
+
+// arithAssignOps lists the assignment operators, which set the variable on
+// their left, e.g. `x += 2`.
+var arithAssignOps = []string{"+=", "-=", "*=", "/=", "%=", "="}
+
+type arith struct {
+	shell *Interpreter
+	expr  string
+	pos   int
+	depth int
+	// skip is set while evaluating the right of a `&&` or `||` whose
+	// result is already known from its left, so that no variables are set.
+	skip bool
+}
+
+// arith evaluates an integer arithmetic expression. A variable in the
+// expression stands for the value of its own value as an expression, and an
+// unset or empty variable, or an empty expression, is zero. A variable can
+// be assigned to with `=` or an operator such as `+=`, or incremented or
+// decremented with `++` or `--` before or after it.
+func (i *Interpreter) arith(expr string) (int64, error) {
+	return (&arith{shell: i, expr: expr}).eval()
+}
+
+func (a *arith) eval() (int64, error) {
+	if strings.TrimSpace(a.expr) == "" {
+		return 0, nil
+	}
+	n, err := a.binary(0)
+	if err == nil && a.skipSpace() < len(a.expr) {
+		err = a.syntaxError()
+	}
+	return n, err
+}
+
+func (a *arith) binary(level int) (int64, error) {
+	if level == len(arithLevels) {
+		return a.unary()
+	}
+	x, err := a.binary(level + 1)
+	for err == nil {
+		op := a.operator(arithLevels[level]...)
+		if op == "" {
+			break
+		}
+		skip := a.skip
+		a.skip = skip || op == "&&" && x == 0 || op == "||" && x != 0
+		var y int64
+		if y, err = a.binary(level + 1); err == nil {
+			x, err = apply(op, x, y)
+		}
+		a.skip = skip
+	}
+	return x, err
+}
+
+func (a *arith) unary() (int64, error) {
+	if op := a.operator("++", "--"); op != "" {
+		name := a.name()
+		if name == "" {
+			return 0, a.syntaxError()
+		}
+		x, err := a.variable(name)
+		if err != nil {
+			return 0, err
+		}
+		x = increment(op, x)
+		return x, a.assign(name, x)
+	}
+	op := a.operator("-", "+", "!")
+	if op == "" {
+		return a.primary()
+	}
+	x, err := a.unary()
+	switch op {
+	case "-":
+		x = -x
+	case "!":
+		x = boolToInt(x == 0)
+	}
+	return x, err
+}
+
+func (a *arith) primary() (int64, error) {
+	if a.operator("(") != "" {
+		x, err := a.binary(0)
+		if err == nil && a.operator(")") == "" {
+			err = a.syntaxError()
+		}
+		return x, err
+	}
+	word := a.name()
+	switch {
+	case word == "":
+		return 0, a.syntaxError()
+	case word[0] >= '0' && word[0] <= '9':
+		n, err := strconv.ParseInt(word, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid number", word)
+		}
+		return n, nil
+	}
+	if op := a.assignOperator(); op != "" {
+		y, err := a.binary(0)
+		if err == nil && op != "=" {
+			var x int64
+			if x, err = a.variable(word); err == nil {
+				y, err = apply(op[:1], x, y)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		return y, a.assign(word, y)
+	}
+	x, err := a.variable(word)
+	if op := a.operator("++", "--"); op != "" && err == nil {
+		// The result is the value from before the change.
+		err = a.assign(word, increment(op, x))
+	}
+	return x, err
+}
+
+// name consumes and returns the name or number which comes next in the
+// expression, or returns "" if neither does.
+func (a *arith) name() string {
+	start := a.skipSpace()
+	end := start
+	for end < len(a.expr) {
+		r, width := utf8.DecodeRuneInString(a.expr[end:])
+		if !isNameRune(r) {
+			break
+		}
+		end += width
+	}
+	a.pos = end
+	return a.expr[start:end]
+}
+
+// variable evaluates the value of a variable as an expression.
+func (a *arith) variable(name string) (int64, error) {
+	if a.depth == maxArithDepth {
+		return 0, fmt.Errorf(
+			"%s: expression recursion level exceeded", name)
+	}
+	value, _ := a.shell.lookupVar(name)
+	nested := &arith{
+		shell: a.shell,
+		expr:  value,
+		depth: a.depth + 1,
+		skip:  a.skip,
+	}
+	return nested.eval()
+}
+
+// assignOperator consumes and returns the assignment operator which comes
+// next in the expression, or returns "" if none does. Unlike the other
+// operators, `=` mustn't be read from the start of `==`.
+func (a *arith) assignOperator() string {
+	a.skipSpace()
+	rest := a.expr[a.pos:]
+	if strings.HasPrefix(rest, "==") {
+		return ""
+	}
+	return a.operator(arithAssignOps...)
+}
+
+// assign sets a variable to the result of an expression, unless the
+// expression is being skipped.
+func (a *arith) assign(name string, n int64) error {
+	if a.skip {
+		return nil
+	} else if name[0] >= '0' && name[0] <= '9' {
+		return fmt.Errorf("%s: not a variable", name)
+	}
+	_, err := a.shell.setScalar(name, strconv.FormatInt(n, 10))
+	return err
+}
+
+// operator consumes and returns the first of the operators which comes next
+// in the expression, or returns "" if none of them does.
+func (a *arith) operator(ops ...string) string {
+	a.skipSpace()
+	for _, op := range ops {
+		if strings.HasPrefix(a.expr[a.pos:], op) {
+			a.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (a *arith) skipSpace() int {
+	for a.pos < len(a.expr) && isSpace(a.expr[a.pos]) {
+		a.pos++
+	}
+	return a.pos
+}
+
+func (a *arith) syntaxError() error {
+	return fmt.Errorf("%s: syntax error in expression", a.expr)
+}
+
+func apply(op string, x, y int64) (int64, error) {
+	switch op {
+	case "||":
+		return boolToInt(x != 0 || y != 0), nil
+	case "&&":
+		return boolToInt(x != 0 && y != 0), nil
+	case "==":
+		return boolToInt(x == y), nil
+	case "!=":
+		return boolToInt(x != y), nil
+	case "<=":
+		return boolToInt(x <= y), nil
+	case ">=":
+		return boolToInt(x >= y), nil
+	case "<":
+		return boolToInt(x < y), nil
+	case ">":
+		return boolToInt(x > y), nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	}
+	if y == 0 {
+		return 0, errors.New("division by 0")
+	} else if op == "/" {
+		return x / y, nil
+	}
+	return x % y, nil
+}
+
+// increment applies `++` or `--` to x.
+func increment(op string, x int64) int64 {
+	if op == "++" {
+		return x + 1
+	}
+	return x - 1
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// isNameRune reports whether r can appear in a variable name: a letter,
+// including any Unicode letter, a digit or `_`.
+func isNameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
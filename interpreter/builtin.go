@@ -18,58 +18,420 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
 )
 
+func init() {
+	registerBuiltin("cd", Builtin{
+		run:     cd,
+		Summary: "Change the working directory.",
+		Usage:   "cd [-L|-P] [dir]",
+	})
+	registerBuiltin("exit", Builtin{
+		run:     exit,
+		Summary: "Exit the shell.",
+		Usage:   "exit [n]",
+	})
+	registerBuiltin("enable", Builtin{
+		run:     enable,
+		Summary: "Turn builtins on or off, or list them.",
+		Usage:   "enable [-an] [name ...]",
+	})
+	registerBuiltin("help", Builtin{
+		run:     help,
+		Summary: "Describe builtin commands.",
+		Usage:   "help [builtin]",
+	})
+	registerBuiltin("type", Builtin{
+		run:     type_,
+		Summary: "Describe how each name would run as a command.",
+		Usage:   "type name [name ...]",
+	})
+}
+
 type builtin struct {
-	fn   func(*builtin) error
-	args []string
+	stdio
+	fn    func(*builtin) (int, error)
+	shell *Interpreter
+	args  []string
 }
 
-func newBuiltin(name string, args []string) (*builtin, bool) {
-	switch name {
-	case "cd":
-		return &builtin{fn: cd, args: args}, true
-	case "exit":
-		return &builtin{fn: exit, args: args}, true
-	default:
+// Builtin describes a builtin command: how to run it, and how to use it.
+type Builtin struct {
+	run func(*builtin) (int, error)
+	// Summary is a one-line description of the builtin.
+	Summary string
+	// Usage shows the arguments which the builtin takes, e.g.
+	// "cd [-L|-P] [dir]".
+	Usage string
+}
+
+// builtins holds every builtin command, by name. Each one is registered by an
+// init function in the same file as its implementation.
+var builtins = make(map[string]Builtin)
+
+func registerBuiltin(name string, b Builtin) {
+	if _, ok := builtins[name]; ok {
+		panic("interpreter: builtin registered twice: " + name)
+	}
+	builtins[name] = b
+}
+
+// Builtins returns every builtin command, by name, e.g. for completing
+// command names.
+func Builtins() map[string]Builtin {
+	copied := make(map[string]Builtin, len(builtins))
+	for name, b := range builtins {
+		copied[name] = b
+	}
+	return copied
+}
+
+func newBuiltin(i *Interpreter, name string, args []string) (*builtin, bool) {
+	registered, ok := builtins[name]
+	if !ok {
 		return nil, false
 	}
+	b := &builtin{
+		stdio: stdio{i.Stdin, i.Stdout, i.Stderr, i.fds},
+		fn:    registered.run,
+		shell: i,
+		args:  args,
+	}
+	return b, true
+}
+
+// builtinEnabled reports whether name is a builtin which hasn't been turned
+// off by `enable -n`.
+func (i *Interpreter) builtinEnabled(name string) bool {
+	_, ok := builtins[name]
+	return ok && !i.disabled[name]
+}
+
+func copyDisabled(disabled map[string]bool) map[string]bool {
+	if disabled == nil {
+		return nil
+	}
+	copied := make(map[string]bool, len(disabled))
+	for name := range disabled {
+		copied[name] = true
+	}
+	return copied
 }
 
-func (b *builtin) run() error {
+func (b *builtin) run() (int, error) {
 	return b.fn(b)
 }
 
-func cd(b *builtin) error {
+// cd changes the working directory. As in other shells, `cd -` changes back
+// to $OLDPWD, and a relative directory is looked for in each directory in
+// $CDPATH. In either case, the new directory is printed, since it may not be
+// obvious.
+//
+// By default, or with `-L`, cd follows the logical path to the directory, in
+// which `..` removes the last directory from $PWD, even if that was a
+// symbolic link. With `-P`, it follows the physical path instead, as the
+// kernel would, and sets $PWD with any symbolic links resolved.
+func cd(b *builtin) (int, error) {
+	args := b.args
+	physical := false
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				err := fmt.Errorf(
+					"cd: -%c: invalid option", flag)
+				return 2, err
+			}
+		}
+	}
 	var target string
-	switch len(b.args) {
+	var print bool
+	switch len(args) {
 	case 0:
 		var err error
-		target, err = os.UserHomeDir()
+		target, err = b.shell.homeDir()
 		if err != nil {
-			return fmt.Errorf("cd: %w", err)
+			return 1, fmt.Errorf("cd: %w", err)
 		}
 	case 1:
-		target = b.args[0]
+		target = args[0]
 		if target == "-" {
 			var ok bool
-			target, ok = os.LookupEnv("OLDPWD")
+			target, ok = b.shell.getenv("OLDPWD")
 			if !ok {
-				return fmt.Errorf("cd: OLDPWD not set")
+				return 1, fmt.Errorf("cd: OLDPWD not set")
 			}
+			print = true
+		} else if dir, ok := b.shell.searchCDPATH(target); ok {
+			target, print = dir, true
 		}
 	default:
-		return errors.New("cd: too many arguments")
+		return 1, errors.New("cd: too many arguments")
+	}
+	oldpwd, _ := b.shell.getenv("PWD")
+	// The logical path has `..` remove the last directory from it.
+	newpwd, err := b.shell.abs(target)
+	if err != nil {
+		return 1, fmt.Errorf("cd: %w", err)
+	}
+	if physical {
+		// Resolve symbolic links before `..`, as the kernel would.
+		// If that fails, chdir will report why.
+		path := target
+		if !filepath.IsAbs(path) {
+			wd, err := b.shell.getwd()
+			if err != nil {
+				return 1, fmt.Errorf("cd: %w", err)
+			}
+			path = wd + string(filepath.Separator) + path
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			newpwd = resolved
+		}
 	}
-	oldpwd := os.Getenv("PWD")
-	newpwd, _ := filepath.Abs(target)
-	if err := os.Chdir(target); err != nil {
-		return fmt.Errorf("cd: %w", err)
+	if err := b.shell.chdir(newpwd); err != nil {
+		// Report the directory as it was given, not the absolute
+		// path.
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			pathErr.Path = target
+		}
+		return 1, fmt.Errorf("cd: %w", err)
+	}
+	b.shell.setenv("OLDPWD", oldpwd)
+	if err := b.shell.setenv("PWD", newpwd); err != nil {
+		return 1, err
+	}
+	if print {
+		if _, err := fmt.Fprintln(b.out, newpwd); err != nil {
+			return 1, err
+		}
 	}
-	os.Setenv("OLDPWD", oldpwd)
-	return os.Setenv("PWD", newpwd)
+	return 0, nil
+}
+
+// searchCDPATH looks for a directory in each of the directories in $CDPATH,
+// returning the first match. Directories which are absolute, or start with
+// `.` or `..`, aren't looked for. An empty entry in $CDPATH stands for the
+// working directory, where cd would look for the directory anyway, so a
+// match there isn't returned.
+func (i *Interpreter) searchCDPATH(dir string) (string, bool) {
+	first := strings.SplitN(dir, string(filepath.Separator), 2)[0]
+	if filepath.IsAbs(dir) || first == "." || first == ".." {
+		return "", false
+	}
+	cdpath, _ := i.getenv("CDPATH")
+	for _, path := range filepath.SplitList(cdpath) {
+		found := filepath.Join(path, dir)
+		info, err := i.stat(found)
+		if err == nil && info.IsDir() {
+			return found, path != ""
+		}
+	}
+	return "", false
+}
+
+// getwd returns the working directory. It starts as the working directory of
+// the process, but cd only changes it for the interpreter, so that commands
+// running concurrently, e.g. in a pipeline, don't affect one another.
+func (i *Interpreter) getwd() (string, error) {
+	if i.dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		i.dir = wd
+	}
+	return i.dir, nil
+}
+
+// chdir changes the working directory of the interpreter.
+func (i *Interpreter) chdir(dir string) error {
+	abs, err := i.abs(dir)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err == nil && !info.IsDir() {
+		err = syscall.ENOTDIR
+	}
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return &os.PathError{Op: "chdir", Path: dir, Err: err}
+	}
+	i.dir = abs
+	return nil
+}
+
+// abs returns an absolute version of a path, relative to the working
+// directory. An empty path, which names no file, is left empty.
+func (i *Interpreter) abs(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	} else if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	wd, err := i.getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(wd, path), nil
+}
+
+// stat is like os.Stat, but relative to the working directory.
+func (i *Interpreter) stat(name string) (os.FileInfo, error) {
+	path, err := i.abs(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+// enable turns the named builtins back on, or off with `-n`, so that an
+// external command of the same name runs instead. Without any names, it lists
+// the builtins which are on, or off with `-n`, or all of them with `-a`.
+func enable(b *builtin) (int, error) {
+	args := b.args
+	var all, off bool
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'a':
+				all = true
+			case 'n':
+				off = true
+			default:
+				err := fmt.Errorf(
+					"enable: -%c: invalid option", flag)
+				return 2, err
+			}
+		}
+	}
+	if len(args) == 0 {
+		return b.listEnabled(all, off)
+	}
+	status := 0
+	for _, name := range args {
+		if _, ok := builtins[name]; !ok {
+			fmt.Fprintf(b.err,
+				"mesh: enable: %s: not a shell builtin\n", name)
+			status = 1
+		} else if off {
+			if b.shell.disabled == nil {
+				b.shell.disabled = make(map[string]bool)
+			}
+			b.shell.disabled[name] = true
+		} else {
+			delete(b.shell.disabled, name)
+		}
+	}
+	return status, nil
+}
+
+// listEnabled lists the builtins which are on, or off if off is set, or all of
+// them if all is set, as the commands which would turn them on or off.
+func (b *builtin) listEnabled(all, off bool) (int, error) {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		disabled := b.shell.disabled[name]
+		if !all && disabled != off {
+			continue
+		}
+		command := "enable " + name
+		if disabled {
+			command = "enable -n " + name
+		}
+		if _, err := fmt.Fprintln(b.out, command); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// help lists every builtin with its summary, or describes the given builtins
+// in more detail.
+func help(b *builtin) (int, error) {
+	if len(b.args) == 0 {
+		names := make([]string, 0, len(builtins))
+		width := 0
+		for name := range builtins {
+			names = append(names, name)
+			if len(name) > width {
+				width = len(name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			_, err := fmt.Fprintf(b.out, "%-*s  %s\n",
+				width, name, builtins[name].Summary)
+			if err != nil {
+				return 1, err
+			}
+		}
+		return 0, nil
+	}
+	for _, name := range b.args {
+		registered, ok := builtins[name]
+		if !ok {
+			return 1, fmt.Errorf("help: %s: no such builtin", name)
+		}
+		_, err := fmt.Fprintf(b.out, "%s: %s\n    %s\n",
+			name, registered.Usage, registered.Summary)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// type_ describes whether each name would run a function, a builtin or an
+// external command. Names which wouldn't run anything are reported, and make
+// it fail.
+func type_(b *builtin) (int, error) {
+	status := 0
+	for _, name := range b.args {
+		var err error
+		if _, ok := b.shell.funcs[name]; ok {
+			_, err = fmt.Fprintf(b.out, "%s is a function\n", name)
+		} else if b.shell.builtinEnabled(name) {
+			_, err = fmt.Fprintf(
+				b.out, "%s is a shell builtin\n", name)
+		} else if path, lookErr := exec.LookPath(name); lookErr == nil {
+			_, err = fmt.Fprintf(b.out, "%s is %s\n", name, path)
+		} else {
+			fmt.Fprintf(b.err, "mesh: type: %s: not found\n", name)
+			status = 1
+		}
+		if err != nil {
+			return 1, err
+		}
+	}
+	return status, nil
 }
 
 type ExitStatus int
@@ -78,17 +440,25 @@ func (e ExitStatus) Error() string {
 	return fmt.Sprintf("exit %d", int(e))
 }
 
-func exit(b *builtin) error {
+// exit exits the shell. An interactive shell warns about any jobs which are
+// still running instead, and only exits if `exit` is run again.
+func exit(b *builtin) (int, error) {
+	if shell := b.shell; shell.Interactive && !shell.exitWarned &&
+		shell.runningJobs() {
+		shell.exitWarned = true
+		fmt.Fprintln(b.err, "There are running jobs.")
+		return 1, nil
+	}
 	switch len(b.args) {
 	case 0:
-		return ExitStatus(0)
+		return 0, ExitStatus(0)
 	case 1:
 		i, err := strconv.Atoi(b.args[0])
 		if err != nil {
-			return errors.New("exit: integer argument required")
+			return 1, errors.New("exit: integer argument required")
 		}
-		return ExitStatus(i)
+		return i, ExitStatus(i)
 	default:
-		return errors.New("exit: too many arguments")
+		return 1, errors.New("exit: too many arguments")
 	}
 }
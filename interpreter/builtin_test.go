@@ -17,11 +17,18 @@ package interpreter
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/ast"
 )
 
 func TestBuiltinCD(t *testing.T) {
@@ -45,14 +52,15 @@ func TestBuiltinCD(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			b, ok := newBuiltin("cd", test.args)
+			interp := &Interpreter{}
+			b, ok := newBuiltin(interp, "cd", test.args)
 			require.True(t, ok)
-			err := b.run()
+			_, err := b.run()
 			if test.target == "" {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				dir, err := os.Getwd()
+				dir, err := interp.getwd()
 				require.NoError(t, err)
 				assert.Equal(t, test.target, dir)
 			}
@@ -60,6 +68,1057 @@ func TestBuiltinCD(t *testing.T) {
 	}
 }
 
+func TestBuiltinCDSymlinks(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	// The temporary directory may itself be reached through a symlink.
+	physical, err := filepath.EvalSymlinks(tempdir)
+	require.NoError(t, err)
+	var process Interpreter
+	defer process.restoreEnv("PWD")()
+	defer process.restoreEnv("OLDPWD")()
+	realDir := filepath.Join(tempdir, "real")
+	require.NoError(t, os.MkdirAll(filepath.Join(realDir, "sub"), 0700))
+	link := filepath.Join(tempdir, "link")
+	require.NoError(t, os.Symlink(realDir, link))
+
+	tests := []struct {
+		name string
+		args []string
+		pwd  string
+	}{
+		{
+			"Logical", []string{filepath.Join(link, "sub")},
+			link + "/sub",
+		},
+		{"LogicalParent", []string{"-L", ".."}, link},
+		{"Physical", []string{"-P", "sub"}, physical + "/real/sub"},
+		{"PhysicalParent", []string{"-LP", ".."}, physical + "/real"},
+	}
+	interp := &Interpreter{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, ok := newBuiltin(interp, "cd", test.args)
+			require.True(t, ok)
+			_, err := b.run()
+			require.NoError(t, err)
+			pwd := os.Getenv("PWD")
+			assert.Equal(t, test.pwd, pwd)
+			// $PWD must be the same directory as the interpreter's
+			// working directory.
+			want, err := os.Stat(pwd)
+			require.NoError(t, err)
+			got, err := interp.stat(".")
+			require.NoError(t, err)
+			assert.True(t, os.SameFile(want, got))
+		})
+	}
+
+	b, ok := newBuiltin(&Interpreter{}, "cd", []string{"-x"})
+	require.True(t, ok)
+	status, err := b.run()
+	assert.Equal(t, 2, status)
+	assert.EqualError(t, err, "cd: -x: invalid option")
+}
+
 func TestExitStatusError(t *testing.T) {
 	assert.Equal(t, "exit 2", ExitStatus(2).Error())
 }
+
+func TestExitWithRunningJobs(t *testing.T) {
+	for name, interactive := range map[string]bool{
+		"Script":      false,
+		"Interactive": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var stderr strings.Builder
+			interp := &Interpreter{
+				Stdout:      ioutil.Discard,
+				Stderr:      &stderr,
+				Interactive: interactive,
+			}
+			bg := &ast.Background{Stmt: &ast.Cmd{Argv: []ast.Expr{
+				ast.String{Text: "sleep"},
+				ast.String{Text: "1"},
+			}}}
+			_, err := interp.VisitBackground(bg)
+			require.NoError(t, err)
+			exit := func() (int, error) {
+				b, ok := newBuiltin(
+					interp, "exit", []string{"2"})
+				require.True(t, ok)
+				return b.run()
+			}
+			if interactive {
+				// The first exit only warns about the job.
+				status, err := exit()
+				assert.NoError(t, err)
+				assert.Equal(t, 1, status)
+				assert.Equal(t, "[1] "+strconv.Itoa(
+					interp.jobs[0].pid)+"\n"+
+					"There are running jobs.\n",
+					stderr.String())
+			}
+			status, err := exit()
+			assert.Equal(t, ExitStatus(2), err)
+			assert.Equal(t, 2, status)
+		})
+	}
+}
+
+func TestBuiltinTestFileComparison(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	older := filepath.Join(tempdir, "older")
+	newer := filepath.Join(tempdir, "newer")
+	missing := filepath.Join(tempdir, "missing")
+	now := time.Now()
+	for path, mtime := range map[string]time.Time{
+		older: now.Add(-time.Hour),
+		newer: now,
+	} {
+		require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+	}{
+		{"NewerThan", []string{newer, "-nt", older}, 0},
+		{"NotNewerThan", []string{older, "-nt", newer}, 1},
+		{"NewerThanMissing", []string{newer, "-nt", missing}, 0},
+		{"MissingNewerThan", []string{missing, "-nt", newer}, 1},
+		{"OlderThan", []string{older, "-ot", newer}, 0},
+		{"NotOlderThan", []string{newer, "-ot", older}, 1},
+		{"OlderThanMissing", []string{older, "-ot", missing}, 1},
+		{"MissingOlderThan", []string{missing, "-ot", older}, 0},
+		{"SameFile", []string{older, "-ef", older}, 0},
+		{"DifferentFiles", []string{older, "-ef", newer}, 1},
+		{"SameMissingFile", []string{missing, "-ef", missing}, 1},
+		{"Negated", []string{"!", older, "-ef", newer}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, ok := newBuiltin(&Interpreter{}, "test", test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			assert.NoError(t, err)
+			assert.Equal(t, test.status, status)
+		})
+	}
+}
+
+func TestBuiltinTest(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    string
+		args   []string
+		status int
+	}{
+		{"NoArgs", "test", []string{}, 1},
+		{"NonEmptyString", "test", []string{"x"}, 0},
+		{"EmptyString", "test", []string{""}, 1},
+		{"StringsEqual", "test", []string{"a", "=", "a"}, 0},
+		{"StringsNotEqual", "test", []string{"a", "!=", "a"}, 1},
+		{"IntsLessThan", "test", []string{"1", "-lt", "2"}, 0},
+		{"IsDirectory", "test", []string{"-d", os.TempDir()}, 0},
+		{"Bracket", "[", []string{"a", "=", "a", "]"}, 0},
+		{"BracketUnclosed", "[", []string{"a", "=", "a"}, 2},
+		{"BadUnaryOperator", "test", []string{"-y", "a"}, 2},
+		{"BadBinaryOperator", "test", []string{"a", "-y", "b"}, 2},
+		{"NotAnInteger", "test", []string{"a", "-eq", "1"}, 2},
+		{"TooManyArgs", "test", []string{"a", "b", "c", "d"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, ok := newBuiltin(&Interpreter{}, test.cmd, test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			assert.Equal(t, test.status, status)
+			if test.status == 2 {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuiltinHash(t *testing.T) {
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+	var stdout, stderr strings.Builder
+	interp := &Interpreter{Stdout: &stdout, Stderr: &stderr}
+	run := func(argv ...string) (int, error) {
+		var exprs []ast.Expr
+		for _, text := range argv {
+			exprs = append(exprs, ast.String{Text: text})
+		}
+		return interp.VisitCmd(&ast.Cmd{Argv: exprs})
+	}
+	hashOutput := func(argv ...string) string {
+		stdout.Reset()
+		status, err := run(append([]string{"hash"}, argv...)...)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+		return stdout.String()
+	}
+
+	assert.Equal(t, "hash: hash table empty\n", hashOutput())
+	for n := 0; n < 2; n++ {
+		status, err := run("true")
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+	}
+	assert.Equal(t, "hits\tcommand\n   2\t"+truePath+"\n", hashOutput())
+	assert.Equal(t, "", hashOutput("-r"))
+	assert.Equal(t, "hash: hash table empty\n", hashOutput())
+	assert.Equal(t, "", hashOutput("true"))
+	assert.Equal(t, "hits\tcommand\n   0\t"+truePath+"\n", hashOutput())
+
+	// Changing $PATH invalidates the table.
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	require.NoError(t, os.Setenv("PATH", path+string(os.PathListSeparator)))
+	status, err := run("true")
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	assert.Equal(t, "hits\tcommand\n   1\t"+truePath+"\n", hashOutput())
+
+	status, err = run("hash", "nonexistent-mesh-command")
+	assert.Equal(t, 1, status)
+	assert.EqualError(t, err, "hash: nonexistent-mesh-command: not found")
+}
+
+func TestBuiltinHashShared(t *testing.T) {
+	// Interpreters which share a table may use it at the same time, which
+	// the race detector checks for.
+	interp := &Interpreter{hash: &hashTable{}}
+	other := &Interpreter{hash: interp.hash}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 100; n++ {
+			_, err := other.lookPath("true")
+			assert.NoError(t, err)
+		}
+	}()
+	for n := 0; n < 100; n++ {
+		b, ok := newBuiltin(interp, "hash", []string{"true"})
+		require.True(t, ok)
+		_, err := b.run()
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+	assert.Equal(t, 100, interp.hash.entries["true"].hits)
+}
+
+func TestBuiltinTimeout(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		args   []string
+		status int
+		stdout string
+		err    string
+	}{
+		{
+			"Finished", []string{"1", "sh", "-c", "echo a; exit 3"},
+			3, "a\n", "exit status 3",
+		},
+		{"TimedOut", []string{"0.1", "sleep", "10"}, 124, "", ""},
+		{
+			// The shell waits for sleep, which is in the same
+			// process group, so is signalled along with it.
+			"Children", []string{
+				"0.1s", "sh", "-c", "sleep 10; echo a",
+			},
+			124, "", "",
+		},
+		{
+			"Signal", []string{"-s", "KILL", "0.1", "sleep", "10"},
+			137, "", "",
+		},
+		{
+			// The ignored SIGTERM is ignored by sleep as well.
+			"KillAfter", []string{
+				"-k", "0.1", "0.1", "sh", "-c",
+				"trap '' TERM; sleep 10",
+			},
+			137, "", "",
+		},
+		{"NoLimit", []string{"0", "echo", "a"}, 0, "a\n", ""},
+		{
+			"InvalidDuration", []string{"1x", "true"}, 125, "",
+			"timeout: 1x: invalid time interval",
+		},
+		{
+			"InvalidSignal", []string{"-s", "NONE", "1", "true"},
+			125, "", "timeout: NONE: invalid signal",
+		},
+		{
+			"MissingOperand", []string{"1"}, 125, "",
+			"timeout: missing operand",
+		},
+		{
+			"NotFound", []string{"1", "nonexistent-mesh-command"},
+			127, "", "nonexistent-mesh-command: command not found",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout strings.Builder
+			interp := &Interpreter{Stdout: &stdout, Stderr: &stdout}
+			b, ok := newBuiltin(interp, "timeout", test.args)
+			require.True(t, ok)
+			start := time.Now()
+			status, err := b.run()
+			assert.True(t, time.Since(start) < 5*time.Second)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.stdout, stdout.String())
+		})
+	}
+}
+
+func TestBuiltinFg(t *testing.T) {
+	// Jobs run concurrently, so discard their output rather than write it
+	// to an unsynchronised strings.Builder.
+	interp := &Interpreter{Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+	background := func(argv ...string) {
+		var exprs []ast.Expr
+		for _, text := range argv {
+			exprs = append(exprs, ast.String{Text: text})
+		}
+		bg := &ast.Background{Stmt: &ast.Cmd{Argv: exprs}}
+		_, err := interp.VisitBackground(bg)
+		require.NoError(t, err)
+	}
+	fg := func(args ...string) (int, error) {
+		b, ok := newBuiltin(interp, "fg", args)
+		require.True(t, ok)
+		return b.run()
+	}
+
+	status, err := fg()
+	assert.Equal(t, 127, status)
+	assert.EqualError(t, err, "fg: %%: no such job")
+
+	background("sh", "-c", "sleep 0.1; exit 3")
+	background("sh", "-c", "exit 4")
+	status, err = fg("%5")
+	assert.Equal(t, 127, status)
+	assert.Error(t, err)
+	status, err = fg("%1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, status)
+	require.Len(t, interp.jobs, 1)
+	status, err = fg()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, status)
+	assert.Empty(t, interp.jobs)
+}
+
+func TestBuiltinEnv(t *testing.T) {
+	tests := []struct {
+		name   string
+		argv   []string
+		status int
+		stdout string
+		err    string
+	}{
+		{"Empty", []string{"-i"}, 0, "", ""},
+		{
+			"Sorted", []string{"-i", "B=2", "A=1", "B=3"},
+			0, "A=1\nB=3\n", "",
+		}, {
+			"RunCommand", []string{"-i", "X=y", "printenv", "X"},
+			0, "y\n", "",
+		}, {
+			"AddsToEnvironment",
+			[]string{"MESH_ENV=1", "printenv", "MESH_ENV"},
+			0, "1\n", "",
+		}, {
+			"CommandStatus", []string{"-i", "false"},
+			1, "", "exit status 1",
+		}, {
+			"BadOption", []string{"-x"},
+			125, "", "env: -x: invalid option",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout strings.Builder
+			interp := &Interpreter{Stdout: &stdout, Stderr: &stdout}
+			exprs := []ast.Expr{ast.String{Text: "env"}}
+			for _, text := range test.argv {
+				exprs = append(exprs, ast.String{Text: text})
+			}
+			status, err := interp.VisitCmd(&ast.Cmd{Argv: exprs})
+			assert.Equal(t, test.status, status)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.stdout, stdout.String())
+		})
+	}
+	_, ok := os.LookupEnv("MESH_ENV")
+	assert.False(t, ok, "env shouldn't change the shell's environment")
+}
+
+func TestBuiltinGetopts(t *testing.T) {
+	type result struct {
+		status int
+		opt    string
+		optarg string
+		optind string
+		stderr string
+	}
+	tests := []struct {
+		name      string
+		optstring string
+		args      []string
+		results   []result
+	}{
+		{
+			"Grouped", "ab:c", []string{"-ac", "-bfoo", "x"},
+			[]result{
+				{0, "a", "", "1", ""},
+				{0, "c", "", "2", ""},
+				{0, "b", "foo", "3", ""},
+				{1, "?", "", "3", ""},
+			},
+		}, {
+			"SeparateArgument", "b:",
+			[]string{"-b", "-c", "--", "-b"},
+			[]result{
+				{0, "b", "-c", "3", ""},
+				{1, "?", "", "4", ""},
+			},
+		}, {
+			"InvalidOption", "a", []string{"-x"},
+			[]result{
+				{
+					0, "?", "", "2",
+					"mesh: getopts: -x: invalid option\n",
+				},
+				{1, "?", "", "2", ""},
+			},
+		}, {
+			"MissingArgument", "b:", []string{"-b"},
+			[]result{{
+				0, "?", "", "2",
+				"mesh: getopts: -b: option requires an " +
+					"argument\n",
+			}},
+		}, {
+			"Silent", ":b:", []string{"-x", "-b"},
+			[]result{
+				{0, "?", "x", "2", ""},
+				{0, ":", "b", "3", ""},
+			},
+		}, {
+			"NoOptions", "a", []string{"-", "-a"},
+			[]result{{1, "?", "", "1", ""}},
+		},
+	}
+	defer os.Unsetenv("OPTIND")
+	defer os.Unsetenv("OPTARG")
+	defer os.Unsetenv("opt")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, os.Setenv("OPTIND", "1"))
+			var stderr strings.Builder
+			interp := &Interpreter{Stderr: &stderr, Args: test.args}
+			cmd := &ast.Cmd{Argv: []ast.Expr{
+				ast.String{Text: "getopts"},
+				ast.String{Text: test.optstring},
+				ast.String{Text: "opt"},
+			}}
+			for _, want := range test.results {
+				stderr.Reset()
+				status, err := interp.VisitCmd(cmd)
+				require.NoError(t, err)
+				assert.Equal(t, want, result{
+					status,
+					os.Getenv("opt"),
+					os.Getenv("OPTARG"),
+					os.Getenv("OPTIND"),
+					stderr.String(),
+				})
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	var process Interpreter
+	defer process.restoreEnv("HISTSIZE")()
+	require.NoError(t, os.Setenv("HISTSIZE", "3"))
+	h := &History{}
+	for _, line := range []string{"a", "b", "c", "d"} {
+		h.Add(line)
+	}
+	lines, first := h.Lines()
+	assert.Equal(t, []string{"b", "c", "d"}, lines)
+	assert.Equal(t, 2, first)
+
+	// The oldest lines are dropped if $HISTSIZE shrinks, but the history
+	// only grows once there are new lines.
+	require.NoError(t, os.Setenv("HISTSIZE", "5"))
+	h.Add("e")
+	require.NoError(t, os.Setenv("HISTSIZE", "2"))
+	h.Add("f")
+	lines, first = h.Lines()
+	assert.Equal(t, []string{"e", "f"}, lines)
+	assert.Equal(t, 5, first)
+
+	require.NoError(t, os.Setenv("HISTSIZE", "0"))
+	h.Add("g")
+	lines, first = h.Lines()
+	assert.Empty(t, lines)
+	assert.Equal(t, 8, first)
+
+	// An interpreter's own $HISTSIZE counts rather than the process's.
+	interp, err := NewInterpreter(Config{Env: []string{"HISTSIZE=1"}})
+	require.NoError(t, err)
+	h = &History{LookupVar: interp.LookupVar}
+	h.Add("a")
+	h.Add("b")
+	lines, _ = h.Lines()
+	assert.Equal(t, []string{"b"}, lines)
+}
+
+func TestBuiltinHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		stdout string
+		err    string
+	}{
+		{"All", nil, 0, "    1  a\n    2  b\n    3  c\n", ""},
+		{"Last", []string{"2"}, 0, "    2  b\n    3  c\n", ""},
+		{"Clear", []string{"-c"}, 0, "", ""},
+		{
+			"NotNumeric", []string{"x"}, 1, "",
+			"history: x: numeric argument required",
+		},
+		{
+			"BadOption", []string{"-x"}, 2, "",
+			"history: -x: invalid option",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &History{}
+			for _, line := range []string{"a", "b", "c"} {
+				h.Add(line)
+			}
+			var stdout strings.Builder
+			interp := &Interpreter{Stdout: &stdout, History: h}
+			b, ok := newBuiltin(interp, "history", test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			assert.Equal(t, test.status, status)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.stdout, stdout.String())
+		})
+	}
+}
+
+func TestBuiltinFc(t *testing.T) {
+	edit := []string{"-e", "sed -i s/b/B/"}
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		stdout string
+		err    string
+	}{
+		{"Edit", edit, 0, "echo B\nB\n", ""},
+		{"EditOffset", append(edit, "-2"), 0, "echo a\na\n", ""},
+		{
+			"EditRange", append(edit, "echo a", "2"), 0,
+			"echo a\necho B\na\nB\n", "",
+		},
+		{"EditorFromEnv", nil, 0, "echo b!\nb!\n", ""},
+		{
+			"EditorFails", []string{"-e", "false"}, 1, "",
+			"fc: false exited with status 1",
+		},
+		{
+			"List", []string{"-l"}, 0,
+			"    1  echo a\n    2  echo b\n", "",
+		},
+		{"ListFrom", []string{"-l", "2"}, 0, "    2  echo b\n", ""},
+		{
+			"NoSuchLine", []string{"-l", "x"}, 1, "",
+			"fc: x: no such line in the history",
+		},
+		{
+			"NoEditor", []string{"-e"}, 2, "",
+			"fc: -e: option requires an argument",
+		},
+		{"BadOption", []string{"-x"}, 2, "", "fc: -x: invalid option"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &History{}
+			h.Add("echo a")
+			h.Add("echo b")
+			h.Add("fc")
+			var stdout strings.Builder
+			interp, err := NewInterpreter(Config{
+				Stdout: &stdout,
+				Env: []string{
+					"PATH=" + os.Getenv("PATH"),
+					"EDITOR=sed -i s/b/b!/",
+				},
+			})
+			require.NoError(t, err)
+			interp.History = h
+			b, ok := newBuiltin(interp, "fc", test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			assert.Equal(t, test.status, status)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.stdout, stdout.String())
+		})
+	}
+}
+
+func TestBuiltinRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		ifs    []string
+		args   []string
+		status int
+		vars   map[string]string
+	}{
+		{
+			"Reply", "  a  b \\c\n", nil, nil, 0,
+			map[string]string{"REPLY": "  a  b c"},
+		},
+		{
+			"Whitespace", "  a  b   c d  \n", nil,
+			[]string{"x", "y"}, 0,
+			map[string]string{"x": "a", "y": "b   c d"},
+		},
+		{
+			"MoreNamesThanFields", "a\n", nil,
+			[]string{"x", "y"}, 0,
+			map[string]string{"x": "a", "y": ""},
+		},
+		{
+			"Colons", "x:y:z\n", []string{"IFS=:"},
+			[]string{"a", "b", "c"}, 0,
+			map[string]string{"a": "x", "b": "y", "c": "z"},
+		},
+		{
+			"EmptyFields", "::z :\n", []string{"IFS=: "},
+			[]string{"a", "b", "c"}, 0,
+			map[string]string{"a": "", "b": "", "c": "z :"},
+		},
+		{
+			"EmptyIFS", " a b \n", []string{"IFS="},
+			[]string{"a", "b"}, 0,
+			map[string]string{"a": " a b ", "b": ""},
+		},
+		{
+			"Escapes", "a\\ b \\\nc\n", nil, []string{"x", "y"}, 0,
+			map[string]string{"x": "a b", "y": "c"},
+		},
+		{
+			"Raw", "a\\ b \\\nc\n", nil,
+			[]string{"-r", "x", "y"}, 0,
+			map[string]string{"x": "a\\", "y": "b \\"},
+		},
+		{
+			"EOF", "a b", nil, []string{"x", "y"}, 1,
+			map[string]string{"x": "a", "y": "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			interp, err := NewInterpreter(Config{
+				Stdin: strings.NewReader(test.input),
+				Env:   test.ifs,
+			})
+			require.NoError(t, err)
+			b, ok := newBuiltin(interp, "read", test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			require.NoError(t, err)
+			assert.Equal(t, test.status, status)
+			for name, value := range test.vars {
+				v, _ := interp.lookupVar(name)
+				assert.Equal(t, value, v, name)
+			}
+		})
+	}
+}
+
+func TestBuiltinReadLeavesRest(t *testing.T) {
+	interp := &Interpreter{Stdin: strings.NewReader("a\nb\n")}
+	defer os.Unsetenv("meshshell_test_read")
+	for _, want := range []string{"a", "b"} {
+		b, ok := newBuiltin(interp, "read", []string{
+			"meshshell_test_read",
+		})
+		require.True(t, ok)
+		status, err := b.run()
+		require.NoError(t, err)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, want, os.Getenv("meshshell_test_read"))
+	}
+}
+
+func TestBuiltinMapfile(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    string
+		builtin  string
+		args     []string
+		status   int
+		array    string
+		elements []string
+		err      string
+	}{
+		{
+			"Default", "a b\n\nc\\\n", "mapfile", nil, 0,
+			"MAPFILE", []string{"a b", "", "c\\"}, "",
+		},
+		{
+			"Named", "a\nb", "readarray", []string{"lines"}, 0,
+			"lines", []string{"a", "b"}, "",
+		},
+		{
+			"KeepNewlines", "a\nb", "mapfile",
+			[]string{"+t", "x"}, 0,
+			"x", []string{"a\n", "b"}, "",
+		},
+		{
+			"Trim", "a\n", "mapfile",
+			[]string{"+t", "-t", "x"}, 0,
+			"x", []string{"a"}, "",
+		},
+		{
+			"Count", "a\nb\nc\n", "mapfile",
+			[]string{"-n", "2", "x"}, 0,
+			"x", []string{"a", "b"}, "",
+		},
+		{
+			"CountPastEOF", "a\n", "mapfile",
+			[]string{"-n", "5", "x"}, 0,
+			"x", []string{"a"}, "",
+		},
+		{
+			"Empty", "", "mapfile", []string{"x"}, 0, "x", nil, "",
+		},
+		{
+			"InvalidOption", "", "mapfile", []string{"-x"}, 2,
+			"", nil, "mapfile: -x: invalid option",
+		},
+		{
+			"InvalidCount", "", "mapfile", []string{"-n", "x"}, 2,
+			"", nil, "mapfile: x: invalid number",
+		},
+		{
+			"MissingCount", "", "mapfile", []string{"-n"}, 2,
+			"", nil, "mapfile: -n: option requires an argument",
+		},
+		{
+			"BadFd", "", "mapfile", []string{"-u", "9"}, 1,
+			"", nil, "mapfile: 9: invalid file descriptor",
+		},
+		{
+			"InvalidName", "", "mapfile", []string{"a-b"}, 1,
+			"", nil, "mapfile: a-b: not a valid identifier",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			interp, err := NewInterpreter(Config{
+				Stdin: strings.NewReader(test.input),
+			})
+			require.NoError(t, err)
+			b, ok := newBuiltin(interp, test.builtin, test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			assert.Equal(t, test.status, status)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t,
+				test.elements, interp.vars[test.array].array)
+		})
+	}
+}
+
+// TestBuiltinMapfileLeavesRest checks that mapfile only reads the lines it
+// needs, leaving the rest of the input for the next command.
+func TestBuiltinMapfileLeavesRest(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = w.WriteString("a\nb\nc\n")
+	require.NoError(t, err)
+	w.Close()
+	interp, err := NewInterpreter(Config{})
+	require.NoError(t, err)
+	args := []string{"-n", "1", "-u", "3"}
+	b, ok := newBuiltin(interp, "mapfile", args)
+	require.True(t, ok)
+	b.extra = map[int]*os.File{3: r}
+	_, err = b.run()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, interp.vars["MAPFILE"].array)
+	b, _ = newBuiltin(interp, "mapfile", []string{"-u", "3"})
+	b.extra = map[int]*os.File{3: r}
+	_, err = b.run()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, interp.vars["MAPFILE"].array)
+}
+
+func TestBuiltinSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		status  int
+		stdout  string
+		err     string
+		options Options
+	}{
+		{
+			"Show", nil, 0,
+			"autocd         off\n" +
+				"dotglob        off\nemacs          on\n" +
+				"errexit        off\nextglob        off\n" +
+				"failglob       off\n" +
+				"noclobber      on\nnounset        off\n" +
+				"nullglob       off\npipefail       off\n" +
+				"posix          off\nsuggest        off\n" +
+				"vi             off\n",
+			"", Options{Noclobber: true},
+		},
+		{
+			"Vi", []string{"-o", "vi"}, 0, "", "",
+			Options{Noclobber: true, Vi: true},
+		},
+		{
+			"Emacs", []string{"-o", "vi", "-o", "emacs"}, 0, "", "",
+			Options{Noclobber: true},
+		},
+		{
+			"NoEmacs", []string{"+o", "emacs"}, 0, "", "",
+			Options{Noclobber: true, Vi: true},
+		},
+		{
+			"On", []string{"-o", "errexit", "-o", "pipefail"},
+			0, "", "",
+			Options{
+				Errexit:   true,
+				Noclobber: true,
+				Pipefail:  true,
+			},
+		},
+		{"Off", []string{"+o", "noclobber"}, 0, "", "", Options{}},
+		{
+			"Letters", []string{"-eu", "+e", "-o", "pipefail"},
+			0, "", "",
+			Options{
+				Noclobber: true,
+				Nounset:   true,
+				Pipefail:  true,
+			},
+		},
+		{
+			"BadName", []string{"-o", "x"}, 1, "",
+			"set: x: invalid option name", Options{Noclobber: true},
+		},
+		{
+			"BadOption", []string{"-x"}, 2, "",
+			"set: -x: invalid option", Options{Noclobber: true},
+		},
+		{
+			"BadLetter", []string{"-ux"}, 2, "",
+			"set: -ux: invalid option", Options{Noclobber: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout strings.Builder
+			interp := &Interpreter{
+				Stdout:  &stdout,
+				Options: Options{Noclobber: true},
+			}
+			b, ok := newBuiltin(interp, "set", test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			assert.Equal(t, test.status, status)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Equal(t, test.options, interp.Options)
+		})
+	}
+}
+
+func TestBuiltinShopt(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		status  int
+		stdout  string
+		err     string
+		options Options
+	}{
+		{
+			"Query", []string{"noclobber", "nounset"}, 1,
+			"noclobber      on\nnounset        off\n",
+			"", Options{Noclobber: true},
+		},
+		{
+			"Quiet", []string{"-q", "noclobber", "emacs"}, 0, "",
+			"", Options{Noclobber: true},
+		},
+		{
+			"QuietOff", []string{"-q", "vi"}, 1, "", "",
+			Options{Noclobber: true},
+		},
+		{
+			"ListOn", []string{"-s"}, 0,
+			"emacs          on\nnoclobber      on\n",
+			"", Options{Noclobber: true},
+		},
+		{
+			"Print", []string{"-p", "vi", "noclobber"}, 1,
+			"shopt -u vi\nshopt -s noclobber\n",
+			"", Options{Noclobber: true},
+		},
+		{
+			"Set", []string{"-s", "nullglob", "pipefail"}, 0, "",
+			"", Options{
+				Noclobber: true,
+				Nullglob:  true,
+				Pipefail:  true,
+			},
+		},
+		{
+			"Unset", []string{"-u", "noclobber"}, 0, "", "",
+			Options{},
+		},
+		{
+			"SetAndUnset", []string{"-su", "vi"}, 1, "",
+			"shopt: cannot set and unset options at once",
+			Options{Noclobber: true},
+		},
+		{
+			"BadName", []string{"x"}, 1, "",
+			"shopt: x: invalid option name",
+			Options{Noclobber: true},
+		},
+		{
+			"BadOption", []string{"-o"}, 2, "",
+			"shopt: -o: invalid option", Options{Noclobber: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout strings.Builder
+			interp := &Interpreter{
+				Stdout:  &stdout,
+				Options: Options{Noclobber: true},
+			}
+			b, ok := newBuiltin(interp, "shopt", test.args)
+			require.True(t, ok)
+			status, err := b.run()
+			assert.Equal(t, test.status, status)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Equal(t, test.options, interp.Options)
+		})
+	}
+}
+
+func TestBuiltinsDescribed(t *testing.T) {
+	for name, b := range Builtins() {
+		assert.NotEmpty(t, b.Summary, name)
+		assert.True(t, strings.HasPrefix(b.Usage, name),
+			"usage of %s should start with its name", name)
+	}
+}
+
+func TestBuiltinHelp(t *testing.T) {
+	var stdout strings.Builder
+	interp := &Interpreter{Stdout: &stdout}
+	b, ok := newBuiltin(interp, "help", nil)
+	require.True(t, ok)
+	_, err := b.run()
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	assert.Len(t, lines, len(builtins))
+	assert.Contains(t, lines,
+		"cd         Change the working directory.")
+
+	stdout.Reset()
+	b, _ = newBuiltin(interp, "help", []string{"exit"})
+	_, err = b.run()
+	require.NoError(t, err)
+	assert.Equal(t,
+		"exit: exit [n]\n    Exit the shell.\n", stdout.String())
+
+	b, _ = newBuiltin(interp, "help", []string{"nonexistent"})
+	status, err := b.run()
+	assert.Equal(t, 1, status)
+	assert.EqualError(t, err, "help: nonexistent: no such builtin")
+}
+
+func TestBuiltinType(t *testing.T) {
+	ls, err := exec.LookPath("ls")
+	require.NoError(t, err)
+	var stdout, stderr strings.Builder
+	interp := &Interpreter{Stdout: &stdout, Stderr: &stderr}
+	_, err = interp.VisitFunc(&ast.Func{Name: "f"})
+	require.NoError(t, err)
+	b, ok := newBuiltin(interp, "type",
+		[]string{"f", "cd", "ls", "nonexistent"})
+	require.True(t, ok)
+	status, err := b.run()
+	assert.Equal(t, 1, status)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"f is a function\ncd is a shell builtin\nls is "+ls+"\n",
+		stdout.String())
+	assert.Equal(t,
+		"mesh: type: nonexistent: not found\n", stderr.String())
+}
@@ -12,31 +12,34 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+
+//go:build unix
+// +build unix
+
+package interpreter
 
 import (
-	"io"
-	"strings"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestNonInteractive(t *testing.T) {
-	n := newNonInteractive(strings.NewReader("one\ntwo"))
+func TestBuiltinTestHardLink(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	file := filepath.Join(tempdir, "file")
+	link := filepath.Join(tempdir, "link")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	require.NoError(t, os.Link(file, link))
 
-	// These functions are no-ops, but run them anyway so that we get points
-	// for test coverage.
-	n.setIgnoreEOF(false)
-	n.setPrompt("")
-	n.setViMode(false)
-
-	line, err := n.readLine()
-	assert.NoError(t, err)
-	assert.Equal(t, "one", line)
-	line, err = n.readLine()
+	b, ok := newBuiltin(&Interpreter{}, "test", []string{file, "-ef", link})
+	require.True(t, ok)
+	status, err := b.run()
 	assert.NoError(t, err)
-	assert.Equal(t, "two", line)
-	_, err = n.readLine()
-	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, status)
 }
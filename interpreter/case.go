@@ -0,0 +1,125 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// VisitCase runs the body of the first clause with a pattern matching the
+// case statement's word. Its status is that of the last body it runs, or 0 if
+// no pattern matches.
+func (i *Interpreter) VisitCase(c *ast.Case) (int, error) {
+	std, closeFiles, err := i.redirect(c.Redirects)
+	if err != nil {
+		return 1, err
+	}
+	defer closeFiles()
+	stdin, stdout, stderr, fds := i.Stdin, i.Stdout, i.Stderr, i.fds
+	defer func() {
+		i.Stdin, i.Stdout, i.Stderr, i.fds = stdin, stdout, stderr, fds
+	}()
+	i.Stdin, i.Stdout, i.Stderr = std.in, std.out, std.err
+	i.fds = std.extra
+	word, err := c.Word.Visit(i)
+	if err != nil {
+		return 1, err
+	}
+	status, matched := 0, false
+	for _, clause := range c.Clauses {
+		// After `;&`, the next body runs without testing its
+		// patterns.
+		if !matched {
+			matched, err = i.matchCase(word, clause)
+			if err != nil {
+				return 1, err
+			} else if !matched {
+				continue
+			}
+		}
+		status, err = clause.Body.Visit(i)
+		if err != nil {
+			return status, err
+		}
+		switch clause.Terminator {
+		case ";&":
+			continue
+		case ";;&":
+			matched = false
+			continue
+		}
+		break
+	}
+	return status, nil
+}
+
+// matchCase reports whether any of a clause's patterns matches word. Only the
+// globs written in a pattern are special, so e.g. a `*` in the value of a
+// variable only matches itself.
+func (i *Interpreter) matchCase(
+	word string, clause *ast.CaseClause) (bool, error) {
+	for _, pattern := range clause.Patterns {
+		if ok, err := i.matchPattern(word, pattern); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// matchPattern reports whether s matches a pattern, in which only the globs
+// written in the word itself are special.
+func (i *Interpreter) matchPattern(s string, expr ast.Expr) (bool, error) {
+	p, err := i.expandPattern(expr)
+	if err != nil {
+		return false, err
+	}
+	re, err := i.compilePattern(p)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", p, err)
+	}
+	return re.MatchString(s), nil
+}
+
+// expandPattern expands a word into a pattern, escaping everything in it but
+// its globs.
+func (i *Interpreter) expandPattern(expr ast.Expr) (string, error) {
+	var subExprs []ast.Expr
+	switch w := expr.(type) {
+	case *ast.Word:
+		subExprs = w.SubExprs
+	case ast.Word:
+		subExprs = w.SubExprs
+	default:
+		subExprs = []ast.Expr{expr}
+	}
+	var pattern string
+	for _, subExpr := range subExprs {
+		switch e := subExpr.(type) {
+		case ast.Glob:
+			pattern += e.Text
+		case *ast.Glob:
+			pattern += e.Text
+		default:
+			text, err := subExpr.Visit(i)
+			if err != nil {
+				return "", err
+			}
+			pattern += escapePattern(text)
+		}
+	}
+	return pattern, nil
+}
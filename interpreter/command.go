@@ -0,0 +1,117 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerBuiltin("command", Builtin{
+		run:     command,
+		Summary: "Run a command, ignoring any function of that name.",
+		Usage:   "command [-pv] name [arg ...]",
+	})
+}
+
+// DefaultPath is searched for commands by `command -p`, instead of $PATH,
+// which a script can't always trust. It can be changed when building, e.g.
+// with `-ldflags "-X $pkg.DefaultPath=/bin"`, where $pkg is the path of this
+// package, github.com/meshshell/mesh/interpreter.
+var DefaultPath = "/usr/local/sbin:/usr/local/bin:" +
+	"/usr/sbin:/usr/bin:/sbin:/bin"
+
+// command runs a builtin or an external command, even if a function has the
+// same name. With `-p`, external commands are looked for in DefaultPath rather
+// than $PATH. With `-v`, it prints how the name would be run instead: the path
+// to an external command, or just the name of a builtin or function.
+func command(b *builtin) (int, error) {
+	args := b.args
+	var defaultPath, describe bool
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'p':
+				defaultPath = true
+			case 'v':
+				describe = true
+			default:
+				err := fmt.Errorf(
+					"command: -%c: invalid option", flag)
+				return 2, err
+			}
+		}
+	}
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if describe {
+		return b.describeCommand(args, defaultPath)
+	}
+	if b.shell.builtinEnabled(args[0]) {
+		named, _ := newBuiltin(b.shell, args[0], args[1:])
+		named.stdio = b.stdio
+		return named.run()
+	}
+	path, err := b.shell.commandPath(args[0], defaultPath)
+	if err != nil {
+		return startError(args[0], err)
+	}
+	return b.shell.executePath(path, args, nil, b.stdio)
+}
+
+// describeCommand prints how each name would be run as a command, failing if
+// any of them wouldn't run anything.
+func (b *builtin) describeCommand(
+	names []string, defaultPath bool,
+) (int, error) {
+	status := 0
+	for _, name := range names {
+		_, isFunc := b.shell.funcs[name]
+		isBuiltin := b.shell.builtinEnabled(name)
+		described := name
+		if !isFunc && !isBuiltin {
+			path, err := b.shell.commandPath(name, defaultPath)
+			if err != nil {
+				status = 1
+				continue
+			}
+			described = path
+		}
+		if _, err := fmt.Fprintln(b.out, described); err != nil {
+			return 1, err
+		}
+	}
+	return status, nil
+}
+
+// commandPath returns the path to an external command, searching DefaultPath
+// for it if defaultPath is set, or $PATH otherwise.
+func (i *Interpreter) commandPath(
+	name string, defaultPath bool,
+) (string, error) {
+	if !defaultPath {
+		return i.lookPath(name)
+	} else if strings.ContainsRune(name, os.PathSeparator) {
+		return i.abs(name)
+	}
+	return i.searchPath(name, DefaultPath)
+}
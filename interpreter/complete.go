@@ -0,0 +1,228 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerBuiltin("complete", Builtin{
+		run:     complete,
+		Summary: "Define how the arguments of commands are completed.",
+		Usage:   "complete [-pr] [-W words | -F function] [name ...]",
+	})
+}
+
+// completion says how to complete the arguments of a command: from a list of
+// words, or with whatever a function puts in $COMPREPLY.
+type completion struct {
+	words    string
+	function string
+}
+
+// String formats the completion as the command which would define it.
+func (c completion) String() string {
+	if c.function != "" {
+		return "complete -F " + quoteWord(c.function)
+	}
+	return "complete -W " + quoteWord(c.words)
+}
+
+// complete defines the completion of each named command, with `-W` giving
+// the words to complete its arguments from, separated by whitespace, or `-F`
+// the function to generate them. `-r` removes the completions of the named
+// commands, or of every command. Otherwise it prints them, as with `-p`.
+func complete(b *builtin) (int, error) {
+	var c completion
+	remove := false
+	args := b.args
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		flag := args[0]
+		if flag == "--" {
+			args = args[1:]
+			break
+		}
+		switch flag {
+		case "-W", "-F":
+			if len(args) == 1 {
+				return 2, fmt.Errorf(
+					"complete: %s: option requires an "+
+						"argument", flag)
+			} else if flag == "-W" {
+				c.words = args[1]
+			} else {
+				c.function = args[1]
+			}
+			args = args[1:]
+		case "-r":
+			remove = true
+		case "-p":
+		default:
+			return 2, fmt.Errorf(
+				"complete: %s: invalid option", flag)
+		}
+	}
+	shell := b.shell
+	switch {
+	case c.words != "" && c.function != "":
+		return 2, errors.New(
+			"complete: -W and -F can't be used together")
+	case remove && len(args) == 0:
+		shell.completions = nil
+	case remove:
+		for _, name := range args {
+			delete(shell.completions, name)
+		}
+	case c.words == "" && c.function == "":
+		return b.printCompletions(args)
+	case len(args) == 0:
+		return 2, errors.New("complete: no command names given")
+	default:
+		if shell.completions == nil {
+			shell.completions = make(map[string]completion)
+		}
+		for _, name := range args {
+			shell.completions[name] = c
+		}
+	}
+	return 0, nil
+}
+
+// printCompletions prints the completions of the named commands, or of every
+// command, in a form which can be run to define them again.
+func (b *builtin) printCompletions(names []string) (int, error) {
+	status := 0
+	if len(names) == 0 {
+		for name := range b.shell.completions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		c, ok := b.shell.completions[name]
+		if !ok {
+			fmt.Fprintf(b.err, "mesh: complete: %s: "+
+				"no completion defined\n", name)
+			status = 1
+			continue
+		}
+		_, err := fmt.Fprintf(b.out, "%v %s\n", c, quoteWord(name))
+		if err != nil {
+			return 1, err
+		}
+	}
+	return status, nil
+}
+
+// Complete returns the candidates for the last of the words typed so far in a
+// command, which is empty if a new word is about to start. The first word is
+// completed from the names of commands, and the rest from the completion
+// defined for the command, or the names of files if there's none. Candidates
+// for a directory end in a slash.
+func (i *Interpreter) Complete(words []string) []string {
+	word := words[len(words)-1]
+	if len(words) == 1 && !strings.ContainsRune(word, '/') {
+		return withPrefix(dedupe(i.commandNames()), word)
+	}
+	c, ok := i.completions[words[0]]
+	switch {
+	case len(words) == 1 || !ok:
+		return i.completeFiles(word)
+	case c.function != "":
+		return i.completeFunc(c.function, words)
+	default:
+		return withPrefix(strings.Fields(c.words), word)
+	}
+}
+
+// completeFunc runs a function to complete the last of the words typed so
+// far, as in bash: $1 is the command, $2 the word being completed and $3 the
+// word before it, while $COMP_WORDS holds every word. The function runs in a
+// subshell, with nothing for input or output, and returns the candidates in
+// $COMPREPLY.
+func (i *Interpreter) completeFunc(name string, words []string) []string {
+	f, ok := i.funcs[name]
+	if !ok {
+		return nil
+	}
+	subshell := i.clone()
+	delete(subshell.vars, "COMPREPLY")
+	words = append([]string(nil), words...)
+	if _, err := subshell.setVar(
+		"COMP_WORDS", &variable{array: words}); err != nil {
+		return nil
+	}
+	last := len(words) - 1
+	args := []string{words[0], words[last], words[last-1]}
+	std := stdio{in: strings.NewReader(""), out: ioutil.Discard,
+		err: ioutil.Discard}
+	subshell.call(f, args, std)
+	return subshell.elements("COMPREPLY")
+}
+
+// completeFiles returns the paths which start with a word, ending those of
+// directories with a slash. Hidden files are only included if the name being
+// completed starts with a `.`.
+func (i *Interpreter) completeFiles(word string) []string {
+	dir, base := "", word
+	if slash := strings.LastIndexByte(word, '/'); slash >= 0 {
+		dir, base = word[:slash+1], word[slash+1:]
+	}
+	names, err := i.readDirNames(dir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, base) ||
+			(name[0] == '.' && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		path := dir + name
+		if info, err := i.stat(path); err == nil && info.IsDir() {
+			path += "/"
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// withPrefix returns the words which start with a prefix.
+func withPrefix(words []string, prefix string) []string {
+	var matches []string
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			matches = append(matches, word)
+		}
+	}
+	return matches
+}
+
+// dedupe returns sorted words without any duplicates.
+func dedupe(words []string) []string {
+	var unique []string
+	for index, word := range words {
+		if index == 0 || word != words[index-1] {
+			unique = append(unique, word)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"regexp"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// VisitCondExpr evaluates a conditional expression such as `[[ -f $x ]]`,
+// which succeeds if it's true. Like `test`, it exits with status 2 if it
+// can't be evaluated.
+func (i *Interpreter) VisitCondExpr(c *ast.CondExpr) (int, error) {
+	ok, err := i.evalCond(c)
+	if err != nil {
+		return 2, err
+	} else if !ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (i *Interpreter) evalCond(c *ast.CondExpr) (bool, error) {
+	switch c.Op {
+	case "&&", "||":
+		// The right operand is only evaluated if it's needed.
+		ok, err := i.evalCond(c.X)
+		if err != nil || ok == (c.Op == "||") {
+			return ok, err
+		}
+		return i.evalCond(c.Y)
+	case "!":
+		ok, err := i.evalCond(c.X)
+		return !ok, err
+	}
+	left, err := c.Args[0].Visit(i)
+	if err != nil {
+		return false, err
+	}
+	if len(c.Args) == 1 && c.Op == "" {
+		return left != "", nil
+	} else if len(c.Args) == 1 {
+		return i.evalUnary(c.Op, left)
+	}
+	switch c.Op {
+	case "==", "=", "!=":
+		// The right operand is a pattern, rather than a string.
+		ok, err := i.matchPattern(left, c.Args[1])
+		return ok == (c.Op != "!="), err
+	case "=~":
+		return i.matchRegexp(left, c.Args[1])
+	}
+	right, err := c.Args[1].Visit(i)
+	if err != nil {
+		return false, err
+	}
+	switch c.Op {
+	case "<":
+		return left < right, nil
+	case ">":
+		return left > right, nil
+	case "-eq", "-ne", "-lt", "-le", "-gt", "-ge":
+		// Unlike in `test`, the operands are arithmetic expressions.
+		l, err := i.arith(left)
+		if err != nil {
+			return false, err
+		}
+		r, err := i.arith(right)
+		if err != nil {
+			return false, err
+		}
+		return compareNumbers(l, c.Op, r), nil
+	default:
+		return i.evalBinary(left, c.Op, right)
+	}
+}
+
+// matchRegexp reports whether s matches a regular expression, using Go's
+// syntax, and sets the BASH_REMATCH array to the text which matched it,
+// followed by that matched by each of its parenthesised groups. The array is
+// left empty if s doesn't match.
+func (i *Interpreter) matchRegexp(s string, expr ast.Expr) (bool, error) {
+	text, err := expr.Visit(i)
+	if err != nil {
+		return false, err
+	}
+	re, err := regexp.Compile(text)
+	if err != nil {
+		// The error already includes the expression.
+		return false, err
+	}
+	match := re.FindStringSubmatch(s)
+	if _, err := i.setVar(
+		"BASH_REMATCH", &variable{array: match}); err != nil {
+		return false, err
+	}
+	return match != nil, nil
+}
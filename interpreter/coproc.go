@@ -0,0 +1,95 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// lastCoprocFd is the highest file descriptor which the shell's ends of a
+// coprocess's pipes are opened at. As in bash, they count down from there, so
+// that they're out of the way of the low descriptors scripts use themselves.
+const lastCoprocFd = 63
+
+// VisitCoproc starts a coprocess, a statement run in the background like one
+// ending in `&`, but with pipes to its stdin and stdout. The shell's end of
+// the pipe from its stdout is opened at the descriptor given by `${NAME[0]}`,
+// and the end of the pipe to its stdin at `${NAME[1]}`, where NAME is the
+// name of the coprocess. If the first thing it runs is an external program,
+// e.g. in `coproc NAME { cmd; }`, its PID is in `$NAME_PID`.
+func (shell *Interpreter) VisitCoproc(c *ast.Coproc) (int, error) {
+	if shell.Options.DryRun {
+		_, err := c.Stmt.Visit(shell.clone())
+		return 0, err
+	}
+	if err := shell.checkWritable(c.Name); err != nil {
+		return 1, err
+	}
+	fds := shell.freeFds(2)
+	if fds == nil {
+		return 1, errors.New("coproc: no free file descriptors")
+	}
+	fromCoproc, stdout, err := os.Pipe()
+	if err != nil {
+		return 1, err
+	}
+	stdin, toCoproc, err := os.Pipe()
+	if err != nil {
+		fromCoproc.Close()
+		stdout.Close()
+		return 1, err
+	}
+	subshell := shell.clone()
+	subshell.Stdin, subshell.Stdout = stdin, stdout
+	j := shell.startJob(subshell, c.Stmt, shell.firstProcess(c.Stmt), func() {
+		// Let the shell see the end of the coprocess's output.
+		stdin.Close()
+		stdout.Close()
+	})
+	if shell.fds == nil {
+		shell.fds = make(map[int]*os.File)
+	}
+	shell.fds[fds[0]], shell.fds[fds[1]] = fromCoproc, toCoproc
+	_, err = shell.setVar(c.Name, &variable{array: []string{
+		strconv.Itoa(fds[0]), strconv.Itoa(fds[1]),
+	}})
+	if err != nil {
+		return 1, err
+	}
+	pid := ""
+	if j.pid != 0 {
+		pid = strconv.Itoa(j.pid)
+	}
+	return shell.setScalar(c.Name+"_PID", pid)
+}
+
+// freeFds returns the n highest file descriptors up to lastCoprocFd which
+// nothing is open at, or nil if there aren't enough of them.
+func (shell *Interpreter) freeFds(n int) []int {
+	var fds []int
+	for fd := lastCoprocFd; fd > 2 && len(fds) < n; fd-- {
+		if _, ok := shell.fds[fd]; !ok {
+			fds = append(fds, fd)
+		}
+	}
+	if len(fds) < n {
+		return nil
+	}
+	return fds
+}
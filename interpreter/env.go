@@ -0,0 +1,229 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	registerBuiltin("env", Builtin{
+		run:     env,
+		Summary: "Run a command in a modified environment.",
+		Usage:   "env [-i] [name=value ...] [command [arg ...]]",
+	})
+}
+
+// env runs a command with a modified environment, or prints the environment
+// if there's no command.
+func env(b *builtin) (int, error) {
+	args := b.args
+	environ := b.shell.environ()
+	if len(args) > 0 && (args[0] == "-i" || args[0] == "-") {
+		environ = []string{}
+		args = args[1:]
+	} else if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		return 125, fmt.Errorf("env: %s: invalid option", args[0])
+	}
+	for len(args) > 0 {
+		name, value, ok := splitAssignment(args[0])
+		if !ok {
+			break
+		}
+		environ = setEnv(environ, name, value)
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		sort.Strings(environ)
+		for _, v := range environ {
+			if _, err := fmt.Fprintln(b.out, v); err != nil {
+				return 1, err
+			}
+		}
+		return 0, nil
+	}
+	return b.shell.execute(args, environ, b.stdio)
+}
+
+// splitAssignment splits an assignment such as `NAME=value` into its name and
+// value.
+func splitAssignment(s string) (string, string, bool) {
+	index := strings.IndexByte(s, '=')
+	if index <= 0 {
+		return "", "", false
+	}
+	return s[:index], s[index+1:], true
+}
+
+// setEnv returns the environment with the named variable set to value,
+// replacing any existing value.
+func setEnv(environ []string, name, value string) []string {
+	prefix := name + "="
+	for index, v := range environ {
+		if strings.HasPrefix(v, prefix) {
+			environ[index] = prefix + value
+			return environ
+		}
+	}
+	return append(environ, prefix+value)
+}
+
+// getenv returns the value of an environment variable. An interpreter created
+// by NewInterpreter has an environment of its own, but any other uses the
+// environment of the process.
+func (i *Interpreter) getenv(name string) (string, bool) {
+	if i.env == nil {
+		return os.LookupEnv(name)
+	}
+	value, ok := i.env[name]
+	return value, ok
+}
+
+func (i *Interpreter) setenv(name, value string) error {
+	if i.env == nil {
+		return os.Setenv(name, value)
+	} else if name == "" || strings.ContainsAny(name, "=\x00") ||
+		strings.ContainsRune(value, 0) {
+		return os.NewSyscallError("setenv", syscall.EINVAL)
+	}
+	i.env[name] = value
+	return nil
+}
+
+func (i *Interpreter) unsetenv(name string) error {
+	if i.env == nil {
+		return os.Unsetenv(name)
+	}
+	delete(i.env, name)
+	return nil
+}
+
+// environ returns the environment in the form "key=value", as passed to
+// commands. Variables which the shell set without exporting them are left out,
+// though they're stored in the environment along with the rest.
+func (i *Interpreter) environ() []string {
+	all := i.allEnv()
+	environ := all[:0]
+	for _, kv := range all {
+		name, _, _ := splitAssignment(kv)
+		if i.attrs[name]&attrNoExport == 0 {
+			environ = append(environ, kv)
+		}
+	}
+	return environ
+}
+
+// allEnv returns every variable stored in the environment, in the form
+// "key=value", whether it's exported or not.
+func (i *Interpreter) allEnv() []string {
+	if i.env == nil {
+		return os.Environ()
+	}
+	environ := make([]string, 0, len(i.env))
+	for name, value := range i.env {
+		environ = append(environ, name+"="+value)
+	}
+	return environ
+}
+
+// SetDefaultEnv sets the variables which scripts expect the shell to set, if
+// they aren't set already: $HOSTNAME, $HOME, $UID and $EUID. $PWD is also set
+// if it doesn't name the working directory, so that it always does; `cd` keeps
+// it up to date after that. NewInterpreter does this itself, but an
+// interpreter created as a zero value leaves the environment of the process as
+// it is until this is called.
+func (i *Interpreter) SetDefaultEnv() error {
+	dir, err := i.getwd()
+	if err != nil {
+		return err
+	}
+	defaults := map[string]func() (string, error){
+		"HOSTNAME": os.Hostname,
+		"HOME":     os.UserHomeDir,
+		"UID": func() (string, error) {
+			return strconv.Itoa(os.Getuid()), nil
+		},
+		"EUID": func() (string, error) {
+			return strconv.Itoa(os.Geteuid()), nil
+		},
+	}
+	for name, get := range defaults {
+		if _, ok := i.getenv(name); ok {
+			continue
+		} else if value, err := get(); err == nil {
+			if err := i.setenv(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	pwd, _ := i.getenv("PWD")
+	if !filepath.IsAbs(pwd) || !sameFile(pwd, dir) {
+		return i.setenv("PWD", dir)
+	}
+	return nil
+}
+
+// sameFile reports whether two paths name the same file.
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+// homeDir returns the user's home directory, from $HOME.
+func (i *Interpreter) homeDir() (string, error) {
+	if i.env == nil {
+		return os.UserHomeDir()
+	} else if home := i.env["HOME"]; home != "" {
+		return home, nil
+	}
+	return "", errors.New("$HOME is not defined")
+}
+
+// envMap converts an environment in the form "key=value" into a map.
+func envMap(environ []string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if name, value, ok := splitAssignment(kv); ok {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// copyEnv returns a copy of an interpreter's environment, for a subshell. If
+// the interpreter uses the environment of the process, the subshell gets a
+// copy of that instead, so that its changes can't affect the interpreter, or
+// race with them while it runs in a pipeline or in the background.
+func copyEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return envMap(os.Environ())
+	}
+	copied := make(map[string]string, len(env))
+	for name, value := range env {
+		copied[name] = value
+	}
+	return copied
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// defaultIFS is used to split fields if $IFS is unset.
+const defaultIFS = " \t\n"
+
+// expandFields expands a command argument, which may result in any number of
+// fields. The results of unquoted expansions are split into separate fields
+// on the characters in $IFS, dropping any empty fields. Then any field with a
+// pattern in it is replaced by the paths which match it.
+func (i *Interpreter) expandFields(expr ast.Expr) ([]string, error) {
+	var subExprs []ast.Expr
+	switch w := expr.(type) {
+	case *ast.Word:
+		subExprs = w.SubExprs
+	case ast.Word:
+		subExprs = w.SubExprs
+	default:
+		text, err := expr.Visit(i)
+		if err != nil {
+			return nil, err
+		}
+		return []string{text}, nil
+	}
+	s := splitter{ifs: i.ifs()}
+	for _, subExpr := range subExprs {
+		elems, ok, err := i.expandAll(subExpr)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			// Each element is a separate field, as well as being
+			// split in the same way as any other expansion.
+			for n, elem := range elems {
+				if n > 0 && s.inField {
+					s.end()
+				}
+				s.split(elem)
+			}
+			continue
+		}
+		text, err := subExpr.Visit(i)
+		if err != nil {
+			return nil, err
+		}
+		switch e := subExpr.(type) {
+		case ast.Var, *ast.Var, ast.FileContents, *ast.FileContents,
+			ast.CmdSubst, *ast.CmdSubst:
+			s.split(text)
+		case ast.Glob:
+			s.glob(e.Text, text)
+		case *ast.Glob:
+			s.glob(e.Text, text)
+		default:
+			s.literal(text)
+		}
+	}
+	if s.inField {
+		s.end()
+	}
+	return i.expandGlobs(s.fields, s.patterns)
+}
+
+// ifs returns the characters which separate fields, from $IFS. If it's unset,
+// fields are separated by whitespace, while if it's empty, they aren't split.
+func (i *Interpreter) ifs() string {
+	if ifs, ok := i.lookupVar("IFS"); ok {
+		return ifs
+	}
+	return defaultIFS
+}
+
+// expandAll expands every element of an array, for an expression such as
+// `${x[@]}`. It returns false for any other expression.
+func (i *Interpreter) expandAll(expr ast.Expr) ([]string, bool, error) {
+	var v ast.Var
+	switch e := expr.(type) {
+	case ast.Var:
+		v = e
+	case *ast.Var:
+		v = *e
+	default:
+		return nil, false, nil
+	}
+	if v.Index == nil && v.Identifier == "@" {
+		elems, err := i.applyParamOps(v, i.elements("@"))
+		return elems, err == nil, err
+	} else if v.Index == nil {
+		return nil, false, nil
+	}
+	index, err := v.Index.Visit(i)
+	if err != nil || index != "@" {
+		return nil, false, err
+	}
+	elems, err := i.applyParamOps(v, i.elements(v.Identifier))
+	return elems, err == nil, err
+}
+
+// splitter splits text into fields, following the POSIX rules: any sequence
+// of IFS whitespace separates fields, while each other IFS character
+// separates fields by itself, even if that makes an empty field.
+//
+// It also notes the pattern which each field would match, for any field with
+// a glob in it, escaping the rest of its text. Other fields have no pattern.
+// Only the globs written in the word itself are patterns, not those in the
+// values of variables.
+type splitter struct {
+	ifs      string
+	fields   []string
+	patterns []string
+	field    strings.Builder
+	pattern  strings.Builder
+	isGlob   bool
+	// inField is set once the current field has any text, or a literal
+	// such as '' which counts as a field even though it's empty.
+	inField bool
+	// afterSpace is set if the last field was ended by whitespace.
+	afterSpace bool
+}
+
+func (s *splitter) literal(text string) {
+	s.field.WriteString(text)
+	s.pattern.WriteString(escapePattern(text))
+	s.inField = true
+	s.afterSpace = false
+}
+
+// glob adds a pattern to the field, along with its text, which the field is
+// left with if the pattern matches nothing.
+func (s *splitter) glob(pattern, text string) {
+	s.field.WriteString(text)
+	s.pattern.WriteString(pattern)
+	s.isGlob = true
+	s.inField = true
+	s.afterSpace = false
+}
+
+func (s *splitter) split(text string) {
+	for _, r := range text {
+		switch {
+		case !strings.ContainsRune(s.ifs, r):
+			s.field.WriteRune(r)
+			s.pattern.WriteString(escapePattern(string(r)))
+			s.inField = true
+			s.afterSpace = false
+		case strings.ContainsRune(defaultIFS, r):
+			if s.inField {
+				s.end()
+				s.afterSpace = true
+			}
+		case s.afterSpace:
+			// Whitespace around a delimiter is part of it.
+			s.afterSpace = false
+		default:
+			// If the field is empty, e.g. between two delimiters in
+			// a row, this still ends it.
+			s.end()
+		}
+	}
+}
+
+func (s *splitter) end() {
+	s.fields = append(s.fields, s.field.String())
+	s.field.Reset()
+	if s.isGlob {
+		s.patterns = append(s.patterns, s.pattern.String())
+	} else {
+		s.patterns = append(s.patterns, "")
+	}
+	s.pattern.Reset()
+	s.isGlob = false
+	s.inField = false
+	s.afterSpace = false
+}
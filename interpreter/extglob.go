@@ -0,0 +1,231 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// matcher matches the whole of a string against a pattern.
+type matcher interface {
+	MatchString(s string) bool
+}
+
+// compilePattern compiles a pattern which has to match the whole of a string.
+// Extended globs, such as `@(a|b)`, are only special if the extglob option is
+// set.
+func (i *Interpreter) compilePattern(pattern string) (matcher, error) {
+	if i.Options.Extglob && hasExtglob(pattern) {
+		return compileExtglob(pattern)
+	}
+	return regexp.Compile("^" + patternRegexp(pattern) + "$")
+}
+
+// extPattern is a pattern with extended globs in it, split into its groups
+// and the plain patterns between them. Regular expressions can't match
+// anything but a pattern, as `!(...)` does, so rather than converting it into
+// one, it's matched by trying each way of dividing up the text between its
+// parts.
+type extPattern []extPart
+
+// extPart is either a plain pattern or an extended glob's group.
+type extPart struct {
+	// re matches a plain pattern.
+	re *regexp.Regexp
+	// op is the operator before a group, e.g. the `@` in `@(a|b)`, and
+	// alts are the patterns separated by `|` inside it.
+	op   byte
+	alts []extPattern
+}
+
+// compileExtglob compiles a pattern which may have extended globs in it.
+func compileExtglob(pattern string) (extPattern, error) {
+	var p extPattern
+	start := 0
+	plain := func(end int) error {
+		if start == end {
+			return nil
+		}
+		expr := "^" + patternRegexp(pattern[start:end]) + "$"
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		p = append(p, extPart{re: re})
+		return nil
+	}
+	for n := 0; n < len(pattern); n++ {
+		switch pattern[n] {
+		case '\\':
+			n++
+			continue
+		case '[':
+			if _, size := bracketExpr(pattern[n:]); size > 0 {
+				n += size - 1
+			}
+			continue
+		}
+		size := extglobGroup(pattern[n:])
+		if size == 0 {
+			continue
+		}
+		if err := plain(n); err != nil {
+			return nil, err
+		}
+		part := extPart{op: pattern[n]}
+		for _, alt := range splitAlts(pattern[n+2 : n+size-1]) {
+			altPattern, err := compileExtglob(alt)
+			if err != nil {
+				return nil, err
+			}
+			part.alts = append(part.alts, altPattern)
+		}
+		p = append(p, part)
+		n += size - 1
+		start = n + 1
+	}
+	if err := plain(len(pattern)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// hasExtglob reports whether a pattern has an extended glob in it.
+func hasExtglob(pattern string) bool {
+	for n := 0; n < len(pattern); n++ {
+		switch pattern[n] {
+		case '\\':
+			n++
+		case '[':
+			if _, size := bracketExpr(pattern[n:]); size > 0 {
+				n += size - 1
+			}
+		default:
+			if extglobGroup(pattern[n:]) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extglobGroup returns the length of the extended glob at the start of a
+// pattern, e.g. `@(a|(b))`, or 0 if it doesn't start with one.
+func extglobGroup(pattern string) int {
+	if len(pattern) < 2 || strings.IndexByte("@*+?!", pattern[0]) == -1 ||
+		pattern[1] != '(' {
+		return 0
+	}
+	depth := 0
+	for n := 1; n < len(pattern); n++ {
+		switch pattern[n] {
+		case '\\':
+			n++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return n + 1
+			}
+		}
+	}
+	return 0
+}
+
+// splitAlts splits the patterns in a group at each `|` which isn't escaped or
+// inside a group nested in it.
+func splitAlts(patterns string) []string {
+	var alts []string
+	depth, start := 0, 0
+	for n := 0; n < len(patterns); n++ {
+		switch patterns[n] {
+		case '\\':
+			n++
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '|':
+			if depth == 0 {
+				alts = append(alts, patterns[start:n])
+				start = n + 1
+			}
+		}
+	}
+	return append(alts, patterns[start:])
+}
+
+// MatchString reports whether the whole of s matches the pattern.
+func (p extPattern) MatchString(s string) bool {
+	if len(p) == 0 {
+		return s == ""
+	}
+	part, rest := p[0], p[1:]
+	for n := 0; n <= len(s); n++ {
+		if n < len(s) && !utf8.RuneStart(s[n]) {
+			continue
+		}
+		if part.match(s[:n]) && rest.MatchString(s[n:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the whole of s matches one part of a pattern.
+func (part *extPart) match(s string) bool {
+	switch part.op {
+	case 0:
+		return part.re.MatchString(s)
+	case '@':
+		return part.matchAlt(s)
+	case '?':
+		return s == "" || part.matchAlt(s)
+	case '*':
+		return s == "" || part.matchRepeated(s)
+	case '+':
+		return part.matchRepeated(s)
+	default: // '!'
+		return !part.matchAlt(s)
+	}
+}
+
+// matchAlt reports whether s matches any of the patterns in a group.
+func (part *extPart) matchAlt(s string) bool {
+	for _, alt := range part.alts {
+		if alt.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRepeated reports whether s is made up of one or more strings which
+// each match one of the patterns in a group.
+func (part *extPart) matchRepeated(s string) bool {
+	if part.matchAlt(s) {
+		return true
+	}
+	for n := 1; n < len(s); n++ {
+		if utf8.RuneStart(s[n]) && part.matchAlt(s[:n]) &&
+			part.matchRepeated(s[n:]) {
+			return true
+		}
+	}
+	return false
+}
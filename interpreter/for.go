@@ -0,0 +1,78 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// VisitArithFor evaluates the loop's first expression, then runs its body for
+// as long as the second is non-zero, or forever if it's empty, evaluating the
+// third after each run. Its status is that of the last body it ran, or 0 if
+// it ran none.
+func (i *Interpreter) VisitArithFor(f *ast.ArithFor) (int, error) {
+	std, closeFiles, err := i.redirect(f.Redirects)
+	if err != nil {
+		return 1, err
+	}
+	defer closeFiles()
+	stdin, stdout, stderr, fds := i.Stdin, i.Stdout, i.Stderr, i.fds
+	defer func() {
+		i.Stdin, i.Stdout, i.Stderr, i.fds = stdin, stdout, stderr, fds
+	}()
+	i.Stdin, i.Stdout, i.Stderr = std.in, std.out, std.err
+	i.fds = std.extra
+	if _, err := i.evalArith(f.Init); err != nil {
+		return 1, err
+	}
+	status := 0
+	for {
+		if err := i.ctx().Err(); err != nil {
+			return status, err
+		}
+		cond, err := f.Cond.Visit(i)
+		if err != nil {
+			return 1, err
+		} else if strings.TrimSpace(cond) != "" {
+			n, err := i.arith(cond)
+			if err != nil {
+				return 1, err
+			} else if n == 0 {
+				return status, nil
+			}
+		}
+		status, err = f.Body.Visit(i)
+		if err != nil {
+			return status, err
+		} else if i.ExitsOnError(status) {
+			return status, nil
+		}
+		if _, err := i.evalArith(f.Update); err != nil {
+			return 1, err
+		}
+	}
+}
+
+// evalArith expands the variables in an arithmetic expression, then
+// evaluates it.
+func (i *Interpreter) evalArith(expr ast.Expr) (int64, error) {
+	text, err := expr.Visit(i)
+	if err != nil {
+		return 0, err
+	}
+	return i.arith(text)
+}
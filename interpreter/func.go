@@ -0,0 +1,247 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+func init() {
+	registerBuiltin("local", Builtin{
+		run:     local,
+		Summary: "Declare variables local to a function.",
+		Usage:   "local [-aAirx] [name[=value] ...]",
+	})
+	registerBuiltin("return", Builtin{
+		run:     return_,
+		Summary: "Return from a function.",
+		Usage:   "return [n]",
+	})
+}
+
+// scope holds the state of a function call: its arguments, and the variables
+// hidden by its local variables. Variables are stored in the same places
+// whether they're local or not, so that reading a variable always finds the
+// innermost one, and each hidden variable is restored when the function
+// returns, much as restoreEnv does for a command's assignments.
+type scope struct {
+	args   []string
+	hidden map[string]hiddenVar
+	// traps holds the EXIT trap set during the call, which runs when it
+	// returns.
+	traps map[string]string
+}
+
+// copyScopes returns a copy of the scopes of the function calls in progress,
+// for a subshell, in which local variables and EXIT traps are then its own.
+func copyScopes(scopes []*scope) []*scope {
+	copied := make([]*scope, len(scopes))
+	for index, s := range scopes {
+		c := &scope{args: s.args}
+		if s.hidden != nil {
+			c.hidden = make(map[string]hiddenVar, len(s.hidden))
+			for name, h := range s.hidden {
+				if h.v != nil {
+					h.v = h.v.copy()
+				}
+				c.hidden[name] = h
+			}
+		}
+		if s.traps != nil {
+			c.traps = make(map[string]string, len(s.traps))
+			for name, action := range s.traps {
+				c.traps[name] = action
+			}
+		}
+		copied[index] = c
+	}
+	return copied
+}
+
+// hiddenVar is the state of a variable before it was hidden by a local
+// variable of the same name.
+type hiddenVar struct {
+	value string
+	isSet bool
+	v     *variable
+	attrs attr
+}
+
+// returnStatus is returned as an error by the return builtin, to unwind the
+// stack up to the call of the function being returned from.
+type returnStatus int
+
+func (r returnStatus) Error() string {
+	return fmt.Sprintf("return %d", int(r))
+}
+
+func (i *Interpreter) VisitFunc(f *ast.Func) (int, error) {
+	if i.funcs == nil {
+		i.funcs = make(map[string]*ast.Func)
+	}
+	i.funcs[f.Name] = f
+	return 0, nil
+}
+
+func copyFuncs(funcs map[string]*ast.Func) map[string]*ast.Func {
+	if funcs == nil {
+		return nil
+	}
+	copied := make(map[string]*ast.Func, len(funcs))
+	for name, f := range funcs {
+		copied[name] = f
+	}
+	return copied
+}
+
+// call calls a function in a new scope, with the given arguments as its
+// positional parameters.
+func (i *Interpreter) call(f *ast.Func, args []string, std stdio) (int, error) {
+	stdin, stdout, stderr, fds := i.Stdin, i.Stdout, i.Stderr, i.fds
+	defer func() {
+		i.Stdin, i.Stdout, i.Stderr, i.fds = stdin, stdout, stderr, fds
+	}()
+	i.Stdin, i.Stdout, i.Stderr = std.in, std.out, std.err
+	i.fds = std.extra
+	i.scopes = append(i.scopes, &scope{args: args})
+	defer i.popScope()
+	status, err := f.Body.Visit(i)
+	if r, ok := err.(returnStatus); ok {
+		status, err = int(r), nil
+	}
+	return i.runExitTrap(i.scopes[len(i.scopes)-1].traps, status, err)
+}
+
+func (i *Interpreter) popScope() {
+	s := i.scopes[len(i.scopes)-1]
+	i.scopes = i.scopes[:len(i.scopes)-1]
+	for name, h := range s.hidden {
+		if h.isSet {
+			i.setenv(name, h.value)
+		} else {
+			i.unsetenv(name)
+		}
+		if h.v != nil {
+			i.vars[name] = h.v
+		} else {
+			delete(i.vars, name)
+		}
+		if h.attrs != 0 {
+			i.attrs[name] = h.attrs
+		} else {
+			delete(i.attrs, name)
+		}
+	}
+}
+
+// makeLocal makes a variable local to the current function call, unset
+// until it's assigned a value. It does nothing outside of a function.
+func (i *Interpreter) makeLocal(name string) error {
+	if len(i.scopes) == 0 {
+		return nil
+	}
+	s := i.scopes[len(i.scopes)-1]
+	if _, ok := s.hidden[name]; ok {
+		return nil
+	} else if err := i.checkWritable(name); err != nil {
+		return err
+	}
+	value, isSet := i.getenv(name)
+	if s.hidden == nil {
+		s.hidden = make(map[string]hiddenVar)
+	}
+	s.hidden[name] = hiddenVar{value, isSet, i.vars[name], i.attrs[name]}
+	delete(i.vars, name)
+	delete(i.attrs, name)
+	return i.unsetenv(name)
+}
+
+// positional returns the positional parameters, i.e. the arguments of the
+// current function call, or of the script outside of any function.
+func (i *Interpreter) positional() []string {
+	if len(i.scopes) == 0 {
+		return i.Args
+	}
+	return i.scopes[len(i.scopes)-1].args
+}
+
+// lookupParam returns the value of a positional parameter such as $1, or a
+// special parameter: $# is the number of positional parameters, $@ all of
+// them, $? the status of the last statement, $$ the PID of the shell, and $!
+// that of the last background job, if it started a process. Its last result
+// is false if name isn't one of these parameters.
+func (i *Interpreter) lookupParam(name string) (string, bool, bool) {
+	args := i.positional()
+	switch name {
+	case "#":
+		return strconv.Itoa(len(args)), true, true
+	case "@":
+		return strings.Join(args, " "), len(args) > 0, true
+	case "?":
+		return strconv.Itoa(i.lastStatus), true, true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true, true
+	case "!":
+		if i.lastPid == 0 {
+			return "", false, true
+		}
+		return strconv.Itoa(i.lastPid), true, true
+	}
+	if name == "" || name[0] < '0' || name[0] > '9' {
+		return "", false, false
+	}
+	n, _ := strconv.Atoi(name)
+	if n < 1 || n > len(args) {
+		return "", false, true
+	}
+	return args[n-1], true, true
+}
+
+// local declares variables which are local to the current function call, in
+// the same way as declare.
+func local(b *builtin) (int, error) {
+	if len(b.shell.scopes) == 0 {
+		return 1, errors.New("local: can only be used in a function")
+	}
+	return declare(b)
+}
+
+// return_ returns from the current function, with either the given status or
+// zero.
+func return_(b *builtin) (int, error) {
+	if len(b.shell.scopes) == 0 {
+		return 1, errors.New("return: can only be used in a function")
+	}
+	switch len(b.args) {
+	case 0:
+		return 0, returnStatus(0)
+	case 1:
+		status, err := strconv.Atoi(b.args[0])
+		if err != nil {
+			return 2, fmt.Errorf(
+				"return: %s: numeric argument required",
+				b.args[0])
+		}
+		return status, returnStatus(status)
+	default:
+		return 1, errors.New("return: too many arguments")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerBuiltin("getopts", Builtin{
+		run:     getopts,
+		Summary: "Parse options in the positional parameters.",
+		Usage:   "getopts optstring name [arg ...]",
+	})
+}
+
+// getoptsState tracks how far getopts has got through the arguments. Since
+// several options can be grouped into one argument, e.g. `-ab`, $OPTIND alone
+// isn't enough.
+type getoptsState struct {
+	// index is the value of $OPTIND when getopts last set it, so that
+	// pos can be reset if anything else changes $OPTIND.
+	index int
+	// pos is the position of the next option in the argument at index,
+	// or zero to start on the next argument.
+	pos int
+}
+
+// getopts parses the options in the positional parameters, or the given
+// arguments, one per call, as in `getopts ab: name [arg ...]`. It sets the
+// variable name to each option letter, with its argument in $OPTARG if the
+// letter is followed by a colon in the option string, and $OPTIND to the
+// index of the next argument. It fails once there are no options left.
+//
+// An unknown option, or one missing its argument, sets name to `?` and
+// prints an error, unless the option string starts with a colon. In that case
+// the option letter is put in $OPTARG instead, and name is set to `:` if the
+// option was missing its argument.
+func getopts(b *builtin) (int, error) {
+	if len(b.args) < 2 {
+		err := errors.New("getopts: usage: getopts optstring name " +
+			"[arg ...]")
+		return 2, err
+	}
+	optstring, name, args := b.args[0], b.args[1], b.args[2:]
+	if !isName(name) {
+		err := fmt.Errorf("getopts: %s: not a valid identifier", name)
+		return 1, err
+	} else if len(b.args) == 2 {
+		args = b.shell.positional()
+	}
+	silent := strings.HasPrefix(optstring, ":")
+	if silent {
+		optstring = optstring[1:]
+	}
+	state := &b.shell.getopts
+	optind, _ := b.shell.getenv("OPTIND")
+	index, err := strconv.Atoi(optind)
+	if err != nil || index < 1 {
+		index = 1
+	}
+	if index != state.index {
+		state.pos = 0
+	}
+	if state.pos == 0 {
+		if index > len(args) || !isOption(args[index-1]) {
+			return b.endOptions(name, index)
+		} else if args[index-1] == "--" {
+			return b.endOptions(name, index+1)
+		}
+		state.pos = 1
+	}
+	arg := args[index-1]
+	opt := arg[state.pos]
+	state.pos++
+	if state.pos == len(arg) {
+		index, state.pos = index+1, 0
+	}
+	n := strings.IndexByte(optstring, opt)
+	var value string
+	var hasValue bool
+	switch {
+	case opt == ':' || n < 0:
+		if !silent {
+			b.optionError("-%c: invalid option", opt)
+		}
+		value, hasValue = string(opt), silent
+		opt = '?'
+	case !strings.HasPrefix(optstring[n+1:], ":"):
+	case state.pos > 0:
+		// The argument is the rest of this one, e.g. `-bvalue`.
+		value, hasValue = arg[state.pos:], true
+		index, state.pos = index+1, 0
+	case index <= len(args):
+		value, hasValue = args[index-1], true
+		index++
+	case silent:
+		value, hasValue = string(opt), true
+		opt = ':'
+	default:
+		b.optionError("-%c: option requires an argument", opt)
+		opt = '?'
+	}
+	state.index = index
+	if _, err := b.shell.setScalar(name, string(opt)); err != nil {
+		return 1, err
+	}
+	if hasValue {
+		if _, err := b.shell.setScalar("OPTARG", value); err != nil {
+			return 1, err
+		}
+	} else if err := b.shell.unsetenv("OPTARG"); err != nil {
+		return 1, err
+	}
+	_, err = b.shell.setScalar("OPTIND", strconv.Itoa(index))
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// endOptions finishes parsing options, leaving $OPTIND as the index of the
+// first argument which isn't an option.
+func (b *builtin) endOptions(name string, index int) (int, error) {
+	b.shell.getopts = getoptsState{index: index}
+	if _, err := b.shell.setScalar(name, "?"); err != nil {
+		return 1, err
+	} else if err := b.shell.unsetenv("OPTARG"); err != nil {
+		return 1, err
+	}
+	_, err := b.shell.setScalar("OPTIND", strconv.Itoa(index))
+	if err != nil {
+		return 1, err
+	}
+	return 1, nil
+}
+
+// optionError reports a bad option, unless $OPTERR is 0. Since getopts still
+// succeeds, the error isn't returned.
+func (b *builtin) optionError(format string, opt byte) {
+	if opterr, _ := b.shell.getenv("OPTERR"); opterr != "0" {
+		fmt.Fprintf(b.err, "mesh: getopts: "+format+"\n", opt)
+	}
+}
+
+func isOption(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-'
+}
@@ -0,0 +1,251 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// expandGlobs replaces each field which has a pattern with the paths which
+// match it. If none do, the field is left as it is, unless the nullglob
+// option is set, which removes it, or the failglob option, which makes it an
+// error.
+func (i *Interpreter) expandGlobs(
+	fields, patterns []string,
+) ([]string, error) {
+	var expanded []string
+	for n, field := range fields {
+		if patterns[n] == "" {
+			expanded = append(expanded, field)
+			continue
+		}
+		paths, err := i.glob(patterns[n])
+		switch {
+		case err != nil:
+			return nil, err
+		case len(paths) > 0:
+			expanded = append(expanded, paths...)
+		case i.Options.Failglob:
+			return nil, fmt.Errorf("no match: %s", field)
+		case !i.Options.Nullglob:
+			expanded = append(expanded, field)
+		}
+	}
+	return expanded, nil
+}
+
+// glob returns the paths which match a pattern, in order. Each part of the
+// pattern between slashes matches the names in one directory, so unlike in
+// the patterns of `${x%pattern}`, `*` and `?` never match a `/`. A part which
+// is just `**` matches any number of directories, though, so `**/*.go`
+// matches every `.go` file at any depth, and a `**` at the end matches every
+// path under the directory before it, unless the posix option is set.
+func (i *Interpreter) glob(pattern string) ([]string, error) {
+	paths := []string{""}
+	parts := strings.Split(pattern, "/")
+	for n, part := range parts {
+		last := n == len(parts)-1
+		var matches []string
+		for _, dir := range paths {
+			if part == "**" && !i.Options.Posix {
+				// Unless only directories can match, as in
+				// `**/`, the directory itself is one of them.
+				if !last && parts[n+1] != "" {
+					matches = append(matches, dir)
+				}
+				matches = append(
+					matches, i.globStar(dir, last)...)
+				continue
+			}
+			m, err := i.globDir(dir, part)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, m...)
+		}
+		paths = matches
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// globStar returns the directories under dir at any depth, for a `**`, along
+// with every other file under it if files is set. Names starting with a `.`
+// are skipped unless the dotglob option is set. Symlinks to directories are
+// followed, but skipped if they lead back to a directory above them, so that
+// a loop doesn't go on forever.
+func (i *Interpreter) globStar(dir string, files bool) []string {
+	var paths []string
+	var walk func(dir string, parents []os.FileInfo)
+	walk = func(dir string, parents []os.FileInfo) {
+		names, err := i.readDirNames(dir)
+		if err != nil {
+			return
+		}
+		for _, name := range names {
+			if name[0] == '.' && !i.Options.Dotglob {
+				continue
+			}
+			path := joinPath(dir, name)
+			info, err := i.stat(path)
+			if err != nil || !info.IsDir() {
+				if files {
+					paths = append(paths, path)
+				}
+				continue
+			}
+			if !isParent(info, parents) {
+				paths = append(paths, path)
+				n := len(parents)
+				walk(path, append(parents[:n:n], info))
+			}
+		}
+	}
+	root := dir
+	if root == "" {
+		root = "."
+	}
+	if info, err := i.stat(root); err == nil && info.IsDir() {
+		walk(dir, []os.FileInfo{info})
+	}
+	return paths
+}
+
+// isParent reports whether a directory is the same as any of its parents,
+// which is only the case if a symlink leads back to one of them.
+func isParent(dir os.FileInfo, parents []os.FileInfo) bool {
+	for _, parent := range parents {
+		if os.SameFile(dir, parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// globDir returns the paths in a directory whose names match one part of a
+// pattern. Names starting with a `.` are only matched by a part which starts
+// with one too, unless the dotglob option is set. An empty part follows a
+// slash, which only directories can be followed by.
+func (i *Interpreter) globDir(dir, part string) ([]string, error) {
+	switch {
+	case part == "" && (dir == "" || strings.HasSuffix(dir, "/")):
+		// The pattern starts with a slash, or has two in a row.
+		return []string{dir + "/"}, nil
+	case part == "":
+		if info, err := i.stat(dir); err != nil || !info.IsDir() {
+			return nil, nil
+		}
+		return []string{dir + "/"}, nil
+	case !isPattern(part):
+		path := joinPath(dir, unescapePattern(part))
+		if abs, err := i.abs(path); err != nil {
+			return nil, err
+		} else if _, err := os.Lstat(abs); err != nil {
+			return nil, nil
+		}
+		return []string{path}, nil
+	}
+	re, err := i.compilePattern(part)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", part, err)
+	}
+	names, err := i.readDirNames(dir)
+	if err != nil {
+		// As in other shells, a directory which can't be read
+		// simply matches nothing.
+		return nil, nil
+	}
+	hidden := i.Options.Dotglob || strings.HasPrefix(part, ".")
+	var paths []string
+	for _, name := range names {
+		if (hidden || name[0] != '.') && re.MatchString(name) {
+			paths = append(paths, joinPath(dir, name))
+		}
+	}
+	return paths, nil
+}
+
+// readDirNames returns the names of the files in a directory, relative to the
+// working directory. An empty name is the working directory itself.
+func (i *Interpreter) readDirNames(dir string) ([]string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	abs, err := i.abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// joinPath joins a name onto the path of the directory it's in, which is
+// empty for the working directory.
+func joinPath(dir, name string) string {
+	if dir == "" || strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+// isPattern reports whether a pattern has any characters which match other
+// text, i.e. a `*` or `?`, or a `[` with a `]` after it, or an extended glob,
+// which aren't escaped.
+func isPattern(pattern string) bool {
+	for n := 0; n < len(pattern); n++ {
+		switch pattern[n] {
+		case '\\':
+			n++
+		case '*', '?':
+			return true
+		case '@', '+', '!':
+			if extglobGroup(pattern[n:]) > 0 {
+				return true
+			}
+		case '[':
+			if strings.IndexByte(pattern[n+1:], ']') > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// escapePattern escapes the characters in text which are special in a
+// pattern, so that the pattern only matches the text itself.
+func escapePattern(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`,
+		`]`, `\]`, `(`, `\(`).Replace(text)
+}
+
+// unescapePattern removes the backslashes which escape characters in a
+// pattern.
+func unescapePattern(pattern string) string {
+	var b strings.Builder
+	for n := 0; n < len(pattern); n++ {
+		if pattern[n] == '\\' && n+1 < len(pattern) {
+			n++
+		}
+		b.WriteByte(pattern[n])
+	}
+	return b.String()
+}
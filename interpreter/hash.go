@@ -0,0 +1,174 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerBuiltin("hash", Builtin{
+		run:     hash,
+		Summary: "Remember or list the locations of commands.",
+		Usage:   "hash [-r] [name ...]",
+	})
+}
+
+type hashEntry struct {
+	path string
+	hits int
+}
+
+// hashTable caches the locations of commands found by searching $PATH, so
+// that running the same command repeatedly doesn't search $PATH every time.
+type hashTable struct {
+	lock    sync.Mutex
+	pathVar string
+	entries map[string]*hashEntry
+	// commands holds the names of the executables in $PATH, if they've
+	// been listed, for suggesting commands.
+	commands []string
+}
+
+// refresh empties the table if $PATH has changed since it was filled.
+func (h *hashTable) refresh(pathVar string) {
+	if pathVar != h.pathVar {
+		h.pathVar = pathVar
+		h.entries = nil
+		h.commands = nil
+	}
+}
+
+// unhit takes back the hit which looking up a command counted, for a lookup
+// which only adds the command to the table.
+func (h *hashTable) unhit(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if entry, ok := h.entries[name]; ok {
+		entry.hits--
+	}
+}
+
+// lookPath returns the path to the named command, searching $PATH only if the
+// command isn't already in the hash table. The table is reset whenever $PATH
+// changes.
+func (i *Interpreter) lookPath(name string) (string, error) {
+	if strings.ContainsRune(name, os.PathSeparator) {
+		return i.abs(name)
+	}
+	if i.hash == nil {
+		i.hash = &hashTable{}
+	}
+	h := i.hash
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	pathVar, _ := i.getenv("PATH")
+	h.refresh(pathVar)
+	if entry, ok := h.entries[name]; ok {
+		entry.hits++
+		return entry.path, nil
+	}
+	path, err := i.searchPath(name, h.pathVar)
+	if err != nil {
+		return "", err
+	}
+	if h.entries == nil {
+		h.entries = make(map[string]*hashEntry)
+	}
+	h.entries[name] = &hashEntry{path: path, hits: 1}
+	return path, nil
+}
+
+// searchPath looks for an executable in each of the directories in pathVar,
+// like exec.LookPath does with the $PATH of the process.
+func (i *Interpreter) searchPath(name, pathVar string) (string, error) {
+	for _, dir := range filepath.SplitList(pathVar) {
+		if dir == "" {
+			// An empty entry stands for the working directory.
+			dir = "."
+		}
+		path, err := i.abs(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().IsRegular() &&
+			info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+func hash(b *builtin) (int, error) {
+	if len(b.args) > 0 && b.args[0] == "-r" {
+		if b.shell.hash != nil {
+			b.shell.hash.lock.Lock()
+			b.shell.hash.entries = nil
+			b.shell.hash.commands = nil
+			b.shell.hash.lock.Unlock()
+		}
+		b.args = b.args[1:]
+	} else if len(b.args) == 0 {
+		return 0, printHashTable(b.shell, b.out)
+	}
+	// Any remaining arguments are commands to add to the table, without
+	// counting as a hit.
+	for _, name := range b.args {
+		if _, err := b.shell.lookPath(name); errors.Is(err, exec.ErrNotFound) {
+			return 1, fmt.Errorf("hash: %s: not found", name)
+		} else if err != nil {
+			return 1, fmt.Errorf("hash: %w", err)
+		}
+		b.shell.hash.unhit(name)
+	}
+	return 0, nil
+}
+
+func printHashTable(i *Interpreter, w io.Writer) error {
+	if i.hash == nil {
+		i.hash = &hashTable{}
+	}
+	i.hash.lock.Lock()
+	defer i.hash.lock.Unlock()
+	if len(i.hash.entries) == 0 {
+		_, err := fmt.Fprintln(w, "hash: hash table empty")
+		return err
+	}
+	names := make([]string, 0, len(i.hash.entries))
+	for name := range i.hash.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if _, err := fmt.Fprintln(w, "hits\tcommand"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		entry := i.hash.entries[name]
+		_, err := fmt.Fprintf(w, "%4d\t%s\n", entry.hits, entry.path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+	"github.com/meshshell/mesh/parser"
+)
+
+func init() {
+	registerBuiltin("fc", Builtin{
+		run:     fc,
+		Summary: "Edit and rerun lines from the history, or list them.",
+		Usage:   "fc [-l] [-e editor] [first [last]]",
+	})
+	registerBuiltin("history", Builtin{
+		run:     history,
+		Summary: "List or clear the command history.",
+		Usage:   "history [-c] [n]",
+	})
+}
+
+// defaultHistSize is the number of lines kept in the history if $HISTSIZE
+// isn't set to a valid size.
+const defaultHistSize = 500
+
+// History is a list of the lines entered in an interactive shell, numbered
+// from 1. Only the last $HISTSIZE lines are kept, in a ring buffer.
+type History struct {
+	// LookupVar looks up $HISTSIZE, as Interpreter.LookupVar does for the
+	// interpreter whose history it is. If it's nil, $HISTSIZE is looked
+	// up in the environment of the process.
+	LookupVar func(name string) (string, bool)
+
+	lines []string
+	// head is the index in lines of the oldest line, once the buffer is
+	// full.
+	head int
+	// count is the number of lines ever added, i.e. the number of the
+	// newest line.
+	count int
+}
+
+// Add adds a line to the end of the history, dropping the oldest line if the
+// history is full.
+func (h *History) Add(line string) {
+	max := h.size()
+	if len(h.lines) > max || len(h.lines) < max && h.head != 0 {
+		// $HISTSIZE has changed since the last line was added.
+		h.resize(max)
+	}
+	h.count++
+	switch {
+	case max == 0:
+	case len(h.lines) < max:
+		h.lines = append(h.lines, line)
+	default:
+		h.lines[h.head] = line
+		h.head = (h.head + 1) % max
+	}
+}
+
+// Lines returns the lines in the history, oldest first, along with the number
+// of the first one.
+func (h *History) Lines() ([]string, int) {
+	lines := make([]string, 0, len(h.lines))
+	lines = append(lines, h.lines[h.head:]...)
+	lines = append(lines, h.lines[:h.head]...)
+	return lines, h.count - len(lines) + 1
+}
+
+// Clear removes every line from the history. The numbering carries on from
+// where it was.
+func (h *History) Clear() {
+	h.lines, h.head = nil, 0
+}
+
+// resize drops the oldest lines until there are no more than max.
+func (h *History) resize(max int) {
+	lines, _ := h.Lines()
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	h.lines, h.head = lines, 0
+}
+
+// size returns the maximum size of the history, from $HISTSIZE.
+func (h *History) size() int {
+	lookupVar := h.LookupVar
+	if lookupVar == nil {
+		lookupVar = os.LookupEnv
+	}
+	value, _ := lookupVar("HISTSIZE")
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		return defaultHistSize
+	}
+	return size
+}
+
+// fcListSize is the number of lines that `fc -l` lists by default.
+const fcListSize = 16
+
+// fc opens lines from the history in an editor, then runs them once it exits:
+// the editor given with `-e`, or else $EDITOR, or vi. With `-l`, it lists the
+// lines instead. By default, it edits the line before the `fc` command itself,
+// or lists the 16 before it. Otherwise, first and last are each either the
+// number of a line, a negative offset back from the `fc` command, so that -1
+// is the line before it, or the start of the last line which begins with it.
+func fc(b *builtin) (int, error) {
+	h := b.shell.History
+	if h == nil {
+		// Only interactive shells keep a history.
+		h = &History{}
+	}
+	args, list, editor := b.args, false, ""
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		if _, err := strconv.Atoi(args[0]); err == nil {
+			// A negative offset, not an option.
+			break
+		}
+		switch args[0] {
+		case "-l":
+			list = true
+		case "-e":
+			if len(args) == 1 {
+				return 2, errors.New(
+					"fc: -e: option requires an argument")
+			}
+			editor, args = args[1], args[1:]
+		default:
+			return 2, fmt.Errorf("fc: %s: invalid option", args[0])
+		}
+	}
+	if len(args) > 2 {
+		return 1, errors.New("fc: too many arguments")
+	}
+	lines, first := h.Lines()
+	if len(lines) > 0 {
+		// The last line is the `fc` command which is running now.
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return 1, errors.New("fc: the history is empty")
+	}
+	start, end := len(lines)-1, len(lines)-1
+	if list && start >= fcListSize {
+		start -= fcListSize - 1
+	} else if list {
+		start = 0
+	}
+	for n, arg := range args {
+		index, err := findLine(lines, first, arg)
+		if err != nil {
+			return 1, err
+		}
+		if n == 0 {
+			start = index
+			if !list {
+				end = index
+			}
+		} else {
+			end = index
+		}
+	}
+	if start > end {
+		start, end = end, start
+	}
+	if !list {
+		return b.edit(lines[start:end+1], editor)
+	}
+	for n, line := range lines[start : end+1] {
+		_, err := fmt.Fprintf(b.out, "%5d  %s\n", first+start+n, line)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// findLine returns the index in lines of the line which arg refers to for fc,
+// given the number of the first line.
+func findLine(lines []string, first int, arg string) (int, error) {
+	index := -1
+	if n, err := strconv.Atoi(arg); err == nil && n < 0 {
+		index = len(lines) + n
+	} else if err == nil {
+		index = n - first
+	} else {
+		for n := len(lines) - 1; n >= 0 && index < 0; n-- {
+			if strings.HasPrefix(lines[n], arg) {
+				index = n
+			}
+		}
+	}
+	if index < 0 || index >= len(lines) {
+		return 0, fmt.Errorf("fc: %s: no such line in the history", arg)
+	}
+	return index, nil
+}
+
+// edit writes lines to a temporary file, opens it in an editor, and then runs
+// what's in the file, unless the editor fails.
+func (b *builtin) edit(lines []string, editor string) (int, error) {
+	if editor == "" {
+		editor, _ = b.shell.getenv("EDITOR")
+	}
+	argv := strings.Fields(editor)
+	if len(argv) == 0 {
+		argv = []string{"vi"}
+	}
+	f, err := ioutil.TempFile("", "mesh-fc-*.sh")
+	if err != nil {
+		return 1, fmt.Errorf("fc: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 1, fmt.Errorf("fc: %w", err)
+	}
+	status, err := b.shell.execute(append(argv, f.Name()), nil, b.stdio)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return status, fmt.Errorf(
+			"fc: %s exited with status %d", argv[0], status)
+	} else if err != nil {
+		return status, err
+	}
+	script, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return 1, fmt.Errorf("fc: %w", err)
+	}
+	stmts, err := parser.ParseAll("fc", bytes.NewReader(script))
+	if err != nil {
+		return 1, fmt.Errorf("fc: %w", err)
+	}
+	// Show the lines as they were edited, before running them.
+	if _, err := b.out.Write(script); err != nil {
+		return 1, err
+	}
+	return (&ast.StmtList{Stmts: stmts}).Visit(b.shell)
+}
+
+// history prints the lines in the history, or with `-c`, clears it. Given a
+// number n, it prints only the last n lines.
+func history(b *builtin) (int, error) {
+	h := b.shell.History
+	if h == nil {
+		// Only interactive shells keep a history.
+		h = &History{}
+	}
+	lines, first := h.Lines()
+	switch {
+	case len(b.args) > 1:
+		return 1, errors.New("history: too many arguments")
+	case len(b.args) == 0:
+	case b.args[0] == "-c":
+		h.Clear()
+		return 0, nil
+	case isOption(b.args[0]):
+		return 2, fmt.Errorf("history: %s: invalid option", b.args[0])
+	default:
+		n, err := strconv.Atoi(b.args[0])
+		if err != nil || n < 0 {
+			return 1, fmt.Errorf(
+				"history: %s: numeric argument required",
+				b.args[0])
+		} else if n < len(lines) {
+			first += len(lines) - n
+			lines = lines[len(lines)-n:]
+		}
+	}
+	for n, line := range lines {
+		_, err := fmt.Fprintf(b.out, "%5d  %s\n", first+n, line)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
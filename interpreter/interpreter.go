@@ -15,11 +15,18 @@
 package interpreter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/user"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/meshshell/mesh/ast"
 )
@@ -28,27 +35,356 @@ type Interpreter struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+	// Interactive enables the job notifications that interactive shells
+	// print when a background job starts or finishes.
+	Interactive bool
+	Options     Options
+	// History, if set, holds the lines entered in an interactive shell,
+	// which the history builtin lists.
+	History *History
+	// Args holds the positional parameters, e.g. $1, outside of any
+	// function, i.e. the arguments of the script.
+	Args []string
+	// Context, if set, stops the interpreter once it's done: the external
+	// commands it's running are killed, no more statements or loop
+	// iterations start, and the statement in progress returns the
+	// context's error, e.g. context.Canceled, rather than a status.
+	Context context.Context
+
+	// env holds the environment of an interpreter created by
+	// NewInterpreter. Otherwise it's unset, and the interpreter uses the
+	// environment of the process.
+	env map[string]string
+	// dir is the working directory, once it's known. See getwd.
+	dir  string
+	jobs []*job
+	hash *hashTable
+	// exitWarned is set once `exit` has refused to exit because jobs were
+	// running, so that the next `exit` exits anyway.
+	exitWarned bool
+	// fds holds the files which `exec` has opened in the shell at
+	// descriptors from 3 upwards, which every command inherits.
+	fds map[int]*os.File
+	// lastPid is the PID of the most recent background job, for $!.
+	lastPid int
+	// lastStatus is the status of the last statement to finish, for $?.
+	lastStatus int
+	// expandStatus is the status of the last command substitution, or 1 if
+	// a `$(< file)` fails to read its file, for the status of a statement
+	// which only assigns variables.
+	expandStatus int
+	// tested counts the statements in progress whose status is being
+	// tested, e.g. by `&&` or `!`, which keep failures in them from
+	// running the ERR trap.
+	tested int
+	// statusTested is set when the status of the statement which just
+	// finished is that of a command whose status was tested, e.g. the
+	// `false` in `false && true`, so that errexit ignores it.
+	statusTested bool
+	// inErrTrap is set while the ERR trap runs, so that a failure in it
+	// doesn't run it again.
+	inErrTrap bool
+	// stderrLock serialises writes to Stderr, which background jobs may
+	// report errors to at any time.
+	stderrLock sync.Mutex
+	// vars holds the variables which can't be stored in the environment,
+	// such as arrays. Any other variables are environment variables.
+	vars map[string]*variable
+	// attrs holds the attributes set on variables by declare.
+	attrs map[string]attr
+	funcs map[string]*ast.Func
+	// disabled holds the builtins which `enable -n` has turned off.
+	disabled map[string]bool
+	// completions holds the completions defined by `complete`, by the
+	// name of the command.
+	completions map[string]completion
+	// traps holds the actions set by `trap`, by the name of the signal.
+	traps map[string]string
+	// signals receives the trapped signals, once any have been trapped.
+	signals chan os.Signal
+	// scopes holds a scope for each function call in progress, the
+	// innermost last.
+	scopes  []*scope
+	getopts getoptsState
+	// started, if set, is called with each external process after it
+	// starts running.
+	started func(p *os.Process)
+	// cpu, if set, totals the CPU time used by external processes.
+	cpu *cpuTimes
+	// cmdDone, if set, is called with each simple command once it's
+	// finished running.
+	cmdDone func(c *ast.Cmd)
+	// ownGroup starts each external process in a process group of its
+	// own, so that it can be signalled along with its children.
+	ownGroup bool
+	// job, if set, is the background job which the shell runs, which
+	// keeps track of the external processes it starts.
+	job *job
+}
+
+// Config holds the initial state of an interpreter created by NewInterpreter.
+type Config struct {
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Options Options
+	// Context stops the interpreter once it's done, as described by
+	// Interpreter.Context.
+	Context context.Context
+	// Dir is the working directory. If empty, it's the working directory
+	// of the process.
+	Dir string
+	// Env holds the environment variables, in the form "key=value". If
+	// nil, it's a copy of the environment of the process. Either way,
+	// $HOSTNAME, $HOME, $UID and $EUID are added if missing, and $PWD is
+	// set to Dir.
+	Env []string
+}
+
+// NewInterpreter creates an interpreter with an environment of its own, so
+// that setting variables in it doesn't change the environment of the process,
+// unlike in an interpreter created as a zero value. This allows several
+// interpreters to run at once in one program.
+func NewInterpreter(c Config) (*Interpreter, error) {
+	dir := c.Dir
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		dir = wd
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	environ := c.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+	i := &Interpreter{
+		Stdin:   c.Stdin,
+		Stdout:  c.Stdout,
+		Stderr:  c.Stderr,
+		Options: c.Options,
+		Context: c.Context,
+		env:     envMap(environ),
+		dir:     dir,
+	}
+	if err := i.SetDefaultEnv(); err != nil {
+		return nil, err
+	}
+	return i, nil
 }
 
 func (i *Interpreter) VisitStmtList(s *ast.StmtList) (int, error) {
 	var status int
 	var err error
 	for _, stmt := range s.Stmts {
-		if status, err = stmt.Visit(i); err != nil {
+		if ctxErr := i.ctx().Err(); ctxErr != nil {
+			return status, ctxErr
+		}
+		status, err = stmt.Visit(i)
+		i.lastStatus = status
+		if trapErr := i.runSignalTraps(); err == nil {
+			err = trapErr
+		}
+		if err != nil {
 			return status, err
+		} else if i.ExitsOnError(status) {
+			return status, nil
 		}
 	}
 	return status, err
 }
 
+// ExitsOnError reports whether the errexit option stops the shell after the
+// statement which just finished with the given status. As with the ERR trap,
+// a failure whose status is tested doesn't count: one in a condition, one
+// negated by `!`, or one in any but the last part of an `&&` or `||` list.
+func (i *Interpreter) ExitsOnError(status int) bool {
+	return status != 0 && i.Options.Errexit && !i.statusTested &&
+		i.tested == 0
+}
+
+func (i *Interpreter) VisitAndOr(a *ast.AndOr) (int, error) {
+	status, err := i.condition(a.Left)
+	if err != nil {
+		return status, err
+	}
+	switch {
+	case a.Op == "&&" && status == 0, a.Op == "||" && status != 0:
+		return a.Right.Visit(i)
+	default:
+		i.statusTested = true
+		return status, nil
+	}
+}
+
+func (i *Interpreter) VisitNot(n *ast.Not) (int, error) {
+	status, err := i.condition(n.Stmt)
+	i.statusTested = true
+	if err != nil {
+		return status, err
+	} else if status == 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// condition runs a statement whose status decides what happens next. Any
+// error counts as failure, and is reported rather than being passed back up
+// to the caller, unless it's from `exit` or `return`, or says that the
+// interpreter's Context is done.
+func (i *Interpreter) condition(stmt ast.Stmt) (int, error) {
+	i.tested++
+	status, err := stmt.Visit(i)
+	i.tested--
+	switch err.(type) {
+	case nil:
+	case ExitStatus, returnStatus:
+		return status, err
+	default:
+		if err == i.ctx().Err() {
+			// The interpreter is stopping, so nothing more should
+			// run, whatever the status.
+			return status, err
+		}
+		i.reportError(err)
+		if status == 0 {
+			status = 1
+		}
+	}
+	return status, nil
+}
+
+func (shell *Interpreter) VisitBackground(b *ast.Background) (int, error) {
+	if shell.Options.DryRun {
+		// Run the job in the foreground, so that its commands are
+		// printed in order.
+		_, err := b.Stmt.Visit(shell.clone())
+		return 0, err
+	}
+	// Background commands don't read from the terminal, so leave stdin
+	// unset, which exec.Cmd treats as the null device.
+	subshell := shell.clone()
+	subshell.Stdin = nil
+	shell.startJob(subshell, b.Stmt, shell.firstProcess(b.Stmt), nil)
+	return 0, nil
+}
+
+// startJob runs a statement in a subshell as a new background job, calling
+// finished, if it's set, once it's done. If first is set, it's the command
+// which the job runs first, as given by firstProcess, and startJob returns
+// once the command has started a process, or finished without one, e.g. if it
+// wasn't found, so that the job's PID is known. Otherwise the job has no PID.
+func (shell *Interpreter) startJob(
+	subshell *Interpreter, stmt ast.Stmt, first *ast.Cmd, finished func(),
+) *job {
+	j := shell.newJob()
+	j.text = ast.Unparse(stmt)
+	subshell.job = j
+	pids := make(chan int, 1)
+	subshell.started = func(p *os.Process) {
+		select {
+		case pids <- p.Pid:
+		default:
+		}
+	}
+	firstDone := make(chan struct{})
+	var once sync.Once
+	cmdDone := subshell.cmdDone
+	subshell.cmdDone = func(c *ast.Cmd) {
+		if cmdDone != nil {
+			cmdDone(c)
+		}
+		if c == first {
+			once.Do(func() { close(firstDone) })
+		}
+	}
+	go func() {
+		j.status, j.err = stmt.Visit(subshell)
+		if j.err != nil {
+			// Nobody is waiting for the result, so report any
+			// error ourselves rather than silently dropping it.
+			shell.reportError(j.err)
+		}
+		if finished != nil {
+			finished()
+		}
+		close(j.done)
+	}()
+	if first != nil {
+		select {
+		case j.pid = <-pids:
+		case <-firstDone:
+			select {
+			case j.pid = <-pids:
+			default:
+			}
+		case <-j.done:
+			select {
+			case j.pid = <-pids:
+			default:
+			}
+		}
+	}
+	shell.lastPid = j.pid
+	if shell.Interactive {
+		shell.stderrLock.Lock()
+		if j.pid != 0 {
+			fmt.Fprintf(shell.Stderr, "[%d] %d\n", j.id, j.pid)
+		} else {
+			// The job runs in the shell, so it has no PID.
+			fmt.Fprintf(shell.Stderr, "[%d]\n", j.id)
+		}
+		shell.stderrLock.Unlock()
+	}
+	return j
+}
+
+// VisitPipeline runs a pipeline, and then the ERR trap if it ends with a simple
+// command which failed.
 func (shell *Interpreter) VisitPipeline(p *ast.Pipeline) (int, error) {
+	// Only a statement in the shell itself, such as a group, can leave
+	// its status tested, so the status of one in a subshell never is.
+	shell.statusTested = false
+	status, err := shell.runPipeline(p)
+	shell.lastStatus = status
+	if _, ok := p.Stmts[len(p.Stmts)-1].(*ast.Cmd); ok && status != 0 {
+		if trapErr := shell.runErrTrap(status); trapErr != nil {
+			return status, trapErr
+		}
+	}
+	return status, err
+}
+
+func (shell *Interpreter) runPipeline(p *ast.Pipeline) (int, error) {
+	if len(p.Stmts) == 1 {
+		// A lone command runs in the shell itself, so that e.g. an
+		// assignment statement affects the shell's variables.
+		return p.Stmts[0].Visit(shell)
+	} else if shell.Options.DryRun {
+		// Nothing runs, so there's nothing to pipe between the
+		// commands. Print each of them in turn instead.
+		status := 0
+		for _, stmt := range p.Stmts {
+			var err error
+			status, err = stmt.Visit(shell.clone())
+			if err != nil {
+				return status, err
+			}
+		}
+		return status, nil
+	}
 	var fromPipe io.ReadCloser
 	statuses := make([]int, len(p.Stmts))
 	errs := make([]error, len(p.Stmts))
 	var wg sync.WaitGroup
 	wg.Add(len(p.Stmts))
 	for index, stmt := range p.Stmts {
-		subshell := &Interpreter{Stderr: shell.Stderr}
+		subshell := shell.clone()
+		var fromPrev io.Closer
 		if index == 0 {
 			// First command in the pipeline, so read from stdin.
 			subshell.Stdin = shell.Stdin
@@ -57,6 +393,7 @@ func (shell *Interpreter) VisitPipeline(p *ast.Pipeline) (int, error) {
 			// pipe will have been created in the previous iteration
 			// of this loop.
 			subshell.Stdin = fromPipe
+			fromPrev = fromPipe
 		}
 		var toPipe io.WriteCloser
 		if index == len(p.Stmts)-1 {
@@ -67,9 +404,8 @@ func (shell *Interpreter) VisitPipeline(p *ast.Pipeline) (int, error) {
 			var pipeErr error
 			fromPipe, toPipe, pipeErr = os.Pipe()
 			if pipeErr != nil {
-				return -1, pipeErr
+				return 1, pipeErr
 			}
-			defer fromPipe.Close()
 			subshell.Stdout = toPipe
 		}
 		go func(index int, stmt ast.Stmt) {
@@ -82,53 +418,437 @@ func (shell *Interpreter) VisitPipeline(p *ast.Pipeline) (int, error) {
 				// trying to read from the pipe.
 				toPipe.Close()
 			}
+			if fromPrev != nil {
+				// Likewise close the read-side, so that if the
+				// previous command is still writing to the
+				// pipe, it gets SIGPIPE rather than blocking
+				// once the pipe is full, e.g. in `yes | head`.
+				fromPrev.Close()
+			}
 			wg.Done()
 		}(index, stmt)
 	}
 	wg.Wait()
-	// TODO: implement `pipefail` behaviour?
-	return statuses[len(p.Stmts)-1], errs[len(p.Stmts)-1]
+	last := len(p.Stmts) - 1
+	if err := shell.ctx().Err(); err != nil {
+		// The commands were killed, so their statuses and errors
+		// only say so.
+		return statuses[last], err
+	}
+	// Only one error can be returned, so report those of the other
+	// commands, unless they only say that a command exited with a failure
+	// status. Those commands fail the pipeline, since they couldn't even
+	// run, e.g. because they weren't found.
+	failed := -1
+	for index, err := range errs[:last] {
+		var exitErr *exec.ExitError
+		var exitStatus ExitStatus
+		if err == nil || errors.As(err, &exitErr) ||
+			errors.As(err, &exitStatus) {
+			continue
+		}
+		shell.reportError(err)
+		errs[index] = nil
+		failed = index
+	}
+	if shell.Options.Pipefail {
+		// The pipeline's status is that of the last command to
+		// fail, or zero if every command succeeded.
+		for index := last; index >= 0; index-- {
+			if statuses[index] != 0 {
+				return statuses[index], errs[index]
+			}
+		}
+	}
+	if statuses[last] == 0 && failed >= 0 {
+		return statuses[failed], nil
+	}
+	return statuses[last], errs[last]
 }
 
 func (i *Interpreter) VisitCmd(c *ast.Cmd) (int, error) {
+	if i.cmdDone != nil {
+		defer i.cmdDone(c)
+	}
+	// A function's status is its own, whatever the statement in it which
+	// gave that status.
+	defer func() { i.statusTested = false }()
+	// Every value is expanded before any variable is set, as in other
+	// shells, so that e.g. `A=1 B=$A cmd` gives B the old value of A.
+	i.expandStatus = 0
+	values := make([]string, len(c.Assignments))
+	for index, a := range c.Assignments {
+		text, err := a.Value.Visit(i)
+		if err != nil {
+			return 1, err
+		}
+		values[index] = text
+	}
 	var argv []string
 	for _, expr := range c.Argv {
-		text, err := expr.Visit(i)
+		fields, err := i.expandFields(expr)
 		if err != nil {
-			return -1, err
+			return 1, err
+		}
+		argv = append(argv, fields...)
+	}
+	std, closeFiles, err := i.redirect(c.Redirects)
+	if err != nil {
+		return 1, err
+	}
+	defer closeFiles()
+	if i.Options.DryRun && len(argv) > 0 {
+		// Functions still run, so that their commands are printed.
+		if _, isFunc := i.funcs[argv[0]]; !isFunc {
+			return 0, i.printCmd(c, values, argv)
 		}
-		argv = append(argv, text)
 	}
 	if len(argv) == 0 {
-		return 0, nil
-	} else if b, ok := newBuiltin(argv[0], argv[1:]); ok {
-		if err := b.run(); err != nil {
-			return 1, err
+		for index, a := range c.Assignments {
+			_, err := i.setScalar(a.Name, values[index])
+			if err != nil {
+				return 1, err
+			}
+		}
+		return i.expandStatus, nil
+	}
+	f, isFunc := i.funcs[argv[0]]
+	var b *builtin
+	isBuiltin := i.builtinEnabled(argv[0])
+	if isBuiltin {
+		b, _ = newBuiltin(i, argv[0], argv[1:])
+	}
+	if !isFunc && !isBuiltin && i.autocd(argv) {
+		b, isBuiltin = newBuiltin(i, "cd", []string{"--", argv[0]})
+	}
+	if isFunc || isBuiltin {
+		// Functions and builtins run in the shell itself, so the
+		// variables are set only until they return.
+		for index, a := range c.Assignments {
+			value, err := i.assignable(a.Name, values[index])
+			if err != nil {
+				return 1, err
+			}
+			defer i.restoreEnv(a.Name)()
+			if i.attrs[a.Name]&attrNoExport != 0 {
+				// It's exported until then, as it would be
+				// to an external command.
+				i.attrs[a.Name] &^= attrNoExport
+			}
+			if err := i.setenv(a.Name, value); err != nil {
+				return 1, err
+			}
+		}
+		if isFunc {
+			return i.call(f, argv[1:], std)
+		}
+		b.stdio = std
+		return b.run()
+	}
+	var env []string
+	if len(c.Assignments) > 0 {
+		env = i.environ()
+		for index, a := range c.Assignments {
+			value, err := i.assignable(a.Name, values[index])
+			if err != nil {
+				return 1, err
+			}
+			env = setEnv(env, a.Name, value)
+		}
+	}
+	return i.execute(argv, env, std)
+}
+
+// autocd reports whether a command should change into the directory it names,
+// rather than run, because the autocd option is on and there's no executable
+// of that name. Functions and builtins have already been ruled out.
+func (i *Interpreter) autocd(argv []string) bool {
+	if !i.Options.Autocd || len(argv) != 1 {
+		return false
+	}
+	dir, err := i.abs(argv[0])
+	if err != nil {
+		return false
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return false
+	}
+	if strings.ContainsRune(argv[0], os.PathSeparator) {
+		// A path is never looked for in $PATH.
+		return true
+	}
+	pathVar, _ := i.getenv("PATH")
+	_, err = i.searchPath(argv[0], pathVar)
+	return err != nil
+}
+
+// printCmd prints a command instead of running it, for a dry run. Its words
+// are quoted where they need to be, so that it could be run as it is.
+func (i *Interpreter) printCmd(c *ast.Cmd, values, argv []string) error {
+	var words []string
+	for index, a := range c.Assignments {
+		words = append(words, quoteWord(a.Name+"="+values[index]))
+	}
+	for _, arg := range argv {
+		words = append(words, quoteWord(arg))
+	}
+	_, err := fmt.Fprintln(i.Stdout, strings.Join(words, " "))
+	return err
+}
+
+// quoteWord puts a word in single quotes if it contains any characters which
+// are special to the shell, escaping any backslashes or single quotes in it.
+func quoteWord(s string) string {
+	if s != "" && !strings.ContainsAny(s, "$&|;<>() \t\n'\"\\!#~*?[]{}") {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// execute runs an external command and waits for it to finish. If env is nil,
+// the command inherits the shell's environment.
+func (i *Interpreter) execute(
+	argv []string, env []string, std stdio,
+) (int, error) {
+	path, err := i.lookPath(argv[0])
+	if err != nil {
+		status, err := startError(argv[0], err)
+		if status == 127 {
+			err = i.suggest(argv[0], err)
 		}
-		return 0, nil
-	} else {
-		cmd := exec.Command(argv[0], argv[1:]...)
-		cmd.Stdin = i.Stdin
-		cmd.Stdout = i.Stdout
-		cmd.Stderr = i.Stderr
-		err := cmd.Run()
-		status := cmd.ProcessState.ExitCode()
 		return status, err
 	}
+	return i.executePath(path, argv, env, std)
+}
+
+// executePath runs the external command at path, as execute does once it's
+// found the command.
+func (i *Interpreter) executePath(
+	path string, argv []string, env []string, std stdio,
+) (int, error) {
+	if env == nil {
+		env = i.environ()
+	}
+	// If the working directory is unknown, e.g. because it's been
+	// removed, leave the command to find out for itself.
+	dir, _ := i.getwd()
+	ctx := i.ctx()
+	// The path is absolute, so CommandContext won't look for it in the
+	// $PATH of the process.
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Args, cmd.Env, cmd.Dir = argv, env, dir
+	if i.ownGroup {
+		startOwnGroup(cmd)
+	}
+	cmd.Stdin = std.in
+	cmd.Stdout = std.out
+	cmd.Stderr = std.err
+	for fd, f := range std.extra {
+		// ExtraFiles starts at descriptor 3, with nil for any gaps.
+		for len(cmd.ExtraFiles) <= fd-3 {
+			cmd.ExtraFiles = append(cmd.ExtraFiles, nil)
+		}
+		cmd.ExtraFiles[fd-3] = f
+	}
+	if err := cmd.Start(); err != nil {
+		return startError(argv[0], err)
+	}
+	if i.started != nil {
+		i.started(cmd.Process)
+	}
+	if j := i.job; j != nil {
+		j.addProcess(cmd.Process)
+		defer j.removeProcess(cmd.Process)
+		// Keep track of whether the job is stopped, as `jobs` shows.
+		watchStops(cmd.Process.Pid, func(stopped bool) {
+			j.setStopped(cmd.Process, stopped)
+		})
+	}
+	err := cmd.Wait()
+	i.cpu.add(cmd.ProcessState)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// The command was killed, so its status is beside the point.
+		err = ctxErr
+	}
+	return exitStatus(cmd.ProcessState), err
+}
+
+// startError converts an error from starting a command into an exit status,
+// using the conventional statuses of 127 if the command couldn't be found, or
+// 126 if it was found but couldn't be executed.
+func startError(name string, err error) (int, error) {
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return 127, fmt.Errorf("%s: command not found", name)
+	case errors.Is(err, os.ErrPermission):
+		return 126, fmt.Errorf("%s: permission denied", name)
+	default:
+		return 126, err
+	}
+}
+
+// exitStatus converts the state of an exited process into a shell exit
+// status. Processes killed by a signal have an exit status of 128 plus the
+// signal number, by convention.
+func exitStatus(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+func (shell *Interpreter) VisitSubshell(s *ast.Subshell) (int, error) {
+	std, closeFiles, err := shell.redirect(s.Redirects)
+	if err != nil {
+		return 1, err
+	}
+	defer closeFiles()
+	subshell := shell.clone()
+	subshell.Stdin, subshell.Stdout = std.in, std.out
+	subshell.Stderr, subshell.fds = std.err, std.extra
+	status, err := s.Body.Visit(subshell)
+	status, err = subshell.runExitTrap(subshell.traps, status, err)
+	if e, ok := err.(ExitStatus); ok {
+		// `exit` only exits the subshell.
+		return int(e), nil
+	}
+	return status, err
+}
+
+// VisitGroup runs the body of a group in the current shell, so unlike in a
+// subshell, any changes it makes to the shell's state are kept.
+func (i *Interpreter) VisitGroup(g *ast.Group) (int, error) {
+	std, closeFiles, err := i.redirect(g.Redirects)
+	if err != nil {
+		return 1, err
+	}
+	defer closeFiles()
+	// Only the redirections are undone once the group has finished.
+	stdin, stdout, stderr, fds := i.Stdin, i.Stdout, i.Stderr, i.fds
+	defer func() {
+		i.Stdin, i.Stdout, i.Stderr, i.fds = stdin, stdout, stderr, fds
+	}()
+	i.Stdin, i.Stdout, i.Stderr = std.in, std.out, std.err
+	i.fds = std.extra
+	return g.Body.Visit(i)
+}
+
+// restoreEnv returns a function which restores an environment variable to its
+// current value, and to whether it's exported.
+func (i *Interpreter) restoreEnv(key string) func() {
+	value, ok := i.getenv(key)
+	noExport := i.attrs[key] & attrNoExport
+	return func() {
+		if ok {
+			i.setenv(key, value)
+		} else {
+			i.unsetenv(key)
+		}
+		if noExport != 0 {
+			i.addAttrs(key, noExport)
+		}
+	}
+}
+
+// clone returns a copy of the interpreter for running a subshell, a background
+// job or a stage of a pipeline, so that the subshell's changes to its state
+// don't affect the original.
+func (i *Interpreter) clone() *Interpreter {
+	return &Interpreter{
+		Stdin:      i.Stdin,
+		Stdout:     i.Stdout,
+		Stderr:     i.Stderr,
+		Options:    i.Options,
+		Args:       i.Args,
+		History:    i.History,
+		Context:    i.Context,
+		env:        copyEnv(i.env),
+		dir:        i.dir,
+		fds:        i.fds,
+		lastPid:    i.lastPid,
+		lastStatus: i.lastStatus,
+		vars:       copyVars(i.vars),
+		attrs:      copyAttrs(i.attrs),
+		funcs:      copyFuncs(i.funcs),
+		disabled:   copyDisabled(i.disabled),
+		scopes:     copyScopes(i.scopes),
+		started:    i.started,
+		cmdDone:    i.cmdDone,
+		cpu:        i.cpu,
+		job:        i.job,
+	}
+}
+
+// ctx returns the interpreter's Context, or a context which is never done if
+// it's unset.
+func (i *Interpreter) ctx() context.Context {
+	if i.Context == nil {
+		return context.Background()
+	}
+	return i.Context
+}
+
+// reportError prints an error which won't be returned to the caller.
+func (i *Interpreter) reportError(err error) {
+	i.stderrLock.Lock()
+	defer i.stderrLock.Unlock()
+	fmt.Fprintf(i.Stderr, "mesh: %v\n", err)
 }
 
 func (i *Interpreter) VisitString(s ast.String) (string, error) {
 	return s.Text, nil
 }
 
+// VisitGlob gives the text of a pattern, where it isn't expanded into paths,
+// e.g. in the value of an assignment.
+func (i *Interpreter) VisitGlob(g ast.Glob) (string, error) {
+	return unescapePattern(g.Text), nil
+}
+
 func (i *Interpreter) VisitTilde(t ast.Tilde) (string, error) {
-	home, err := os.UserHomeDir()
-	return home, err
+	name := strings.TrimPrefix(t.Text, "~")
+	if name == "" {
+		return i.homeDir()
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		// As in other shells, the tilde is left alone if there's no
+		// such user.
+		return t.Text, nil
+	}
+	return u.HomeDir, nil
 }
 
 func (i *Interpreter) VisitVar(v ast.Var) (string, error) {
-	// TODO: Implement an internal symbol table for shell variables.
-	return os.Getenv(v.Identifier), nil
+	if v.Index != nil {
+		index, err := v.Index.Visit(i)
+		if err != nil {
+			return "", err
+		} else if index == "@" {
+			elems := i.elements(v.Identifier)
+			elems, err = i.applyParamOps(v, elems)
+			return strings.Join(elems, " "), err
+		}
+		value, err := i.element(v.Identifier, index)
+		if err != nil {
+			return "", err
+		} else if isDefaultOp(v.Op) {
+			// An element counts as set unless it's empty.
+			return i.applyDefault(v, value, value != "")
+		}
+		return i.applyParamOp(v, value)
+	}
+	value, ok := i.lookupVar(v.Identifier)
+	if isDefaultOp(v.Op) {
+		return i.applyDefault(v, value, ok)
+	} else if !ok && i.Options.Nounset && v.Identifier != "@" {
+		// $@ is only unset because there are no arguments.
+		return "", fmt.Errorf("%s: unbound variable", v.Identifier)
+	}
+	return i.applyParamOp(v, value)
 }
 
 func (i *Interpreter) VisitWord(w ast.Word) (string, error) {
@@ -142,3 +862,48 @@ func (i *Interpreter) VisitWord(w ast.Word) (string, error) {
 	}
 	return word.String(), nil
 }
+
+// VisitCmdSubst runs the body of a command substitution in a subshell, and
+// gives its output. Any error is reported there, rather than stopping the
+// statement which expands it, and the status of an assignment which expands
+// it is that of the body, as in `x = $(false)`.
+func (i *Interpreter) VisitCmdSubst(c ast.CmdSubst) (string, error) {
+	var out strings.Builder
+	subshell := i.clone()
+	subshell.Stdout = &out
+	status, err := c.Body.Visit(subshell)
+	status, err = subshell.runExitTrap(subshell.traps, status, err)
+	if e, ok := err.(ExitStatus); ok {
+		status = int(e)
+	} else if err != nil {
+		subshell.reportError(err)
+		if status == 0 {
+			status = 1
+		}
+	}
+	i.expandStatus = status
+	return strings.TrimRight(out.String(), "\n"), nil
+}
+
+// VisitFileContents reads a file for `$(< file)`. If it can't be read, the
+// error is reported and the contents are empty, but the status of an
+// assignment which expands it is 1, as it would be if `cat` had failed.
+func (i *Interpreter) VisitFileContents(f ast.FileContents) (string, error) {
+	path, err := i.expandTarget(f.Path)
+	if err != nil {
+		return "", err
+	}
+	file, err := i.openFile(path, os.O_RDONLY)
+	if err != nil {
+		i.reportError(err)
+		i.expandStatus = 1
+		return "", nil
+	}
+	defer file.Close()
+	contents, err := ioutil.ReadAll(file)
+	if err != nil {
+		i.reportError(err)
+		i.expandStatus = 1
+	}
+	return strings.TrimRight(string(contents), "\n"), nil
+}
@@ -15,14 +15,24 @@
 package interpreter
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/meshshell/mesh/ast"
+	"github.com/meshshell/mesh/parser"
 )
 
 func TestInterpreter(t *testing.T) {
@@ -47,7 +57,11 @@ func TestInterpreter(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			var stdout, stderr strings.Builder
-			interp := Interpreter{stdin, &stdout, &stderr}
+			interp := Interpreter{
+				Stdin:  stdin,
+				Stdout: &stdout,
+				Stderr: &stderr,
+			}
 			var exprs []ast.Expr
 			for _, text := range test.argv {
 				exprs = append(exprs, ast.String{Text: text})
@@ -64,3 +78,562 @@ func TestInterpreter(t *testing.T) {
 		})
 	}
 }
+
+func TestNewInterpreter(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	dir, err := filepath.EvalSymlinks(tempdir)
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0700))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	_, pwdSet := os.LookupEnv("PWD")
+	pwd := os.Getenv("PWD")
+
+	var stdout strings.Builder
+	interp, err := NewInterpreter(Config{
+		Stdout: &stdout,
+		Stderr: &stdout,
+		Dir:    dir,
+		Env:    []string{"PATH=" + os.Getenv("PATH")},
+	})
+	require.NoError(t, err)
+	run := func(argv ...string) {
+		var exprs []ast.Expr
+		for _, text := range argv {
+			exprs = append(exprs, ast.String{Text: text})
+		}
+		status, err := interp.VisitCmd(&ast.Cmd{Argv: exprs})
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+	}
+	run("cd", "sub")
+	run("declare", "-x", "MESH_VALUE=local")
+	run("sh", "-c", `pwd; echo "$MESH_VALUE"`)
+	assert.Equal(t, filepath.Join(dir, "sub")+"\nlocal\n", stdout.String())
+
+	// The process itself is left as it was.
+	after, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, wd, after)
+	_, ok := os.LookupEnv("MESH_VALUE")
+	assert.False(t, ok)
+	_, ok = os.LookupEnv("PWD")
+	assert.Equal(t, pwdSet, ok)
+	assert.Equal(t, pwd, os.Getenv("PWD"))
+}
+
+func TestNewInterpreterDefaultEnv(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(os.TempDir())
+	require.NoError(t, err)
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	for _, test := range []struct {
+		name string
+		env  []string
+		vars map[string]string
+	}{
+		{
+			"Missing", []string{}, map[string]string{
+				"HOSTNAME": hostname,
+				"UID":      strconv.Itoa(os.Getuid()),
+				"EUID":     strconv.Itoa(os.Geteuid()),
+				"PWD":      dir,
+			},
+		},
+		{
+			"Set", []string{"HOSTNAME=h", "UID=u", "HOME=/h"},
+			map[string]string{
+				"HOSTNAME": "h",
+				"UID":      "u",
+				"HOME":     "/h",
+			},
+		},
+		{
+			"SamePWD", []string{"PWD=" + dir + "/."},
+			map[string]string{"PWD": dir + "/."},
+		},
+		{
+			"OtherPWD", []string{"PWD=/"},
+			map[string]string{"PWD": dir},
+		},
+		{
+			"RelativePWD", []string{"PWD=."},
+			map[string]string{"PWD": dir},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			interp, err := NewInterpreter(Config{
+				Dir: dir,
+				Env: test.env,
+			})
+			require.NoError(t, err)
+			for name, value := range test.vars {
+				actual, ok := interp.getenv(name)
+				assert.True(t, ok, name)
+				assert.Equal(t, value, actual, name)
+			}
+		})
+	}
+}
+
+func TestKilledBySignal(t *testing.T) {
+	for _, test := range []struct {
+		signal string
+		status int
+	}{
+		{"INT", 130},
+		{"KILL", 137},
+		{"TERM", 143},
+	} {
+		t.Run(test.signal, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			interp := Interpreter{Stdout: &stdout, Stderr: &stderr}
+			script := "kill -" + test.signal + " $$"
+			cmd := &ast.Cmd{Argv: []ast.Expr{
+				ast.String{Text: "sh"},
+				ast.String{Text: "-c"},
+				ast.String{Text: script},
+			}}
+			status, err := interp.VisitCmd(cmd)
+			assert.Error(t, err)
+			assert.Equal(t, test.status, status)
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var stdout, stderr strings.Builder
+	interp := Interpreter{Stdout: &stdout, Stderr: &stderr, Context: ctx}
+	sleep := &ast.Cmd{Argv: []ast.Expr{
+		ast.String{Text: "sleep"},
+		ast.String{Text: "10"},
+	}}
+	echo := &ast.Cmd{Argv: []ast.Expr{
+		ast.String{Text: "echo"},
+		ast.String{Text: "a"},
+	}}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	status, err := interp.VisitStmtList(&ast.StmtList{
+		Stmts: []ast.Stmt{sleep, echo},
+	})
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 137, status)
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "", stderr.String())
+}
+
+func TestJobNotifications(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		interactive bool
+		argv        []string
+		stderr      string
+	}{
+		{"Script", false, []string{"true"}, ""},
+		{"Interactive", true, []string{"true"}, "[1] PID\n[1]+ Done\n"},
+		{"NonZeroExit", true, []string{"false"}, "[1] PID\n[1]+ Exit 1\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			interp := Interpreter{
+				Stdout:      &stdout,
+				Stderr:      &stderr,
+				Interactive: test.interactive,
+			}
+			var exprs []ast.Expr
+			for _, text := range test.argv {
+				exprs = append(exprs, ast.String{Text: text})
+			}
+			bg := &ast.Background{Stmt: &ast.Cmd{Argv: exprs}}
+			status, err := interp.VisitBackground(bg)
+			require.NoError(t, err)
+			assert.Equal(t, 0, status)
+			require.Len(t, interp.jobs, 1)
+			j := interp.jobs[0]
+			<-j.done
+			interp.NotifyJobs()
+			assert.Empty(t, interp.jobs)
+			pid := strconv.Itoa(j.pid)
+			assert.Equal(t,
+				strings.ReplaceAll(test.stderr, "PID", pid),
+				// Background commands that fail print an
+				// error, which isn't a job notification.
+				strings.Replace(stderr.String(),
+					"mesh: exit status 1\n", "", 1))
+			assert.Empty(t, stdout.String())
+		})
+	}
+}
+
+func TestBackgroundBuiltins(t *testing.T) {
+	// The job never starts a process, so there's no PID to wait for.
+	stmts, err := parser.ParseAll("test", strings.NewReader(
+		"for ((;;)); do test 1; done &\n"))
+	require.NoError(t, err)
+	require.Len(t, stmts, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var stdout, stderr strings.Builder
+	interp := Interpreter{
+		Stdout:      &stdout,
+		Stderr:      &stderr,
+		Interactive: true,
+		Context:     ctx,
+	}
+	started := make(chan error, 1)
+	go func() {
+		_, err := stmts[0].Visit(&interp)
+		started <- err
+	}()
+	select {
+	case err := <-started:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job didn't start in the background")
+	}
+	require.Len(t, interp.jobs, 1)
+	// There's no PID to report, rather than a PID of 0.
+	assert.Equal(t, "[1]\n", stderr.String())
+	// Stop the loop, which otherwise runs forever.
+	cancel()
+	<-interp.jobs[0].done
+}
+
+func TestProgramsRunByCmd(t *testing.T) {
+	// Each program appends its name to the log, which must be in the
+	// command which VisitCmd ran it for, whatever statement that's in.
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "log")
+	run := "sh -c 'echo $0 >>" + log + "' "
+	names := []string{
+		"plain", "pipeline", "subshell", "group", "and", "or", "for",
+		"case", "function", "background", "command", "timeout", "not",
+		"time",
+	}
+	stmts, err := parser.ParseAll("test", strings.NewReader(
+		run+"plain\n"+
+			run+"pipeline | cat\n"+
+			"("+run+"subshell)\n"+
+			"{ "+run+"group; }\n"+
+			run+"and && ! "+run+"or || true\n"+
+			"for ((n = 0; n < 1; n += 1)); do "+run+"for; done\n"+
+			"case x in x) "+run+"case;; esac\n"+
+			"f() { "+run+"function; }; f\n"+
+			run+"background &\n"+
+			"command "+run+"command\n"+
+			"timeout 5 "+run+"timeout\n"+
+			"! "+run+"not\n"+
+			"time "+run+"time\n"))
+	require.NoError(t, err)
+	var stdout, stderr strings.Builder
+	var mu sync.Mutex
+	var cmds []string
+	interp := Interpreter{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		cmdDone: func(c *ast.Cmd) {
+			mu.Lock()
+			defer mu.Unlock()
+			cmds = append(cmds, ast.Unparse(c))
+		},
+	}
+	for _, s := range stmts {
+		_, _ = s.Visit(&interp)
+	}
+	for _, j := range interp.jobs {
+		<-j.done
+	}
+
+	data, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	logged := strings.Fields(string(data))
+	sort.Strings(names)
+	sort.Strings(logged)
+	assert.Equal(t, names, logged)
+	for _, name := range logged {
+		found := false
+		for _, c := range cmds {
+			if strings.HasSuffix(c, "' "+name) {
+				found = true
+			}
+		}
+		assert.True(t, found, "%s didn't run through VisitCmd", name)
+	}
+}
+
+func TestFieldSplitting(t *testing.T) {
+	tests := []struct {
+		name   string
+		ifs    *string
+		value  string
+		before string
+		after  string
+		fields []string
+	}{
+		{
+			"Unset", nil, " a\tb\n c ",
+			"", "", []string{"a", "b", "c"},
+		},
+		{"Empty", nil, "", "", "", nil},
+		{"OnlySpaces", nil, "   ", "", "", nil},
+		{
+			"JoinedToLiterals", nil, "a b",
+			"<", ">", []string{"<a", "b>"},
+		},
+		{
+			"SpacesBetweenLiterals", nil, " ",
+			"a", "b", []string{"a", "b"},
+		},
+		{"EmptyLiteral", nil, "a ", "", "''", []string{"a", ""}},
+		{
+			"NoSplitting", strPtr(""), " a b ",
+			"", "", []string{" a b "},
+		},
+		{
+			"Delimiter", strPtr(":"), "a::b:",
+			"", "", []string{"a", "", "b"},
+		},
+		{
+			"LeadingDelimiter", strPtr(":"), ":a",
+			"", "", []string{"", "a"},
+		}, {
+			"SpaceAroundDelimiter", strPtr(" :"), " a : b :: c ",
+			"", "", []string{"a", "b", "", "c"},
+		},
+	}
+	old, ok := os.LookupEnv("IFS")
+	if ok {
+		defer os.Setenv("IFS", old)
+	} else {
+		defer os.Unsetenv("IFS")
+	}
+	defer os.Unsetenv("MESH_VALUE")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.ifs == nil {
+				require.NoError(t, os.Unsetenv("IFS"))
+			} else {
+				require.NoError(t, os.Setenv("IFS", *test.ifs))
+			}
+			require.NoError(t, os.Setenv("MESH_VALUE", test.value))
+			var word ast.Word
+			if test.before != "" {
+				word.SubExprs = append(word.SubExprs,
+					ast.String{Text: test.before})
+			}
+			word.SubExprs = append(word.SubExprs,
+				&ast.Var{Identifier: "MESH_VALUE"})
+			if test.after == "''" {
+				word.SubExprs = append(word.SubExprs,
+					ast.String{Text: ""})
+			} else if test.after != "" {
+				word.SubExprs = append(word.SubExprs,
+					ast.String{Text: test.after})
+			}
+			fields, err := (&Interpreter{}).expandFields(&word)
+			require.NoError(t, err)
+			assert.Equal(t, test.fields, fields)
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestPatternRegexp(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		matches []string
+		others  []string
+	}{
+		{"", []string{""}, []string{"a"}},
+		{"a*b", []string{"ab", "a/b", "axxb"}, []string{"a", "ba"}},
+		{"?.go", []string{"a.go", "/.go"}, []string{".go", "ab.go"}},
+		{`\*.\?`, []string{"*.?"}, []string{"a.b"}},
+		{"[ab]", []string{"a", "b"}, []string{"c", "[ab]"}},
+		{"[!a-c]", []string{"d", "-"}, []string{"a", "b"}},
+		{"[]x]", []string{"]", "x"}, []string{"[]x]"}},
+		{"[[:digit:]^]", []string{"1", "^"}, []string{"a"}},
+		{"[a", []string{"[a"}, []string{"a"}},
+		{"(.+)", []string{"(.+)"}, []string{"(a)"}},
+	} {
+		t.Run(test.pattern, func(t *testing.T) {
+			expr := "^" + patternRegexp(test.pattern) + "$"
+			re, err := regexp.Compile(expr)
+			require.NoError(t, err)
+			for _, s := range test.matches {
+				assert.True(t, re.MatchString(s), s)
+			}
+			for _, s := range test.others {
+				assert.False(t, re.MatchString(s), s)
+			}
+		})
+	}
+}
+
+func TestCompileExtglob(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		matches []string
+		others  []string
+	}{
+		{"@(a|bc).go", []string{"a.go", "bc.go"}, []string{"ab.go"}},
+		{"?(a|b)c", []string{"c", "ac", "bc"}, []string{"abc", "dc"}},
+		{"*(ab|c)", []string{"", "ab", "cabc"}, []string{"a", "abb"}},
+		{"+(ab|c)", []string{"ab", "cabc"}, []string{"", "a"}},
+		{"!(*.go)", []string{"", "a.txt", "go"}, []string{"a.go"}},
+		{"a!(b)c", []string{"ac", "axc", "abbc"}, []string{"abc"}},
+		{"@(a|+(b))", []string{"a", "bbb"}, []string{"ab", ""}},
+		{"[@(]x|y", []string{"@x|y", "(x|y"}, []string{"x", "y"}},
+		{`@(\)|\|)`, []string{")", "|"}, []string{`\`, ""}},
+		{"*(é|a)", []string{"éa", "aé"}, []string{"e", "b"}},
+	} {
+		t.Run(test.pattern, func(t *testing.T) {
+			p, err := compileExtglob(test.pattern)
+			require.NoError(t, err)
+			for _, s := range test.matches {
+				assert.True(t, p.MatchString(s), s)
+			}
+			for _, s := range test.others {
+				assert.False(t, p.MatchString(s), s)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"git", "git", 0},
+		{"gti", "git", 2},
+		{"gt", "git", 1},
+		{"gitt", "git", 1},
+		{"got", "git", 1},
+		{"", "ls", 2},
+		{"kitten", "sitting", 3},
+		{"héllo", "hello", 1},
+	} {
+		t.Run(test.a+"/"+test.b, func(t *testing.T) {
+			assert.Equal(t,
+				test.distance, editDistance(test.a, test.b))
+			assert.Equal(t,
+				test.distance, editDistance(test.b, test.a))
+		})
+	}
+}
+
+func TestArith(t *testing.T) {
+	defer os.Unsetenv("MESH_A")
+	defer os.Unsetenv("MESH_B")
+	require.NoError(t, os.Setenv("MESH_A", "MESH_B * 2"))
+	require.NoError(t, os.Setenv("MESH_B", "3"))
+	for _, test := range []struct {
+		expr   string
+		result int64
+		err    string
+	}{
+		{expr: "", result: 0},
+		{expr: "1 + 2 * 3", result: 7},
+		{expr: "(1 + 2) * 3", result: 9},
+		{expr: "-7 / 2 + 7 % 2", result: -2},
+		{expr: "0x10 + 010", result: 24},
+		{expr: "1 < 2 && !(2 <= 1) || 0", result: 1},
+		{expr: "3 == 3 != 0", result: 1},
+		{expr: "MESH_A + MESH_UNSET", result: 6},
+		{expr: "1 / 0", err: "division by 0"},
+		{expr: "09", err: "09: invalid number"},
+		{expr: "1 +", err: "1 +: syntax error in expression"},
+		{expr: "(1", err: "(1: syntax error in expression"},
+		{expr: "1 2", err: "1 2: syntax error in expression"},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			result, err := (&Interpreter{}).arith(test.expr)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.result, result)
+		})
+	}
+}
+
+func TestArithAssign(t *testing.T) {
+	defer os.Unsetenv("MESH_C")
+	for _, test := range []struct {
+		expr   string
+		result int64
+		value  string
+	}{
+		{expr: "MESH_C = 2 * 3", result: 6, value: "6"},
+		{expr: "MESH_C += MESH_C == 1", result: 2, value: "2"},
+		{expr: "MESH_C %= 2", result: 1, value: "1"},
+		{expr: "MESH_C++ + 1", result: 2, value: "2"},
+		{expr: "--MESH_C", result: 0, value: "0"},
+		{expr: "0 && MESH_C++ || 1", result: 1, value: "1"},
+		{expr: "1 || (MESH_C = 5)", result: 1, value: "1"},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			require.NoError(t, os.Setenv("MESH_C", "1"))
+			result, err := (&Interpreter{}).arith(test.expr)
+			require.NoError(t, err)
+			assert.Equal(t, test.result, result)
+			assert.Equal(t, test.value, os.Getenv("MESH_C"))
+		})
+	}
+
+	for _, expr := range []string{"1 = 2", "++1", "MESH_C++ = 2"} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := (&Interpreter{}).arith(expr)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestArithRecursion(t *testing.T) {
+	defer os.Unsetenv("MESH_A")
+	require.NoError(t, os.Setenv("MESH_A", "MESH_A"))
+	_, err := (&Interpreter{}).arith("MESH_A")
+	assert.EqualError(t, err,
+		"MESH_A: expression recursion level exceeded")
+}
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	interp := &Interpreter{Options: Options{Noclobber: true}}
+	interp.dir = dir
+	write := func(name string, clobber bool) error {
+		f, err := interp.create(name, clobber)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// The file is only opened for writing.
+		_, err = f.Read(make([]byte, 1))
+		assert.Error(t, err)
+		_, err = f.WriteString(name + "\n")
+		return err
+	}
+
+	require.NoError(t, write("new", false))
+	assert.EqualError(t, write("new", false),
+		"new: cannot overwrite existing file")
+	require.NoError(t, write(os.DevNull, false))
+	require.NoError(t, write("new", true))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	assert.Equal(t, "new\n", string(data))
+}
@@ -0,0 +1,362 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+func init() {
+	registerBuiltin("fg", Builtin{
+		run:     fg,
+		Summary: "Move a background job into the foreground.",
+		Usage:   "fg [%job]",
+	})
+	registerBuiltin("bg", Builtin{
+		run:     bg,
+		Summary: "Continue a stopped job in the background.",
+		Usage:   "bg [%job]",
+	})
+	registerBuiltin("jobs", Builtin{
+		run:     jobs,
+		Summary: "List the background jobs and their states.",
+		Usage:   "jobs",
+	})
+}
+
+// job is a statement running in the background.
+type job struct {
+	id  int
+	pid int
+	// text is the statement which the job runs, as `jobs` shows it.
+	text string
+	// done is closed once the job has finished, after which status and
+	// err are safe to read.
+	done   chan struct{}
+	status int
+	err    error
+	// procs holds the external processes which the job is running, each
+	// mapped to whether it's stopped, e.g. by SIGTSTP. It's guarded by
+	// lock, since the processes start and stop while the job runs.
+	lock  sync.Mutex
+	procs map[*os.Process]bool
+	// stopReported is set once an interactive shell has reported that
+	// the job is stopped, so that it's only reported once.
+	stopReported bool
+}
+
+func (j *job) addProcess(p *os.Process) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.procs == nil {
+		j.procs = make(map[*os.Process]bool)
+	}
+	j.procs[p] = false
+}
+
+func (j *job) removeProcess(p *os.Process) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	delete(j.procs, p)
+}
+
+func (j *job) setStopped(p *os.Process, stopped bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.procs[p]; ok {
+		j.procs[p] = stopped
+	}
+}
+
+// stopped reports whether any of the job's processes are stopped.
+func (j *job) stopped() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for _, stopped := range j.procs {
+		if stopped {
+			return true
+		}
+	}
+	return false
+}
+
+// resume sends SIGCONT to each of the job's processes, so that any which are
+// stopped carry on running.
+func (j *job) resume() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for p := range j.procs {
+		resumeProcess(p)
+		j.procs[p] = false
+	}
+	j.stopReported = false
+}
+
+// state describes the state of the job, as `jobs` shows it.
+func (j *job) state() string {
+	switch {
+	case !j.finished() && j.stopped():
+		return "Stopped"
+	case !j.finished():
+		return "Running"
+	case j.status == 0:
+		return "Done"
+	default:
+		return fmt.Sprintf("Exit %d", j.status)
+	}
+}
+
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// newJob adds a new job to the job table. Job IDs count up from one more
+// than the highest ID currently in use, as in other shells.
+func (i *Interpreter) newJob() *job {
+	id := 1
+	if len(i.jobs) > 0 {
+		id = i.jobs[len(i.jobs)-1].id + 1
+	}
+	j := &job{id: id, done: make(chan struct{})}
+	i.jobs = append(i.jobs, j)
+	return j
+}
+
+// NotifyJobs removes finished jobs from the job table. In interactive mode it
+// also reports each one to stderr, so it should be called before each prompt.
+func (i *Interpreter) NotifyJobs() {
+	running := i.jobs[:0]
+	for _, j := range i.jobs {
+		if !j.finished() {
+			running = append(running, j)
+			if i.Interactive && j.stopped() && !j.stopReported {
+				fmt.Fprintf(i.Stderr, "[%d]+ Stopped\n", j.id)
+				j.stopReported = true
+			}
+		} else if !i.Interactive {
+			continue
+		} else if j.status == 0 {
+			fmt.Fprintf(i.Stderr, "[%d]+ Done\n", j.id)
+		} else {
+			fmt.Fprintf(i.Stderr, "[%d]+ Exit %d\n", j.id, j.status)
+		}
+	}
+	i.jobs = running
+	if len(running) == 0 {
+		// Warn again next time there are running jobs.
+		i.exitWarned = false
+	}
+}
+
+// runningJobs reports whether any background jobs are still running.
+func (i *Interpreter) runningJobs() bool {
+	for _, j := range i.jobs {
+		if !j.finished() {
+			return true
+		}
+	}
+	return false
+}
+
+// findJob returns the job identified by a job spec such as `%1`. The specs
+// `%%` and `%+` refer to the most recent job, and `%-` to the one before it.
+func (i *Interpreter) findJob(spec string) (*job, bool) {
+	n := len(i.jobs)
+	switch spec {
+	case "%%", "%+":
+		if n > 0 {
+			return i.jobs[n-1], true
+		}
+	case "%-":
+		if n > 1 {
+			return i.jobs[n-2], true
+		}
+	default:
+		id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+		if err != nil {
+			return nil, false
+		}
+		for _, j := range i.jobs {
+			if j.id == id {
+				return j, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// firstProcess returns the simple command which a job, such as a coprocess,
+// runs first, if it runs an external program, whose PID is then the job's, as
+// in `{ cmd; }` or `cmd | other`. Otherwise it returns nil: the job starts in
+// the shell itself, so it has no PID, and waiting for one could wait forever,
+// e.g. for `read` to be given a line, or for a loop which only runs builtins.
+func (i *Interpreter) firstProcess(stmt ast.Stmt) *ast.Cmd {
+	switch s := stmt.(type) {
+	case *ast.Pipeline:
+		return i.firstProcess(s.Stmts[0])
+	case *ast.StmtList:
+		if len(s.Stmts) > 0 {
+			return i.firstProcess(s.Stmts[0])
+		}
+	case *ast.Group:
+		return i.firstProcess(s.Body)
+	case *ast.Subshell:
+		return i.firstProcess(s.Body)
+	case *ast.AndOr:
+		return i.firstProcess(s.Left)
+	case *ast.Not:
+		return i.firstProcess(s.Stmt)
+	case *ast.Cmd:
+		if i.runsProgram(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+// runsProgram reports whether a simple command runs an external program,
+// rather than a builtin or a function.
+func (i *Interpreter) runsProgram(c *ast.Cmd) bool {
+	if len(c.Argv) == 0 {
+		return false
+	}
+	first := c.Argv[0]
+	if w, ok := first.(*ast.Word); ok && len(w.SubExprs) == 1 {
+		first = w.SubExprs[0]
+	}
+	name, ok := first.(ast.String)
+	if !ok {
+		return false
+	}
+	_, isFunc := i.funcs[name.Text]
+	return !isFunc && !i.builtinEnabled(name.Text)
+}
+
+func (i *Interpreter) removeJob(j *job) {
+	for index, other := range i.jobs {
+		if other == j {
+			i.jobs = append(i.jobs[:index], i.jobs[index+1:]...)
+			return
+		}
+	}
+}
+
+// jobSpec returns the job spec given to fg or bg, which is the most recent
+// job if there isn't one.
+func jobSpec(b *builtin, name string) (string, error) {
+	switch len(b.args) {
+	case 0:
+		return "%%", nil
+	case 1:
+		return b.args[0], nil
+	default:
+		return "", fmt.Errorf("%s: too many arguments", name)
+	}
+}
+
+func fg(b *builtin) (int, error) {
+	spec, err := jobSpec(b, "fg")
+	if err != nil {
+		return 1, err
+	}
+	j, ok := b.shell.findJob(spec)
+	if !ok {
+		return 127, fmt.Errorf("fg: %s: no such job", spec)
+	}
+	// The job may have been stopped, in which case it would never finish.
+	j.resume()
+	// While the job is in the foreground, pass on any signals from the
+	// terminal to the job instead of letting them kill the shell.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	for !j.finished() {
+		select {
+		case sig := <-signals:
+			if p, err := os.FindProcess(j.pid); err == nil && j.pid > 0 {
+				p.Signal(sig)
+			}
+		case <-j.done:
+		}
+	}
+	b.shell.removeJob(j)
+	return j.status, nil
+}
+
+func bg(b *builtin) (int, error) {
+	spec, err := jobSpec(b, "bg")
+	if err != nil {
+		return 1, err
+	}
+	j, ok := b.shell.findJob(spec)
+	if !ok {
+		return 1, fmt.Errorf("bg: %s: no such job", spec)
+	} else if j.finished() {
+		return 1, fmt.Errorf("bg: %s: job has finished", spec)
+	}
+	j.resume()
+	_, err = fmt.Fprintf(b.out, "[%d] %s &\n", j.id, j.text)
+	return 0, err
+}
+
+// jobs lists the background jobs, marking the most recent with `+` and the
+// one before it with `-`, as the specs `%+` and `%-` refer to them. Any which
+// have finished are removed from the table once they've been listed.
+func jobs(b *builtin) (int, error) {
+	if len(b.args) > 0 {
+		return 1, fmt.Errorf("jobs: too many arguments")
+	}
+	n := len(b.shell.jobs)
+	running := b.shell.jobs[:0]
+	var err error
+	for index, j := range b.shell.jobs {
+		// Check first, so that a job isn't removed without its state
+		// being listed as finished.
+		finished := j.finished()
+		mark := ' '
+		switch index {
+		case n - 1:
+			mark = '+'
+		case n - 2:
+			mark = '-'
+		}
+		_, e := fmt.Fprintf(b.out, "[%d]%c  %-24s%s\n",
+			j.id, mark, j.state(), j.text)
+		if err == nil {
+			err = e
+		}
+		if !finished {
+			running = append(running, j)
+		}
+	}
+	b.shell.jobs = running
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
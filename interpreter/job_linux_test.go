@@ -0,0 +1,86 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package interpreter
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/parser"
+)
+
+func TestStoppedJob(t *testing.T) {
+	var stdout strings.Builder
+	interp := &Interpreter{Stdout: &stdout, Stderr: &stdout}
+	run := func(name string) (int, error) {
+		b, ok := newBuiltin(interp, name, nil)
+		require.True(t, ok)
+		return b.run()
+	}
+	stmts, err := parser.ParseAll("test", strings.NewReader("sleep 1 &\n"))
+	require.NoError(t, err)
+	_, err = stmts[0].Visit(interp)
+	require.NoError(t, err)
+	require.Len(t, interp.jobs, 1)
+	j := interp.jobs[0]
+	require.NotEqual(t, 0, j.pid)
+	stop := func() {
+		require.NoError(t, syscall.Kill(j.pid, syscall.SIGSTOP))
+		deadline := time.Now().Add(5 * time.Second)
+		for !j.stopped() {
+			require.True(t, time.Now().Before(deadline),
+				"the job wasn't seen to stop")
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	stop()
+	_, err = run("jobs")
+	require.NoError(t, err)
+	assert.Equal(t, "[1]+  Stopped                 sleep 1\n",
+		stdout.String())
+	stdout.Reset()
+	status, err := run("bg")
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "[1] sleep 1 &\n", stdout.String())
+	stdout.Reset()
+	_, err = run("jobs")
+	require.NoError(t, err)
+	assert.Equal(t, "[1]+  Running                 sleep 1\n",
+		stdout.String())
+
+	// Without being continued, the job would never finish.
+	stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		status, err = run("fg")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fg didn't continue the job")
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Empty(t, interp.jobs)
+}
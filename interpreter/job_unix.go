@@ -0,0 +1,30 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+//go:build unix
+// +build unix
+
+package interpreter
+
+import (
+	"os"
+	"syscall"
+)
+
+// resumeProcess sends SIGCONT to a process, which continues it if it's
+// stopped. It may have just finished, so there's no error to report.
+func resumeProcess(p *os.Process) {
+	_ = p.Signal(syscall.SIGCONT)
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func init() {
+	registerBuiltin("json", Builtin{
+		run:     json_,
+		Summary: "Convert variables to and from JSON.",
+		Usage:   "json dump name | json parse name",
+	})
+}
+
+// json_ converts variables to and from JSON. `json dump name` prints an
+// associative array as an object, an array as an array, and any other
+// variable as a string. `json parse name` reads a JSON value from stdin into
+// a variable in the same way, so an object becomes an associative array. Any
+// value nested in an array or object is kept as JSON text, so that it can be
+// parsed in turn, as in `json parse inner <<<$outer[key]`.
+//
+// The variable must be named, and is set in the shell which runs json, so
+// its input should be redirected rather than piped in, since each command in
+// a pipeline runs in a subshell. The output of a command can be parsed by
+// substituting it, as in `json parse data <<<$(curl ...)`.
+func json_(b *builtin) (int, error) {
+	if len(b.args) != 2 ||
+		(b.args[0] != "dump" && b.args[0] != "parse") {
+		err := errors.New("json: usage: json dump name | " +
+			"json parse name")
+		return 2, err
+	}
+	name := b.args[1]
+	if !isName(name) {
+		err := fmt.Errorf("json: %s: not a valid identifier", name)
+		return 1, err
+	}
+	if b.args[0] == "dump" {
+		return b.shell.dumpJSON(b.out, name)
+	}
+	return b.shell.parseJSON(b.in, name)
+}
+
+// dumpJSON writes a variable to w as JSON.
+func (i *Interpreter) dumpJSON(w io.Writer, name string) (int, error) {
+	var value interface{}
+	if v, ok := i.vars[name]; ok && v.assoc != nil {
+		value = v.assoc
+	} else if ok {
+		value = append([]string{}, v.array...)
+	} else if scalar, ok := i.lookupVar(name); ok {
+		value = scalar
+	} else {
+		return 1, fmt.Errorf("json: %s: not set", name)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return 1, fmt.Errorf("json: %w", err)
+	}
+	return 0, nil
+}
+
+// parseJSON reads a JSON value from r into a variable.
+func (i *Interpreter) parseJSON(r io.Reader, name string) (int, error) {
+	if r == nil {
+		r = strings.NewReader("")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 1, fmt.Errorf("json: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return 1, fmt.Errorf("json: %v", err)
+	} else if dec.More() {
+		return 1, errors.New("json: more than one value in input")
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		assoc := make(map[string]string, len(value))
+		for key, elem := range value {
+			assoc[key], err = i.assignable(name, jsonText(elem))
+			if err != nil {
+				return 1, err
+			}
+		}
+		return i.setVar(name, &variable{assoc: assoc})
+	case []interface{}:
+		array := make([]string, len(value))
+		for n, elem := range value {
+			array[n], err = i.assignable(name, jsonText(elem))
+			if err != nil {
+				return 1, err
+			}
+		}
+		return i.setVar(name, &variable{array: array})
+	default:
+		return i.setScalar(name, jsonText(value))
+	}
+}
+
+// jsonText converts a decoded JSON value into text: a string is given as it
+// is, null is empty, and anything else is given as JSON.
+func jsonText(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		var b strings.Builder
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		// A decoded value can always be encoded again.
+		_ = enc.Encode(value)
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+)
+
+func init() {
+	registerBuiltin("set", Builtin{
+		run:     set,
+		Summary: "Turn shell options on or off.",
+		Usage:   "set [-eu|+eu] [-o|+o] [option]",
+	})
+	registerBuiltin("shopt", Builtin{
+		run:     shopt,
+		Summary: "Query shell options, or turn them on or off.",
+		Usage:   "shopt [-pqsu] [option ...]",
+	})
+}
+
+// Options are the shell options that change how statements are run.
+type Options struct {
+	// Autocd changes into a directory named on its own as a command, as
+	// if by `cd`, unless there's a command of that name.
+	Autocd bool
+	// Dotglob lets patterns match names starting with a `.`, though not
+	// `.` and `..` themselves.
+	Dotglob bool
+	// Errexit exits the shell as soon as a statement fails, unless its
+	// status is tested, e.g. by `&&`, `||` or `!`.
+	Errexit bool
+	// Extglob turns on extended globs, such as `@(a|b)`, which match
+	// one of the patterns in their group, or as many as they say, or
+	// anything but them with `!`.
+	Extglob bool
+	// Failglob makes a pattern which matches nothing an error. It takes
+	// precedence over Nullglob.
+	Failglob bool
+	// Noclobber stops `>` from overwriting an existing file, though `>|`
+	// still can.
+	Noclobber bool
+	// Nounset makes expanding an unset variable an error.
+	Nounset bool
+	// Nullglob removes a pattern which matches nothing, rather than
+	// leaving it as it is.
+	Nullglob bool
+	// Pipefail makes a pipeline fail if any command in it fails, rather
+	// than only the last one.
+	Pipefail bool
+	// Posix turns off mesh's extensions which would change the meaning
+	// of a portable script, for testing that it is one. A `**` in a
+	// pattern is the same as `*`, and the shell's parser is told to
+	// turn off its extensions too.
+	Posix bool
+	// Suggest adds the closest builtin, function or executable to the
+	// error for a command which isn't found, if one is close enough to
+	// be a typo. It's on by default in interactive shells.
+	Suggest bool
+	// Vi edits the lines typed into an interactive shell with vi's keys,
+	// rather than emacs's. The emacs option is simply its opposite.
+	Vi bool
+	// DryRun prints each command, once its words have been expanded,
+	// instead of running it, and skips redirections. Every command is
+	// assumed to succeed. Assignments and functions still run, so that
+	// later commands expand as they would have. Unlike the other options,
+	// it can't be set with `set`, which wouldn't run to unset it again.
+	DryRun bool
+}
+
+// optionNames lists the name of every option, in the order `set -o` shows
+// them.
+var optionNames = []string{
+	"autocd", "dotglob", "emacs", "errexit", "extglob", "failglob",
+	"noclobber", "nounset", "nullglob", "pipefail", "posix", "suggest",
+	"vi",
+}
+
+// option returns the named option, or nil if there's no such option. The
+// emacs option isn't stored separately, so it isn't one of them.
+func (o *Options) option(name string) *bool {
+	switch name {
+	case "autocd":
+		return &o.Autocd
+	case "dotglob":
+		return &o.Dotglob
+	case "errexit":
+		return &o.Errexit
+	case "extglob":
+		return &o.Extglob
+	case "failglob":
+		return &o.Failglob
+	case "noclobber":
+		return &o.Noclobber
+	case "nounset":
+		return &o.Nounset
+	case "nullglob":
+		return &o.Nullglob
+	case "pipefail":
+		return &o.Pipefail
+	case "posix":
+		return &o.Posix
+	case "suggest":
+		return &o.Suggest
+	case "vi":
+		return &o.Vi
+	default:
+		return nil
+	}
+}
+
+// Get reports whether the named option is on.
+func (o *Options) Get(name string) (bool, error) {
+	if name == "emacs" {
+		return !o.Vi, nil
+	}
+	option := o.option(name)
+	if option == nil {
+		return false, fmt.Errorf("%s: invalid option name", name)
+	}
+	return *option, nil
+}
+
+// Set turns the named option on or off.
+func (o *Options) Set(name string, on bool) error {
+	if name == "emacs" {
+		o.Vi = !on
+		return nil
+	}
+	option := o.option(name)
+	if option == nil {
+		return fmt.Errorf("%s: invalid option name", name)
+	}
+	*option = on
+	return nil
+}
+
+// optionLetters gives the options which `set` can also turn on or off by a
+// single letter, e.g. `set -eu`.
+var optionLetters = map[rune]string{
+	'e': "errexit",
+	'u': "nounset",
+}
+
+// set turns options on with `-o name`, or off with `+o name`, or by their
+// letters with e.g. `-u` or `+u`. Without a name, it shows whether each option
+// is on or off.
+func set(b *builtin) (int, error) {
+	args := b.args
+	if len(args) == 0 {
+		args = []string{"-o"}
+	}
+	for len(args) > 0 {
+		flag := args[0]
+		if flag != "-o" && flag != "+o" {
+			if err := b.setLetters(flag); err != nil {
+				return 2, err
+			}
+			args = args[1:]
+			continue
+		} else if len(args) == 1 {
+			return b.showOptions()
+		}
+		err := b.shell.Options.Set(args[1], flag == "-o")
+		if err != nil {
+			return 1, fmt.Errorf("set: %v", err)
+		}
+		args = args[2:]
+	}
+	return 0, nil
+}
+
+// setLetters sets the options given by letter in a flag such as `-eu`.
+func (b *builtin) setLetters(flag string) error {
+	if len(flag) < 2 || flag[0] != '-' && flag[0] != '+' {
+		return fmt.Errorf("set: %s: invalid option", flag)
+	}
+	for _, r := range flag[1:] {
+		if _, ok := optionLetters[r]; !ok {
+			return fmt.Errorf("set: %s: invalid option", flag)
+		}
+	}
+	for _, r := range flag[1:] {
+		b.shell.Options.Set(optionLetters[r], flag[0] == '-')
+	}
+	return nil
+}
+
+func (b *builtin) showOptions() (int, error) {
+	for _, name := range optionNames {
+		on, _ := b.shell.Options.Get(name)
+		state := "off"
+		if on {
+			state = "on"
+		}
+		_, err := fmt.Fprintf(b.out, "%-15s%s\n", name, state)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// shopt turns the named options on with `-s`, or off with `-u`. Otherwise it
+// shows whether they're on, or just gives a status of 0 if they all are with
+// `-q`. With `-p`, they're shown as the commands which would set them. Without
+// any names, every option is shown, or only those on or off with `-s` or `-u`.
+func shopt(b *builtin) (int, error) {
+	args := b.args
+	var commands, quiet bool
+	var turn rune
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'p':
+				commands = true
+			case 'q':
+				quiet = true
+			case 's', 'u':
+				if turn != 0 && turn != flag {
+					return 1, fmt.Errorf(
+						"shopt: cannot set and unset " +
+							"options at once")
+				}
+				turn = flag
+			default:
+				err := fmt.Errorf(
+					"shopt: -%c: invalid option", flag)
+				return 2, err
+			}
+		}
+	}
+	if turn != 0 && len(args) > 0 {
+		for _, name := range args {
+			err := b.shell.Options.Set(name, turn == 's')
+			if err != nil {
+				return 1, fmt.Errorf("shopt: %v", err)
+			}
+		}
+		return 0, nil
+	}
+	names := args
+	if len(names) == 0 {
+		names = optionNames
+	}
+	status := 0
+	for _, name := range names {
+		on, err := b.shell.Options.Get(name)
+		if err != nil {
+			return 1, fmt.Errorf("shopt: %v", err)
+		}
+		if !on {
+			status = 1
+		}
+		if quiet || turn == 's' && !on || turn == 'u' && on {
+			continue
+		}
+		if commands {
+			flag := "-u"
+			if on {
+				flag = "-s"
+			}
+			_, err = fmt.Fprintf(b.out, "shopt %s %s\n", flag, name)
+		} else {
+			state := "off"
+			if on {
+				state = "on"
+			}
+			_, err = fmt.Fprintf(b.out, "%-15s%s\n", name, state)
+		}
+		if err != nil {
+			return 1, err
+		}
+	}
+	if len(args) == 0 {
+		// Listing every option isn't asking whether they're on.
+		status = 0
+	}
+	return status, nil
+}
@@ -0,0 +1,327 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// applyParamOp applies the operator in a variable expansion such as
+// `${x%.txt}` to the variable's value.
+func (i *Interpreter) applyParamOp(v ast.Var, value string) (string, error) {
+	args := make([]string, len(v.Args))
+	for n, arg := range v.Args {
+		var err error
+		if args[n], err = arg.Visit(i); err != nil {
+			return "", err
+		}
+	}
+	switch v.Op {
+	case "":
+		return value, nil
+	case "#", "##", "%", "%%":
+		re, err := regexp.Compile("^" + patternRegexp(args[0]) + "$")
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", v.Identifier, err)
+		}
+		return removeAffix(v.Op, value, re), nil
+	case "/", "//", "/#", "/%":
+		expr := patternRegexp(args[0])
+		if v.Op == "/#" {
+			expr = "^" + expr
+		} else if v.Op == "/%" {
+			expr += "$"
+		} else if args[0] == "" {
+			// There's nothing to replace.
+			return value, nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", v.Identifier, err)
+		}
+		var replacement string
+		if len(args) > 1 {
+			replacement = args[1]
+		}
+		return substitute(v.Op, value, re, replacement), nil
+	case ":":
+		return i.substring(v, value, args)
+	case "-", ":-", "=", ":=", "+", ":+", "?", ":?":
+		// Each element of an array is set.
+		return i.applyDefault(v, value, true)
+	case "^", "^^", ",", ",,":
+		pattern := args[0]
+		if pattern == "" {
+			pattern = "?"
+		}
+		re, err := regexp.Compile("^" + patternRegexp(pattern) + "$")
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", v.Identifier, err)
+		}
+		return convertCase(v.Op, value, re), nil
+	default:
+		return "", fmt.Errorf("%s: bad substitution", v.Identifier)
+	}
+}
+
+// isDefaultOp reports whether an operator in `${...}` depends on whether the
+// variable is set, e.g. `${x:-default}`.
+func isDefaultOp(op string) bool {
+	switch strings.TrimPrefix(op, ":") {
+	case "-", "=", "+", "?":
+		return true
+	}
+	return false
+}
+
+// applyDefault applies an operator which depends on whether a variable is
+// set: `-` gives its argument instead of an unset variable, `=` assigns it to
+// the variable as well, `+` gives it instead of a set variable, and `?`
+// fails, with the argument as the message. With a `:`, an empty variable
+// counts as unset. The argument is only expanded if it's used.
+func (i *Interpreter) applyDefault(
+	v ast.Var, value string, set bool,
+) (string, error) {
+	if strings.HasPrefix(v.Op, ":") && value == "" {
+		set = false
+	}
+	op := strings.TrimPrefix(v.Op, ":")
+	if set != (op == "+") {
+		if op == "+" {
+			return "", nil
+		}
+		return value, nil
+	}
+	arg, err := v.Args[0].Visit(i)
+	if err != nil {
+		return "", err
+	}
+	switch op {
+	case "=":
+		if v.Index != nil || !isName(v.Identifier) {
+			return "", fmt.Errorf(
+				"%s: cannot assign in this way", v.Identifier)
+		}
+		if _, err := i.setScalar(v.Identifier, arg); err != nil {
+			return "", err
+		}
+	case "?":
+		if arg == "" {
+			arg = "parameter null or not set"
+		}
+		return "", fmt.Errorf("%s: %s", v.Identifier, arg)
+	}
+	return arg, nil
+}
+
+// applyParamOps applies the operator in a variable expansion such as
+// `${x[@]%.txt}` to each element of an array, returning the results.
+func (i *Interpreter) applyParamOps(
+	v ast.Var, elems []string,
+) ([]string, error) {
+	if v.Op == "" {
+		return elems, nil
+	}
+	values := make([]string, len(elems))
+	for n, elem := range elems {
+		var err error
+		if values[n], err = i.applyParamOp(v, elem); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// substring returns the part of value given by the offset and optional length
+// in args, for `${x:offset:length}`, counting in runes. Both are arithmetic
+// expressions, and negative ones count back from the end of the value. If the
+// offset or length is out of range, the result is empty.
+func (i *Interpreter) substring(
+	v ast.Var, value string, args []string,
+) (string, error) {
+	runes := []rune(value)
+	start, err := i.arith(args[0])
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", v.Identifier, err)
+	}
+	if start < 0 {
+		start += int64(len(runes))
+	}
+	end := int64(len(runes))
+	if len(args) > 1 {
+		n, err := i.arith(args[1])
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", v.Identifier, err)
+		}
+		if n < 0 {
+			end += n
+		} else if start+n < end {
+			end = start + n
+		}
+	}
+	if start < 0 || start >= end {
+		return "", nil
+	}
+	return string(runes[start:end]), nil
+}
+
+// convertCase converts the first character of value to upper case for `^`, or
+// every character for `^^`, and likewise to lower case for `,` and `,,`. Only
+// the characters which match re are converted.
+func convertCase(op, value string, re *regexp.Regexp) string {
+	convert := unicode.ToUpper
+	if op[0] == ',' {
+		convert = unicode.ToLower
+	}
+	var b strings.Builder
+	for n, r := range value {
+		if len(op) == 1 && n > 0 {
+			b.WriteString(value[n:])
+			break
+		}
+		if re.MatchString(string(r)) {
+			r = convert(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// removeAffix removes the shortest prefix of value which matches re for `#`,
+// or the longest for `##`, and likewise the shortest or longest suffix for
+// `%` and `%%`. The value is returned as it is if nothing matches.
+func removeAffix(op, value string, re *regexp.Regexp) string {
+	// cuts are the places where the value can be split in two, from the
+	// start to the end of it.
+	cuts := make([]int, 0, len(value)+1)
+	for n := range value {
+		cuts = append(cuts, n)
+	}
+	cuts = append(cuts, len(value))
+	prefix, shortest := op[0] == '#', len(op) == 1
+	for k := range cuts {
+		// Try the shortest prefix or suffix first, or the longest.
+		cut := cuts[k]
+		if prefix != shortest {
+			cut = cuts[len(cuts)-1-k]
+		}
+		if prefix && re.MatchString(value[:cut]) {
+			return value[cut:]
+		} else if !prefix && re.MatchString(value[cut:]) {
+			return value[:cut]
+		}
+	}
+	return value
+}
+
+// substitute replaces the longest match of re in value with replacement, the
+// first such match for `/`, or every one for `//`. The `/#` and `/%` operators
+// only match at the start or end of the value, which re is anchored to.
+func substitute(
+	op, value string, re *regexp.Regexp, replacement string,
+) string {
+	re.Longest()
+	if op == "//" {
+		return re.ReplaceAllLiteralString(value, replacement)
+	}
+	loc := re.FindStringIndex(value)
+	if loc == nil {
+		return value
+	}
+	return value[:loc[0]] + replacement + value[loc[1]:]
+}
+
+// patternRegexp converts a glob pattern into a regular expression which
+// matches the same strings. Unlike with filepath.Match, `*` and `?` match `/`
+// too, since the strings needn't be paths.
+func patternRegexp(pattern string) string {
+	var re strings.Builder
+	re.WriteString(`(?s:`)
+	for pattern != "" {
+		r, size := utf8.DecodeRuneInString(pattern)
+		switch r {
+		case '*':
+			re.WriteString(`.*`)
+		case '?':
+			re.WriteString(`.`)
+		case '\\':
+			if len(pattern) > size {
+				pattern = pattern[size:]
+				r, size = utf8.DecodeRuneInString(pattern)
+			}
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		case '[':
+			if class, n := bracketExpr(pattern); n > 0 {
+				re.WriteString(class)
+				size = n
+			} else {
+				re.WriteString(`\[`)
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+		pattern = pattern[size:]
+	}
+	re.WriteString(`)`)
+	return re.String()
+}
+
+// bracketExpr converts the bracket expression at the start of a pattern, e.g.
+// `[!a-z]`, into a character class, returning it along with the length of the
+// expression. The length is 0 if the `[` doesn't start an expression, because
+// there's no `]` to end it.
+func bracketExpr(pattern string) (string, int) {
+	var class strings.Builder
+	class.WriteString("[")
+	n := 1
+	if strings.HasPrefix(pattern[n:], "!") ||
+		strings.HasPrefix(pattern[n:], "^") {
+		class.WriteString("^")
+		n++
+	}
+	for first := true; n < len(pattern); first = false {
+		r, size := utf8.DecodeRuneInString(pattern[n:])
+		switch {
+		case r == ']' && !first:
+			class.WriteString("]")
+			return class.String(), n + size
+		case strings.HasPrefix(pattern[n:], "[:"):
+			// A character class such as `[:alpha:]`, which
+			// regular expressions have too.
+			end := strings.Index(pattern[n+2:], ":]")
+			if end == -1 {
+				return "", 0
+			}
+			size = end + 4
+			class.WriteString(pattern[n : n+size])
+		case r == '\\' && n+size < len(pattern):
+			n += size
+			r, size = utf8.DecodeRuneInString(pattern[n:])
+			class.WriteString(regexp.QuoteMeta(string(r)))
+		case r == '-':
+			class.WriteString("-")
+		default:
+			class.WriteString(regexp.QuoteMeta(string(r)))
+		}
+		n += size
+	}
+	return "", 0
+}
@@ -0,0 +1,260 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerBuiltin("read", Builtin{
+		run:     read,
+		Summary: "Read a line into variables.",
+		Usage:   "read [-r] [name ...]",
+	})
+	for _, name := range []string{"mapfile", "readarray"} {
+		registerBuiltin(name, Builtin{
+			run:     mapfile,
+			Summary: "Read lines into an array.",
+			Usage: name + " [-t|+t] [-n count] [-u fd] " +
+				"[array]",
+		})
+	}
+}
+
+// read reads a line from stdin, splits it into fields on the characters in
+// $IFS, and assigns each field to the next variable in turn, or the whole line
+// to $REPLY if there are none. The last variable gets the rest of the line. A
+// backslash escapes the character after it, so that it doesn't separate
+// fields, or joins the next line on if it's at the end of one, unless `-r` is
+// given. The status is 1 at the end of the input, though any text before it
+// is still assigned.
+func read(b *builtin) (int, error) {
+	args, raw := b.args, false
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		} else if args[0] != "-r" {
+			return 2, fmt.Errorf(
+				"read: %s: invalid option", args[0])
+		}
+		raw = true
+	}
+	names := args
+	if len(names) == 0 {
+		names = []string{"REPLY"}
+	}
+	for _, name := range names {
+		if !isName(name) {
+			return 1, fmt.Errorf(
+				"read: %s: not a valid identifier", name)
+		}
+	}
+	line, err := readLine(b.in, raw)
+	status := 0
+	if err == io.EOF {
+		status = 1
+	} else if err != nil {
+		return 1, fmt.Errorf("read: %w", err)
+	}
+	var fields []string
+	if len(args) == 0 {
+		// $REPLY gets the line as it is.
+		fields = []string{string(line.text)}
+	} else {
+		fields = line.fields(b.shell.ifs(), len(names))
+	}
+	for n, name := range names {
+		value := ""
+		if n < len(fields) {
+			value = fields[n]
+		}
+		if _, err := b.shell.setScalar(name, value); err != nil {
+			return 1, err
+		}
+	}
+	return status, nil
+}
+
+// mapfile reads lines from stdin into the elements of an array, $MAPFILE if
+// none is named, until the end of the input, or until it's read `-n count`
+// lines if count isn't zero. The newline at the end of each line is removed,
+// as with `-t`, unless `+t` is given. `-u fd` reads from another file
+// descriptor instead of stdin. Unlike read, it reads lines as they are,
+// without treating backslashes specially.
+func mapfile(b *builtin) (int, error) {
+	trim, count, in := true, 0, b.in
+	args := b.args
+	for ; len(args) > 0; args = args[1:] {
+		flag := args[0]
+		if !isOption(flag) && flag != "+t" {
+			break
+		} else if flag == "--" {
+			args = args[1:]
+			break
+		}
+		switch flag {
+		case "-t", "+t":
+			trim = flag == "-t"
+			continue
+		case "-n", "-u":
+		default:
+			return 2, fmt.Errorf(
+				"mapfile: %s: invalid option", flag)
+		}
+		if len(args) == 1 {
+			return 2, fmt.Errorf("mapfile: %s: "+
+				"option requires an argument", flag)
+		}
+		args = args[1:]
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return 2, fmt.Errorf(
+				"mapfile: %s: invalid number", args[0])
+		}
+		if flag == "-n" {
+			count = n
+			continue
+		}
+		r, ok := b.get(n).(io.Reader)
+		if !ok {
+			return 1, fmt.Errorf(
+				"mapfile: %d: invalid file descriptor", n)
+		}
+		in = r
+	}
+	array := "MAPFILE"
+	switch len(args) {
+	case 0:
+	case 1:
+		array = args[0]
+	default:
+		return 2, errors.New("mapfile: too many arguments")
+	}
+	if !isName(array) {
+		return 1, fmt.Errorf(
+			"mapfile: %s: not a valid identifier", array)
+	}
+	var lines []string
+	for count == 0 || len(lines) < count {
+		line, err := readLine(in, true)
+		if err != nil && err != io.EOF {
+			return 1, fmt.Errorf("mapfile: %w", err)
+		} else if err == io.EOF && len(line.text) == 0 {
+			break
+		}
+		text := string(line.text)
+		if !trim && err == nil {
+			text += "\n"
+		}
+		lines = append(lines, text)
+		if err == io.EOF {
+			break
+		}
+	}
+	return b.shell.setVar(array, &variable{array: lines})
+}
+
+// inputLine is a line read by `read`, noting which of its bytes were escaped.
+type inputLine struct {
+	text    []byte
+	escaped []bool
+}
+
+// readLine reads a line a byte at a time, so as not to read past the end of
+// it, leaving the rest of the input to whatever reads it next. It returns
+// io.EOF if the input ends before a newline.
+func readLine(r io.Reader, raw bool) (inputLine, error) {
+	var line inputLine
+	if r == nil {
+		return line, io.EOF
+	}
+	escape := false
+	buf := make([]byte, 1)
+	for {
+		if n, err := r.Read(buf); n == 0 && err != nil {
+			return line, err
+		} else if n == 0 {
+			continue
+		}
+		c := buf[0]
+		switch {
+		case escape && c == '\n':
+			// The line carries on to the next one.
+			escape = false
+		case escape:
+			line.text = append(line.text, c)
+			line.escaped = append(line.escaped, true)
+			escape = false
+		case c == '\\' && !raw:
+			escape = true
+		case c == '\n':
+			return line, nil
+		default:
+			line.text = append(line.text, c)
+			line.escaped = append(line.escaped, false)
+		}
+	}
+}
+
+// fields splits a line into at most n fields, in the same way as the splitter
+// splits an expansion, except that the last field is the rest of the line, as
+// it is, other than any whitespace around it.
+func (l inputLine) fields(ifs string, n int) []string {
+	// Only unescaped ASCII characters can separate fields, so that the
+	// line can be split byte by byte.
+	isIFS := func(pos int) bool {
+		c := l.text[pos]
+		return !l.escaped[pos] && c < 0x80 &&
+			strings.IndexByte(ifs, c) >= 0
+	}
+	isSpace := func(pos int) bool {
+		return isIFS(pos) &&
+			strings.IndexByte(defaultIFS, l.text[pos]) >= 0
+	}
+	skipSpace := func(pos int) int {
+		for pos < len(l.text) && isSpace(pos) {
+			pos++
+		}
+		return pos
+	}
+	var fields []string
+	pos := skipSpace(0)
+	for len(fields) < n-1 && pos < len(l.text) {
+		start := pos
+		for pos < len(l.text) && !isIFS(pos) {
+			pos++
+		}
+		fields = append(fields, string(l.text[start:pos]))
+		// Whitespace around a delimiter is part of it.
+		pos = skipSpace(pos)
+		if pos < len(l.text) && isIFS(pos) {
+			pos = skipSpace(pos + 1)
+		}
+	}
+	if pos < len(l.text) {
+		end := len(l.text)
+		for end > pos && isSpace(end-1) {
+			end--
+		}
+		fields = append(fields, string(l.text[pos:end]))
+	}
+	return fields
+}
@@ -0,0 +1,312 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+func init() {
+	registerBuiltin("exec", Builtin{
+		run:     exec_,
+		Summary: "Replace the shell with a command, or redirect it.",
+		Usage:   "exec [command [arg ...]]",
+	})
+}
+
+// maxFd is the highest file descriptor which can be redirected.
+const maxFd = 255
+
+// stdio holds the standard streams of a command, and any other files open at
+// descriptors from 3 upwards, keyed by descriptor.
+type stdio struct {
+	in    io.Reader
+	out   io.Writer
+	err   io.Writer
+	extra map[int]*os.File
+}
+
+// get returns what's open at file descriptor fd, or nil if nothing is.
+func (s *stdio) get(fd int) interface{} {
+	switch fd {
+	case 0:
+		if s.in != nil {
+			return s.in
+		}
+	case 1:
+		if s.out != nil {
+			return s.out
+		}
+	case 2:
+		if s.err != nil {
+			return s.err
+		}
+	default:
+		if f, ok := s.extra[fd]; ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// set opens f at file descriptor fd. The standard streams can be any reader or
+// writer, as appropriate, but other descriptors must be files, so that they can
+// be passed on to external commands.
+func (s *stdio) set(fd int, f interface{}) error {
+	var ok bool
+	switch {
+	case fd < 0 || fd > maxFd:
+	case fd == 0:
+		s.in, ok = f.(io.Reader)
+	case fd == 1:
+		s.out, ok = f.(io.Writer)
+	case fd == 2:
+		s.err, ok = f.(io.Writer)
+	default:
+		var file *os.File
+		if file, ok = f.(*os.File); ok {
+			if s.extra == nil {
+				s.extra = make(map[int]*os.File)
+			}
+			s.extra[fd] = file
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%d: bad file descriptor", fd)
+	}
+	return nil
+}
+
+// copyFds returns a copy of the files open at descriptors from 3 upwards.
+func copyFds(fds map[int]*os.File) map[int]*os.File {
+	c := make(map[int]*os.File, len(fds))
+	for fd, f := range fds {
+		c[fd] = f
+	}
+	return c
+}
+
+// redirect returns the standard streams for a command after applying its
+// redirections, along with a function to close any files that were opened,
+// which must be called once the command has finished.
+func (i *Interpreter) redirect(
+	redirects []*ast.Redirect,
+) (stdio, func(), error) {
+	std := stdio{i.Stdin, i.Stdout, i.Stderr, copyFds(i.fds)}
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			// Leave any files which `exec` has kept open.
+			if !i.hasFile(f) {
+				f.Close()
+			}
+		}
+	}
+	if i.Options.DryRun {
+		// Don't create or truncate any files.
+		return std, closeFiles, nil
+	}
+	for _, r := range redirects {
+		var target string
+		var err error
+		if r.Op == "<<" || r.Op == "<<<" {
+			// Text read by the command isn't split into words.
+			target, err = r.Target.Visit(i)
+		} else {
+			target, err = i.expandTarget(r.Target)
+		}
+		if err != nil {
+			closeFiles()
+			return std, nil, err
+		}
+		var f interface{}
+		switch r.Op {
+		case "<":
+			file, err := i.openFile(target, os.O_RDONLY)
+			if err != nil {
+				closeFiles()
+				return std, nil, err
+			}
+			files = append(files, file)
+			f = file
+		case "<<", "<<<":
+			if r.Op == "<<<" {
+				target += "\n"
+			}
+			if r.Fd < 3 {
+				f = strings.NewReader(target)
+				break
+			}
+			// Other descriptors must be files, so that they can be
+			// passed on to external commands.
+			file, err := pipeText(target)
+			if err != nil {
+				closeFiles()
+				return std, nil, err
+			}
+			files = append(files, file)
+			f = file
+		case ">", ">|", "&>", ">>", "&>>":
+			var file *os.File
+			if strings.HasSuffix(r.Op, ">>") {
+				file, err = i.openFile(target,
+					os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+			} else {
+				file, err = i.create(target, r.Op == ">|")
+			}
+			if err != nil {
+				closeFiles()
+				return std, nil, err
+			}
+			files = append(files, file)
+			f = file
+			if strings.HasPrefix(r.Op, "&") {
+				std.err = file
+			}
+		case "<&", ">&":
+			fd, err := strconv.Atoi(target)
+			if err == nil {
+				f = std.get(fd)
+			}
+			if f == nil {
+				closeFiles()
+				return std, nil, fmt.Errorf(
+					"%s: bad file descriptor", target)
+			}
+		default:
+			closeFiles()
+			err := fmt.Errorf("%s: unknown redirection", r.Op)
+			return std, nil, err
+		}
+		if err := std.set(r.Fd, f); err != nil {
+			closeFiles()
+			return std, nil, err
+		}
+	}
+	return std, closeFiles, nil
+}
+
+// pipeText returns the read end of a pipe which text is written to, for a
+// here-doc at a descriptor which has to be a file. The text is written in the
+// background, since the pipe may not hold all of it at once. Closing the read
+// end stops the writing early.
+func pipeText(text string) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		io.WriteString(w, text)
+		w.Close()
+	}()
+	return r, nil
+}
+
+// expandTarget expands the target of a redirection like a command argument,
+// except that it must result in exactly one field, rather than creating a file
+// named after only part of it, or with an empty name.
+func (i *Interpreter) expandTarget(target ast.Expr) (string, error) {
+	fields, err := i.expandFields(target)
+	if err != nil {
+		return "", err
+	} else if len(fields) != 1 {
+		return "", fmt.Errorf(
+			"%s: ambiguous redirect", ast.Unparse(target))
+	}
+	return fields[0], nil
+}
+
+// hasFile reports whether the shell itself has a file open, which happens when
+// `exec` redirects the shell's own file descriptors.
+func (i *Interpreter) hasFile(f *os.File) bool {
+	if i.Stdin == io.Reader(f) || i.Stdout == io.Writer(f) ||
+		i.Stderr == io.Writer(f) {
+		return true
+	}
+	for _, file := range i.fds {
+		if file == f {
+			return true
+		}
+	}
+	return false
+}
+
+// exec_ runs a command in place of the shell, which then exits with the
+// command's status. Without a command, its redirections are kept by the shell
+// instead, so that `exec 3<file` opens descriptor 3 for every later command.
+func exec_(b *builtin) (int, error) {
+	if len(b.args) == 0 {
+		shell := b.shell
+		shell.Stdin, shell.Stdout, shell.Stderr = b.in, b.out, b.err
+		shell.fds = b.extra
+		return 0, nil
+	}
+	status, err := b.shell.execute(b.args, nil, b.stdio)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		// The command couldn't be started, so the shell carries on.
+		return status, err
+	}
+	return status, ExitStatus(status)
+}
+
+// create opens a file for output, truncating it if it already exists. With the
+// noclobber option on, an existing regular file is only overwritten if clobber
+// is set.
+func (i *Interpreter) create(name string, clobber bool) (*os.File, error) {
+	if !i.Options.Noclobber || clobber {
+		return i.openFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	}
+	// Only create the file if it doesn't exist, rather than checking
+	// first, so that one created in the meantime isn't truncated.
+	f, err := i.openFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if !errors.Is(err, os.ErrExist) {
+		return f, err
+	}
+	// Anything other than a regular file, such as /dev/null, can still be
+	// written to, though not truncated.
+	f, err = i.openFile(name, os.O_WRONLY)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%s: cannot overwrite existing file", name)
+	}
+	return f, nil
+}
+
+// openFile is like os.OpenFile, but relative to the working directory.
+func (i *Interpreter) openFile(name string, flag int) (*os.File, error) {
+	path, err := i.abs(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, flag, 0666)
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		// Report the file as it was given, not the absolute path.
+		pathErr.Path = name
+	}
+	return f, err
+}
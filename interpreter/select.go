@@ -0,0 +1,114 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// defaultPS3 is the prompt for a choice in a select loop when $PS3 is unset.
+const defaultPS3 = "#? "
+
+// VisitSelect prints a numbered menu of the select loop's words to stderr,
+// then reads a line from stdin, prompted by $PS3, into $REPLY. An empty line
+// prints the menu again, while any other sets the loop's variable to the
+// word with that number, or to nothing if there isn't one, and runs the body.
+// The loop goes on until the end of the input, and its status is that of the
+// last body it ran, or 0 if it ran none.
+func (i *Interpreter) VisitSelect(s *ast.Select) (int, error) {
+	std, closeFiles, err := i.redirect(s.Redirects)
+	if err != nil {
+		return 1, err
+	}
+	defer closeFiles()
+	stdin, stdout, stderr, fds := i.Stdin, i.Stdout, i.Stderr, i.fds
+	defer func() {
+		i.Stdin, i.Stdout, i.Stderr, i.fds = stdin, stdout, stderr, fds
+	}()
+	i.Stdin, i.Stdout, i.Stderr = std.in, std.out, std.err
+	i.fds = std.extra
+	words := i.positional()
+	if s.Words != nil {
+		words = nil
+		for _, expr := range s.Words {
+			fields, err := i.expandFields(expr)
+			if err != nil {
+				return 1, err
+			}
+			words = append(words, fields...)
+		}
+	}
+	if len(words) == 0 {
+		return 0, nil
+	}
+	status, menu := 0, true
+	for {
+		if err := i.ctx().Err(); err != nil {
+			return status, err
+		}
+		if menu {
+			i.printMenu(words)
+		}
+		ps3, ok := i.lookupVar("PS3")
+		if !ok {
+			ps3 = defaultPS3
+		}
+		fmt.Fprint(i.Stderr, ps3)
+		line, err := readLine(i.Stdin, false)
+		if err == io.EOF {
+			// End the prompt's line, as the user's newline would.
+			fmt.Fprintln(i.Stderr)
+			return status, nil
+		} else if err != nil {
+			return 1, err
+		}
+		reply := string(line.text)
+		if _, err := i.setScalar("REPLY", reply); err != nil {
+			return 1, err
+		}
+		reply = strings.TrimSpace(reply)
+		if menu = reply == ""; menu {
+			continue
+		}
+		choice := ""
+		n, err := strconv.Atoi(reply)
+		if err == nil && n >= 1 && n <= len(words) {
+			choice = words[n-1]
+		}
+		if _, err := i.setScalar(s.Name, choice); err != nil {
+			return 1, err
+		}
+		status, err = s.Body.Visit(i)
+		if err != nil {
+			return status, err
+		} else if i.ExitsOnError(status) {
+			return status, nil
+		}
+	}
+}
+
+// printMenu prints the words of a select loop to stderr, one to a line, each
+// after its number.
+func (i *Interpreter) printMenu(words []string) {
+	width := len(strconv.Itoa(len(words)))
+	for n, word := range words {
+		fmt.Fprintf(i.Stderr, "%*d) %s\n", width, n+1, word)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxTypos is the furthest a command can be from one which wasn't found, as
+// counted by editDistance, for it to be suggested instead.
+const maxTypos = 2
+
+// suggest adds the name of the closest command to the error for a command
+// which wasn't found, if the suggest option is on and any is close enough.
+func (i *Interpreter) suggest(name string, err error) error {
+	if !i.Options.Suggest {
+		return err
+	}
+	best, bestDistance := "", maxTypos+1
+	for _, candidate := range i.commandNames() {
+		distance := editDistance(name, candidate)
+		if distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return err
+	}
+	return fmt.Errorf("%v. Did you mean '%s'?", err, best)
+}
+
+// commandNames returns the names of every builtin, function and executable in
+// $PATH, sorted so that the first of those equally close is suggested.
+func (i *Interpreter) commandNames() []string {
+	var names []string
+	for name := range builtins {
+		names = append(names, name)
+	}
+	for name := range i.funcs {
+		names = append(names, name)
+	}
+	names = append(names, i.pathCommands()...)
+	sort.Strings(names)
+	return names
+}
+
+// pathCommands returns the names of the executables in $PATH. Listing them
+// means reading every directory in $PATH, so they're kept in the hash table
+// until $PATH changes.
+func (i *Interpreter) pathCommands() []string {
+	if i.hash == nil {
+		i.hash = &hashTable{}
+	}
+	h := i.hash
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	pathVar, _ := i.getenv("PATH")
+	h.refresh(pathVar)
+	if h.commands != nil {
+		return h.commands
+	}
+	h.commands = []string{}
+	for _, dir := range filepath.SplitList(pathVar) {
+		if dir == "" {
+			// An empty entry stands for the working directory.
+			dir = "."
+		}
+		names, err := i.readDirNames(dir)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			path, err := i.abs(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err == nil && info.Mode().IsRegular() &&
+				info.Mode()&0111 != 0 {
+				h.commands = append(h.commands, name)
+			}
+		}
+	}
+	return h.commands
+}
+
+// editDistance returns the Levenshtein distance between two strings: the
+// fewest runes which must be inserted, deleted or replaced to turn one into
+// the other.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// prev holds the distances from the first index-1 runes of s to
+	// each prefix of t, and curr those from the first index runes.
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for index := 1; index <= len(s); index++ {
+		curr[0] = index
+		for j := 1; j <= len(t); j++ {
+			// Replace the rune, or keep it if it's the same.
+			curr[j] = prev[j-1]
+			if s[index-1] != t[j-1] {
+				curr[j]++
+			}
+			// Delete it from s, or insert it into s.
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(t)]
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func init() {
+	registerBuiltin("test", Builtin{
+		run:     test,
+		Summary: "Evaluate a conditional expression.",
+		Usage:   "test [expr]",
+	})
+	registerBuiltin("[", Builtin{
+		run:     bracket,
+		Summary: "Evaluate a conditional expression.",
+		Usage:   "[ expr ]",
+	})
+}
+
+// testError is returned for malformed `test` expressions. Following POSIX,
+// these exit with status 2, to distinguish them from an expression that
+// merely evaluates to false.
+type testError struct {
+	msg string
+}
+
+func newTestError(format string, a ...interface{}) testError {
+	return testError{fmt.Sprintf(format, a...)}
+}
+
+func (te testError) Error() string {
+	return "test: " + te.msg
+}
+
+func test(b *builtin) (int, error) {
+	ok, err := b.shell.evalTest(b.args)
+	if err != nil {
+		return 2, err
+	} else if !ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func bracket(b *builtin) (int, error) {
+	if len(b.args) == 0 || b.args[len(b.args)-1] != "]" {
+		return 2, newTestError("missing `]'")
+	}
+	b.args = b.args[:len(b.args)-1]
+	return test(b)
+}
+
+// evalTest evaluates a `test` expression, using the number of arguments to
+// decide how to interpret them, as described by POSIX.
+func (i *Interpreter) evalTest(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		if args[0] == "!" {
+			ok, err := i.evalTest(args[1:])
+			return !ok, err
+		}
+		return i.evalUnary(args[0], args[1])
+	case 3:
+		if args[0] == "!" {
+			ok, err := i.evalTest(args[1:])
+			return !ok, err
+		}
+		return i.evalBinary(args[0], args[1], args[2])
+	case 4:
+		if args[0] == "!" {
+			ok, err := i.evalTest(args[1:])
+			return !ok, err
+		}
+		fallthrough
+	default:
+		return false, newTestError("too many arguments")
+	}
+}
+
+func (i *Interpreter) evalUnary(op, arg string) (bool, error) {
+	switch op {
+	case "-n":
+		return arg != "", nil
+	case "-z":
+		return arg == "", nil
+	case "-e":
+		_, err := i.stat(arg)
+		return err == nil, nil
+	case "-f":
+		info, err := i.stat(arg)
+		return err == nil && info.Mode().IsRegular(), nil
+	case "-d":
+		info, err := i.stat(arg)
+		return err == nil && info.IsDir(), nil
+	case "-s":
+		info, err := i.stat(arg)
+		return err == nil && info.Size() > 0, nil
+	default:
+		return false, newTestError(
+			"%s: unary operator expected", op)
+	}
+}
+
+func (i *Interpreter) evalBinary(left, op, right string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "-eq", "-ne", "-lt", "-le", "-gt", "-ge":
+		return compareInts(left, op, right)
+	case "-nt":
+		// As in bash, an existing file is newer than a missing one.
+		l, lerr := i.stat(left)
+		r, rerr := i.stat(right)
+		if lerr != nil {
+			return false, nil
+		} else if rerr != nil {
+			return true, nil
+		}
+		return l.ModTime().After(r.ModTime()), nil
+	case "-ot":
+		return i.evalBinary(right, "-nt", left)
+	case "-ef":
+		l, lerr := i.stat(left)
+		r, rerr := i.stat(right)
+		if lerr != nil || rerr != nil {
+			return false, nil
+		}
+		// os.SameFile compares the device and inode numbers on
+		// Unix, and the equivalent file IDs on Windows.
+		return os.SameFile(l, r), nil
+	default:
+		return false, newTestError(
+			"%s: binary operator expected", op)
+	}
+}
+
+func compareInts(left, op, right string) (bool, error) {
+	l, err := strconv.ParseInt(left, 10, 64)
+	if err != nil {
+		return false, newTestError(
+			"%s: integer expression expected", left)
+	}
+	r, err := strconv.ParseInt(right, 10, 64)
+	if err != nil {
+		return false, newTestError(
+			"%s: integer expression expected", right)
+	}
+	return compareNumbers(l, op, r), nil
+}
+
+// compareNumbers compares two integers with one of the operators `-eq`,
+// `-ne`, `-lt`, `-le`, `-gt` or `-ge`.
+func compareNumbers(l int64, op string, r int64) bool {
+	switch op {
+	case "-eq":
+		return l == r
+	case "-ne":
+		return l != r
+	case "-lt":
+		return l < r
+	case "-le":
+		return l <= r
+	case "-gt":
+		return l > r
+	default:
+		return l >= r
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+// cpuTimes totals the CPU time used by the external commands which the shell
+// has run, so that time can report it.
+type cpuTimes struct {
+	lock         sync.Mutex
+	user, system time.Duration
+}
+
+func (c *cpuTimes) add(state *os.ProcessState) {
+	if c == nil || state == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.user += state.UserTime()
+	c.system += state.SystemTime()
+}
+
+func (c *cpuTimes) get() (time.Duration, time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.user, c.system
+}
+
+func (i *Interpreter) VisitTime(t *ast.Time) (int, error) {
+	if i.cpu == nil {
+		i.cpu = &cpuTimes{}
+	}
+	user, system := i.cpu.get()
+	start := time.Now()
+	status, err := t.Stmt.Visit(i)
+	real := time.Since(start)
+	endUser, endSystem := i.cpu.get()
+	i.stderrLock.Lock()
+	defer i.stderrLock.Unlock()
+	fmt.Fprintf(i.Stderr, "real %s\nuser %s\nsys %s\n",
+		formatDuration(real),
+		formatDuration(endUser-user),
+		formatDuration(endSystem-system))
+	return status, err
+}
+
+// formatDuration formats a duration in minutes and seconds, e.g. `1m2.345s`.
+func formatDuration(d time.Duration) string {
+	minutes := int(d.Minutes())
+	seconds := (d - time.Duration(minutes)*time.Minute).Seconds()
+	return fmt.Sprintf("%dm%.3fs", minutes, seconds)
+}
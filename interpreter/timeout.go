@@ -0,0 +1,188 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func init() {
+	registerBuiltin("timeout", Builtin{
+		run:     timeout,
+		Summary: "Run a command, killing it if it takes too long.",
+		Usage: "timeout [-s signal] [-k duration] duration " +
+			"name [arg ...]",
+	})
+}
+
+// timeout runs an external command, as GNU timeout does, sending it and its
+// children a signal, SIGTERM unless `-s` gives another, if it's still running
+// once the duration is up. With `-k`, it's sent SIGKILL if it's still running
+// that long after the first signal. Its status is then 124, or 137 if SIGKILL
+// was needed. Durations are in seconds, or have a unit, e.g. "2m", and zero
+// means there's no limit. Builtins and functions can't be sent signals, so
+// only external commands can be run. Where there are no signals or process
+// groups, as on Windows, the command is killed instead, without its children.
+func timeout(b *builtin) (int, error) {
+	sig := syscall.SIGTERM
+	var killAfter time.Duration
+	args := b.args
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		flag := args[0]
+		if flag == "--" {
+			args = args[1:]
+			break
+		} else if flag != "-s" && flag != "-k" {
+			return 125, fmt.Errorf(
+				"timeout: %s: invalid option", flag)
+		} else if len(args) == 1 {
+			return 125, fmt.Errorf("timeout: %s: "+
+				"option requires an argument", flag)
+		}
+		args = args[1:]
+		if flag == "-k" {
+			d, err := parseTimeout(args[0])
+			if err != nil {
+				return 125, err
+			}
+			killAfter = d
+			continue
+		}
+		s, ok := killSignal(args[0])
+		if !ok {
+			return 125, fmt.Errorf(
+				"timeout: %s: invalid signal", args[0])
+		}
+		sig = s
+	}
+	if len(args) < 2 {
+		return 125, errors.New("timeout: missing operand")
+	}
+	d, err := parseTimeout(args[0])
+	if err != nil {
+		return 125, err
+	}
+	args = args[1:]
+	path, err := b.shell.lookPath(args[0])
+	if err != nil {
+		return startError(args[0], err)
+	}
+	if d == 0 {
+		return b.shell.executePath(path, args, nil, b.stdio)
+	}
+
+	// The command runs in a process group of its own, so that its
+	// children can be signalled along with it.
+	started, ownGroup := b.shell.started, b.shell.ownGroup
+	defer func() {
+		b.shell.started, b.shell.ownGroup = started, ownGroup
+	}()
+	procs := make(chan *os.Process, 1)
+	b.shell.started = func(p *os.Process) {
+		if started != nil {
+			started(p)
+		}
+		procs <- p
+	}
+	b.shell.ownGroup = true
+	ctx, cancel := context.WithTimeout(b.shell.ctx(), d)
+	defer cancel()
+	// sent is the last signal sent to the command, if any: sig when the
+	// duration was up, or SIGKILL after that.
+	var sent syscall.Signal
+	done, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		var p *os.Process
+		select {
+		case p = <-procs:
+		case <-done:
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		if ctx.Err() != context.DeadlineExceeded {
+			// The shell is stopping, and kills the command itself.
+			return
+		}
+		signalGroup(p, sig)
+		sent = sig
+		if killAfter == 0 || sig == syscall.SIGKILL {
+			return
+		}
+		timer := time.NewTimer(killAfter)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			signalGroup(p, syscall.SIGKILL)
+			sent = syscall.SIGKILL
+		case <-done:
+		}
+	}()
+	status, err := b.shell.executePath(path, args, nil, b.stdio)
+	close(done)
+	<-finished
+	switch {
+	case sent == 0 || b.shell.ctx().Err() != nil:
+		return status, err
+	case sent == syscall.SIGKILL:
+		return 128 + int(syscall.SIGKILL), nil
+	default:
+		return 124, nil
+	}
+}
+
+// maxTimeout is the longest duration a time.Duration can hold.
+const maxTimeout = time.Duration(1<<63 - 1)
+
+// parseTimeout parses a duration for timeout, which is a number of seconds
+// unless it has a unit, e.g. "1.5" or "2m".
+func parseTimeout(text string) (time.Duration, error) {
+	if d, err := time.ParseDuration(text); err == nil && d >= 0 {
+		return d, nil
+	}
+	secs, err := strconv.ParseFloat(text, 64)
+	if err != nil || !(secs >= 0 && secs < maxTimeout.Seconds()) {
+		return 0, fmt.Errorf(
+			"timeout: %s: invalid time interval", text)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// killSignal returns the signal given by spec, which may be its name, with or
+// without the "SIG" prefix, or its number. Unlike trap, it accepts SIGKILL,
+// which can be sent though it can't be trapped.
+func killSignal(spec string) (syscall.Signal, bool) {
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	if name == "KILL" || name == strconv.Itoa(int(syscall.SIGKILL)) {
+		return syscall.SIGKILL, true
+	}
+	for _, s := range trapSignals {
+		if name == s.name || name == strconv.Itoa(int(s.sig)) {
+			return s.sig, true
+		}
+	}
+	return 0, false
+}
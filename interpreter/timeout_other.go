@@ -0,0 +1,34 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+// +build !unix
+
+package interpreter
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startOwnGroup does nothing, since there are no process groups to start
+// commands in.
+func startOwnGroup(cmd *exec.Cmd) {}
+
+// signalGroup kills p, which is all that can be done to a process here,
+// whatever the signal. Its children are left running.
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	return p.Kill()
+}
@@ -0,0 +1,307 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/meshshell/mesh/ast"
+	"github.com/meshshell/mesh/parser"
+)
+
+func init() {
+	registerBuiltin("trap", Builtin{
+		run:     trap,
+		Summary: "Run commands when the shell receives a signal.",
+		Usage:   "trap [-lp] [[action] signal ...]",
+	})
+}
+
+// trapSignal is a signal which can be trapped, by the name it's given
+// without its "SIG" prefix.
+type trapSignal struct {
+	name string
+	sig  syscall.Signal
+}
+
+// signalName returns the name of the signal given by spec, which may be its
+// name, with or without the "SIG" prefix, or its number.
+func signalName(spec string) (string, bool) {
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	switch name {
+	case "EXIT", "0":
+		return "EXIT", true
+	case "ERR":
+		return "ERR", true
+	}
+	for _, s := range trapSignals {
+		if name == s.name || name == strconv.Itoa(int(s.sig)) {
+			return s.name, true
+		}
+	}
+	return "", false
+}
+
+// trappedName returns the name of a signal received by the shell.
+func trappedName(sig os.Signal) string {
+	for _, s := range trapSignals {
+		if s.sig == sig {
+			return s.name
+		}
+	}
+	return sig.String()
+}
+
+// trap sets the action to run when each of the given signals is received, or
+// with an action of `-`, resets them. An empty action ignores the signals.
+// With `-l`, it lists the signals, and with `-p` or no arguments, it prints
+// the traps of the given signals, or of every signal, in a form which can be
+// run to set them again.
+func trap(b *builtin) (int, error) {
+	list, print := false, false
+	args := b.args
+	for ; len(args) > 0 && isOption(args[0]); args = args[1:] {
+		flag := args[0]
+		if flag == "--" {
+			args = args[1:]
+			break
+		}
+		switch flag {
+		case "-l":
+			list = true
+		case "-p":
+			print = true
+		default:
+			return 2, fmt.Errorf("trap: %s: invalid option", flag)
+		}
+	}
+	switch {
+	case list:
+		return b.listSignals()
+	case print || len(args) == 0:
+		return b.printTraps(args)
+	}
+	action, specs := args[0], args[1:]
+	if len(specs) == 0 {
+		// As in other shells, a signal by itself resets its trap.
+		action, specs = "-", args
+	} else if action != "-" {
+		// Report any syntax error now, rather than once the signal
+		// is received.
+		_, err := parser.ParseAll("trap", strings.NewReader(action))
+		if err != nil {
+			return 1, fmt.Errorf("trap: %w", err)
+		}
+	}
+	status := 0
+	for _, spec := range specs {
+		name, ok := signalName(spec)
+		if !ok {
+			fmt.Fprintf(b.err, "mesh: trap: %s: invalid signal "+
+				"specification\n", spec)
+			status = 1
+			continue
+		}
+		b.shell.setTrap(name, action)
+	}
+	b.shell.watchSignals()
+	return status, nil
+}
+
+func (b *builtin) listSignals() (int, error) {
+	for _, s := range trapSignals {
+		_, err := fmt.Fprintf(b.out, "%2d) SIG%s\n", int(s.sig), s.name)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+func (b *builtin) printTraps(specs []string) (int, error) {
+	var names []string
+	if len(specs) == 0 {
+		names = append(names, "EXIT")
+		for _, s := range trapSignals {
+			names = append(names, s.name)
+		}
+		names = append(names, "ERR")
+	}
+	status := 0
+	for _, spec := range specs {
+		name, ok := signalName(spec)
+		if !ok {
+			fmt.Fprintf(b.err, "mesh: trap: %s: invalid signal "+
+				"specification\n", spec)
+			status = 1
+			continue
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		action, ok := b.shell.trap(name)
+		if !ok {
+			continue
+		}
+		_, err := fmt.Fprintf(
+			b.out, "trap -- %s %s\n", quoteWord(action), name)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return status, nil
+}
+
+// trap returns the action set for a signal. While a function is running, its
+// EXIT trap is the one which will run when it returns.
+func (i *Interpreter) trap(name string) (string, bool) {
+	traps := i.traps
+	if name == "EXIT" && len(i.scopes) > 0 {
+		traps = i.scopes[len(i.scopes)-1].traps
+	}
+	action, ok := traps[name]
+	return action, ok
+}
+
+// setTrap sets the action for a signal, or removes it if action is "-". An
+// EXIT trap set while a function is running belongs to that call.
+func (i *Interpreter) setTrap(name, action string) {
+	traps := &i.traps
+	if name == "EXIT" && len(i.scopes) > 0 {
+		traps = &i.scopes[len(i.scopes)-1].traps
+	}
+	if action == "-" {
+		delete(*traps, name)
+		return
+	}
+	if *traps == nil {
+		*traps = make(map[string]string)
+	}
+	(*traps)[name] = action
+}
+
+// watchSignals starts watching for each signal which is trapped, and stops
+// watching for the rest. Other parts of the shell, such as fg, watch for
+// signals on channels of their own, which this leaves alone.
+func (i *Interpreter) watchSignals() {
+	var sigs []os.Signal
+	for _, s := range trapSignals {
+		if _, ok := i.traps[s.name]; ok {
+			sigs = append(sigs, s.sig)
+		}
+	}
+	if i.signals == nil {
+		if len(sigs) == 0 {
+			return
+		}
+		i.signals = make(chan os.Signal, len(trapSignals))
+	}
+	signal.Stop(i.signals)
+	if len(sigs) > 0 {
+		signal.Notify(i.signals, sigs...)
+	}
+}
+
+// runSignalTraps runs the traps of any signals received since it was last
+// called. The shell only runs them between statements, so that they don't
+// interrupt whatever it was doing.
+func (i *Interpreter) runSignalTraps() error {
+	for i.signals != nil {
+		select {
+		case sig := <-i.signals:
+			// The trap may have been reset since the signal was
+			// received.
+			action, ok := i.traps[trappedName(sig)]
+			if !ok {
+				continue
+			}
+			if err := i.runTrap(action); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// runTrap runs a trap's action. Any error is reported rather than returned,
+// except those from `exit` and `return`, which leave the shell or function
+// that the trap ran in.
+func (i *Interpreter) runTrap(action string) error {
+	stmts, err := parser.ParseAll("trap", strings.NewReader(action))
+	if err == nil {
+		_, err = (&ast.StmtList{Stmts: stmts}).Visit(i)
+	}
+	switch err.(type) {
+	case nil:
+	case ExitStatus, returnStatus:
+		return err
+	default:
+		i.reportError(err)
+	}
+	return nil
+}
+
+// runErrTrap runs the ERR trap after a simple command fails with the given
+// status, which it sees as $?. As with errexit, a command whose status is
+// being tested, e.g. by `&&` or `!`, doesn't count as failing.
+func (i *Interpreter) runErrTrap(status int) error {
+	action, ok := i.traps["ERR"]
+	if !ok || i.tested > 0 || i.inErrTrap {
+		return nil
+	}
+	i.inErrTrap = true
+	defer func() {
+		i.inErrTrap = false
+		// $? is left as it was before the trap ran.
+		i.lastStatus = status
+	}()
+	return i.runTrap(action)
+}
+
+// runExitTrap runs the EXIT trap among traps, if there is one, after a
+// statement has finished with the given status and error. Calling `exit` in
+// the trap replaces them.
+func (i *Interpreter) runExitTrap(
+	traps map[string]string, status int, err error,
+) (int, error) {
+	action, ok := traps["EXIT"]
+	if !ok {
+		return status, err
+	}
+	var e ExitStatus
+	if trapErr := i.runTrap(action); errors.As(trapErr, &e) {
+		return int(e), e
+	}
+	return status, err
+}
+
+// RunExitTrap runs the shell's EXIT trap, if it has one, as it exits with the
+// given status. It returns the status to exit with, which the trap may change
+// by calling `exit`.
+func (i *Interpreter) RunExitTrap(status int) int {
+	traps := i.traps
+	// The trap only runs once, even if it calls `exit`.
+	i.traps = nil
+	status, _ = i.runExitTrap(traps, status, nil)
+	return status
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+// +build unix
+
+package interpreter
+
+import "syscall"
+
+// trapSignals lists the signals which can be trapped, besides the
+// pseudo-signals EXIT, which is raised when the shell exits or a function
+// returns, and ERR.
+var trapSignals = []trapSignal{
+	{"HUP", syscall.SIGHUP},
+	{"INT", syscall.SIGINT},
+	{"QUIT", syscall.SIGQUIT},
+	{"USR1", syscall.SIGUSR1},
+	{"USR2", syscall.SIGUSR2},
+	{"PIPE", syscall.SIGPIPE},
+	{"ALRM", syscall.SIGALRM},
+	{"TERM", syscall.SIGTERM},
+	{"CHLD", syscall.SIGCHLD},
+	{"CONT", syscall.SIGCONT},
+	{"TSTP", syscall.SIGTSTP},
+	{"TTIN", syscall.SIGTTIN},
+	{"TTOU", syscall.SIGTTOU},
+	{"WINCH", syscall.SIGWINCH},
+}
@@ -0,0 +1,523 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/meshshell/mesh/ast"
+)
+
+func init() {
+	registerBuiltin("declare", Builtin{
+		run:     declare,
+		Summary: "Set the attributes and values of variables.",
+		Usage:   "declare [-aAirx] [name[=value] ...]",
+	})
+	registerBuiltin("typeset", Builtin{
+		run:     declare,
+		Summary: "Set the attributes and values of variables.",
+		Usage:   "typeset [-aAirx] [name[=value] ...]",
+	})
+}
+
+// variable is a shell variable whose value can't be stored in the
+// environment, i.e. an array, or an associative array (a map) if assoc is
+// non-nil.
+type variable struct {
+	array []string
+	assoc map[string]string
+}
+
+func (v *variable) copy() *variable {
+	c := &variable{array: append([]string(nil), v.array...)}
+	if v.assoc != nil {
+		c.assoc = make(map[string]string, len(v.assoc))
+		for key, value := range v.assoc {
+			c.assoc[key] = value
+		}
+	}
+	return c
+}
+
+func copyVars(vars map[string]*variable) map[string]*variable {
+	if vars == nil {
+		return nil
+	}
+	copied := make(map[string]*variable, len(vars))
+	for name, v := range vars {
+		copied[name] = v.copy()
+	}
+	return copied
+}
+
+// lookupVar returns the value of a variable. As in other shells, the value of
+// an array is its first element, and that of an associative array is its
+// element with the key "0".
+func (i *Interpreter) lookupVar(name string) (string, bool) {
+	if value, ok, isParam := i.lookupParam(name); isParam {
+		return value, ok
+	}
+	if _, ok := i.vars[name]; ok {
+		value, _ := i.element(name, "0")
+		return value, true
+	}
+	return i.getenv(name)
+}
+
+// LookupVar returns the value of a variable, as `$name` would expand to, and
+// whether it's set.
+func (i *Interpreter) LookupVar(name string) (string, bool) {
+	return i.lookupVar(name)
+}
+
+// SetVar sets a variable to a value, as `name = value` would.
+func (i *Interpreter) SetVar(name, value string) error {
+	_, err := i.setScalar(name, value)
+	return err
+}
+
+// elements returns every element of an array, or every value of an
+// associative array, in order of their keys. Any other variable is treated
+// as an array of its value.
+func (i *Interpreter) elements(name string) []string {
+	if name == "@" {
+		return i.positional()
+	}
+	if v, ok := i.vars[name]; ok && v.assoc != nil {
+		keys := make([]string, 0, len(v.assoc))
+		for key := range v.assoc {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, len(keys))
+		for n, key := range keys {
+			values[n] = v.assoc[key]
+		}
+		return values
+	} else if ok {
+		return v.array
+	} else if value, ok := i.getenv(name); ok {
+		return []string{value}
+	}
+	return nil
+}
+
+// element returns one element of an array. Negative indices count back from
+// the end, and an index which is out of range gives an empty string, as does
+// a key which is missing from an associative array.
+func (i *Interpreter) element(name, index string) (string, error) {
+	if v, ok := i.vars[name]; ok && v.assoc != nil {
+		return v.assoc[index], nil
+	}
+	array := i.elements(name)
+	n, err := arrayIndex(name, index, len(array))
+	if err != nil {
+		return "", err
+	} else if n < 0 || n >= len(array) {
+		return "", nil
+	}
+	return array[n], nil
+}
+
+// arrayIndex converts an index into an array of the given length into an
+// int, counting negative indices back from the end.
+func arrayIndex(name, index string, length int) (int, error) {
+	n, err := strconv.Atoi(index)
+	if err != nil {
+		return 0, fmt.Errorf("%s[%s]: bad array subscript", name, index)
+	} else if n < 0 {
+		n += length
+	}
+	return n, nil
+}
+
+func (i *Interpreter) VisitAssign(a *ast.Assign) (int, error) {
+	if a.Array != nil {
+		var array []string
+		for _, elem := range a.Array.Elems {
+			fields, err := i.expandFields(elem)
+			if err != nil {
+				return 1, err
+			}
+			for _, field := range fields {
+				value, err := i.assignable(a.Name, field)
+				if err != nil {
+					return 1, err
+				}
+				array = append(array, value)
+			}
+		}
+		return i.setVar(a.Name, &variable{array: array})
+	}
+	i.expandStatus = 0
+	value, err := a.Value.Visit(i)
+	if err != nil {
+		return 1, err
+	}
+	var status int
+	if a.Index == nil {
+		status, err = i.setScalar(a.Name, value)
+	} else {
+		var index string
+		if index, err = a.Index.Visit(i); err != nil {
+			return 1, err
+		}
+		status, err = i.setElement(a.Name, index, value)
+	}
+	if status == 0 && err == nil {
+		// As in other shells, the assignment fails if a
+		// substitution in it does.
+		status = i.expandStatus
+	}
+	return status, err
+}
+
+// setScalar sets a variable to a single value, which is stored in the
+// environment. A variable which wasn't there already isn't exported, unless
+// `declare -x` has been used on it, as in other shells.
+func (i *Interpreter) setScalar(name, value string) (int, error) {
+	value, err := i.assignable(name, value)
+	if err != nil {
+		return 1, err
+	}
+	delete(i.vars, name)
+	if _, ok := i.getenv(name); !ok && i.attrs[name]&attrExport == 0 {
+		i.addAttrs(name, attrNoExport)
+	}
+	if err := i.setenv(name, value); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// setElement sets one element of an array, or of an associative array,
+// making the variable an array if it isn't one already.
+func (i *Interpreter) setElement(name, index, value string) (int, error) {
+	value, err := i.assignable(name, value)
+	if err != nil {
+		return 1, err
+	}
+	if v, ok := i.vars[name]; ok && v.assoc != nil {
+		v.assoc[index] = value
+		return 0, nil
+	}
+	array := append([]string(nil), i.elements(name)...)
+	n, err := arrayIndex(name, index, len(array))
+	if err != nil {
+		return 1, err
+	} else if n < 0 {
+		err := fmt.Errorf("%s[%s]: bad array subscript", name, index)
+		return 1, err
+	}
+	for len(array) <= n {
+		array = append(array, "")
+	}
+	array[n] = value
+	return i.setVar(name, &variable{array: array})
+}
+
+// setVar sets a variable which can't be stored in the environment.
+func (i *Interpreter) setVar(name string, v *variable) (int, error) {
+	if err := i.checkWritable(name); err != nil {
+		return 1, err
+	}
+	if err := i.unsetenv(name); err != nil {
+		return 1, err
+	}
+	if i.vars == nil {
+		i.vars = make(map[string]*variable)
+	}
+	i.vars[name] = v
+	return 0, nil
+}
+
+// attr is a set of the attributes of a variable.
+type attr uint8
+
+const (
+	// attrInteger makes every value assigned to a variable be evaluated
+	// as arithmetic.
+	attrInteger attr = 1 << iota
+	attrReadonly
+	attrExport
+	// attrNoExport marks a variable which is stored in the environment,
+	// but which the shell set without exporting it, so that it's left
+	// out of the environment of commands.
+	attrNoExport
+)
+
+func copyAttrs(attrs map[string]attr) map[string]attr {
+	if attrs == nil {
+		return nil
+	}
+	copied := make(map[string]attr, len(attrs))
+	for name, a := range attrs {
+		copied[name] = a
+	}
+	return copied
+}
+
+// attrsOf returns the attributes of a variable. Variables stored in the
+// environment are exported unless the shell set them without exporting them.
+func (i *Interpreter) attrsOf(name string) attr {
+	a := i.attrs[name]
+	if _, ok := i.vars[name]; !ok && a&attrNoExport == 0 {
+		if _, ok := i.getenv(name); ok {
+			a |= attrExport
+		}
+	}
+	return a &^ attrNoExport
+}
+
+func (i *Interpreter) addAttrs(name string, a attr) {
+	if a == 0 {
+		return
+	} else if i.attrs == nil {
+		i.attrs = make(map[string]attr)
+	}
+	if a&attrExport != 0 {
+		i.attrs[name] &^= attrNoExport
+	}
+	i.attrs[name] |= a
+}
+
+func (i *Interpreter) checkWritable(name string) error {
+	if i.attrs[name]&attrReadonly != 0 {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	return nil
+}
+
+// assignable checks that a variable can be assigned a value, and returns the
+// value it should be set to, which for an integer variable is the result of
+// evaluating the value as arithmetic.
+func (i *Interpreter) assignable(name, value string) (string, error) {
+	if err := i.checkWritable(name); err != nil {
+		return "", err
+	} else if i.attrs[name]&attrInteger == 0 {
+		return value, nil
+	}
+	n, err := i.arith(value)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+// isName reports whether s is a valid variable name.
+func isName(s string) bool {
+	for n, r := range s {
+		if !isNameRune(r) || n == 0 && unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// declare sets the attributes of variables, and optionally their values, as
+// in `declare -i n=1` or `declare -i n = 1`: `-a` makes each one an array,
+// `-A` an associative array, `-i` an integer, `-r` read-only and `-x`
+// exported. With no variables, it prints every variable which has all of the
+// given attributes. Inside a function, the variables are local to it.
+func declare(b *builtin) (int, error) {
+	var array, assoc bool
+	var attrs attr
+	args := b.args
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'a':
+				array = true
+			case 'A':
+				assoc = true
+			case 'i':
+				attrs |= attrInteger
+			case 'r':
+				attrs |= attrReadonly
+			case 'x':
+				attrs |= attrExport
+			default:
+				err := fmt.Errorf(
+					"declare: -%c: invalid option", flag)
+				return 2, err
+			}
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return b.shell.printVars(b.out, array, assoc, attrs)
+	}
+	for len(args) > 0 {
+		name, value, hasValue := splitAssignment(args[0])
+		if !hasValue {
+			name = args[0]
+		}
+		args = args[1:]
+		if !hasValue && len(args) > 0 && args[0] == "=" {
+			// The value may also be given as in an assignment
+			// statement, e.g. `declare -i n = 1`.
+			hasValue = true
+			if len(args) > 1 {
+				value = args[1]
+				args = args[1:]
+			}
+			args = args[1:]
+		}
+		if !isName(name) {
+			return 1, fmt.Errorf(
+				"declare: %s: not a valid identifier", name)
+		} else if err := b.shell.makeLocal(name); err != nil {
+			return 1, err
+		}
+		v, ok := b.shell.vars[name]
+		switch {
+		case assoc && ok && v.assoc == nil:
+			return 1, fmt.Errorf("declare: %s: cannot convert "+
+				"indexed to associative array", name)
+		case array && ok && v.assoc != nil:
+			return 1, fmt.Errorf("declare: %s: cannot convert "+
+				"associative to indexed array", name)
+		case assoc && !ok:
+			v = &variable{assoc: make(map[string]string)}
+		case array && !ok:
+			v = &variable{array: b.shell.elements(name)}
+		default:
+			v = nil
+		}
+		if v != nil {
+			if status, err := b.shell.setVar(name, v); err != nil {
+				return status, err
+			}
+		}
+		// A read-only variable can still be given its value here.
+		b.shell.addAttrs(name, attrs&^attrReadonly)
+		if _, ok := b.shell.vars[name]; ok && hasValue {
+			_, err := b.shell.setElement(name, "0", value)
+			if err != nil {
+				return 1, err
+			}
+		} else if hasValue {
+			_, err := b.shell.setScalar(name, value)
+			if err != nil {
+				return 1, err
+			}
+		}
+		b.shell.addAttrs(name, attrs&attrReadonly)
+	}
+	return 0, nil
+}
+
+// printVars prints the variables which have all of the given attributes, in
+// the form `declare -ir n="1"`.
+func (i *Interpreter) printVars(
+	w io.Writer, array, assoc bool, attrs attr,
+) (int, error) {
+	names := make([]string, 0, len(i.vars))
+	for name := range i.vars {
+		names = append(names, name)
+	}
+	for _, kv := range i.allEnv() {
+		if name, _, ok := splitAssignment(kv); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, isVar := i.vars[name]
+		a := i.attrsOf(name)
+		switch {
+		case a&attrs != attrs:
+			continue
+		case array && (!isVar || v.assoc != nil):
+			continue
+		case assoc && (!isVar || v.assoc == nil):
+			continue
+		}
+		var flags string
+		if isVar && v.assoc == nil {
+			flags += "a"
+		} else if isVar {
+			flags += "A"
+		}
+		for _, f := range []struct {
+			a    attr
+			flag string
+		}{{attrInteger, "i"}, {attrReadonly, "r"}, {attrExport, "x"}} {
+			if a&f.a != 0 {
+				flags += f.flag
+			}
+		}
+		if flags == "" {
+			flags = "-"
+		}
+		var value string
+		switch {
+		case !isVar:
+			scalar, _ := i.getenv(name)
+			value = quoteValue(scalar)
+		case v.assoc != nil:
+			keys := make([]string, 0, len(v.assoc))
+			for key := range v.assoc {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			value = formatElements(keys, v.assoc)
+		default:
+			keys := make([]string, len(v.array))
+			assoc := make(map[string]string, len(v.array))
+			for n, elem := range v.array {
+				keys[n] = strconv.Itoa(n)
+				assoc[keys[n]] = elem
+			}
+			value = formatElements(keys, assoc)
+		}
+		_, err := fmt.Fprintf(
+			w, "declare -%s %s=%s\n", flags, name, value)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// formatElements formats the elements of an array as in `([0]="a" [1]="b")`.
+func formatElements(keys []string, elems map[string]string) string {
+	formatted := make([]string, len(keys))
+	for n, key := range keys {
+		formatted[n] = "[" + key + "]=" + quoteValue(elems[key])
+	}
+	return "(" + strings.Join(formatted, " ") + ")"
+}
+
+// quoteValue puts a value in double quotes, escaping any characters which
+// are special inside them.
+func quoteValue(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if strings.ContainsRune("\"\\$`", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
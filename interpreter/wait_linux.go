@@ -0,0 +1,73 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package interpreter
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// The values from <sys/wait.h> and <signal.h> which package syscall doesn't
+// define.
+const (
+	pPID         = 1
+	cldStopped   = 5
+	cldContinued = 6
+)
+
+// siginfo is the siginfo_t which waitid fills in, of which only the code
+// saying what happened to the process is needed.
+type siginfo struct {
+	signo, errno, code int32
+	_                  [116]byte
+}
+
+// watchStops waits for the process with the given PID to exit, calling
+// stopped each time it's stopped or continued before then, e.g. by SIGTSTP or
+// SIGCONT. The process isn't reaped, so that exec.Cmd.Wait still can.
+func watchStops(pid int, stopped func(bool)) {
+	const events = syscall.WEXITED | syscall.WSTOPPED | syscall.WCONTINUED
+	for {
+		var info siginfo
+		err := waitid(pid, &info, events|syscall.WNOWAIT)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return
+		}
+		// WNOWAIT leaves the event to be waited for again, so once a
+		// stop or continue is handled, wait for it without WNOWAIT.
+		switch info.code {
+		case cldStopped:
+			stopped(true)
+			_ = waitid(pid, &info, syscall.WSTOPPED|syscall.WNOHANG)
+		case cldContinued:
+			stopped(false)
+			_ = waitid(pid, &info, syscall.WCONTINUED|syscall.WNOHANG)
+		default:
+			return
+		}
+	}
+}
+
+func waitid(pid int, info *siginfo, options int) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+		uintptr(unsafe.Pointer(info)), uintptr(options), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -19,12 +19,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime/debug"
 	"strings"
 
 	"golang.org/x/crypto/ssh/terminal"
 
-	"github.com/meshshell/mesh/interpreter"
-	"github.com/meshshell/mesh/parser"
+	"github.com/meshshell/mesh/mesh"
 )
 
 type stdio struct {
@@ -34,24 +34,71 @@ type stdio struct {
 }
 
 func main() {
+	defer func() {
+		// By now the terminal has been restored, since mesh.Run defers
+		// that, so all that's left is to report the panic. It exits
+		// with EX_SOFTWARE, as for any other internal error.
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "mesh: internal error: %v\n%s",
+				r, debug.Stack())
+			os.Exit(70)
+		}
+	}()
 	std := &stdio{os.Stdin, os.Stdout, os.Stderr}
-	os.Exit(mesh(os.Args[0], os.Args[1:], std))
+	os.Exit(run(os.Args[0], os.Args[1:], std))
 }
 
-func mesh(cmd string, args []string, std *stdio) int {
+// run parses the command line, and runs the shell it describes.
+func run(cmd string, args []string, std *stdio) int {
 	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
 	fs.SetOutput(std.err)
 	snippet := fs.String("c", "", "run command from argument string")
+	noexec := fs.Bool("n", false, "read commands but do not execute them")
+	dump := fs.Bool("dump", false, "print the syntax tree of each command")
+	dryRun := fs.Bool("dry-run", false,
+		"print commands instead of running them")
+	posix := fs.Bool("posix", false,
+		"turn off extensions to POSIX shell syntax")
+	login := fs.Bool("l", false, "act as a login shell")
+	fs.BoolVar(login, "login", false, "act as a login shell")
 	if err := fs.Parse(args); err == flag.ErrHelp {
 		return 0
 	} else if err != nil {
 		fmt.Fprintf(std.err, "mesh: %v\n", err)
 		return 1
 	}
+	cfg := mesh.Config{
+		Stdin:  std.in,
+		Stdout: std.out,
+		Stderr: std.err,
+		NoExec: *noexec,
+		Dump:   *dump,
+	}
+	// As in other shells, a login shell is also started with a `-`
+	// before its name.
+	*login = *login || strings.HasPrefix(cmd, "-")
+	cfg.Startup = startupFiles(*login, false)
+	cfg.Options.DryRun = *dryRun
+	cfg.Options.Posix = *posix
+	// Lines are edited with vi's keys unless $MESH_OPTIONS says emacs.
+	cfg.Options.Vi = true
+	// $MESH_OPTIONS lists shell options to turn on before running
+	// anything, e.g. to run every script in strict mode.
+	for _, name := range strings.Fields(os.Getenv("MESH_OPTIONS")) {
+		if err := cfg.Options.Set(name, true); err != nil {
+			fmt.Fprintf(std.err, "mesh: MESH_OPTIONS: %v\n", err)
+			return 1
+		}
+	}
 
 	if *snippet != "" {
-		s := newNonInteractive(strings.NewReader(*snippet))
-		return repl("-c", s, std)
+		cfg.Mode = mesh.Command
+		cfg.Command = *snippet
+		// As in other shells, the first argument after the command
+		// would be $0, so the positional parameters start after it.
+		if fs.NArg() > 0 {
+			cfg.Args = fs.Args()[1:]
+		}
 	} else if script := fs.Arg(0); script != "" {
 		f, err := os.Open(script)
 		if err != nil {
@@ -59,58 +106,21 @@ func mesh(cmd string, args []string, std *stdio) int {
 			return 1
 		}
 		defer f.Close()
-		return repl(script, newNonInteractive(f), std)
+		cfg.Name = script
+		cfg.Script = f
+		cfg.Args = fs.Args()[1:]
 	} else if !terminal.IsTerminal(int(std.in.Fd())) {
-		return repl("(stdin)", newNonInteractive(std.in), std)
+		cfg.Script = std.in
 	} else {
-		s, err := newInteractive()
-		if err != nil {
-			fmt.Fprintf(std.err, "mesh: %v\n", err)
-			return 1
-		}
-		defer s.close_()
-		return repl("(stdin)", s, std)
+		cfg.Mode = mesh.Interactive
+		cfg.Startup = startupFiles(*login, true)
+		// Suggesting commands for typos is only worth the time it
+		// takes when someone's there to read it.
+		cfg.Options.Suggest = true
 	}
-}
-
-func repl(filename string, s scanner, std *stdio) int {
-	status := 0
-	parse := parser.NewParser(filename)
-	interp := &interpreter.Interpreter{
-		Stdin:  std.in,
-		Stdout: std.out,
-		Stderr: std.err,
-	}
-	s.setPrompt("] ")
-	for {
-		line, err := s.readLine()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Fprintf(std.err, "mesh: %v\n", err)
-			continue
-		}
-		if done := parse.Parse(line); !done {
-			s.setPrompt(". ")
-			continue
-		}
-		s.setPrompt("] ")
-		stmt, err := parse.Result()
-		if err != nil {
-			status = 1
-			fmt.Fprintf(std.err, "mesh: %v\n", err)
-			continue
-		}
-		status, err = stmt.Visit(interp)
-		if err != nil {
-			if e, ok := err.(interpreter.ExitStatus); ok {
-				status = int(e)
-				break
-			}
-			status = 1
-			fmt.Fprintf(std.err, "mesh: %v\n", err)
-			continue
-		}
+	status, err := mesh.Run(cfg)
+	if err != nil {
+		fmt.Fprintf(std.err, "mesh: %v\n", err)
 	}
 	return status
 }
@@ -15,9 +15,9 @@
 package main
 
 import (
-	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -36,17 +36,15 @@ func createFile(t *testing.T, contents string) string {
 	require.Equal(t, len(contents), n)
 	return f.Name()
 }
-
 func mustOpen(t *testing.T, name string) *os.File {
 	f, err := os.Open(name)
 	require.NoError(t, err)
 	return f
 }
-
 func TestCommandFromArgs(t *testing.T) {
 	stdin := mustOpen(t, os.DevNull)
 	var stdout, stderr strings.Builder
-	status := mesh(
+	status := run(
 		"mesh",
 		[]string{"-c", "echo foo"},
 		&stdio{stdin, &stdout, &stderr},
@@ -55,11 +53,10 @@ func TestCommandFromArgs(t *testing.T) {
 	assert.Equal(t, "foo\n", stdout.String())
 	assert.Empty(t, stderr.String())
 }
-
 func TestScriptFromFile(t *testing.T) {
 	stdin := mustOpen(t, os.DevNull)
 	var stdout, stderr strings.Builder
-	status := mesh(
+	status := run(
 		"mesh",
 		[]string{createFile(t, "echo bar\n")},
 		&stdio{stdin, &stdout, &stderr},
@@ -68,25 +65,41 @@ func TestScriptFromFile(t *testing.T) {
 	assert.Equal(t, "bar\n", stdout.String())
 	assert.Empty(t, stderr.String())
 }
-
+func TestScriptArguments(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	script := createFile(t, "echo $# $2\n")
+	status := run(
+		"mesh",
+		[]string{script, "a", "b"},
+		&stdio{stdin, &stdout, &stderr},
+	)
+	assert.Equal(t, 0, status)
+	status = run(
+		"mesh",
+		[]string{"-c", "echo $# $@", "name", "c", "d"},
+		&stdio{stdin, &stdout, &stderr},
+	)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "2 b\n2 c d\n", stdout.String())
+	assert.Empty(t, stderr.String())
+}
 func TestScriptFromStdin(t *testing.T) {
 	stdin := mustOpen(t, createFile(t, "echo baz\n"))
 	var stdout, stderr strings.Builder
-	status := mesh("mesh", []string{}, &stdio{stdin, &stdout, &stderr})
+	status := run("mesh", []string{}, &stdio{stdin, &stdout, &stderr})
 	assert.Equal(t, 0, status)
 	assert.Equal(t, "baz\n", stdout.String())
 	assert.Empty(t, stderr.String())
 }
-
 func TestMultiLineScript(t *testing.T) {
 	stdin := mustOpen(t, createFile(t, "echo foo\necho 'bar\nbaz'\n"))
 	var stdout, stderr strings.Builder
-	status := mesh("mesh", []string{}, &stdio{stdin, &stdout, &stderr})
+	status := run("mesh", []string{}, &stdio{stdin, &stdout, &stderr})
 	assert.Equal(t, 0, status)
 	assert.Equal(t, "foo\nbar\nbaz\n", stdout.String())
 	assert.Empty(t, stderr.String())
 }
-
 func TestExit(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -103,7 +116,7 @@ func TestExit(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			stdin := mustOpen(t, createFile(t, test.script))
 			var stdout, stderr strings.Builder
-			status := mesh(
+			status := run(
 				"mesh",
 				[]string{},
 				&stdio{stdin, &stdout, &stderr},
@@ -120,52 +133,203 @@ func TestExit(t *testing.T) {
 		})
 	}
 }
-
 func TestErrorCases(t *testing.T) {
 	tests := []struct {
-		name          string
-		arg           string
-		shouldSucceed bool
+		name   string
+		arg    string
+		status int
 	}{
-		{"ShortHelpFlag", "-h", true},
-		{"LongHelpFlag", "-help", true},
-		{"BadFlag", "-badflag", false},
-		{"NonExistentScript", "/nonexistent", false},
-		{"ParseError", "-c=|", false},
-		{"ExecError", "-c=/nonexistent", false},
+		{"ShortHelpFlag", "-h", 0},
+		{"LongHelpFlag", "-help", 0},
+		{"BadFlag", "-badflag", 1},
+		{"NonExistentScript", "/nonexistent", 1},
+		{"ParseError", "-c=|", 2},
+		{"IncompleteInput", "-c=echo 'foo", 2},
+		{"ExecError", "-c=/nonexistent", 127},
+		{"CommandNotFound", "-c=nonexistent-mesh-command", 127},
+		{"NotExecutable", "-c=" + os.DevNull, 126},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			stdin := mustOpen(t, os.DevNull)
 			var stdout, stderr strings.Builder
-			status := mesh(
+			status := run(
 				"mesh",
 				[]string{test.arg},
 				&stdio{stdin, &stdout, &stderr},
 			)
-			if test.shouldSucceed {
-				assert.Equal(t, 0, status)
-			} else {
-				assert.NotEqual(t, 0, status)
-			}
+			assert.Equal(t, test.status, status)
 			assert.NotEmpty(t, stderr.String())
 		})
 	}
 }
-
-type mockReader struct{}
-
-func (r *mockReader) Read(p []byte) (n int, err error) {
-	return 0, errors.New("mock error")
+func TestPosixFlag(t *testing.T) {
+	script := "echo a:~\n)\necho b\n"
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	status := run(
+		"mesh", []string{"--posix", "-c", script},
+		&stdio{stdin, &stdout, &stderr},
+	)
+	// The rest of the script is skipped after a syntax error, as POSIX
+	// requires, which mesh does anyway.
+	assert.Equal(t, 2, status)
+	assert.Equal(t, "a:~\n", stdout.String())
+	assert.Equal(t,
+		"mesh: -c:2:1: unexpected token: RParen(\")\")\n)\n^\n",
+		stderr.String())
 }
+func TestCommandNotFound(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	status := run(
+		"mesh",
+		[]string{"-c", "nonexistent-mesh-command"},
+		&stdio{stdin, &stdout, &stderr},
+	)
+	assert.Equal(t, 127, status)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t,
+		"mesh: nonexistent-mesh-command: command not found\n",
+		stderr.String())
+}
+func TestNoExec(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		status int
+		errors int
+	}{
+		{"ValidScript", "echo foo\necho bar | cat\n", 0, 0},
+		{"OneParseError", "echo foo\n|\necho bar\n", 1, 1},
+		{"ReportsEveryParseError", "|\necho foo\n|\n", 1, 2},
+	}
 
-func TestScannerError(t *testing.T) {
-	n := newNonInteractive(&mockReader{})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdin := mustOpen(t, os.DevNull)
+			var stdout, stderr strings.Builder
+			status := run(
+				"mesh",
+				[]string{"-n", createFile(t, test.script)},
+				&stdio{stdin, &stdout, &stderr},
+			)
+			assert.Equal(t, test.status, status)
+			assert.Empty(t, stdout.String())
+			// Each error is followed by the offending line and a
+			// caret pointing into it.
+			errors := strings.Count(stderr.String(), "mesh: ")
+			assert.Equal(t, test.errors, errors)
+		})
+	}
+}
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	script := "x = 'a b'; echo $x \\$ >" + out + "\n" +
+		"cd /nonexistent; A=$x env | sort\n" +
+		"f() { echo in f $1; }; f 1 &\n" +
+		"false && echo and; false || echo or\n" +
+		"exit 1\n"
 	stdin := mustOpen(t, os.DevNull)
 	var stdout, stderr strings.Builder
-	status := repl(t.Name(), n, &stdio{stdin, &stdout, &stderr})
+	status := run("mesh", []string{"-dry-run", createFile(t, script)},
+		&stdio{stdin, &stdout, &stderr})
 	assert.Equal(t, 0, status)
-	assert.Empty(t, stdout.String())
-	assert.Equal(t, "mesh: mock error\n", stderr.String())
+	assert.Equal(t, "echo a b '$'\n"+
+		"cd /nonexistent\n'A=a b' env\nsort\n"+
+		"echo in f 1\n"+
+		// Every command is assumed to succeed.
+		"false\necho and\nfalse\n"+
+		"exit 1\n", stdout.String())
+	assert.Empty(t, stderr.String())
+	// Nothing was redirected.
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+}
+func TestDump(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	status := run(
+		"mesh",
+		[]string{"-n", "-dump", "-c", "echo $x >f"},
+		&stdio{stdin, &stdout, &stderr},
+	)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, `StmtList
+  Pipeline
+    Cmd
+      Word
+        String "echo"
+      Word
+        Var x
+      Redirect 1 ">"
+        Word
+          String "f"
+`, stdout.String())
+	assert.Empty(t, stderr.String())
+}
+func TestShellOptionsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		script  string
+		status  int
+		stdout  string
+		stderr  string
+	}{
+		{
+			"NoOptions", "",
+			"echo $meshshell_unset_var\ntest a = b\necho after",
+			0, "\nafter\n", "",
+		}, {
+			"Nounset", "nounset",
+			"echo $meshshell_unset_var\necho after",
+			0, "after\n",
+			"mesh: meshshell_unset_var: unbound variable\n",
+		}, {
+			"Errexit", "errexit",
+			"test a = b\necho after",
+			1, "", "",
+		}, {
+			"Pipefail", "pipefail",
+			"false | true",
+			1, "", "mesh: exit status 1\n",
+		}, {
+			"Strict", " errexit  nounset ",
+			"echo $meshshell_unset_var\necho after",
+			1, "",
+			"mesh: meshshell_unset_var: unbound variable\n",
+		}, {
+			"UnknownOption", "errexit bogus",
+			"echo after",
+			1, "",
+			"mesh: MESH_OPTIONS: bogus: invalid option name\n",
+		},
+	}
+
+	old, ok := os.LookupEnv("MESH_OPTIONS")
+	if ok {
+		defer os.Setenv("MESH_OPTIONS", old)
+	} else {
+		defer os.Unsetenv("MESH_OPTIONS")
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, os.Setenv("MESH_OPTIONS", test.options))
+			stdin := mustOpen(t, os.DevNull)
+			var stdout, stderr strings.Builder
+			status := run(
+				"mesh",
+				[]string{"-c", test.script},
+				&stdio{stdin, &stdout, &stderr},
+			)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Equal(t, test.stderr, stderr.String())
+		})
+	}
 }
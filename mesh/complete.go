@@ -0,0 +1,67 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"strings"
+
+	"github.com/meshshell/mesh/interpreter"
+)
+
+// completer completes the word before the cursor when tab is pressed, with
+// the candidates which the interpreter finds for it.
+type completer struct {
+	interp *interpreter.Interpreter
+}
+
+// Do returns the rest of each candidate for the word before the cursor, along
+// with the length of the part of it which has been typed, as readline
+// expects. A lone candidate is followed by a space, unless it's a directory,
+// so that the next word can be typed straight away.
+func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
+	words := commandWords(string(line[:pos]))
+	word := words[len(words)-1]
+	var matches []string
+	for _, candidate := range c.interp.Complete(words) {
+		// A function may return candidates which don't match,
+		// which can't be completed.
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate[len(word):])
+		}
+	}
+	if len(matches) == 1 && !strings.HasSuffix(matches[0], "/") {
+		matches[0] += " "
+	}
+	rest := make([][]rune, len(matches))
+	for index, match := range matches {
+		rest[index] = []rune(match)
+	}
+	return rest, len([]rune(word))
+}
+
+// commandWords splits the text before the cursor into the words of the
+// command being typed, the last of which is empty if a new word is about to
+// start. It only looks for blanks and the operators which separate commands,
+// which is enough for completion, if not for parsing.
+func commandWords(text string) []string {
+	if end := strings.LastIndexAny(text, ";&|(){}"); end >= 0 {
+		text = text[end+1:]
+	}
+	words := strings.Fields(text)
+	if len(words) == 0 || strings.TrimRight(text, " \t") != text {
+		words = append(words, "")
+	}
+	return words
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/interpreter"
+	"github.com/meshshell/mesh/parser"
+)
+
+func TestCompleter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "apricot"), 0777))
+	for _, name := range []string{"apple", ".hidden", "apricot/jam"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		require.NoError(t, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "mycmd"), nil, 0777)
+	require.NoError(t, err)
+
+	var stderr strings.Builder
+	interp, err := interpreter.NewInterpreter(interpreter.Config{
+		Stderr: &stderr,
+		Dir:    dir,
+		Env:    []string{"PATH=" + dir},
+	})
+	require.NoError(t, err)
+	script := "complete -W 'start stop restart' svc\n" +
+		"gen() { COMPREPLY = (x$2 y$2 $1$3 $COMP_WORDS[0]); }\n" +
+		"complete -F gen g\n"
+	stmts, err := parser.ParseAll(t.Name(), strings.NewReader(script))
+	require.NoError(t, err)
+	for _, stmt := range stmts {
+		_, err := stmt.Visit(interp)
+		require.NoError(t, err)
+	}
+	require.Empty(t, stderr.String())
+
+	c := &completer{interp}
+	for _, test := range []struct {
+		line   string
+		rest   []string
+		length int
+	}{
+		{"myc", []string{"md "}, 3},
+		{"compl", []string{"ete "}, 5},
+		{"svc st", []string{"art", "op"}, 2},
+		{"svc re", []string{"start "}, 2},
+		{"svc ", []string{"start", "stop", "restart"}, 0},
+		{"echo a; svc sto", []string{"p "}, 3},
+		{"svc x", nil, 1},
+		{"g x", []string{"x "}, 1},
+		{"g a ", []string{"x", "y", "ga", "g"}, 0},
+		{"cat ap", []string{"ple", "ricot/"}, 2},
+		{"cat apr", []string{"icot/"}, 3},
+		{"cat apricot/", []string{"jam "}, 8},
+		{"cat .", []string{"hidden "}, 1},
+		{"./ap", []string{"ple", "ricot/"}, 4},
+	} {
+		t.Run(test.line, func(t *testing.T) {
+			line := []rune(test.line + "...")
+			rest, length := c.Do(line, len([]rune(test.line)))
+			var strs []string
+			for _, r := range rest {
+				strs = append(strs, string(r))
+			}
+			assert.Equal(t, test.rest, strs)
+			assert.Equal(t, test.length, length)
+		})
+	}
+}
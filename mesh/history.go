@@ -0,0 +1,108 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/meshshell/mesh/interpreter"
+)
+
+// eventEnd lists the characters which end a history event such as `!echo`.
+const eventEnd = " \t;&|()<>'\""
+
+// expandHistory replaces each history event in a line with the line from the
+// history which it refers to: `!!` is the last line, `!n` is line n, `!-n` is
+// the nth last line, and `!prefix` is the last line starting with prefix. As
+// in other shells, there's no expansion inside single quotes, of a `!`
+// followed by whitespace or `=` or `(`, or of the `!` in `$!`. It returns
+// whether the line changed.
+func expandHistory(line string, h *interpreter.History) (string, bool, error) {
+	var expanded strings.Builder
+	changed, quoted := false, false
+	for len(line) > 0 {
+		c := line[0]
+		switch {
+		case c == '\\' && len(line) > 1 && !quoted:
+			// Leave escapes for the lexer, but don't expand an
+			// escaped `!`.
+			expanded.WriteString(line[:2])
+			line = line[2:]
+			continue
+		case strings.HasPrefix(line, "$!") && !quoted:
+			expanded.WriteString("$!")
+			line = line[2:]
+			continue
+		case c == '\'':
+			quoted = !quoted
+		case c == '!' && !quoted && eventLength(line) > 0:
+			n := eventLength(line)
+			event, err := findEvent(line[1:n], h)
+			if err != nil {
+				return "", false, err
+			}
+			expanded.WriteString(event)
+			line = line[n:]
+			changed = true
+			continue
+		}
+		expanded.WriteByte(c)
+		line = line[1:]
+	}
+	return expanded.String(), changed, nil
+}
+
+// eventLength returns the length of the history event at the start of line,
+// including the `!`, or zero if there isn't one.
+func eventLength(line string) int {
+	switch {
+	case strings.HasPrefix(line, "!!"):
+		return 2
+	case len(line) < 2 || strings.IndexByte(" \t=(", line[1]) >= 0:
+		return 0
+	}
+	switch n := strings.IndexAny(line[1:], eventEnd); n {
+	case -1:
+		return len(line)
+	case 0:
+		return 0
+	default:
+		return n + 1
+	}
+}
+
+// findEvent finds the line in the history which an event refers to, given
+// the event without its leading `!`.
+func findEvent(event string, h *interpreter.History) (string, error) {
+	lines, first := h.Lines()
+	n, err := strconv.Atoi(event)
+	switch {
+	case event == "!" && len(lines) > 0:
+		return lines[len(lines)-1], nil
+	case err == nil && n < 0 && -n <= len(lines):
+		return lines[len(lines)+n], nil
+	case err == nil && n >= first && n < first+len(lines):
+		return lines[n-first], nil
+	case err != nil:
+		for index := len(lines) - 1; index >= 0; index-- {
+			if strings.HasPrefix(lines[index], event) {
+				return lines[index], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("!%s: event not found", event)
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/interpreter"
+)
+
+func TestExpandHistory(t *testing.T) {
+	h := &interpreter.History{}
+	for _, line := range []string{"echo a", "ls -l", "echo b"} {
+		h.Add(line)
+	}
+	for _, test := range []struct {
+		line     string
+		expanded string
+		err      string
+	}{
+		{line: "!!", expanded: "echo b"},
+		{line: "!! | cat", expanded: "echo b | cat"},
+		{line: "!1;!-2", expanded: "echo a;ls -l"},
+		{line: "x=$(!ls)", expanded: "x=$(ls -l)"},
+		{line: "!echo", expanded: "echo b"},
+		{line: `"!l"`, expanded: `"ls -l"`},
+		{line: "'!!' \\!! ! a != b !( !", expanded: ""},
+		{line: "kill $!1", expanded: ""},
+		{line: "!4", err: "!4: event not found"},
+		{line: "!-4", err: "!-4: event not found"},
+		{line: "!cd", err: "!cd: event not found"},
+	} {
+		t.Run(test.line, func(t *testing.T) {
+			expanded, changed, err := expandHistory(test.line, h)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			if test.expanded == "" {
+				assert.False(t, changed)
+				assert.Equal(t, test.line, expanded)
+			} else {
+				assert.True(t, changed)
+				assert.Equal(t, test.expanded, expanded)
+			}
+		})
+	}
+}
@@ -0,0 +1,2100 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type integrationTest struct {
+	name   string
+	script string
+	status int
+	stdout string
+	stderr string
+}
+
+func (test *integrationTest) run(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	s := newNonInteractive(strings.NewReader(test.script))
+	std := &stdio{stdin, &stdout, &stderr}
+	status, err := repl(test.name, s, std, options{})
+	require.NoError(t, err)
+	assert.Equal(t, test.status, status)
+	assert.Equal(t, test.stdout, stdout.String())
+	assert.Equal(t, test.stderr, stderr.String())
+}
+
+func TestTildeExpansion(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	u, err := user.Current()
+	require.NoError(t, err)
+	for _, test := range []integrationTest{
+		{
+			name:   "Tilde",
+			script: "echo ~\n",
+			stdout: home + "\n",
+		}, {
+			name:   "TildeWithSubDirs",
+			script: "echo " + filepath.Join("~", "Desktop") + "\n",
+			stdout: filepath.Join(home, "Desktop") + "\n",
+		}, {
+			name:   "TildeInsideQuotes",
+			script: "echo '~'\n",
+			stdout: "~\n",
+		}, {
+			name:   "TildeInsideString",
+			script: "echo x~\n",
+			stdout: "x~\n",
+		}, {
+			name:   "TildeWithUserName",
+			script: "echo ~" + u.Username + "/x\n",
+			stdout: u.HomeDir + "/x\n",
+		}, {
+			name:   "TildeWithUnknownUserName",
+			script: "echo ~nonexistent-mesh-user/x\n",
+			stdout: "~nonexistent-mesh-user/x\n",
+		}, {
+			name:   "TildeAfterColon",
+			script: "echo a:~/x:~" + u.Username + "/y:\\~\n",
+			stdout: "a:" + home + "/x:" + u.HomeDir + "/y:~\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestVariableExpansion(t *testing.T) {
+	key := "meshshell_test_key"
+	_, ok := os.LookupEnv(key)
+	require.False(t, ok)
+	require.NoError(t, os.Setenv(key, "test value"))
+	defer os.Unsetenv(key)
+	for _, test := range []integrationTest{
+		{
+			name:   "GetEnvVar",
+			script: "echo x/$meshshell_test_key/y\n",
+			stdout: "x/test value/y\n",
+		}, {
+			name:   "DollarWithoutIdentifier",
+			script: "echo x/$/y\n",
+			stdout: "x/$/y\n",
+		}, {
+			name:   "ShellPID",
+			script: "echo $$\nsh -c 'echo $PPID'\n",
+			stdout: strings.Repeat(
+				strconv.Itoa(os.Getpid())+"\n", 2),
+		}, {
+			name:   "NoBackgroundPID",
+			script: "echo [$!]\n",
+			stdout: "[]\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestBackgroundPID(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	script := "sh -c 'echo $$' &\nfg\necho $!\n"
+	s := newNonInteractive(strings.NewReader(script))
+	std := &stdio{stdin, &stdout, &stderr}
+	status, err := repl(t.Name(), s, std, options{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Empty(t, stderr.String())
+	lines := strings.Fields(stdout.String())
+	require.Len(t, lines, 2)
+	assert.Equal(t, lines[0], lines[1])
+}
+
+func TestRemovePrefixAndSuffix(t *testing.T) {
+	for _, name := range []string{"MESH_FILE", "MESH_PATH", "MESH_EXT"} {
+		defer os.Unsetenv(name)
+	}
+	vars := "MESH_FILE = notes.txt; MESH_PATH = /a/b.c/d.tar.gz\n" +
+		"MESH_EXT = .txt\n"
+	for _, test := range []integrationTest{
+		{
+			name:   "ShortestSuffix",
+			script: "echo ${MESH_FILE%.txt} ${MESH_PATH%.*}\n",
+			stdout: "notes /a/b.c/d.tar\n",
+		}, {
+			name:   "LongestSuffix",
+			script: "echo ${MESH_PATH%%.*}\n",
+			stdout: "/a/b\n",
+		}, {
+			name:   "ShortestPrefix",
+			script: "echo ${MESH_PATH#*/}\n",
+			stdout: "a/b.c/d.tar.gz\n",
+		}, {
+			name:   "LongestPrefix",
+			script: "echo ${MESH_PATH##*/}\n",
+			stdout: "d.tar.gz\n",
+		}, {
+			name:   "PatternWithVariable",
+			script: "echo ${MESH_FILE%$MESH_EXT}\n",
+			stdout: "notes\n",
+		}, {
+			name:   "NoMatch",
+			script: "echo ${MESH_FILE%.md} ${MESH_FILE#x}\n",
+			stdout: "notes.txt notes.txt\n",
+		}, {
+			name:   "EachElement",
+			script: "x = (a.c b.c); echo ${x[@]%.c}\n",
+			stdout: "a b\n",
+		},
+	} {
+		test.script = vars + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestSubstitution(t *testing.T) {
+	defer os.Unsetenv("MESH_X")
+	defer os.Unsetenv("MESH_Y")
+	vars := "MESH_X = foo/bar/foo; MESH_Y = o\n"
+	for _, test := range []integrationTest{
+		{
+			name:   "First",
+			script: "echo ${MESH_X/foo/baz}\n",
+			stdout: "baz/bar/foo\n",
+		}, {
+			name:   "All",
+			script: "echo ${MESH_X//foo/baz}\n",
+			stdout: "baz/bar/baz\n",
+		}, {
+			name:   "Longest",
+			script: "echo ${MESH_X/b*o/x} ${MESH_X//?o/x}\n",
+			stdout: "foo/x xo/bar/xo\n",
+		}, {
+			name:   "Start",
+			script: "echo ${MESH_X/#foo/x} ${MESH_X/#bar/x}\n",
+			stdout: "x/bar/foo foo/bar/foo\n",
+		}, {
+			name:   "End",
+			script: "echo ${MESH_X/%foo/x} ${MESH_X/%/.txt}\n",
+			stdout: "foo/bar/x foo/bar/foo.txt\n",
+		}, {
+			name:   "Delete",
+			script: "echo ${MESH_X//o/} ${MESH_X//o}\n",
+			stdout: "f/bar/f f/bar/f\n",
+		}, {
+			name: "EscapedSlash",
+			script: "echo ${MESH_X//\\//\\\\} " +
+				"${MESH_X/r\\/f/r\\/}\n",
+			stdout: "foo\\bar\\foo foo/bar/oo\n",
+		}, {
+			name:   "Variables",
+			script: "echo ${MESH_X//$MESH_Y/<$MESH_Y\\$MESH_Y>}\n",
+			stdout: "f<o$MESH_Y><o$MESH_Y>/bar/" +
+				"f<o$MESH_Y><o$MESH_Y>\n",
+		}, {
+			name:   "EmptyPattern",
+			script: "echo ${MESH_X//} ${MESH_X//x/y}\n",
+			stdout: "foo/bar/foo foo/bar/foo\n",
+		},
+	} {
+		test.script = vars + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestSubstring(t *testing.T) {
+	defer os.Unsetenv("MESH_X")
+	vars := "MESH_X = héllo\n"
+	for _, test := range []integrationTest{
+		{
+			name:   "OffsetAndLength",
+			script: "echo ${MESH_X:1:3}\n",
+			stdout: "éll\n",
+		}, {
+			name:   "Offset",
+			script: "echo ${MESH_X:2}\n",
+			stdout: "llo\n",
+		}, {
+			name:   "NegativeOffset",
+			script: "echo ${MESH_X: -3} ${MESH_X: -3:2}\n",
+			stdout: "llo ll\n",
+		}, {
+			name:   "NegativeLength",
+			script: "echo ${MESH_X:1:-1}\n",
+			stdout: "éll\n",
+		}, {
+			name:   "Arithmetic",
+			script: "n = 2; echo ${MESH_X:$n-1:$n*2}\n",
+			stdout: "éllo\n",
+		}, {
+			name: "OutOfRange",
+			script: "echo a${MESH_X:9}b${MESH_X: -9}" +
+				"c${MESH_X:1:-9}d\n",
+			stdout: "abcd\n",
+		}, {
+			name:   "EachElement",
+			script: "x = (abc def); echo ${x[@]:1}\n",
+			stdout: "bc ef\n",
+		},
+	} {
+		test.script = vars + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestCaseConversion(t *testing.T) {
+	defer os.Unsetenv("MESH_X")
+	vars := "MESH_X = 'ärger Über'\n"
+	for _, test := range []integrationTest{
+		{
+			name:   "Upper",
+			script: "echo ${MESH_X^^} ${MESH_X^}\n",
+			stdout: "ÄRGER ÜBER Ärger Über\n",
+		}, {
+			name:   "Lower",
+			script: "echo ${MESH_X,,} ${MESH_X,}\n",
+			stdout: "ärger über ärger Über\n",
+		}, {
+			name:   "Pattern",
+			script: "echo ${MESH_X^^[aeiouä]} ${MESH_X^[b]}\n",
+			stdout: "ÄrgEr ÜbEr ärger Über\n",
+		}, {
+			name:   "EachElement",
+			script: "x = (ab cd); echo ${x[@]^}\n",
+			stdout: "Ab Cd\n",
+		},
+	} {
+		test.script = vars + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestDefaultValues(t *testing.T) {
+	defer os.Unsetenv("MESH_Y")
+	defer os.Unsetenv("MESH_E")
+	vars := "MESH_E =\n"
+	for _, test := range []integrationTest{
+		{
+			name: "Default",
+			script: "echo ${MESH_X-a} ${MESH_X:-b} " +
+				"${MESH_E-c} ${MESH_E:-d}\n",
+			stdout: "a b d\n",
+		}, {
+			name: "Alternative",
+			script: "echo ${MESH_X+a}${MESH_X:+b} " +
+				"${MESH_E+c}${MESH_E:+d}\n",
+			stdout: "c\n",
+		}, {
+			name:   "Assign",
+			script: "echo ${MESH_Y=a} ${MESH_Y:=b} ${MESH_E:=c}\n",
+			stdout: "a a c\n",
+		}, {
+			name:   "Assigned",
+			script: "echo ${MESH_Y:=b}; echo $MESH_Y $MESH_E\n",
+			stdout: "a\na\n",
+		}, {
+			name:   "Error",
+			script: "echo ${MESH_E?} ${MESH_E:?empty}\n",
+			status: 1,
+			stderr: "mesh: MESH_E: empty\n",
+		}, {
+			name:   "NotSet",
+			script: "echo ${MESH_X?}\n",
+			status: 1,
+			stderr: "mesh: MESH_X: parameter null or not set\n",
+		}, {
+			name: "Nounset",
+			script: "set -u; echo ${MESH_X:-a} $@ x\n" +
+				"echo $MESH_X\n",
+			status: 1,
+			stdout: "a x\n",
+			stderr: "mesh: MESH_X: unbound variable\n",
+		},
+	} {
+		test.script = vars + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0777))
+	for _, name := range []string{
+		"a.go", "b.go", ".hidden.go", "sub/c.go", "x y.txt",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		require.NoError(t, err)
+	}
+	defer os.Unsetenv("p")
+	defer os.Unsetenv("e")
+	for _, test := range []integrationTest{
+		{
+			name:   "Star",
+			script: "echo *.go\n",
+			stdout: "a.go b.go\n",
+		}, {
+			name:   "QuestionMarkAndBrackets",
+			script: "echo ?.go [!a].go\n",
+			stdout: "a.go b.go b.go\n",
+		}, {
+			name:   "Directories",
+			script: "echo */*.go */ s*/\n",
+			stdout: "sub/c.go sub/ sub/\n",
+		}, {
+			name:   "AbsolutePath",
+			script: "echo " + dir + "/s*\n",
+			stdout: dir + "/sub\n",
+		}, {
+			name:   "NoMatch",
+			script: "echo *.md s*/*.md\n",
+			stdout: "*.md s*/*.md\n",
+		}, {
+			name:   "Quoted",
+			script: "echo '*.go' \\*.go\n",
+			stdout: "*.go *.go\n",
+		}, {
+			name:   "Variables",
+			script: "p = '*.go'; e = go\necho $p *.$e\n",
+			stdout: "*.go a.go b.go\n",
+		}, {
+			name:   "NotSplit",
+			script: "printf '<%s>' *.txt; echo\n",
+			stdout: "<x y.txt>\n",
+		}, {
+			name:   "Array",
+			script: "x = (*.go)\necho $x[1]\n",
+			stdout: "b.go\n",
+		}, {
+			name:   "Hidden",
+			script: "echo .*.go\n",
+			stdout: ".hidden.go\n",
+		}, {
+			name:   "Dotglob",
+			script: "set -o dotglob\necho *.go\n",
+			stdout: ".hidden.go a.go b.go\n",
+		}, {
+			name:   "Nullglob",
+			script: "set -o nullglob\necho a *.md b\n",
+			stdout: "a b\n",
+		}, {
+			name:   "ShoptInPipeline",
+			script: "shopt -s nullglob\necho a *.md b | cat\n",
+			stdout: "a b\n",
+		}, {
+			name:   "Failglob",
+			script: "set -o failglob\necho *.md\necho after\n",
+			stdout: "after\n",
+			stderr: "mesh: no match: *.md\n",
+		},
+	} {
+		test.script = "cd " + dir + "\n" + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestAutocd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "x"), 0777))
+	for _, test := range []integrationTest{
+		{
+			name:   "Off",
+			script: "sub\npwd\n",
+			stdout: dir + "\n",
+			stderr: "mesh: sub: command not found\n",
+		}, {
+			name:   "On",
+			script: "set -o autocd\nsub\npwd\n",
+			stdout: dir + "/sub\n",
+		}, {
+			name:   "Path",
+			script: "set -o autocd\nsub/x/\npwd\n../..\npwd\n",
+			stdout: dir + "/sub/x\n" + dir + "\n",
+		}, {
+			name:   "WithArguments",
+			script: "set -o autocd\nsub x\n",
+			status: 127,
+			stderr: "mesh: sub: command not found\n",
+		}, {
+			name:   "NotADirectory",
+			script: "set -o autocd\nnonexistent\n",
+			status: 127,
+			stderr: "mesh: nonexistent: command not found\n",
+		},
+	} {
+		test.script = "cd " + dir + "\n" + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "mycmd"), nil, 0777)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "mydata"), nil, 0666)
+	require.NoError(t, err)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	for _, test := range []integrationTest{
+		{
+			name:   "Off",
+			script: "mycnd\n",
+			status: 127,
+			stderr: "mesh: mycnd: command not found\n",
+		}, {
+			name:   "Executable",
+			script: "set -o suggest\nmycnd\n",
+			status: 127,
+			stderr: "mesh: mycnd: command not found. " +
+				"Did you mean 'mycmd'?\n",
+		}, {
+			name:   "NotExecutable",
+			script: "set -o suggest\nmydatx\n",
+			status: 127,
+			stderr: "mesh: mydatx: command not found\n",
+		}, {
+			name:   "Builtin",
+			script: "set -o suggest\nhsah\n",
+			status: 127,
+			stderr: "mesh: hsah: command not found. " +
+				"Did you mean 'hash'?\n",
+		}, {
+			name:   "Function",
+			script: "set -o suggest\ngreet() { echo hi; }\ngret\n",
+			status: 127,
+			stderr: "mesh: gret: command not found. " +
+				"Did you mean 'greet'?\n",
+		}, {
+			name:   "TooFar",
+			script: "set -o suggest\nxyzzy\n",
+			status: 127,
+			stderr: "mesh: xyzzy: command not found\n",
+		},
+	} {
+		test.script = "PATH = " + dir + "\n" + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestGlobStar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"a/b/c", ".git/d"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0777)
+		require.NoError(t, err)
+	}
+	for _, name := range []string{
+		"main.go", "a/a.go", "a/b/b.go", "a/b/c/c.go", "a/b/c/c.txt",
+		".git/d/d.go",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		require.NoError(t, err)
+	}
+	// Symlinks which lead back to a directory above them are skipped.
+	require.NoError(t, os.Symlink("..", filepath.Join(dir, "a/b/loop")))
+	for _, test := range []integrationTest{
+		{
+			name:   "AnyDepth",
+			script: "echo **/*.go\n",
+			stdout: "a/a.go a/b/b.go a/b/c/c.go main.go\n",
+		}, {
+			name: "Subdirectory",
+			// Here the symlink leads outside the walk, so
+			// it's followed until it leads back into it.
+			script: "echo a/b/**/*.go\n",
+			stdout: "a/b/b.go a/b/c/c.go a/b/loop/a.go\n",
+		}, {
+			name:   "StarDoesNotCrossDirectories",
+			script: "echo */*.go\n",
+			stdout: "a/a.go\n",
+		}, {
+			name:   "Everything",
+			script: "echo a/**\n",
+			stdout: "a/a.go a/b a/b/b.go a/b/c a/b/c/c.go " +
+				"a/b/c/c.txt\n",
+		}, {
+			name:   "Directories",
+			script: "echo **/\n",
+			stdout: "a/ a/b/ a/b/c/\n",
+		}, {
+			name:   "Dotglob",
+			script: "set -o dotglob\necho **/d*.go\n",
+			stdout: ".git/d/d.go\n",
+		}, {
+			name:   "NoMatch",
+			script: "echo **/*.md\n",
+			stdout: "**/*.md\n",
+		},
+	} {
+		test.script = "cd " + dir + "\n" + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestExtglob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"a.go", "b.go", "ab.go", "abab.go", "c.txt", ".d.txt",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		require.NoError(t, err)
+	}
+	for _, test := range []integrationTest{
+		{
+			name:   "OneOf",
+			script: "echo @(a|b).go\n",
+			stdout: "a.go b.go\n",
+		}, {
+			name:   "ZeroOrOne",
+			script: "echo a?(b).go\n",
+			stdout: "a.go ab.go\n",
+		}, {
+			name:   "ZeroOrMore",
+			script: "echo *(ab).go\n",
+			stdout: "ab.go abab.go\n",
+		}, {
+			name:   "OneOrMore",
+			script: "echo +(a|b).go\n",
+			stdout: "a.go ab.go abab.go b.go\n",
+		}, {
+			name:   "AnythingBut",
+			script: "echo !(*.go)\n",
+			stdout: "c.txt\n",
+		}, {
+			name:   "Nested",
+			script: "echo @(+(ab)|c).*\n",
+			stdout: "ab.go abab.go c.txt\n",
+		}, {
+			name:   "NoMatch",
+			script: "echo @(x|y)\n",
+			stdout: "@(x|y)\n",
+		}, {
+			name: "Case",
+			script: "case abab.go in +(a|b).go) echo yes;; esac\n" +
+				"case ab in !(ab)) echo no;; esac\n",
+			stdout: "yes\n",
+		},
+	} {
+		test.script = "cd " + dir + "\nshopt -s extglob\n" + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestFileContents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "f")
+	err = ioutil.WriteFile(path, []byte("a  b\nc\n\n"), 0666)
+	require.NoError(t, err)
+	defer os.Unsetenv("MESH_F")
+	defer os.Unsetenv("IFS")
+	noFile := "mesh: open missing: no such file or directory\n"
+	for _, test := range []integrationTest{
+		{
+			name:   "Assign",
+			script: "MESH_F = $(< f)\nIFS = ''\necho x${MESH_F}x\n",
+			// Only the newlines at the end are removed.
+			stdout: "xa  b\ncx\n",
+		}, {
+			name:   "Split",
+			script: "printf '<%s>' $(<f) x$(< f)x; echo\n",
+			stdout: "<a><b><c><xa><b><cx>\n",
+		}, {
+			name:   "Missing",
+			script: "MESH_F = $(< missing)\necho $? x${MESH_F}x\n",
+			stdout: "1 xx\n",
+			stderr: noFile,
+		}, {
+			name:   "MissingInCommand",
+			script: "echo x$(< missing)x\necho $?\n",
+			stdout: "xx\n0\n",
+			stderr: noFile,
+		},
+	} {
+		os.Unsetenv("IFS")
+		test.script = "cd " + dir + "\n" + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestCmdSubst(t *testing.T) {
+	defer os.Unsetenv("MESH_X")
+	for _, test := range []integrationTest{
+		{
+			name:   "Output",
+			script: "echo a$(echo b; echo c | tr c d)e\n",
+			stdout: "ab de\n",
+		}, {
+			name: "Split",
+			script: "MESH_X = $(printf '1 2\\\\n\\\\n')\n" +
+				"printf '<%s>' $MESH_X $(echo 3 4); echo\n",
+			stdout: "<1><2><3><4>\n",
+		}, {
+			name: "Lines",
+			script: "echo $(\necho a\necho b\n)\n" +
+				"echo $(echo $(echo nested))\n",
+			stdout: "a b\nnested\n",
+		}, {
+			name: "Subshell",
+			script: "MESH_X = 1\necho $(MESH_X = 2; echo $MESH_X)\n" +
+				"echo $MESH_X\n",
+			stdout: "2\n1\n",
+		}, {
+			name: "Status",
+			script: "MESH_X = $(exit 3)\necho $?\n" +
+				"echo x$(exit 3)x; echo $?\n",
+			stdout: "3\nxx\n0\n",
+		}, {
+			name:   "Error",
+			script: "MESH_X = $(nonexistent-mesh-command)\necho $?\n",
+			stdout: "127\n",
+			stderr: "mesh: nonexistent-mesh-command: " +
+				"command not found\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestCoproc(t *testing.T) {
+	defer os.Unsetenv("COPROC_PID")
+	defer os.Unsetenv("P_PID")
+	for _, test := range []integrationTest{
+		{
+			name: "Group",
+			script: "coproc P { read x; echo got $x; }\n" +
+				"echo ${P[@]} x${P_PID}x\n" +
+				"echo hi >&${P[1]}\n" +
+				"read y <&${P[0]}\necho $y\n",
+			stdout: "63 62 xx\ngot hi\n",
+		}, {
+			// The group's PID is that of the command it starts
+			// with, as it would be for the command by itself.
+			name: "GroupCommand",
+			script: "coproc P { sh -c 'echo $$'; echo done; }\n" +
+				"read y <&${P[0]}\nread z <&${P[0]}\n" +
+				"test $y = $P_PID && echo $z\n",
+			stdout: "done\n",
+		}, {
+			name: "Command",
+			script: "coproc head -n 1\n" +
+				"echo hi >&${COPROC[1]}\n" +
+				"read y <&${COPROC[0]}\n" +
+				"echo $y\ntest -n $COPROC_PID\n",
+			stdout: "hi\n",
+		}, {
+			name: "Builtin",
+			script: "coproc read x\necho x${COPROC_PID}x\n" +
+				"echo hi >&${COPROC[1]}\n",
+			stdout: "xx\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	defer os.Unsetenv("MESH_S")
+	defer os.Unsetenv("REPLY")
+	defer os.Unsetenv("PS3")
+	for _, test := range []integrationTest{
+		{
+			name: "Menu",
+			script: "{ echo 2; echo; echo 5; } | " +
+				"select MESH_S in a 'b c'; do " +
+				"echo $MESH_S $REPLY; done\n",
+			stdout: "b c 2\n5\n",
+			stderr: "1) a\n2) b c\n#? #? 1) a\n2) b c\n#? #? \n",
+		}, {
+			name: "Prompt",
+			script: "PS3 = '> '\nselect MESH_S\nin a\n" +
+				"do echo $MESH_S\ndone <<<1\n",
+			stdout: "a\n",
+			stderr: "1) a\n> > \n",
+		}, {
+			name: "Positional",
+			script: "f() { select MESH_S; do echo $MESH_S; " +
+				"done; }\nf a b <<<2\n",
+			stdout: "b\n",
+			stderr: "1) a\n2) b\n#? #? \n",
+		},
+	} {
+		os.Unsetenv("PS3")
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestArithFor(t *testing.T) {
+	defer os.Unsetenv("MESH_I")
+	defer os.Unsetenv("MESH_N")
+	for _, test := range []integrationTest{
+		{
+			name: "Count",
+			script: "MESH_N = 3\n" +
+				"for ((MESH_I = 0; MESH_I < $MESH_N; " +
+				"MESH_I++))\n" +
+				"do echo $MESH_I\ndone\necho $MESH_I\n",
+			stdout: "0\n1\n2\n3\n",
+		}, {
+			name: "EmptyCondition",
+			script: "f() { for ((MESH_I = 1;; MESH_I *= 2)); do " +
+				"[[ $MESH_I -lt 5 ]] || return 3; " +
+				"echo $MESH_I; done; }\nf\necho $?\n",
+			stdout: "1\n2\n4\n3\n",
+		}, {
+			name: "NoRuns",
+			script: "for ((MESH_I = 5; MESH_I < 3;)); do " +
+				"false; done\n",
+		}, {
+			name:   "Error",
+			script: "for ((MESH_I = 1 +; ;)); do echo a; done\n",
+			status: 1,
+			stderr: "mesh: MESH_I = 1 +: " +
+				"syntax error in expression\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+// TestPosix checks each of the extensions which the posix option turns off.
+func TestPosix(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a/b"), 0777))
+	for _, name := range []string{"main.go", "a/a.go", "a/b/b.go"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		require.NoError(t, err)
+	}
+	defer os.Unsetenv("MESH_P")
+	for _, test := range []integrationTest{
+		{
+			name:   "GlobStarIsStar",
+			script: "set -o posix\necho **/*.go\n",
+			stdout: "a/a.go\n",
+		}, {
+			name:   "TildeAfterColon",
+			script: "set -o posix\necho a:~/x ~/y\n",
+			stdout: "a:~/x " + home + "/y\n",
+		}, {
+			name: "IndexWithoutBraces",
+			script: "MESH_P = (a b)\nset -o posix\n" +
+				"echo $MESH_P[1] ${MESH_P[1]}\n",
+			stdout: "a[1] b\n",
+		}, {
+			name: "Off",
+			script: "set -o posix\nset +o posix\n" +
+				"echo **/*.go a:~\n",
+			stdout: "a/a.go a/b/b.go main.go a:" + home + "\n",
+		},
+	} {
+		test.script = "cd " + dir + "\n" + test.script
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestChdir(t *testing.T) {
+	dir1, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.Remove(dir1)
+	dir2, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.Remove(dir2)
+	sub := filepath.Join(dir1, "sub")
+	require.NoError(t, os.Mkdir(sub, 0700))
+	defer os.Remove(sub)
+	for _, test := range []integrationTest{
+		{
+			name: "CDUpdatesPWDAndOLDPWD",
+			script: fmt.Sprintf(
+				"cd %s\ncd %s\necho $OLDPWD\necho $PWD\n",
+				dir1, dir2,
+			),
+			stdout: fmt.Sprintf("%s\n%s\n", dir1, dir2),
+		}, {
+			name: "ChangeToOldWorkingDirectory",
+			script: fmt.Sprintf(
+				"cd %s\ncd %s\ncd -\npwd\n", dir1, dir2,
+			),
+			stdout: dir1 + "\n" + dir1 + "\n",
+		}, {
+			name: "PWDIsAbsolute",
+			script: fmt.Sprintf(
+				"cd %s\ncd ..\ncd %s\necho $PWD\n",
+				dir1,
+				filepath.Base(dir1),
+			),
+			stdout: dir1 + "\n",
+		}, {
+			name: "CDPATH",
+			script: fmt.Sprintf(
+				"CDPATH=/nonexistent:%s\ncd %s\n",
+				filepath.Dir(dir1), filepath.Base(dir1),
+			),
+			stdout: dir1 + "\n",
+		}, {
+			name: "CDPATHNotSearchedForDotDirectories",
+			script: fmt.Sprintf(
+				"CDPATH=%s\ncd %s\ncd ./sub\n", dir1, dir2,
+			),
+			status: 1,
+			stderr: "mesh: cd: chdir ./sub: no such file or " +
+				"directory\n",
+		}, {
+			name: "CDPATHCurrentDirectory",
+			script: fmt.Sprintf(
+				"cd %s\nCDPATH=:%s\ncd %s\npwd\n",
+				filepath.Dir(dir1), dir1, filepath.Base(dir2),
+			),
+			stdout: dir2 + "\n",
+		}, {
+			name: "RelativePathsAfterCD",
+			script: fmt.Sprintf(
+				"cd %s\necho x >f\ncat <f\n"+
+					"test -f f && ./sub/../f\nrm f\n",
+				dir1,
+			),
+			stdout: "x\n",
+			stderr: "mesh: ./sub/../f: permission denied\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+	os.Unsetenv("CDPATH")
+	// cd only changes the shell's working directory, not the process's.
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	assert.NotEqual(t, dir1, dir)
+}
+
+func TestWhitespace(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name:   "EmptyString",
+			script: "\n",
+		}, {
+			name:   "MultiLine",
+			script: "echo 'multiline\nstring'\n",
+			stdout: "multiline\nstring\n",
+		}, {
+			name:   "JoinLines",
+			script: "echo \\\nfoo\n",
+			stdout: "foo\n",
+		}, {
+			name:   "JoinWord",
+			script: "echo foo\\\nbar\n",
+			stdout: "foobar\n",
+		}, {
+			name:   "SemicolonsAndWhitespace",
+			script: "; ;; \n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestIFS(t *testing.T) {
+	ifs, ok := os.LookupEnv("IFS")
+	defer func() {
+		if ok {
+			os.Setenv("IFS", ifs)
+		} else {
+			os.Unsetenv("IFS")
+		}
+	}()
+	for _, test := range []integrationTest{
+		{
+			name: "Read",
+			script: "IFS = :\nread a b c <<<x:y:z\n" +
+				"IFS = ' '\necho $c $b $a\n",
+			stdout: "z y x\n",
+		}, {
+			name: "SplitExpansion",
+			script: "x = a:b::c\nIFS = :\n" +
+				"printf '[%s]' $x\necho\n",
+			stdout: "[a][b][][c]\n",
+		}, {
+			name:   "NoSplitting",
+			script: "x = 'a b'\nIFS = ''\nprintf '[%s]' $x\necho\n",
+			stdout: "[a b]\n",
+		},
+	} {
+		os.Unsetenv("IFS")
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name:   "CommandList",
+			script: "echo foo; echo bar\n",
+			stdout: "foo\nbar\n",
+		}, {
+			name:   "Pipeline",
+			script: "seq 3 -1 1 | sort\n",
+			stdout: "1\n2\n3\n",
+		}, {
+			name:   "Background",
+			script: "true &; echo foo\n",
+			stdout: "foo\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestRedirection(t *testing.T) {
+	key := "meshshell_test_key"
+	require.NoError(t, os.Setenv(key, "test value"))
+	defer os.Unsetenv(key)
+	file := createFile(t, "from a file\n")
+	existing := createFile(t, "existing\n")
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	home := os.Getenv("HOME")
+	require.NoError(t, os.Setenv("HOME", dir))
+	defer os.Setenv("HOME", home)
+	defer os.Unsetenv("meshshell_test_words")
+	defer os.Unsetenv("MESH_X")
+	defer os.Unsetenv("MESH_Y")
+	for _, test := range []integrationTest{
+		{
+			name:   "HereDoc",
+			script: "cat <<EOF\nhello\n  world\nEOF\necho after\n",
+			stdout: "hello\n  world\nafter\n",
+		}, {
+			name:   "EmptyHereDoc",
+			script: "cat <<EOF\nEOF\n",
+		}, {
+			name:   "HereDocWithVariables",
+			script: "cat <<EOF\nx/$meshshell_test_key/y $\nEOF\n",
+			stdout: "x/test value/y $\n",
+		}, {
+			name:   "HereDocWithEscapes",
+			script: "cat <<EOF\n\\$HOME \\\\ \\n\nEOF\n",
+			stdout: "$HOME \\ \\n\n",
+		}, {
+			name:   "QuotedDelimiter",
+			script: "cat <<'EOF'\n$meshshell_test_key \\$\nEOF\n",
+			stdout: "$meshshell_test_key \\$\n",
+		}, {
+			name:   "PartiallyQuotedDelimiter",
+			script: "cat <<E\\OF\n$meshshell_test_key\nEOF\n",
+			stdout: "$meshshell_test_key\n",
+		}, {
+			name:   "StripTabs",
+			script: "cat <<-EOF\n\t\thello\n\t world\n\tEOF\n",
+			stdout: "hello\n world\n",
+		}, {
+			name:   "DelimiterMustMatchWholeLine",
+			script: "cat <<EOF\n EOF\nEOF \nEOF\n",
+			stdout: " EOF\nEOF \n",
+		}, {
+			name:   "TwoHereDocsOnOneLine",
+			script: "cat <<A; cat <<B\na\nA\nb\nB\n",
+			stdout: "a\nb\n",
+		}, {
+			name: "HereDocsAtTwoFds",
+			script: "cat - /dev/fd/3 3<<A <<B\nthree\nA\n" +
+				"zero\nB\n",
+			stdout: "zero\nthree\n",
+		}, {
+			name: "HereDocAtFdForBuiltin",
+			script: "read MESH_X MESH_Y 3<<EOF <&3\na b\nEOF\n" +
+				"echo $MESH_Y $MESH_X\n",
+			stdout: "b a\n",
+		}, {
+			name:   "HereStringAtFd",
+			script: "cat /dev/fd/4 4<<<four\n",
+			stdout: "four\n",
+		}, {
+			name:   "HereDocInPipeline",
+			script: "cat <<EOF | sort\nb\na\nEOF\n",
+			stdout: "a\nb\n",
+		}, {
+			name:   "RedirectIn",
+			script: "cat <" + file + "\n",
+			stdout: "from a file\n",
+		}, {
+			name:   "RedirectInNonExistent",
+			script: "cat < /nonexistent\necho after\n",
+			status: 0,
+			stdout: "after\n",
+			stderr: "mesh: open /nonexistent: " +
+				"no such file or directory\n",
+		}, {
+			name: "RedirectOut",
+			script: "echo a >" + out + "\necho b >" + out + "\n" +
+				"cat " + out + "\n",
+			stdout: "b\n",
+		}, {
+			name:   "Noclobber",
+			script: "set -o noclobber\necho x >" + existing + "\n",
+			status: 1,
+			stderr: "mesh: " + existing +
+				": cannot overwrite existing file\n",
+		}, {
+			name: "NoclobberKeepsFile",
+			script: "set -o noclobber\necho x >" + existing + "\n" +
+				"cat " + existing + "\n",
+			stdout: "existing\n",
+			stderr: "mesh: " + existing +
+				": cannot overwrite existing file\n",
+		}, {
+			name: "NoclobberOverride",
+			script: "set -o noclobber\necho x >| " + existing +
+				"\ncat " + existing + "\n",
+			stdout: "x\n",
+		}, {
+			name: "NoclobberNewFile",
+			script: "set -o noclobber\necho x >" + out + "-new\n" +
+				"cat " + out + "-new\n",
+			stdout: "x\n",
+		}, {
+			name:   "NoclobberDevNull",
+			script: "set -o noclobber\necho x >/dev/null\n",
+		}, {
+			name:   "StdoutToStderr",
+			script: "echo x1>&2\n",
+			stderr: "x1\n",
+		}, {
+			name:   "StderrToStdout",
+			script: "sh -c 'echo err >&2' 2>&1 >/dev/null\n",
+			stdout: "err\n",
+		}, {
+			name:   "ChildReadsFd",
+			script: "sh -c 'cat <&3' 3<" + file + "\n",
+			stdout: "from a file\n",
+		}, {
+			name: "ExecOpensFd",
+			script: "exec 3<" + file + "\nsh -c 'cat <&3'\n" +
+				"echo after\n",
+			stdout: "from a file\nafter\n",
+		}, {
+			name: "Append",
+			script: "echo a >" + out + "\necho b >>" + out + "\n" +
+				"cat " + out + "\n",
+			stdout: "a\nb\n",
+		}, {
+			name: "RedirectAll",
+			script: "sh -c 'echo out; echo err >&2' &>" + out +
+				"\ncat " + out + "\n",
+			stdout: "out\nerr\n",
+		}, {
+			name: "RedirectAllAppend",
+			script: "echo a >" + out + "\n" +
+				"sh -c 'echo err >&2' &>>" + out + "\n" +
+				"cat " + out + "\n",
+			stdout: "a\nerr\n",
+		}, {
+			name: "RedirectAllThenStderrToStdout",
+			script: "sh -c 'echo err >&2' &>" + out + " 2>&1\n" +
+				"cat " + out + "\n",
+			stdout: "err\n",
+		}, {
+			name: "RedirectAllInPipeline",
+			script: "sh -c 'echo err >&2' &>/dev/null | cat\n" +
+				"sh -c 'echo err >&2' 2>&1 | cat\n",
+			stdout: "err\n",
+		}, {
+			name: "ExpandTarget",
+			script: "meshshell_test_key = " + out + "\n" +
+				"echo x >$meshshell_test_key\ncat ~/out\n",
+			stdout: "x\n",
+		}, {
+			name:   "EmptyTarget",
+			script: "echo x >$meshshell_test_unset\n",
+			status: 1,
+			stderr: "mesh: $meshshell_test_unset: " +
+				"ambiguous redirect\n",
+		}, {
+			name: "MultipleWordTarget",
+			script: "meshshell_test_words = 'a b'\n" +
+				"cat <$meshshell_test_words\n",
+			status: 1,
+			stderr: "mesh: $meshshell_test_words: " +
+				"ambiguous redirect\n",
+		}, {
+			name:   "BadFd",
+			script: "echo x >&5\n",
+			status: 1,
+			stderr: "mesh: 5: bad file descriptor\n",
+		}, {
+			name:   "ExecCommand",
+			script: "exec sh -c 'exit 3'\necho not reached\n",
+			status: 3,
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestTime(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	s := newNonInteractive(strings.NewReader("time sleep 0.1 | false\n"))
+	std := &stdio{stdin, &stdout, &stderr}
+	status, err := repl("Time", s, std, options{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, status)
+	assert.Empty(t, stdout.String())
+	assert.Regexp(t, regexp.MustCompile(
+		`^real 0m0\.[1-9]\d\ds\nuser 0m\d\.\d{3}s\nsys 0m\d\.\d{3}s\n`),
+		stderr.String())
+}
+
+func TestNegation(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name:   "True",
+			script: "! true || echo failed\n",
+			stdout: "failed\n",
+		}, {
+			name:   "Builtin",
+			script: "! test -f /nonexistent && echo ok\n",
+			stdout: "ok\n",
+		}, {
+			name:   "Pipeline",
+			script: "! echo x | grep -q y && echo ok\n",
+			stdout: "ok\n",
+			stderr: "mesh: exit status 1\n",
+		}, {
+			name:   "Status",
+			script: "! true\n",
+			status: 1,
+		}, {
+			name:   "BareBang",
+			script: "!\n",
+			status: 2,
+			stderr: "mesh: BareBang:1:2: unexpected token: " +
+				"Newline(\"\")\n!\n ^\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestSubshells(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	// pwd prints the working directory with any symbolic links resolved.
+	wd, err = filepath.EvalSymlinks(wd)
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.Remove(dir)
+	for _, test := range []integrationTest{
+		{
+			name:   "And",
+			script: "true && echo yes; test a = b && echo no\n",
+			status: 1,
+			stdout: "yes\n",
+		}, {
+			name:   "Or",
+			script: "true || echo no; test a = b || echo yes\n",
+			stdout: "yes\n",
+		}, {
+			name:   "ErrorBeforeOr",
+			script: "nonexistent-mesh-command || echo yes\n",
+			stdout: "yes\n",
+			stderr: "mesh: nonexistent-mesh-command: " +
+				"command not found\n",
+		}, {
+			name:   "CommandNotFoundInPipeline",
+			script: "nonexistent-mesh-command | cat\n",
+			status: 127,
+			stderr: "mesh: nonexistent-mesh-command: " +
+				"command not found\n",
+		}, {
+			name:   "FailureInPipeline",
+			script: "false | cat\n",
+		}, {
+			name:   "Subshell",
+			script: "(echo a; echo b) | sort -r\n",
+			stdout: "b\na\n",
+		}, {
+			name:   "CdInSubshell",
+			script: "(cd " + dir + " && pwd); pwd\n",
+			stdout: dir + "\n" + wd + "\n",
+		}, {
+			name: "AssignInSubshell",
+			script: "(MESH_S = 1; echo [$MESH_S])\n" +
+				"echo [$MESH_S]\n",
+			stdout: "[1]\n[]\n",
+		}, {
+			name:   "ExitInSubshell",
+			script: "(exit 3) || echo failed\n",
+			stdout: "failed\n",
+		}, {
+			name:   "MultiLineSubshell",
+			script: "(\necho a\n\necho b\n)\n",
+			stdout: "a\nb\n",
+		}, {
+			name:   "Group",
+			script: "{ echo a; echo b; } | sort -r\n",
+			stdout: "b\na\n",
+		}, {
+			name:   "CdInGroup",
+			script: "{ cd " + dir + "; }; pwd; cd " + wd + "\n",
+			stdout: dir + "\n",
+		}, {
+			name:   "RedirectGroup",
+			script: "{ cat; echo c; } <<EOF\na\nb\nEOF\n",
+			stdout: "a\nb\nc\n",
+		}, {
+			name:   "RedirectSubshell",
+			script: "(cat) <<EOF\na\nEOF\n",
+			stdout: "a\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestPipelineSIGPIPE(t *testing.T) {
+	for _, name := range []string{"yes", "head"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s: %v", name, err)
+		}
+	}
+	test := integrationTest{
+		name:   "YesHead",
+		script: "yes | head -n 2\n",
+		stdout: "y\ny\n",
+	}
+	done := make(chan struct{})
+	go func() {
+		test.run(t)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pipeline didn't finish")
+	}
+}
+
+func TestPipelineState(t *testing.T) {
+	defer os.Unsetenv("MESH_X")
+	defer os.Unsetenv("MESH_Y")
+	for _, test := range []integrationTest{
+		{
+			name:   "Variable",
+			script: "MESH_X = 1; echo $MESH_X | cat\n",
+			stdout: "1\n",
+		}, {
+			name:   "Array",
+			script: "a = (p q); echo $a[@] | cat | cat\n",
+			stdout: "p q\n",
+		}, {
+			name:   "Function",
+			script: "f() { echo $1; }; f a | cat\n",
+			stdout: "a\n",
+		}, {
+			name:   "Arguments",
+			script: "f() { echo $# $@ | cat; }; f a b\n",
+			stdout: "2 a b\n",
+		}, {
+			name:   "Local",
+			script: "f() { local y = l; echo $y | cat; }; f\n",
+			stdout: "l\n",
+		}, {
+			name:   "Options",
+			script: "set -o nounset; echo $MESH_UNSET | cat\n",
+			status: 1,
+			stderr: "mesh: MESH_UNSET: unbound variable\n",
+		}, {
+			// Each stage runs in a subshell, so assignments in
+			// it don't affect the shell, or race with the others.
+			name: "Assignments",
+			script: "MESH_Y = 1 | MESH_Y = 2 | echo [$MESH_Y]\n" +
+				"echo [$MESH_Y]\n",
+			stdout: "[]\n[]\n",
+		}, {
+			name:   "Arrays",
+			script: "a = (1); a[2] = 2 | a[3] = 3; echo $a[@]\n",
+			stdout: "1\n",
+		}, {
+			name: "Locals",
+			script: "f() { local y = l; y = m | y = n; echo $y; }" +
+				"\nf\n",
+			stdout: "l\n",
+		}, {
+			name:   "Cd",
+			script: "cd / | cd /tmp; echo $PWD\n",
+			stdout: os.Getenv("PWD") + "\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	for _, name := range []string{"MESH_A", "MESH_B", "MESH_é", "MESH_n"} {
+		defer os.Unsetenv(name)
+	}
+	for _, test := range []integrationTest{
+		{
+			name:   "OnlyForCommand",
+			script: "MESH_A=1 printenv MESH_A; echo x$MESH_A\n",
+			stdout: "1\nx\n",
+		}, {
+			name: "ExpandedBeforeAssigning",
+			script: "MESH_A=1; echo $MESH_A\n" +
+				"MESH_A=2 MESH_B=$MESH_A/b " +
+				"printenv MESH_A MESH_B\n",
+			stdout: "1\n2\n1/b\n",
+		}, {
+			name: "OnlyForBuiltin",
+			script: "MESH_B=2; MESH_B=3 env printenv MESH_B\n" +
+				"echo $MESH_B\n",
+			stdout: "3\n2\n",
+		}, {
+			name:   "NotAfterCommandName",
+			script: "echo MESH_A=3; echo $MESH_A\n",
+			stdout: "MESH_A=3\n1\n",
+		}, {
+			name: "UnicodeNames",
+			script: "MESH_é = 1; declare -i MESH_n = MESH_é+1\n" +
+				"echo $MESH_é ${MESH_é}x $MESH_n\n",
+			stdout: "1 1x 2\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestExport(t *testing.T) {
+	defer os.Unsetenv("MESH_X")
+	for _, test := range []struct {
+		integrationTest
+		// inherited, if set, is the value of $MESH_X in the
+		// environment which the shell starts with.
+		inherited string
+	}{
+		{
+			integrationTest: integrationTest{
+				name: "NotExported",
+				script: "MESH_X = 1\nsh -c 'echo x$MESH_X'\n" +
+					"echo $MESH_X\n",
+				stdout: "x\n1\n",
+			},
+		}, {
+			integrationTest: integrationTest{
+				name: "Declared",
+				script: "declare -x MESH_X = 1\n" +
+					"sh -c 'echo $MESH_X'\n",
+				stdout: "1\n",
+			},
+		}, {
+			integrationTest: integrationTest{
+				name: "DeclaredAfterwards",
+				script: "MESH_X = 1; declare -x MESH_X\n" +
+					"sh -c 'echo $MESH_X'\n",
+				stdout: "1\n",
+			},
+		}, {
+			integrationTest: integrationTest{
+				name:   "Inherited",
+				script: "MESH_X = 2\nsh -c 'echo $MESH_X'\n",
+				stdout: "2\n",
+			},
+			inherited: "1",
+		}, {
+			integrationTest: integrationTest{
+				name: "ForCommand",
+				script: "MESH_X = 1\nMESH_X=2 env printenv MESH_X\n" +
+					"printenv MESH_X\n",
+				stdout: "2\n",
+				status: 1,
+				stderr: "mesh: exit status 1\n",
+			},
+		},
+	} {
+		os.Unsetenv("MESH_X")
+		if test.inherited != "" {
+			os.Setenv("MESH_X", test.inherited)
+		}
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestArrays(t *testing.T) {
+	defer os.Unsetenv("x")
+	for _, test := range []integrationTest{
+		{
+			name:   "Index",
+			script: "x = (a b c)\necho $x[1] ${x[-1]} $x\n",
+			stdout: "b c a\n",
+		}, {
+			name:   "OutOfRange",
+			script: "x = (a b c)\necho x$x[3]x x${x[-4]}x\n",
+			stdout: "xx xx\n",
+		}, {
+			name: "AllElements",
+			script: "x = (a b c)\nx[4] = 'd e'\n" +
+				"printf '<%s>' ${x[@]}; echo\n",
+			stdout: "<a><b><c><d><e>\n",
+		}, {
+			name:   "ScalarBecomesArray",
+			script: "x = a\nx[1] = b\necho ${x[@]}\n",
+			stdout: "a b\n",
+		}, {
+			name:   "BadIndex",
+			script: "x = (a b c)\necho $x[a]\n",
+			status: 1,
+			stderr: "mesh: x[a]: bad array subscript\n",
+		}, {
+			name: "Mapfile",
+			script: "mapfile x <<<'a b\nc'\n" +
+				"echo $x[1]; echo $x[0]\n",
+			stdout: "c\na b\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestAssociativeArrays(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name: "Lookup",
+			script: "declare -A m\nm[host] = localhost\n" +
+				"k = host\necho $m[host] ${m[$k]} x$m[port]x\n",
+			stdout: "localhost localhost xx\n",
+		}, {
+			name: "AllValues",
+			script: "typeset -A m\nm[b] = 2\nm[a] = 1\n" +
+				"echo ${m[@]}\n",
+			stdout: "1 2\n",
+		}, {
+			name:   "CannotConvertArray",
+			script: "m = (a b)\ndeclare -A m\n",
+			status: 1,
+			stderr: "mesh: declare: m: cannot convert " +
+				"indexed to associative array\n",
+		}, {
+			name:   "InvalidOption",
+			script: "declare -Q m\n",
+			status: 2,
+			stderr: "mesh: declare: -Q: invalid option\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+	os.Unsetenv("k")
+}
+
+func TestDeclare(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name: "Integer",
+			script: "declare -i n=1+2*3\necho $n\n" +
+				"n = n*2\necho $n\n",
+			stdout: "7\n14\n",
+		}, {
+			name:   "IntegerArray",
+			script: "declare -ai a\na = (1+1 2*2)\necho ${a[@]}\n",
+			stdout: "2 4\n",
+		}, {
+			name:   "ReadOnly",
+			script: "declare -r ro=x\nro = y\necho $ro\n",
+			stdout: "x\n",
+			stderr: "mesh: ro: readonly variable\n",
+		}, {
+			name:   "ReadOnlyPrefix",
+			script: "typeset -r ro=x\nro=y printenv ro\n",
+			status: 1,
+			stderr: "mesh: ro: readonly variable\n",
+		}, {
+			name:   "ReadOnlyArray",
+			script: "declare -r ro\nro[0] = x\n",
+			status: 1,
+			stderr: "mesh: ro: readonly variable\n",
+		}, {
+			name: "List",
+			script: "declare -ri n=5\nro = (a 'b c')\n" +
+				"declare -r ro\ndeclare -r\n",
+			stdout: "declare -irx n=\"5\"\n" +
+				"declare -ar ro=([0]=\"a\" [1]=\"b c\")\n",
+		}, {
+			name:   "ListAssociative",
+			script: "declare -A m\nm[k] = '\"$x'\ndeclare -A\n",
+			stdout: "declare -A m=([k]=\"\\\"\\$x\")\n",
+		}, {
+			name:   "InvalidIdentifier",
+			script: "declare 1x=2\n",
+			status: 1,
+			stderr: "mesh: declare: 1x: not a valid identifier\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+	os.Unsetenv("n")
+	os.Unsetenv("ro")
+}
+
+func TestJSON(t *testing.T) {
+	defer os.Unsetenv("s")
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "m.json")
+	for _, test := range []integrationTest{
+		{
+			name: "Dump",
+			script: "declare -A m\nm[b] = '<\"2\">'\n" +
+				"m[a] = 1\nx = (a b)\ns = é\n" +
+				"json dump m; json dump x; json dump s\n",
+			stdout: "{\"a\":\"1\",\"b\":\"<\\\"2\\\">\"}\n" +
+				"[\"a\",\"b\"]\n\"é\"\n",
+		}, {
+			name: "ParseObject",
+			script: "json parse m <<<'{\"name\": \"mesh\", " +
+				"\"n\": 1.50, \"ok\": true, \"no\": null, " +
+				"\"x\": {\"y\": [1]}}'\n" +
+				"echo $m[name] $m[n] $m[ok] x$m[no]x $m[x]\n" +
+				"declare -A\n",
+			stdout: "mesh 1.50 true xx {\"y\":[1]}\n" +
+				"declare -A m=([n]=\"1.50\" " +
+				"[name]=\"mesh\" [no]=\"\" [ok]=\"true\" " +
+				"[x]=\"{\\\"y\\\":[1]}\")\n",
+		}, {
+			name: "ParseArrayAndScalar",
+			script: "json parse x <<<'[\"a b\", 2]'\n" +
+				"json parse s <<<'\"c\"'\n" +
+				"echo $x[0] $x[1] $s\n",
+			stdout: "a b 2 c\n",
+		}, {
+			name: "RoundTrip",
+			script: "declare -A m\nm[k] = 'a\\\\b'\n" +
+				"json dump m >" + file + "\n" +
+				"json parse n <" + file + "\necho $n[k]\n",
+			stdout: "a\\b\n",
+		}, {
+			name: "Substitution",
+			script: "json parse m <<<$(echo '{\"name\": \"mesh\"}')\n" +
+				"echo $m[name]\n",
+			stdout: "mesh\n",
+		}, {
+			name:   "Invalid",
+			script: "json parse m <<<'{'\n",
+			status: 1,
+			stderr: "mesh: json: unexpected EOF\n",
+		}, {
+			name:   "Unset",
+			script: "json dump nothing\n",
+			status: 1,
+			stderr: "mesh: json: nothing: not set\n",
+		}, {
+			name:   "Usage",
+			script: "json m\n",
+			status: 2,
+			stderr: "mesh: json: usage: json dump name | " +
+				"json parse name\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name: "Print",
+			script: "complete -W 'a b' x y\ncomplete -F f z\n" +
+				"complete\ncomplete -p z nonexistent\n",
+			status: 1,
+			stdout: "complete -W 'a b' x\ncomplete -W 'a b' y\n" +
+				"complete -F f z\ncomplete -F f z\n",
+			stderr: "mesh: complete: nonexistent: " +
+				"no completion defined\n",
+		}, {
+			name: "Replace",
+			script: "complete -W a x\ncomplete -F f x\n" +
+				"complete -p\n",
+			stdout: "complete -F f x\n",
+		}, {
+			name: "Remove",
+			script: "complete -W a x y z\ncomplete -r x y\n" +
+				"complete\ncomplete -r\ncomplete\n",
+			stdout: "complete -W a z\n",
+		}, {
+			name:   "NoNames",
+			script: "complete -W a\n",
+			status: 2,
+			stderr: "mesh: complete: no command names given\n",
+		}, {
+			name:   "MissingArgument",
+			script: "complete -F\n",
+			status: 2,
+			stderr: "mesh: complete: -F: " +
+				"option requires an argument\n",
+		}, {
+			name:   "WordsAndFunction",
+			script: "complete -W a -F f x\n",
+			status: 2,
+			stderr: "mesh: complete: -W and -F can't be used " +
+				"together\n",
+		}, {
+			name:   "InvalidOption",
+			script: "complete -x\n",
+			status: 2,
+			stderr: "mesh: complete: -x: invalid option\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestCase(t *testing.T) {
+	// The patterns overlap, so that each terminator's behaviour shows.
+	clauses := "\ta*) echo prefix %s\n" +
+		"\t*b) echo suffix %s\n" +
+		"\tab) echo exact ;;\n" +
+		"\t*) echo other\n"
+	script := func(terminator string) string {
+		return "f() {\n\tcase $1 in\n" +
+			fmt.Sprintf(clauses, terminator, terminator) +
+			"\tesac\n}\nf ab\nf a\nf zb\nf z\n"
+	}
+	for _, test := range []integrationTest{
+		{
+			name:   "Break",
+			script: script(";;"),
+			stdout: "prefix\nprefix\nsuffix\nother\n",
+		}, {
+			name:   "Fallthrough",
+			script: script(";&"),
+			stdout: "prefix\nsuffix\nexact\n" +
+				"prefix\nsuffix\nexact\n" +
+				"suffix\nexact\nother\n",
+		}, {
+			name:   "Continue",
+			script: script(";;&"),
+			stdout: "prefix\nsuffix\nexact\n" +
+				"prefix\nother\n" +
+				"suffix\nother\nother\n",
+		}, {
+			name: "Alternatives",
+			script: "f() { case $1 in a | b) echo ab;; " +
+				"c) echo c; esac; }\nf b\nf c\nf d\n",
+			stdout: "ab\nc\n",
+		}, {
+			name: "QuotedPatterns",
+			script: "case a in '*') echo star;; " +
+				"a) echo a;; esac\n" +
+				"p = '?'\ncase x in $p) echo glob;; " +
+				"?) echo char;; esac\n",
+			stdout: "a\nchar\n",
+		}, {
+			name: "Status",
+			script: "case a in a) test a = b;; esac || " +
+				"echo failed\n" +
+				"test a = b; case a in b) ;; esac && " +
+				"echo none\n",
+			stdout: "failed\nnone\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestCondExpr(t *testing.T) {
+	defer os.Unsetenv("x")
+	for _, test := range []integrationTest{
+		{
+			name: "Patterns",
+			script: "[[ abc == a* ]] && echo glob\n" +
+				"[[ abc == 'a*' ]] || echo quoted\n" +
+				"[[ abc != ?b? ]] || echo not\n",
+			stdout: "glob\nquoted\nnot\n",
+		}, {
+			name: "NoSplitting",
+			script: "x = 'a  *'\n" +
+				"[[ $x == 'a  *' && -n $x ]] && echo same\n" +
+				"[[ $x == $x ]] && echo literal\n",
+			stdout: "same\nliteral\n",
+		}, {
+			name: "Numbers",
+			script: "x = 3\n" +
+				"[[ x+1 -gt 3 && 2*5 -eq 10 ]] && " +
+				"echo arith\n" +
+				"[[ 10 -gt 9 ]] && [[ 10 < 9 ]] && " +
+				"echo strings\n" +
+				"[[ b > a && ! a > b ]] && echo order\n",
+			stdout: "arith\nstrings\norder\n",
+		}, {
+			name: "Regexp",
+			script: "[[ v1.22-rc =~ " +
+				"'^v([0-9]+)\\.([0-9]+)' ]] && " +
+				"printf '<%s>' ${BASH_REMATCH[@]}; echo\n" +
+				"[[ v1 =~ '^[0-9]' ]] || " +
+				"echo [${BASH_REMATCH[@]}]\n",
+			stdout: "<v1.22><1><22>\n[]\n",
+		}, {
+			name: "RegexpGroups",
+			script: "[[ abc =~ (b)(c) ]] && echo ${BASH_REMATCH[@]}\n" +
+				"[[ 'a b' =~ ^(x|a( b))$ && x ]] && " +
+				"echo ${BASH_REMATCH[2]}\n",
+			stdout: "bc b c\nb\n",
+		}, {
+			name: "AndOrNot",
+			script: "[[ ! a == b && ( '' || x ) ]] && echo yes\n" +
+				"[[ a || b && '' ]] && echo precedence\n" +
+				"[[ -z '' ||\n\t-z x ]] && echo lines\n",
+			stdout: "yes\nprecedence\nlines\n",
+		}, {
+			name:   "BadRegexp",
+			script: "[[ a =~ '(' ]] || echo failed\n",
+			stdout: "failed\n",
+			stderr: "mesh: error parsing regexp: " +
+				"missing closing ): `(`\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestTrap(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name:   "Exit",
+			script: "trap 'echo bye' EXIT\necho hi\n",
+			stdout: "hi\nbye\n",
+		}, {
+			name:   "ExitCalled",
+			script: "trap 'echo bye' 0\nexit 2\necho no\n",
+			status: 2,
+			stdout: "bye\n",
+		}, {
+			name:   "ExitInTrap",
+			script: "trap 'exit 3' EXIT\necho hi\n",
+			status: 3,
+			stdout: "hi\n",
+		}, {
+			name: "Function",
+			script: "f() { trap 'echo cleanup' EXIT; " +
+				"echo in f; }\nf\necho after\n",
+			stdout: "in f\ncleanup\nafter\n",
+		}, {
+			name: "Subshell",
+			script: "trap 'echo outer' EXIT\n" +
+				"(trap 'echo inner' EXIT; echo a)\necho b\n",
+			stdout: "a\ninner\nb\nouter\n",
+		}, {
+			name: "Reset",
+			script: "trap 'echo no' EXIT\ntrap - EXIT\n" +
+				"trap 'echo no' SIGUSR2\ntrap USR2\ntrap\n",
+		}, {
+			name: "Print",
+			script: "trap 'echo a b' USR1 EXIT\ntrap '' 12\n" +
+				"trap -p usr1\ntrap\n" +
+				"trap - usr1 USR2 EXIT\n",
+			stdout: "trap -- 'echo a b' USR1\n" +
+				"trap -- 'echo a b' EXIT\n" +
+				"trap -- 'echo a b' USR1\n" +
+				"trap -- '' USR2\n",
+		}, {
+			name: "Signal",
+			script: "trap 'echo got' USR1\nkill -USR1 $$\n" +
+				"sleep 0.2\necho after\ntrap - USR1\n",
+			stdout: "got\nafter\n",
+		}, {
+			name:   "List",
+			script: "trap -l | head -2\n",
+			stdout: " 1) SIGHUP\n 2) SIGINT\n",
+		}, {
+			name:   "InvalidSignal",
+			script: "trap 'echo a' EXIT NOPE\n",
+			status: 1,
+			stdout: "a\n",
+			stderr: "mesh: trap: NOPE: invalid signal " +
+				"specification\n",
+		}, {
+			name:   "SyntaxError",
+			script: "trap 'echo (' EXIT\n",
+			status: 1,
+			stderr: "mesh: trap: trap:1:7: unexpected token " +
+				"after \"(\": Newline(\"\")\n" +
+				"echo (\n      ^\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestErrTrap(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name:   "Status",
+			script: "test a = b; echo $?; echo $?\n",
+			stdout: "1\n0\n",
+		}, {
+			name: "Trap",
+			script: "trap 'echo failed $?' ERR\ntest a = b\n" +
+				"true | test 1 -eq 2\necho ok\n",
+			stdout: "failed 1\nfailed 1\nok\n",
+		}, {
+			name: "Tested",
+			script: "trap 'echo failed' ERR\n" +
+				"test a = b || echo or\n! test a = b\n" +
+				"test a = b && echo no\ntrue && test a = b\n",
+			status: 1,
+			stdout: "or\nfailed\n",
+		}, {
+			name: "Function",
+			script: "trap 'echo failed' ERR\n" +
+				"f() { test a = b; echo in f; }\n" +
+				"f\nf || true\n",
+			stdout: "failed\nin f\nin f\n",
+		}, {
+			name: "FailureInTrap",
+			script: "trap 'echo trapped; test $? = 0' ERR\n" +
+				"test a = b; echo $?\n",
+			stdout: "trapped\n1\n",
+		}, {
+			name:   "ExitInTrap",
+			script: "trap 'exit 4' ERR\ntest a = b\necho no\n",
+			status: 4,
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestErrexit(t *testing.T) {
+	defer os.Unsetenv("MESH_N")
+	for _, test := range []integrationTest{
+		{
+			name:   "Fails",
+			script: "set -o errexit\ntest a = b\necho no\n",
+			status: 1,
+		}, {
+			name: "AndOr",
+			script: "set -o errexit\ntest a = b && true\n" +
+				"test a = b || test a = b || true\necho ok\n" +
+				"true && test a = b\necho no\n",
+			status: 1,
+			stdout: "ok\n",
+		}, {
+			name: "Negated",
+			script: "set -o errexit\n! true\n! test a = a\n" +
+				"echo ok\n",
+			stdout: "ok\n",
+		}, {
+			name: "Condition",
+			script: "set -o errexit\n" +
+				"! { test a = b; echo in; } && echo no\n" +
+				"echo ok\n",
+			stdout: "in\nok\n",
+		}, {
+			name: "Group",
+			script: "set -o errexit\n{ test a = b && true; }\n" +
+				"echo ok\n{ test a = b; }\necho no\n",
+			status: 1,
+			stdout: "ok\n",
+		}, {
+			// The subshell fails as a whole, even though the
+			// statement in it which failed was tested.
+			name: "Subshell",
+			script: "set -o errexit\n(test a = b && true)\n" +
+				"echo no\n",
+			status: 1,
+		}, {
+			name: "Function",
+			script: "set -o errexit\n" +
+				"f() { test a = b && true; }\nf\necho no\n",
+			status: 1,
+		}, {
+			name: "Loop",
+			script: "set -o errexit\n" +
+				"for ((MESH_N = 0; MESH_N < 2; MESH_N++)); " +
+				"do test a = b && true; echo $MESH_N; done\n" +
+				"for ((;;)); do test a = b; done\necho no\n",
+			status: 1,
+			stdout: "0\n1\n",
+		}, {
+			name: "ErrTrap",
+			script: "set -o errexit\ntrap 'echo failed' ERR\n" +
+				"test a = b && true\n! true\necho ok\n" +
+				"test a = b\necho no\n",
+			status: 1,
+			stdout: "ok\nfailed\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name:   "Arguments",
+			script: "f() { echo $# $1 $@; }\nf a b\nf\n",
+			stdout: "2 a a b\n0\n",
+		}, {
+			name: "Local",
+			script: "x = global\nf() {\n\tlocal x = local\n" +
+				"\ty = $x\n\tg\n}\n" +
+				"g() { echo $x; }\nf\necho $x $y\n",
+			stdout: "local\nglobal local\n",
+		}, {
+			name: "DeclareIsLocal",
+			script: "f() { declare -i n=1+1; echo $n; }\n" +
+				"f\necho [$n]\n",
+			stdout: "2\n[]\n",
+		}, {
+			name: "LocalArray",
+			script: "a = (x y)\n" +
+				"f() { local -a a; a[1] = z; echo $a[@]; }\n" +
+				"f\necho $a[@]\n",
+			stdout: "z\nx y\n",
+		}, {
+			name: "Return",
+			script: "f() { return 3; echo no; }\n" +
+				"f || echo failed\n" +
+				"g() { true && return; echo no; }\ng\n",
+			stdout: "failed\n",
+		}, {
+			name:   "ReturnOutsideFunction",
+			script: "return\n",
+			status: 1,
+			stderr: "mesh: return: can only be used in a " +
+				"function\n",
+		}, {
+			name:   "LocalOutsideFunction",
+			script: "local x\n",
+			status: 1,
+			stderr: "mesh: local: can only be used in a function\n",
+		}, {
+			name:   "Redirect",
+			script: "f() { cat; }\nf <<EOF\nhere\nEOF\n",
+			stdout: "here\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+	os.Unsetenv("x")
+	os.Unsetenv("y")
+	os.Unsetenv("a")
+}
+
+func TestCommand(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	require.NoError(t, err)
+	for _, test := range []integrationTest{
+		{
+			name:   "SkipsFunction",
+			script: "echo() { printf f; }; echo; command echo b\n",
+			stdout: "fb\n",
+		}, {
+			name:   "External",
+			script: "command sh -c 'echo $0 $1' a b\n",
+			stdout: "a b\n",
+		}, {
+			name:   "Describe",
+			script: "f() { :; }\ncommand -v f cd sh\n",
+			stdout: "f\ncd\n" + sh + "\n",
+		}, {
+			name:   "NotFound",
+			script: "command -v nonexistent-mesh-command\n",
+			status: 1,
+		}, {
+			name:   "DefaultPath",
+			script: "PATH=/nonexistent command -p sh -c 'echo a'\n",
+			stdout: "a\n",
+		}, {
+			name:   "UserPath",
+			script: "PATH=/nonexistent command sh\n",
+			status: 127,
+			stderr: "mesh: sh: command not found\n",
+		}, {
+			name:   "BadOption",
+			script: "command -x\n",
+			status: 2,
+			stderr: "mesh: command: -x: invalid option\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestEnable(t *testing.T) {
+	for _, test := range []integrationTest{
+		{
+			name:   "Disable",
+			script: "enable -n shopt type; enable -n\nshopt\n",
+			status: 127,
+			stdout: "enable -n shopt\nenable -n type\n",
+			stderr: "mesh: shopt: command not found\n",
+		}, {
+			name:   "Enable",
+			script: "enable -n cd; enable cd; enable -n; cd .\n",
+		}, {
+			name: "Describe",
+			script: "enable -n shopt; type shopt\n" +
+				"command -v shopt\n",
+			status: 1,
+			stderr: "mesh: type: shopt: not found\n",
+		}, {
+			name: "Subshell",
+			script: "enable -n shopt\n" +
+				"(enable shopt; shopt -q vi)\nshopt\n",
+			status: 127,
+			stderr: "mesh: shopt: command not found\n",
+		}, {
+			name:   "All",
+			script: "enable -n cd; enable -a | head -n 3\n",
+			stdout: "enable [\nenable bg\nenable -n cd\n",
+		}, {
+			name:   "NotBuiltin",
+			script: "enable -n nonexistent-mesh-command\n",
+			status: 1,
+			stderr: "mesh: enable: nonexistent-mesh-command: " +
+				"not a shell builtin\n",
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mesh runs the mesh shell, so that it can be embedded in another
+// program as well as run by the mesh command.
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/meshshell/mesh/ast"
+	"github.com/meshshell/mesh/interpreter"
+	"github.com/meshshell/mesh/parser"
+)
+
+// Mode says where a shell reads its statements from.
+type Mode int
+
+const (
+	// Script reads statements from Config.Script until it ends.
+	Script Mode = iota
+	// Command runs the statements in Config.Command, as `mesh -c` does.
+	Command
+	// Interactive reads statements from the terminal as they're typed,
+	// with a prompt, line editing and a history.
+	Interactive
+)
+
+// Config holds what a shell run by Run reads, where it writes, and the state
+// it starts in.
+type Config struct {
+	// Stdin, Stdout and Stderr are the shell's standard input, output and
+	// error. Commands inherit them, and errors are reported to Stderr.
+	// In Interactive mode, statements are read from the terminal rather
+	// than from Stdin.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Env holds the environment variables, in the form "key=value", and
+	// Dir is the working directory. If both are unset, the shell uses
+	// the environment and working directory of the process, and changes
+	// them as it runs, as the mesh command does. Otherwise, it has its
+	// own, as described by interpreter.NewInterpreter, so that several
+	// shells can run at once in one program. Either way, variables such
+	// as $UID are set if they're missing, as described by
+	// interpreter.Interpreter.SetDefaultEnv.
+	Env []string
+	Dir string
+	// Mode says where the statements come from: Script, the default,
+	// reads them from Script, and Command takes them from Command.
+	Mode    Mode
+	Script  io.Reader
+	Command string
+	// Name names the input in error messages, e.g. the path of a script.
+	// If empty, it's "-c" in Command mode, and "(stdin)" otherwise.
+	Name string
+	// Args holds the positional parameters, e.g. $1.
+	Args []string
+	// Options holds the initial shell options.
+	Options interpreter.Options
+	// Startup lists the files to source before reading any statements,
+	// such as ~/.meshrc.
+	Startup []string
+	// NoExec parses every statement without running any, so that every
+	// parse error is reported, and Dump prints the syntax tree of every
+	// statement to Stdout, for debugging.
+	NoExec bool
+	Dump   bool
+	// Context, if set, stops the shell once it's done, killing any
+	// commands it's running.
+	Context context.Context
+}
+
+// Run runs a shell until its input ends or it exits, and returns the status
+// it exits with. Errors in the statements it runs are reported to
+// Config.Stderr, as the mesh command does, so the error it returns is only
+// for a shell which couldn't start, in which case the status is 1, or for one
+// stopped by Config.Context, in which case it's the context's error.
+func Run(cfg Config) (int, error) {
+	std := &stdio{cfg.Stdin, cfg.Stdout, cfg.Stderr}
+	opts := options{
+		noexec:  cfg.NoExec,
+		dump:    cfg.Dump,
+		shell:   cfg.Options,
+		args:    cfg.Args,
+		startup: cfg.Startup,
+		env:     cfg.Env,
+		dir:     cfg.Dir,
+		ctx:     cfg.Context,
+	}
+	name := cfg.Name
+	switch cfg.Mode {
+	case Script:
+		if name == "" {
+			name = "(stdin)"
+		}
+		return repl(name, newNonInteractive(cfg.Script), std, opts)
+	case Command:
+		if name == "" {
+			name = "-c"
+		}
+		// The command needn't end in a newline, as a script should.
+		r := strings.NewReader(cfg.Command + "\n")
+		return repl(name, newNonInteractive(r), std, opts)
+	case Interactive:
+		if name == "" {
+			name = "(stdin)"
+		}
+		s, err := newInteractive()
+		if err != nil {
+			return 1, err
+		}
+		defer s.close_()
+		defer s.closeOnSignal()()
+		opts.interactive = true
+		return repl(name, s, std, opts)
+	default:
+		return 1, fmt.Errorf("invalid mode: %d", cfg.Mode)
+	}
+}
+
+type stdio struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
+}
+
+// options holds the settings that affect how the repl behaves.
+type options struct {
+	// noexec parses every statement without executing it.
+	noexec bool
+	// dump prints the syntax tree of every statement, for debugging.
+	dump bool
+	// interactive is set when reading commands from a terminal.
+	interactive bool
+	// shell holds the initial shell options.
+	shell interpreter.Options
+	// args holds the positional parameters, e.g. $1.
+	args []string
+	// startup lists the files to source before reading any commands.
+	startup []string
+	// env and dir, if either is set, give the interpreter an environment
+	// and working directory of its own, rather than the process's.
+	env []string
+	dir string
+	// ctx, if set, stops the repl once it's done.
+	ctx context.Context
+}
+
+// newInterpreter creates the interpreter which runs the statements that the
+// repl reads.
+func newInterpreter(
+	std *stdio, opts options) (*interpreter.Interpreter, error) {
+	interp := &interpreter.Interpreter{
+		Stdin:   std.in,
+		Stdout:  std.out,
+		Stderr:  std.err,
+		Options: opts.shell,
+	}
+	if opts.env != nil || opts.dir != "" {
+		var err error
+		interp, err = interpreter.NewInterpreter(interpreter.Config{
+			Stdin:   std.in,
+			Stdout:  std.out,
+			Stderr:  std.err,
+			Options: opts.shell,
+			Dir:     opts.dir,
+			Env:     opts.env,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if err := interp.SetDefaultEnv(); err != nil {
+		return nil, err
+	}
+	interp.Interactive = opts.interactive
+	interp.Context = opts.ctx
+	interp.Args = opts.args
+	// Any $OPTIND inherited from the environment would confuse getopts,
+	// so start from the first argument, as other shells do.
+	if err := interp.SetVar("OPTIND", "1"); err != nil {
+		return nil, err
+	}
+	return interp, nil
+}
+
+func repl(filename string, s scanner, std *stdio, opts options) (int, error) {
+	status := 0
+	interp, err := newInterpreter(std, opts)
+	if err != nil {
+		return 1, err
+	}
+	if opts.interactive {
+		interp.History = &interpreter.History{
+			LookupVar: interp.LookupVar,
+		}
+		s.setCompleter(&completer{interp})
+		s.setLookupVar(interp.LookupVar)
+	}
+	for _, path := range opts.startup {
+		if opts.noexec {
+			// Nothing runs, so there's nothing for them to set up.
+			break
+		} else if status, exit := source(interp, path, std.err); exit {
+			return status, nil
+		}
+	}
+	var parse *parser.Parser
+	var next func() (ast.Stmt, error)
+	// noNewline is set if the last line of a script doesn't end in a
+	// newline.
+	noNewline := false
+	if opts.interactive {
+		parse = parser.NewParser(filename)
+		r := &lineReader{
+			s:         s,
+			parse:     parse,
+			history:   interp.History,
+			stderr:    std.err,
+			lookupVar: interp.LookupVar,
+			promptCommand: func() error {
+				return runPromptCommand(interp, std.err)
+			},
+		}
+		next = r.next
+	} else {
+		// The whole input is available up front, so there's no need
+		// to wait for each line as it's typed.
+		readLine := func() (string, error) {
+			line, err := s.readLine()
+			if err == errNoNewline {
+				// The line still counts, as in other shells.
+				noNewline = true
+				err = nil
+			}
+			return line, err
+		}
+		parse = parser.NewSyncParser(filename, readLine)
+		next = parse.Next
+	}
+	for {
+		interp.NotifyJobs()
+		// Pick up any change to the editing mode or the options
+		// which change the syntax by the last statement, e.g.
+		// `set -o emacs`.
+		s.setViMode(interp.Options.Vi)
+		parse.SetPosix(interp.Options.Posix)
+		parse.SetExtglob(interp.Options.Extglob)
+		stmt, err := next()
+		var parseErr *parser.Error
+		if err == io.EOF {
+			break
+		} else if e, ok := err.(interpreter.ExitStatus); ok {
+			// $PROMPT_COMMAND exited the shell.
+			status = int(e)
+			break
+		} else if errors.As(err, &parseErr) {
+			fmt.Fprintf(std.err, "mesh: %v\n", err)
+			if parseErr.Incomplete && noNewline {
+				fmt.Fprintf(std.err, "mesh: %s: %v, "+
+					"so it may have been cut short\n",
+					filename, errNoNewline)
+			}
+			if opts.interactive || opts.noexec {
+				// Carry on with the next statement. Under -n,
+				// nothing runs, so every error can be reported
+				// with no harm done.
+				status = 1
+				continue
+			}
+			// The rest of a script can't be trusted to do what was
+			// intended, so don't run any of it.
+			status = 2
+			break
+		} else if err != nil {
+			fmt.Fprintf(std.err, "mesh: %v\n", err)
+			continue
+		}
+		if opts.dump {
+			ast.Dump(std.out, stmt)
+		}
+		if opts.noexec {
+			// Keep parsing to the end of the input, so that every
+			// parse error is reported rather than just the first.
+			continue
+		} else if opts.ctx != nil && opts.ctx.Err() != nil {
+			return status, opts.ctx.Err()
+		}
+		status, err = stmt.Visit(interp)
+		if opts.ctx != nil && opts.ctx.Err() != nil {
+			// The caller stopped the shell, so there's nothing for
+			// it to report, and no exit trap to run.
+			return status, opts.ctx.Err()
+		}
+		if err != nil {
+			if e, ok := err.(interpreter.ExitStatus); ok {
+				status = int(e)
+				break
+			}
+			if status == 0 {
+				// An error should never count as success.
+				status = 1
+			}
+			fmt.Fprintf(std.err, "mesh: %v\n", err)
+		}
+		if interp.ExitsOnError(status) {
+			break
+		}
+	}
+	status = interp.RunExitTrap(status)
+	// Report any jobs which finished since the last prompt, since there
+	// won't be another.
+	interp.NotifyJobs()
+	return status, nil
+}
+
+// lineReader reads statements interactively, passing each line to the parser
+// as it's typed, so that the prompt can show whether the statement continues
+// onto the next line.
+type lineReader struct {
+	s       scanner
+	parse   *parser.Parser
+	history *interpreter.History
+	stderr  io.Writer
+	// lookupVar looks up the variables which hold the prompts.
+	lookupVar func(name string) (string, bool)
+	// promptCommand runs $PROMPT_COMMAND, before each statement's prompt.
+	promptCommand func() error
+	// pending holds the lines still to be parsed from a statement which
+	// was recalled from the history and then entered as a whole.
+	pending []string
+	// eof is set once the input has ended.
+	eof bool
+}
+
+// next reads lines until they make up a whole statement, which it returns. It
+// returns io.EOF once the input has ended.
+func (r *lineReader) next() (ast.Stmt, error) {
+	if r.eof {
+		return nil, io.EOF
+	}
+	if r.promptCommand != nil {
+		if err := r.promptCommand(); err != nil {
+			return nil, err
+		}
+	}
+	// The prompt is rendered afresh for each statement, so that it shows
+	// any changes made by the last one, such as to the working directory.
+	r.s.setPrompt(prompt(r.lookupVar, "PS1", defaultPS1))
+	var lines []string
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			r.eof = true
+			if err := r.parse.EOF(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		if r.history != nil && strings.TrimSpace(line) != "" {
+			expanded, changed, err := expandHistory(line, r.history)
+			if err != nil {
+				return nil, err
+			} else if changed {
+				// Show what's about to run, since it wasn't
+				// what was typed.
+				fmt.Fprintln(r.stderr, expanded)
+				line = expanded
+			}
+			r.history.Add(line)
+		}
+		lines = append(lines, line)
+		if done := r.parse.Parse(line); !done {
+			r.s.setPrompt(prompt(r.lookupVar, "PS2", defaultPS2))
+			continue
+		}
+		r.s.saveHistory(strings.Join(lines, "\n"))
+		return r.parse.Result()
+	}
+}
+
+// readLine returns the next line to parse. A statement recalled from the
+// history comes back from the scanner with all of its lines at once, so they're
+// returned one at a time, as if each had been typed.
+func (r *lineReader) readLine() (string, error) {
+	if len(r.pending) == 0 {
+		text, err := r.s.readLine()
+		if err != nil {
+			return text, err
+		}
+		r.pending = strings.Split(text, "\n")
+	}
+	line := r.pending[0]
+	r.pending = r.pending[1:]
+	return line, nil
+}
+
+// runPromptCommand runs the statements in $PROMPT_COMMAND, as an interactive
+// shell does before showing the prompt for each statement. Errors are reported
+// to stderr, so that they can't stop the shell from reading statements, and
+// only an `exit` is returned.
+func runPromptCommand(interp *interpreter.Interpreter, stderr io.Writer) error {
+	command, _ := interp.LookupVar("PROMPT_COMMAND")
+	if command == "" {
+		return nil
+	}
+	stmts, err := parser.ParseAll(
+		"PROMPT_COMMAND", strings.NewReader(command))
+	if err != nil {
+		fmt.Fprintf(stderr, "mesh: %v\n", err)
+		return nil
+	}
+	for _, stmt := range stmts {
+		_, err := stmt.Visit(interp)
+		if e, ok := err.(interpreter.ExitStatus); ok {
+			return e
+		} else if err != nil {
+			fmt.Fprintf(stderr, "mesh: %v\n", err)
+		}
+	}
+	return nil
+}
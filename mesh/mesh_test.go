@@ -0,0 +1,446 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createFile(t *testing.T, contents string) string {
+	assert.Equal(t, "\n", contents[len(contents)-1:], "no trailing newline")
+	f, err := ioutil.TempFile("", "mesh")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, os.Remove(f.Name())) })
+	defer f.Close()
+	n, err := f.WriteString(contents)
+	require.NoError(t, err)
+	require.Equal(t, len(contents), n)
+	return f.Name()
+}
+func mustOpen(t *testing.T, name string) *os.File {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	return f
+}
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, test := range []struct {
+		name   string
+		cfg    Config
+		status int
+		stdout string
+		stderr string
+	}{
+		{
+			name: "Script",
+			cfg: Config{
+				Script: strings.NewReader("echo a\n)\n"),
+				Name:   "f",
+			},
+			status: 2,
+			stdout: "a\n",
+			stderr: "mesh: f:2:1: " +
+				"unexpected token: RParen(\")\")\n)\n^\n",
+		}, {
+			name: "Command",
+			cfg: Config{
+				Mode:    Command,
+				Command: "echo $1; exit 3",
+				Args:    []string{"b"},
+			},
+			status: 3,
+			stdout: "b\n",
+		}, {
+			name: "Env",
+			cfg: Config{
+				Mode: Command,
+				Command: "echo $MESH_R; MESH_R = d; " +
+					"echo $MESH_R",
+				Env: []string{
+					"MESH_R=c", "PATH=" + os.Getenv("PATH"),
+				},
+			},
+			stdout: "c\nd\n",
+		}, {
+			name: "Dir",
+			cfg:  Config{Mode: Command, Command: "pwd", Dir: dir},
+			// The environment is a copy of the process's.
+			stdout: dir + "\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			test.cfg.Stdout = &stdout
+			test.cfg.Stderr = &stderr
+			status, err := Run(test.cfg)
+			require.NoError(t, err)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Equal(t, test.stderr, stderr.String())
+		})
+	}
+	// A shell with an environment of its own leaves the process's alone.
+	_, ok := os.LookupEnv("MESH_R")
+	assert.False(t, ok)
+
+	status, err := Run(Config{Mode: Interactive + 1})
+	assert.Equal(t, 1, status)
+	assert.EqualError(t, err, "invalid mode: 3")
+}
+
+func TestRunDefaultEnv(t *testing.T) {
+	// The shell sets the defaults in the environment of the process, so
+	// restore it afterwards.
+	for _, name := range []string{"HOSTNAME", "UID", "EUID", "PWD"} {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		require.NoError(t, os.Unsetenv(name))
+	}
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	var stdout, stderr strings.Builder
+	status, err := Run(Config{
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+		Mode:    Command,
+		Command: "echo $HOSTNAME $UID $EUID $PWD",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, fmt.Sprintf("%s %d %d %s\n",
+		hostname, os.Getuid(), os.Geteuid(), wd), stdout.String())
+	assert.Empty(t, stderr.String())
+}
+
+func TestRunContext(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		command string
+		stdout  string
+	}{
+		{"Command", "echo a; sleep 10; echo b", "a\n"},
+		{"Pipeline", "sleep 10 | cat; echo b", ""},
+		{"Subshell", "(sleep 10; echo a); echo b", ""},
+		{"Condition", "sleep 10 || echo a; echo b", ""},
+		{"Loop", "for ((;;)); do true; done; echo b", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(
+				context.Background(), 100*time.Millisecond)
+			defer cancel()
+			var stdout, stderr strings.Builder
+			start := time.Now()
+			_, err := Run(Config{
+				Stdout:  &stdout,
+				Stderr:  &stderr,
+				Mode:    Command,
+				Command: test.command,
+				Context: ctx,
+			})
+			assert.True(t, time.Since(start) < 5*time.Second)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded))
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Equal(t, "", stderr.String())
+		})
+	}
+}
+
+func TestNoNewlineAtEnd(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	std := &stdio{stdin, &stdout, &stderr}
+
+	// The last line runs even without a newline...
+	n := newNonInteractive(strings.NewReader("echo foo\necho bar"))
+	status, err := repl("script", n, std, options{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "foo\nbar\n", stdout.String())
+	assert.Empty(t, stderr.String())
+
+	// ...but if it leaves a statement unfinished, the error says that the
+	// script may have been cut short.
+	stdout.Reset()
+	n = newNonInteractive(strings.NewReader("echo foo\necho 'bar"))
+	status, err = repl("script", n, std, options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, status)
+	assert.Equal(t, "foo\n", stdout.String())
+	assert.Equal(t, "mesh: script:2:10: unexpected end of input "+
+		"while looking for matching '\necho 'bar\n         ^\n"+
+		"mesh: script: no newline at end of input, "+
+		"so it may have been cut short\n", stderr.String())
+}
+func TestParseErrorStatus(t *testing.T) {
+	script := "echo foo\n|\necho bar\n"
+	stderr := "mesh: script:2:1: unexpected token: Pipe(\"|\")\n|\n^\n"
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, errOut strings.Builder
+	std := &stdio{stdin, &stdout, &errOut}
+
+	// A script stops at the first parse error...
+	n := newNonInteractive(strings.NewReader(script))
+	status, err := repl("script", n, std, options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, status)
+	assert.Equal(t, "foo\n", stdout.String())
+	assert.Equal(t, stderr, errOut.String())
+
+	// ...but an interactive shell carries on.
+	stdout.Reset()
+	errOut.Reset()
+	n = newNonInteractive(strings.NewReader(script))
+	status, err = repl("script", n, std, options{interactive: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "foo\nbar\n", stdout.String())
+	assert.Equal(t, stderr, errOut.String())
+}
+
+type mockReader struct{}
+
+func (r *mockReader) Read(p []byte) (n int, err error) {
+	return 0, errors.New("mock error")
+}
+func TestScannerError(t *testing.T) {
+	n := newNonInteractive(&mockReader{})
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	std := &stdio{stdin, &stdout, &stderr}
+	status, err := repl(t.Name(), n, std, options{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "mesh: mock error\n", stderr.String())
+}
+func TestJobNotifications(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		script      string
+		interactive bool
+	}{
+		{"Script", "true &\nsleep 0.2\ntrue\n", false},
+		{"Interactive", "true &\nsleep 0.2\ntrue\n", true},
+		// Jobs which finish just before the shell exits are still
+		// reported.
+		{"Exit", "true &\nsleep 0.2; exit\n", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			n := newNonInteractive(strings.NewReader(test.script))
+			stdin := mustOpen(t, os.DevNull)
+			var stdout, stderr strings.Builder
+			status, err := repl(
+				t.Name(),
+				n,
+				&stdio{stdin, &stdout, &stderr},
+				options{interactive: test.interactive},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, 0, status)
+			assert.Empty(t, stdout.String())
+			if test.interactive {
+				assert.Regexp(t,
+					`^\[1\] \d+\n\[1\]\+ Done\n$`,
+					stderr.String())
+			} else {
+				assert.Empty(t, stderr.String())
+			}
+		})
+	}
+}
+func TestHistory(t *testing.T) {
+	script := "echo a\n\necho 'b\nc'\nhistory\nhistory -c\nhistory\n"
+	for _, test := range []struct {
+		name        string
+		interactive bool
+		stdout      string
+	}{
+		{"Script", false, "a\nb\nc\n"},
+		{
+			"Interactive", true,
+			"a\nb\nc\n" +
+				"    1  echo a\n" +
+				"    2  echo 'b\n" +
+				"    3  c'\n" +
+				"    4  history\n" +
+				"    6  history\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			n := newNonInteractive(strings.NewReader(script))
+			stdin := mustOpen(t, os.DevNull)
+			var stdout, stderr strings.Builder
+			status, err := repl(
+				t.Name(),
+				n,
+				&stdio{stdin, &stdout, &stderr},
+				options{interactive: test.interactive},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, 0, status)
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Empty(t, stderr.String())
+		})
+	}
+}
+func TestHistoryExpansion(t *testing.T) {
+	script := "echo a\n!!\n!x\n!e | tr a b\n"
+	n := newNonInteractive(strings.NewReader(script))
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	status, err := repl(
+		t.Name(),
+		n,
+		&stdio{stdin, &stdout, &stderr},
+		options{interactive: true},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "a\na\nb\n", stdout.String())
+	assert.Equal(t,
+		"echo a\nmesh: !x: event not found\necho a | tr a b\n",
+		stderr.String())
+}
+func TestPromptCommand(t *testing.T) {
+	defer os.Unsetenv("PROMPT_COMMAND")
+	for _, test := range []struct {
+		name    string
+		command string
+		status  int
+		stdout  string
+		stderr  string
+	}{
+		{"Unset", "", 0, "a\n\n", ""},
+		{"Echo", "echo -n '> '", 0, "> a\n> \n> ", ""},
+		{"Variable", "MESH_N = x$MESH_N", 0, "a\nxx\n", ""},
+		{
+			// Each error is reported before the prompt, and
+			// doesn't stop the rest of the command.
+			"Error", "nonexistent-mesh-command; echo -n .", 0,
+			".a\n.\n.",
+			strings.Repeat("mesh: nonexistent-mesh-command: "+
+				"command not found\n", 3),
+		},
+		{
+			"ParseError", ")", 0, "a\n\n",
+			strings.Repeat("mesh: PROMPT_COMMAND:1:1: "+
+				"unexpected token: RParen(\")\")\n)\n^\n", 3),
+		},
+		{"Exit", "exit 3", 3, "", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv("PROMPT_COMMAND", test.command)
+			defer os.Unsetenv("MESH_N")
+			n := newNonInteractive(
+				strings.NewReader("echo a\necho $MESH_N\n"))
+			stdin := mustOpen(t, os.DevNull)
+			var stdout, stderr strings.Builder
+			status, err := repl(
+				t.Name(),
+				n,
+				&stdio{stdin, &stdout, &stderr},
+				options{interactive: true},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Equal(t, test.stderr, stderr.String())
+		})
+	}
+}
+
+// historyScanner returns each of its lines in turn, which may contain
+// newlines, as a statement recalled from the history would. It records the
+// statements that the repl saves to the history.
+type historyScanner struct {
+	*noninteractive
+	lines []string
+	saved []string
+}
+
+func (s *historyScanner) readLine() (string, error) {
+	if len(s.lines) == 0 {
+		return "", io.EOF
+	}
+	line := s.lines[0]
+	s.lines = s.lines[1:]
+	return line, nil
+}
+func (s *historyScanner) saveHistory(statement string) {
+	s.saved = append(s.saved, statement)
+}
+func TestMultiLineHistory(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	// The last line stands for a statement which was recalled from the
+	// history, and then edited so that it continues onto another.
+	s := &historyScanner{lines: []string{
+		"echo 'a", "b'", "echo c", "echo 'a\nb'; echo d\necho e",
+	}}
+	status, err := repl(
+		t.Name(),
+		s,
+		&stdio{stdin, &stdout, &stderr},
+		options{interactive: true},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "a\nb\nc\na\nb\nd\ne\n", stdout.String())
+	assert.Empty(t, stderr.String())
+	assert.Equal(t, []string{
+		"echo 'a\nb'", "echo c", "echo 'a\nb'; echo d", "echo e",
+	}, s.saved)
+}
+
+// viModeScanner records the editing modes that the repl sets.
+type viModeScanner struct {
+	*noninteractive
+	modes []bool
+}
+
+func (s *viModeScanner) setViMode(vi bool) {
+	s.modes = append(s.modes, vi)
+}
+func TestSetViMode(t *testing.T) {
+	stdin := mustOpen(t, os.DevNull)
+	var stdout, stderr strings.Builder
+	s := &viModeScanner{noninteractive: newNonInteractive(
+		strings.NewReader("set -o emacs\nset -o vi\n"))}
+	var opts options
+	opts.shell.Vi = true
+	status, err := repl(t.Name(), s, &stdio{stdin, &stdout, &stderr}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Empty(t, stderr.String())
+	assert.Equal(t, []bool{true, false, true}, s.modes)
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The prompts used when $PS1 and $PS2 are unset. PS2 is shown while a
+// statement continues onto another line.
+const (
+	defaultPS1 = "] "
+	defaultPS2 = ". "
+)
+
+// tabWidth is the distance between tab stops on the terminal.
+const tabWidth = 8
+
+// Readline doesn't count the characters between these towards the width of
+// the prompt, which is what `\[` and `\]` mark in a prompt.
+const (
+	startIgnore = '\001'
+	endIgnore   = '\002'
+)
+
+// promptInfo holds what the escapes in a prompt can refer to.
+type promptInfo struct {
+	user, host string
+	// dir is the working directory, and home the user's home directory.
+	dir, home string
+	root      bool
+}
+
+// currentPromptInfo returns the details of the shell's user and environment
+// for a prompt, looking up $HOME and $PWD with lookupVar.
+func currentPromptInfo(lookupVar func(string) (string, bool)) promptInfo {
+	home, _ := lookupVar("HOME")
+	info := promptInfo{root: os.Geteuid() == 0, home: home}
+	if u, err := user.Current(); err == nil {
+		info.user = u.Username
+	}
+	info.host, _ = os.Hostname()
+	if info.dir, _ = lookupVar("PWD"); info.dir == "" {
+		info.dir, _ = os.Getwd()
+	}
+	return info
+}
+
+// prompt returns the prompt held in the variable name, such as PS1, or def if
+// it's unset, ready to display. Variables are looked up with lookupVar.
+func prompt(lookupVar func(string) (string, bool), name, def string) string {
+	ps, ok := lookupVar(name)
+	if !ok {
+		ps = def
+	}
+	p, _ := renderPrompt(ps, currentPromptInfo(lookupVar))
+	return p
+}
+
+// renderPrompt expands the escapes in a prompt: `\u` is the user name, `\h`
+// the host name up to the first `.`, and `\H` all of it, `\w` the working
+// directory and `\W` its last element, either with the home directory as `~`,
+// `\$` is `#` for root and `$` otherwise, `\n`, `\a` and `\e` are a newline,
+// bell and escape, and `\\` a backslash. Text between `\[` and `\]`, such as
+// the escape sequences which set colors, takes up no room on the terminal.
+//
+// It returns the rendered prompt along with the number of columns that its
+// last line takes up. Tabs are expanded to spaces, since readline doesn't
+// count them.
+func renderPrompt(ps string, info promptInfo) (string, int) {
+	var b strings.Builder
+	width, ignore := 0, false
+	write := func(s string) {
+		for _, r := range s {
+			switch {
+			case ignore:
+				b.WriteRune(r)
+			case r == '\t':
+				n := tabWidth - width%tabWidth
+				b.WriteString(strings.Repeat(" ", n))
+				width += n
+			case r == '\n':
+				b.WriteRune(r)
+				width = 0
+			case unicode.IsPrint(r):
+				b.WriteRune(r)
+				width++
+			default:
+				b.WriteRune(r)
+			}
+		}
+	}
+	for ps != "" {
+		if ps[0] != '\\' || len(ps) == 1 {
+			_, n := utf8.DecodeRuneInString(ps)
+			write(ps[:n])
+			ps = ps[n:]
+			continue
+		}
+		switch ps[1] {
+		case '[':
+			b.WriteRune(startIgnore)
+			ignore = true
+		case ']':
+			b.WriteRune(endIgnore)
+			ignore = false
+		case 'u':
+			write(info.user)
+		case 'h':
+			write(strings.SplitN(info.host, ".", 2)[0])
+		case 'H':
+			write(info.host)
+		case 'w':
+			write(tildeHome(info.dir, info.home))
+		case 'W':
+			if dir := tildeHome(info.dir, info.home); dir == "~" {
+				write(dir)
+			} else {
+				write(filepath.Base(info.dir))
+			}
+		case '$':
+			if info.root {
+				write("#")
+			} else {
+				write("$")
+			}
+		case 'n':
+			write("\n")
+		case 'a':
+			write("\a")
+		case 'e':
+			write("\033")
+		case '\\':
+			write(`\`)
+		default:
+			// Leave anything else as it is.
+			write(`\`)
+			ps = ps[1:]
+			continue
+		}
+		ps = ps[2:]
+	}
+	return b.String(), width
+}
+
+// tildeHome abbreviates the home directory at the start of a path to `~`.
+func tildeHome(path, home string) string {
+	if home == "" || home == "/" {
+		return path
+	} else if path == home {
+		return "~"
+	} else if strings.HasPrefix(path, home+"/") {
+		return "~" + path[len(home):]
+	}
+	return path
+}
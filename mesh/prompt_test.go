@@ -0,0 +1,70 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPrompt(t *testing.T) {
+	info := promptInfo{
+		user: "sam",
+		host: "box.example.com",
+		dir:  "/home/sam/src",
+		home: "/home/sam",
+	}
+	for _, test := range []struct {
+		name   string
+		ps     string
+		prompt string
+		width  int
+	}{
+		{"Plain", "] ", "] ", 2},
+		{"User", `\u@\h:\w\$ `, "sam@box:~/src$ ", 15},
+		{"FullHost", `\H \W`, "box.example.com src", 19},
+		{
+			"Colored",
+			`\[\e[1;32m\]\u\[\e[0m\] \$ `,
+			"\001\033[1;32m\002sam\001\033[0m\002 $ ",
+			6,
+		},
+		{"Unicode", "λ> ", "λ> ", 3},
+		{"Tab", "a\tb\t", "a       b       ", 16},
+		{
+			"TabAfterColor",
+			"\\[\\e[31m\\]ab\t",
+			"\001\033[31m\002ab      ",
+			8,
+		},
+		{"Newline", `\w\n> `, "~/src\n> ", 2},
+		{"Escapes", `\\ \a \x \`, "\\ \a \\x \\", 7},
+		{"UnclosedIgnore", `\[\e[31m> `, "\001\033[31m> ", 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			prompt, width := renderPrompt(test.ps, info)
+			assert.Equal(t, test.prompt, prompt)
+			assert.Equal(t, test.width, width)
+		})
+	}
+}
+
+func TestRenderPromptRoot(t *testing.T) {
+	info := promptInfo{dir: "/home/sam", home: "/home/sam", root: true}
+	prompt, width := renderPrompt(`\W\$ `, info)
+	assert.Equal(t, "~# ", prompt)
+	assert.Equal(t, 3, width)
+}
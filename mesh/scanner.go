@@ -0,0 +1,352 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var errIgnoreEOF = errors.New("use `exit` to leave the shell")
+
+// The terminal marks the start and end of pasted text in bracketed paste mode,
+// which is turned on and off by the first two of these escape sequences.
+const (
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+	pasteStart        = "\x1b[200~"
+	pasteEnd          = "\x1b[201~"
+)
+
+// pastedNewline stands in for each newline in pasted text, which readline
+// would otherwise take as Enter, running the text before it's all there. It
+// shows as a symbol for a newline until the line is read.
+const pastedNewline = '\u2424'
+
+// errNoNewline is returned along with the last line of a script if it doesn't
+// end in a newline, which suggests that the script may have been cut short.
+var errNoNewline = errors.New("no newline at end of input")
+
+type scanner interface {
+	// readLine returns the next line of input, without its newline, or
+	// io.EOF once there are none left. The last line is returned with
+	// errNoNewline if it wasn't terminated by a newline.
+	readLine() (string, error)
+	setIgnoreEOF(ignore bool)
+	setPrompt(prompt string)
+	setViMode(vi bool)
+	// setCompleter sets what completes words as they're typed, if they're
+	// typed at all.
+	setCompleter(c readline.AutoCompleter)
+	// setLookupVar sets how the variables which affect reading, such as
+	// $IGNOREEOF, are looked up.
+	setLookupVar(lookupVar func(name string) (string, bool))
+	// saveHistory adds a whole statement, which may span several lines, to
+	// the history of what's been typed, if it was typed at all.
+	saveHistory(statement string)
+}
+
+// lineEditor is the part of *readline.Instance which interactive uses, so that
+// it can be replaced in tests.
+type lineEditor interface {
+	Readline() (string, error)
+	SetPrompt(prompt string)
+	SetVimMode(vi bool)
+	SaveHistory(content string) error
+	Close() error
+}
+
+type interactive struct {
+	r lineEditor
+	// config is readline's configuration, which is nil in tests.
+	config *readline.Config
+	// closeOnce ensures that the terminal is only restored once, however
+	// the shell exits.
+	closeOnce sync.Once
+	closeErr  error
+	// ignoreEOF enables $IGNOREEOF, and eofs counts the EOFs in a row
+	// which have been ignored so far.
+	ignoreEOF bool
+	eofs      int
+	// lookupVar looks up $IGNOREEOF, in the environment of the process if
+	// it's unset.
+	lookupVar func(name string) (string, bool)
+	// terminal, if set, is where bracketed paste mode is turned on while
+	// each line is read, so that pasted text only runs once Enter is
+	// pressed, however many lines it has.
+	terminal io.Writer
+}
+
+func newInteractive() (*interactive, error) {
+	// Each line isn't saved to the history as it's typed, since a statement
+	// may span several lines, and it should be recalled as a whole.
+	config := &readline.Config{DisableAutoSaveHistory: true}
+	var term io.Writer
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		config.Stdin = readline.NewCancelableStdin(
+			&pasteReader{r: os.Stdin})
+		term = os.Stdout
+	}
+	r, err := readline.NewEx(config)
+	if err != nil {
+		return nil, err
+	}
+	r.SetVimMode(true)
+	return &interactive{
+		r:         r,
+		config:    r.Config,
+		ignoreEOF: true,
+		terminal:  term,
+	}, nil
+}
+
+// close_ restores the terminal to the state it was in before the shell
+// started. It may be called more than once, e.g. by a signal handler while the
+// shell is also exiting normally.
+func (i *interactive) close_() error {
+	i.closeOnce.Do(func() {
+		i.closeErr = i.r.Close()
+	})
+	return i.closeErr
+}
+
+// closeOnSignal restores the terminal and exits if the shell is hung up on or
+// terminated, since the signal would otherwise kill it with the terminal left
+// in whatever state readline had put it. It returns a function to stop
+// waiting for the signals.
+func (i *interactive) closeOnSignal() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			i.close_()
+			// As in other shells, the exit status shows which
+			// signal the shell was killed by.
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// readLine reads a line from the terminal. An EOF, i.e. Ctrl-D, ends the input
+// unless fewer than $IGNOREEOF of them have been typed in a row. Any text which
+// was pasted keeps its newlines, so the line may be several lines long.
+func (i *interactive) readLine() (string, error) {
+	if i.terminal != nil {
+		// Other programs may not expect pasted text to be marked,
+		// so bracketed paste mode is only on while the shell reads.
+		io.WriteString(i.terminal, bracketedPasteOn)
+		defer io.WriteString(i.terminal, bracketedPasteOff)
+	}
+	line, err := i.r.Readline()
+	line = strings.ReplaceAll(line, string(pastedNewline), "\n")
+	if err != io.EOF {
+		i.eofs = 0
+		return line, err
+	}
+	lookupVar := i.lookupVar
+	if lookupVar == nil {
+		lookupVar = os.LookupEnv
+	}
+	if i.ignoreEOF && i.eofs < maxIgnoredEOFs(lookupVar) {
+		i.eofs++
+		return line, errIgnoreEOF
+	}
+	return line, err
+}
+
+// maxIgnoredEOFs returns the number of EOFs in a row which an interactive shell
+// ignores before exiting, according to $IGNOREEOF. As in bash, that's none if
+// it's unset, or 10 if it's set to anything other than a number.
+func maxIgnoredEOFs(lookupVar func(name string) (string, bool)) int {
+	value, ok := lookupVar("IGNOREEOF")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 10
+	}
+	return n
+}
+
+func (i *interactive) setIgnoreEOF(ignore bool) {
+	i.ignoreEOF = ignore
+}
+
+func (i *interactive) setLookupVar(
+	lookupVar func(name string) (string, bool)) {
+	i.lookupVar = lookupVar
+}
+
+func (i *interactive) setPrompt(prompt string) {
+	i.r.SetPrompt(prompt)
+}
+
+func (i *interactive) setViMode(vi bool) {
+	i.r.SetVimMode(vi)
+}
+
+func (i *interactive) setCompleter(c readline.AutoCompleter) {
+	if i.config != nil {
+		i.config.AutoComplete = c
+	}
+}
+
+// saveHistory adds a statement to readline's history, so that it can be
+// recalled and edited as a whole, even if it spans several lines. Recalling it
+// returns all of its lines at once from readLine.
+func (i *interactive) saveHistory(statement string) {
+	if strings.TrimSpace(statement) != "" {
+		i.r.SaveHistory(statement)
+	}
+}
+
+// pasteReader reads from a terminal in bracketed paste mode, removing the
+// marks around pasted text, and replacing each newline in it with
+// pastedNewline.
+type pasteReader struct {
+	r       io.Reader
+	pasting bool
+	// held is the start of what may be a mark, which is held back until
+	// the rest of it has been read.
+	held []byte
+	out  []byte
+	err  error
+}
+
+func (p *pasteReader) Read(b []byte) (int, error) {
+	for len(p.out) == 0 && p.err == nil {
+		in := make([]byte, len(b))
+		n, err := p.r.Read(in)
+		p.filter(in[:n])
+		if err != nil {
+			p.out = append(p.out, p.held...)
+			p.held, p.err = nil, err
+		}
+	}
+	if len(p.out) == 0 {
+		return 0, p.err
+	}
+	n := copy(b, p.out)
+	p.out = p.out[n:]
+	return n, nil
+}
+
+func (p *pasteReader) filter(in []byte) {
+	in = append(p.held, in...)
+	p.held = nil
+	for len(in) > 0 {
+		switch c := in[0]; {
+		case bytes.HasPrefix(in, []byte(pasteStart)):
+			p.pasting = true
+			in = in[len(pasteStart):]
+			continue
+		case bytes.HasPrefix(in, []byte(pasteEnd)):
+			p.pasting = false
+			in = in[len(pasteEnd):]
+			continue
+		case c == '\x1b' && len(in) > 1 && len(in) < len(pasteStart) &&
+			(strings.HasPrefix(pasteStart, string(in)) ||
+				strings.HasPrefix(pasteEnd, string(in))):
+			// A lone escape isn't held back, since it's more
+			// likely to be a key pressed on its own, e.g. to leave
+			// vi's insert mode.
+			p.held = in
+			return
+		case p.pasting && (c == '\r' || c == '\n'):
+			if c == '\r' && len(in) > 1 && in[1] == '\n' {
+				in = in[1:]
+			}
+			p.out = append(p.out, string(pastedNewline)...)
+			in = in[1:]
+			continue
+		}
+		p.out = append(p.out, in[0])
+		in = in[1:]
+	}
+}
+
+// noninteractive reads a script line by line. Unlike a bufio.Scanner, which
+// gives up on lines longer than its buffer, it reads lines of any length.
+type noninteractive struct {
+	r   *bufio.Reader
+	eof bool
+}
+
+func newNonInteractive(r io.Reader) *noninteractive {
+	return &noninteractive{r: bufio.NewReader(r)}
+}
+
+func (n *noninteractive) readLine() (string, error) {
+	if n.eof {
+		return "", io.EOF
+	}
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		// Return io.EOF (or the error) on every subsequent call, and
+		// the last line now if it wasn't terminated by a newline.
+		n.eof = true
+		if err != io.EOF || line == "" {
+			return "", err
+		}
+		return line, errNoNewline
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+func (n *noninteractive) setIgnoreEOF(_ bool) {
+	// We never want to ignore EOF in non-interactive mode, otherwise we'll
+	// get stuck in an infinite loop when we hit the end of the script.
+}
+
+func (n *noninteractive) setPrompt(_ string) {
+	// Do nothing.
+}
+
+func (n *noninteractive) setViMode(_ bool) {
+	// Do nothing.
+}
+
+func (n *noninteractive) setCompleter(_ readline.AutoCompleter) {
+	// Do nothing.
+}
+
+func (n *noninteractive) setLookupVar(
+	_ func(name string) (string, bool)) {
+	// Do nothing, since nothing affects how a script is read.
+}
+
+func (n *noninteractive) saveHistory(_ string) {
+	// Do nothing.
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/interpreter"
+)
+
+func TestNonInteractive(t *testing.T) {
+	n := newNonInteractive(strings.NewReader("one\ntwo"))
+
+	// These functions are no-ops, but run them anyway so that we get points
+	// for test coverage.
+	n.setIgnoreEOF(false)
+	n.setPrompt("")
+	n.setViMode(false)
+	n.setCompleter(nil)
+	n.setLookupVar(nil)
+	n.saveHistory("")
+
+	line, err := n.readLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "one", line)
+	line, err = n.readLine()
+	assert.Equal(t, errNoNewline, err)
+	assert.Equal(t, "two", line)
+	_, err = n.readLine()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestNonInteractiveLongLine(t *testing.T) {
+	// bufio.Scanner can't read lines longer than 64KB by default.
+	long := strings.Repeat("x", 100000)
+	n := newNonInteractive(strings.NewReader(long + "\r\n" + long))
+
+	line, err := n.readLine()
+	assert.NoError(t, err)
+	assert.Equal(t, long, line)
+	line, err = n.readLine()
+	assert.Equal(t, errNoNewline, err)
+	assert.Equal(t, long, line)
+	_, err = n.readLine()
+	assert.Equal(t, io.EOF, err)
+	_, err = n.readLine()
+	assert.Equal(t, io.EOF, err)
+}
+
+// fakeEditor stands in for readline, returning each of its lines in turn. A
+// line of "^D" stands for an EOF, as does the end of the lines.
+type fakeEditor struct {
+	lines []string
+	// saved holds what's been saved to the history.
+	saved []string
+	// closed counts the calls to Close.
+	closed int
+}
+
+func (f *fakeEditor) Readline() (string, error) {
+	if len(f.lines) == 0 {
+		return "", io.EOF
+	}
+	line := f.lines[0]
+	f.lines = f.lines[1:]
+	if line == "^D" {
+		return "", io.EOF
+	}
+	return line, nil
+}
+
+func (f *fakeEditor) SetPrompt(_ string) {}
+func (f *fakeEditor) SetVimMode(_ bool)  {}
+func (f *fakeEditor) SaveHistory(content string) error {
+	f.saved = append(f.saved, content)
+	return nil
+}
+func (f *fakeEditor) Close() error {
+	f.closed++
+	return nil
+}
+
+func TestIgnoreEOF(t *testing.T) {
+	value, ok := os.LookupEnv("IGNOREEOF")
+	defer func() {
+		if ok {
+			os.Setenv("IGNOREEOF", value)
+		} else {
+			os.Unsetenv("IGNOREEOF")
+		}
+	}()
+	for _, test := range []struct {
+		name      string
+		ignoreEOF string
+		lines     []string
+		errs      []error
+	}{
+		{"Unset", "", []string{"^D"}, []error{io.EOF}},
+		{
+			"Two",
+			"2",
+			[]string{"^D", "^D", "a", "^D", "^D", "^D"},
+			[]error{
+				errIgnoreEOF, errIgnoreEOF, nil,
+				errIgnoreEOF, errIgnoreEOF, io.EOF,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.ignoreEOF == "" {
+				os.Unsetenv("IGNOREEOF")
+			} else {
+				os.Setenv("IGNOREEOF", test.ignoreEOF)
+			}
+			i := &interactive{
+				r:         &fakeEditor{lines: test.lines},
+				ignoreEOF: true,
+			}
+			for _, want := range test.errs {
+				_, err := i.readLine()
+				assert.Equal(t, want, err)
+			}
+		})
+	}
+
+	// As in bash, any other value ignores 10 EOFs.
+	os.Setenv("IGNOREEOF", "x")
+	assert.Equal(t, 10, maxIgnoredEOFs(os.LookupEnv))
+
+	// An interpreter's own variables count rather than the process's.
+	i := &interactive{
+		r:         &fakeEditor{lines: []string{"^D", "^D"}},
+		ignoreEOF: true,
+	}
+	interp, err := interpreter.NewInterpreter(interpreter.Config{
+		Env: []string{"IGNOREEOF=2"},
+	})
+	require.NoError(t, err)
+	i.setLookupVar(interp.LookupVar)
+	require.NoError(t, interp.SetVar("IGNOREEOF", "1"))
+	_, err = i.readLine()
+	assert.Equal(t, errIgnoreEOF, err)
+	_, err = i.readLine()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSetIgnoreEOF(t *testing.T) {
+	value, ok := os.LookupEnv("IGNOREEOF")
+	defer func() {
+		if ok {
+			os.Setenv("IGNOREEOF", value)
+		} else {
+			os.Unsetenv("IGNOREEOF")
+		}
+	}()
+	os.Setenv("IGNOREEOF", "1")
+	i := &interactive{r: &fakeEditor{lines: []string{"^D", "^D"}}}
+	i.setIgnoreEOF(true)
+	_, err := i.readLine()
+	assert.Equal(t, errIgnoreEOF, err)
+	i.setIgnoreEOF(false)
+	_, err = i.readLine()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestInteractiveClose(t *testing.T) {
+	editor := &fakeEditor{}
+	i := &interactive{r: editor}
+	stop := i.closeOnSignal()
+	assert.NoError(t, i.close_())
+	assert.NoError(t, i.close_())
+	stop()
+	assert.Equal(t, 1, editor.closed)
+}
+
+func TestInteractiveSaveHistory(t *testing.T) {
+	editor := &fakeEditor{}
+	i := &interactive{r: editor}
+	i.saveHistory("echo 'a\nb'")
+	i.saveHistory(" ")
+	i.saveHistory("echo c")
+	assert.Equal(t, []string{"echo 'a\nb'", "echo c"}, editor.saved)
+}
+
+func TestPasteReader(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		reads []string
+		want  string
+	}{
+		{"Typed", []string{"ab\r", "\x1b", "[A"}, "ab\r\x1b[A"},
+		{
+			"Pasted",
+			[]string{"a\x1b[200~b\r\nc\nd\x1b[201~\r"},
+			"ab␤c␤d\r",
+		},
+		{
+			"SplitMarks",
+			[]string{"\x1b[20", "0~b\r", "c\x1b[201", "~\r"},
+			"b␤c\r",
+		},
+		{"NotAMark", []string{"\x1b[2", "1~"}, "\x1b[21~"},
+		{"HeldAtEOF", []string{"a\x1b[20"}, "a\x1b[20"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var readers []io.Reader
+			for _, s := range test.reads {
+				readers = append(readers, strings.NewReader(s))
+			}
+			p := &pasteReader{r: &chunkReader{readers}}
+			got, err := ioutil.ReadAll(p)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, string(got))
+		})
+	}
+}
+
+// chunkReader returns what each of its readers has to read in a separate call
+// to Read, as a terminal might.
+type chunkReader struct {
+	readers []io.Reader
+}
+
+func (c *chunkReader) Read(b []byte) (int, error) {
+	for len(c.readers) > 0 {
+		n, err := c.readers[0].Read(b)
+		if err == io.EOF {
+			c.readers = c.readers[1:]
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, io.EOF
+}
+
+func TestInteractivePaste(t *testing.T) {
+	var term strings.Builder
+	i := &interactive{
+		r:        &fakeEditor{lines: []string{"a␤b"}},
+		terminal: &term,
+	}
+	line, err := i.readLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb", line)
+	assert.Equal(t, "\x1b[?2004h\x1b[?2004l", term.String())
+}
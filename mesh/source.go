@@ -0,0 +1,55 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/meshshell/mesh/interpreter"
+	"github.com/meshshell/mesh/parser"
+)
+
+// source runs the statements in a file, reporting any errors to stderr as the
+// repl does. A file which doesn't exist is skipped, as is the whole of one
+// which doesn't parse. It returns true, along with the status to exit with,
+// if a statement exits the shell.
+func source(
+	interp *interpreter.Interpreter, path string, stderr io.Writer,
+) (int, bool) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, false
+	} else if err != nil {
+		fmt.Fprintf(stderr, "mesh: %v\n", err)
+		return 0, false
+	}
+	defer f.Close()
+	stmts, err := parser.ParseAll(path, f)
+	if err != nil {
+		fmt.Fprintf(stderr, "mesh: %v\n", err)
+		return 0, false
+	}
+	for _, stmt := range stmts {
+		_, err := stmt.Visit(interp)
+		if e, ok := err.(interpreter.ExitStatus); ok {
+			return int(e), true
+		} else if err != nil {
+			fmt.Fprintf(stderr, "mesh: %v\n", err)
+		}
+	}
+	return 0, false
+}
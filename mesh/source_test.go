@@ -0,0 +1,72 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/meshshell/mesh/interpreter"
+)
+
+func TestSource(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		script string
+		status int
+		exit   bool
+		stdout string
+		stderr string
+	}{
+		{"Runs", "echo a\necho b\n", 0, false, "a\nb\n", ""},
+		{
+			"Errors", "cd /nonexistent\necho a\n", 0, false, "a\n",
+			"mesh: cd: chdir /nonexistent: " +
+				"no such file or directory\n",
+		},
+		{
+			"ParseError", "echo a\n)\n", 0, false, "",
+			"mesh: {file}:2:1: unexpected token: RParen(\")\")\n" +
+				")\n^\n",
+		},
+		{"Exit", "echo a\nexit 3\necho b\n", 3, true, "a\n", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := createFile(t, test.script)
+			var stdout, stderr strings.Builder
+			interp := &interpreter.Interpreter{
+				Stdout: &stdout,
+				Stderr: &stderr,
+			}
+			status, exit := source(interp, path, &stderr)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.exit, exit)
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Equal(t,
+				strings.ReplaceAll(test.stderr, "{file}", path),
+				stderr.String())
+		})
+	}
+
+	// A file which doesn't exist is skipped.
+	var stderr strings.Builder
+	status, exit := source(&interpreter.Interpreter{}, "/nonexistent",
+		&stderr)
+	assert.Equal(t, 0, status)
+	assert.False(t, exit)
+	assert.Empty(t, stderr.String())
+}
@@ -17,6 +17,7 @@ package parser
 import (
 	"fmt"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/meshshell/mesh/token"
@@ -31,85 +32,681 @@ func (l lexeme) String() string {
 	return fmt.Sprintf("%v(%q)", l.tok, l.text)
 }
 
+// position is the location of a lexeme in the input, for reporting errors.
+type position struct {
+	line int
+	col  int
+	// text is the whole line containing the lexeme, so that errors can
+	// point out where on the line the problem is.
+	text string
+}
+
+// item is a lexeme along with its position in the input.
+type item struct {
+	lexeme
+	pos position
+}
+
 type stateFn func(*lexer, string, int) stateFn
 
+// hereDoc is a here-doc whose body hasn't been lexed yet.
+type hereDoc struct {
+	delimiter string
+	// quoted is set if any part of the delimiter was quoted, in which
+	// case variables aren't expanded in the body.
+	quoted bool
+	// stripTabs is set for `<<-`, which strips leading tabs from each
+	// line of the body.
+	stripTabs bool
+}
+
 type lexer struct {
-	name    string
-	lexemes chan lexeme
-	state   stateFn
+	name string
+	// lexemes receives the lexemes of each line together, once the whole
+	// line has been lexed, so that the parser only has to wait once per
+	// line rather than once per lexeme. It's nil for a parser created by
+	// NewSyncParser, which takes the lexemes from items itself.
+	lexemes chan []item
+	// items holds the lexemes of the line being lexed.
+	items []item
+	state stateFn
+	// line is the number of the line being lexed, and text is its content.
+	line int
+	text string
+	// col is the column of the byte offset pos in the current line, so
+	// that the column of each lexeme can be counted from the one before,
+	// rather than from the start of the line.
+	pos, col int
+	// hereDocs are the here-docs started on the current line, whose
+	// bodies begin on the line after it.
+	hereDocs []hereDoc
+	// quote is the quote which opened a string continuing onto the next
+	// line, if any, so that the end of input there can be reported.
+	quote rune
+	// posix turns off the extensions to POSIX syntax which would change
+	// the meaning of a portable script, as described by SetPosix.
+	posix bool
+	// extglob keeps an extended glob, such as `@(a|b)`, in the pattern
+	// around it, as described by SetExtglob.
+	extglob bool
+	// regex is set while lexing the regular expression after `=~` in a
+	// conditional expression, in which parentheses and `|` are just
+	// text. parens counts the parentheses open in it, inside which
+	// whitespace is text too.
+	regex  bool
+	parens int
 }
 
 func newLexer(name string) *lexer {
-	return &lexer{name: name, lexemes: make(chan lexeme), state: lexStart}
+	return &lexer{name: name, lexemes: make(chan []item), state: lexStart}
 }
 
 func (l *lexer) lex(line string) {
+	l.line++
+	l.text = line
+	l.pos, l.col = 0, 1
 	l.state = l.state(l, line, 0)
+	l.flush()
+}
+
+// eof signals the end of the input, after the last line.
+func (l *lexer) eof() {
+	l.emit(token.EOF, "", len(l.text))
+	l.flush()
+}
+
+// emit adds a lexeme starting at byte offset pos in the current line to those
+// which will be sent once the line has been lexed.
+func (l *lexer) emit(tok token.Token, text string, pos int) {
+	if pos < l.pos {
+		l.pos, l.col = 0, 1
+	}
+	l.col += utf8.RuneCountInString(l.text[l.pos:pos])
+	l.pos = pos
+	l.items = append(l.items,
+		item{lexeme{tok, text}, position{l.line, l.col, l.text}})
+}
+
+// afterFor reports whether the last word lexed is `for` by itself, so that a
+// `((` after it starts an arithmetic for loop, e.g. `for ((i = 0; i < 3;
+// i++))`.
+func (l *lexer) afterFor() bool {
+	n := len(l.items)
+	if n > 0 && l.items[n-1].tok == token.Whitespace {
+		n--
+	}
+	if n == 0 || l.items[n-1].tok != token.String ||
+		l.items[n-1].text != "for" {
+		return false
+	}
+	return n == 1 || !continuesWord(l.items[n-2].tok)
+}
+
+// afterMatch reports whether the last word lexed is `=~` by itself, followed
+// by whitespace, so that the next word is a regular expression, e.g. the
+// `(b)(c)` in `[[ abc =~ (b)(c) ]]`.
+func (l *lexer) afterMatch() bool {
+	n := len(l.items)
+	if n < 2 || l.items[n-1].tok != token.Whitespace ||
+		l.items[n-2].tok != token.String || l.items[n-2].text != "=~" {
+		return false
+	}
+	return n == 2 || !continuesWord(l.items[n-3].tok)
+}
+
+// wordStart reports whether the next lexeme would start a new word, rather
+// than continuing the one before it, such as the `1` in `a$x1`.
+func (l *lexer) wordStart() bool {
+	return len(l.items) == 0 ||
+		!continuesWord(l.items[len(l.items)-1].tok)
+}
+
+// continuesWord reports whether a lexeme directly after one of the given kind
+// would be part of the same word.
+func continuesWord(tok token.Token) bool {
+	switch tok {
+	case token.Identifier, token.String, token.SubString, token.Glob,
+		token.RBrace, token.RBracket, token.Tilde:
+		return true
+	}
+	return false
+}
+
+// flush sends the lexemes of the current line, if there's a parser waiting
+// for them.
+func (l *lexer) flush() {
+	if l.lexemes != nil {
+		l.lexemes <- l.items
+		l.items = nil
+	}
+}
+
+// reset discards any partially lexed input, so that the next line is lexed
+// from the start of a new statement.
+func (l *lexer) reset() {
+	l.state = lexStart
+	l.hereDocs = nil
+	l.quote = 0
+	l.regex, l.parens = false, 0
+}
+
+// newline emits the newline at the end of a line, and returns the state for
+// the start of the next line.
+func (l *lexer) newline(text string) stateFn {
+	l.emit(token.Newline, text, len(l.text))
+	l.regex, l.parens = false, 0
+	if len(l.hereDocs) > 0 {
+		return lexHereDoc
+	}
+	return lexStart
 }
 
 const digits = "0123456789"
-const lowercase = "abcdefghijklmnopqrstuvwxyz"
-const uppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-const special = "$|;"
 const whitespace = " \t\n"
 const quotes = `'"`
 
+// operators lists the operators which are tokens of their own, wherever they
+// appear, longest first so that e.g. `&&` is lexed in preference to `&`. A
+// here-doc's `<<` isn't one of them, since its delimiter follows it.
+var operators = []struct {
+	text string
+	tok  token.Token
+}{
+	{"&>>", token.RedirectAllAppend},
+	{"<<<", token.HereString},
+	{";;&", token.CaseContinue},
+	{"&>", token.RedirectAll},
+	{"&&", token.And},
+	{"||", token.Or},
+	{"<&", token.RedirectDupIn},
+	{">>", token.RedirectAppend},
+	{">&", token.RedirectDupOut},
+	{">|", token.RedirectClobber},
+	{";;", token.CaseBreak},
+	{";&", token.CaseFallthrough},
+	{"&", token.Ampersand},
+	{"|", token.Pipe},
+	{"(", token.LParen},
+	{")", token.RParen},
+	{";", token.Semicolon},
+	{"<", token.RedirectIn},
+	{">", token.RedirectOut},
+}
+
+// special lists the characters which end an unquoted word: the first
+// character of every operator, and the `$` which starts a variable. It's
+// derived from operators so that adding one can't leave it out.
+var special = specialChars()
+
+func specialChars() string {
+	chars := "$"
+	for _, op := range operators {
+		if !strings.Contains(chars, op.text[:1]) {
+			chars += op.text[:1]
+		}
+	}
+	return chars
+}
+
+// userNameEnd lists the characters which end the user name after a `~`.
+var userNameEnd = "/:\\" + special + whitespace + quotes
+
 func lexStart(l *lexer, line string, pos int) stateFn {
 	right := strings.TrimLeft(line, whitespace)
 	left := line[0 : len(line)-len(right)]
-	if left != "" {
-		l.lexemes <- lexeme{token.Whitespace, left}
+	if left != "" && l.regex && l.parens > 0 && right != "" {
+		l.emit(token.String, left, pos)
+	} else if left != "" {
+		l.emit(token.Whitespace, left, pos)
+		l.regex, l.parens = false, 0
 	}
 	line = right
 	pos += len(left)
 
 	if line == "" {
-		l.lexemes <- lexeme{token.Newline, line}
-		return lexStart
+		return l.newline(line)
 	} else if line == "\\" {
-		l.lexemes <- lexeme{token.EscapedNewline, line}
+		l.emit(token.EscapedNewline, line, pos)
 		return lexStart
 	}
 
+	if strings.HasPrefix(line, "<<") && !strings.HasPrefix(line, "<<<") {
+		op := "<<"
+		if strings.HasPrefix(line, "<<-") {
+			op = "<<-"
+		}
+		l.emit(token.HereDoc, op, pos)
+		return lexDelimiter(l, line[len(op):], pos+len(op), op == "<<-")
+	}
+	if strings.HasPrefix(line, "((") && l.afterFor() {
+		if end := arithLength(line); end >= 0 {
+			return lexArith(l, line, pos, end)
+		}
+	}
+	if !l.regex && l.afterMatch() {
+		l.regex = true
+	}
+	if l.regex && strings.IndexByte("()|", line[0]) >= 0 {
+		switch line[0] {
+		case '(':
+			l.parens++
+		case ')':
+			if l.parens == 0 {
+				// It closes something around the expression.
+				l.regex = false
+				break
+			}
+			l.parens--
+		}
+		if l.regex {
+			l.emit(token.String, line[:1], pos)
+			return lexStart(l, line[1:], pos+1)
+		}
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(line, op.text) {
+			l.emit(op.tok, op.text, pos)
+			n := len(op.text)
+			return lexStart(l, line[n:], pos+n)
+		}
+	}
+
 	switch r, width := utf8.DecodeRuneInString(line); r {
 	case '$':
-		l.lexemes <- lexeme{token.Dollar, string(r)}
+		l.emit(token.Dollar, string(r), pos)
 		return lexIdentifier(l, line[width:], pos+width)
-	case '|':
-		l.lexemes <- lexeme{token.Pipe, string(r)}
-		return lexStart(l, line[width:], pos+width)
-	case ';':
-		l.lexemes <- lexeme{token.Semicolon, string(r)}
+	case '!':
+		// `!` is only special as a word by itself, which negates a
+		// command if it comes first.
+		if next := line[width:]; next != "" &&
+			!strings.ContainsAny(next[:1], whitespace) {
+			return lexUnquoted(l, line, pos)
+		}
+		l.emit(token.Bang, string(r), pos)
 		return lexStart(l, line[width:], pos+width)
 	case '~':
-		// TODO: extract an (optional) username, e.g. "~sam"
-		l.lexemes <- lexeme{token.Tilde, string(r)}
-		return lexStart(l, line[width:], pos+width)
+		return lexTilde(l, line, pos)
 	case '\'':
 		return lexSingleQuoted(l, line[width:], pos+width)
 	case '"':
 		return lexDoubleQuoted(l, line[width:], pos+width)
 	default:
+		// A number is the file descriptor to redirect if it's a
+		// whole word followed directly by `<` or `>`, as in `2>err`.
+		n := len(line) - len(strings.TrimLeft(line, digits))
+		if n > 0 && n < len(line) && l.wordStart() &&
+			strings.IndexByte("<>", line[n]) >= 0 {
+			l.emit(token.IONumber, line[:n], pos)
+			return lexStart(l, line[n:], pos+n)
+		}
 		return lexUnquoted(l, line, pos)
 	}
 }
 
+// lexTilde lexes a `~`, along with the name of the user whose home directory it
+// stands for, if it has one, e.g. the `~sam` in `~sam/bin`.
+func lexTilde(l *lexer, line string, pos int) stateFn {
+	n := strings.IndexAny(line[1:], userNameEnd) + 1
+	if n == 0 {
+		n = len(line)
+	}
+	l.emit(token.Tilde, line[:n], pos)
+	return lexStart(l, line[n:], pos+n)
+}
+
 func lexIdentifier(l *lexer, line string, pos int) stateFn {
-	r, size := utf8.DecodeRuneInString(line)
-	if !strings.ContainsRune(lowercase+uppercase+"_", r) {
+	if strings.HasPrefix(line, "{") {
+		return lexBraced(l, line, pos)
+	}
+	n := paramLength(line)
+	if n == 0 {
+		return lexStart(l, line, pos)
+	}
+	// If the identifier runs to the end of the line, lexStart() will emit
+	// the newline token and finish up.
+	l.emit(token.Identifier, line[:n], pos)
+	line, pos = line[n:], pos+n
+	if !l.posix {
+		line, pos = lexIndex(l, line, pos)
+	}
+	return lexStart(l, line, pos)
+}
+
+// lexBraced lexes a variable name in braces, e.g. `${x}` or `${x[1]}`. Any
+// problems, such as a missing closing brace, are left for the parser to
+// report.
+func lexBraced(l *lexer, line string, pos int) stateFn {
+	l.emit(token.LBrace, "{", pos)
+	line, pos = line[1:], pos+1
+	if n := paramLength(line); n > 0 {
+		l.emit(token.Identifier, line[:n], pos)
+		line, pos = lexIndex(l, line[n:], pos+n)
+		line, pos = lexParamOp(l, line, pos)
+	}
+	if strings.HasPrefix(line, "}") {
+		l.emit(token.RBrace, "}", pos)
+		line, pos = line[1:], pos+1
+	}
+	return lexStart(l, line, pos)
+}
+
+// lexIndex lexes the index after a variable name, e.g. the `[1]` in `$x[1]`,
+// if there is one, and returns the rest of the line. Variables in the index
+// are expanded, but it can't contain any other special characters.
+func lexIndex(l *lexer, line string, pos int) (string, int) {
+	end := strings.IndexByte(line, ']')
+	if !strings.HasPrefix(line, "[") || end == -1 {
+		return line, pos
+	}
+	l.emit(token.LBracket, "[", pos)
+	lexVarsOnly(l, line[1:end], pos+1, false)
+	l.emit(token.RBracket, "]", pos+end)
+	return line[end+1:], pos + end + 1
+}
+
+// arithLength returns the byte offset of the `))` which closes the `((` at
+// the start of line, skipping over any parentheses in between, or -1 if it
+// isn't closed on the same line.
+func arithLength(line string) int {
+	depth := 0
+	for i := 2; i < len(line); i++ {
+		switch {
+		case line[i] == '(':
+			depth++
+		case line[i] == ')' && depth > 0:
+			depth--
+		case line[i] == ')':
+			if strings.HasPrefix(line[i:], "))") {
+				return i
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// lexArith lexes the `((init; cond; update))` of an arithmetic for loop,
+// whose `))` is at byte offset end in line, and returns the state for the
+// rest of the line. As in an index, only variables are expanded in the
+// expressions, so that e.g. `<` isn't a redirection, and any whitespace
+// around them is left out.
+func lexArith(l *lexer, line string, pos, end int) stateFn {
+	l.emit(token.LArith, "((", pos)
+	start := 2
+	for i := start; i <= end; i++ {
+		if i < end && line[i] != ';' {
+			continue
+		}
+		// Whitespace around an expression isn't part of it.
+		expr := strings.TrimLeft(line[start:i], whitespace)
+		at := pos + i - len(expr)
+		lexVarsOnly(l, strings.TrimRight(expr, whitespace), at, false)
+		if i < end {
+			l.emit(token.Semicolon, ";", pos+i)
+		}
+		start = i + 1
+	}
+	l.emit(token.RArith, "))", pos+end)
+	return lexStart(l, line[end+2:], pos+end+2)
+}
+
+// paramOps are the operators which can follow the name in `${...}`, with the
+// longer of any which share a prefix first, so that e.g. `##` isn't read as
+// `#`.
+var paramOps = []string{
+	"##", "#", "%%", "%", "//", "/#", "/%", "/", ":-", ":=", ":+", ":?",
+	":", "^^", "^", ",,", ",", "-", "=", "+", "?",
+}
+
+// lexParamOp lexes the operator after the name in `${...}`, and its arguments,
+// e.g. the `%.txt` in `${x%.txt}`, if there is one, and returns the rest of
+// the line. The arguments run up to the closing brace. The first is a
+// pattern, so any backslashes are left in it to escape the special characters
+// in it, except for the operators which give a default value, such as `:-`,
+// whose argument is plain text. The `/` operators take a replacement too,
+// after another `/`, which is plain text. The `:` operator takes an offset,
+// and optionally a length after another `:`, as in `${x:1:3}`.
+func lexParamOp(l *lexer, line string, pos int) (string, int) {
+	end := unescapedIndex(line, '}')
+	if end == -1 {
+		return line, pos
+	}
+	for _, op := range paramOps {
+		if !strings.HasPrefix(line, op) {
+			continue
+		}
+		// As in other shells, a negative offset needs a space before
+		// it, so that `${x:-3}` is read as a default value instead.
+		l.emit(token.ParamOp, op, pos)
+		arg, i := line[len(op):end], pos+len(op)
+		if op == ":" {
+			if sep := strings.IndexByte(arg, ':'); sep == -1 {
+				lexVarsOnly(l, arg, i, false)
+			} else {
+				lexVarsOnly(l, arg[:sep], i, false)
+				l.emit(token.ParamOp, ":", i+sep)
+				lexVarsOnly(l, arg[sep+1:], i+sep+1, false)
+			}
+		} else if strings.ContainsAny(op, "-=+?") {
+			lexVarsOnly(l, arg, i, true)
+		} else if op[0] != '/' {
+			lexVarsOnly(l, arg, i, false)
+		} else if sep := unescapedIndex(arg, '/'); sep == -1 {
+			lexVarsOnly(l, arg, i, false)
+		} else {
+			lexVarsOnly(l, arg[:sep], i, false)
+			l.emit(token.ParamOp, "/", i+sep)
+			lexVarsOnly(l, arg[sep+1:], i+sep+1, true)
+		}
+		return line[end:], pos + end
+	}
+	return line, pos
+}
+
+// unescapedIndex returns the index of the first instance of b in s which isn't
+// escaped by a backslash, or -1 if there isn't one.
+func unescapedIndex(s string, b byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// lexVarsOnly lexes text in which variables are expanded, but no other special
+// characters are recognised, such as an index. If unescape is set, a
+// backslash escapes the character after it, including a `$`, and is removed.
+func lexVarsOnly(l *lexer, text string, pos int, unescape bool) {
+	for text != "" {
+		r, _ := utf8.DecodeRuneInString(text[1:])
+		if text[0] == '$' && isIdentifierStart(r) {
+			l.emit(token.Dollar, "$", pos)
+			n := identifierLength(text[1:])
+			l.emit(token.Identifier, text[1:1+n], pos+1)
+			text, pos = text[1+n:], pos+1+n
+			continue
+		}
+		// Any other text runs up to the next `$`, if there is one,
+		// which mustn't be escaped if unescape is set.
+		n := strings.IndexByte(text[1:], '$') + 1
+		if unescape && text[0] == '\\' {
+			n = unescapedIndex(text, '$')
+		} else if unescape {
+			n = unescapedIndex(text[1:], '$') + 1
+		}
+		if n <= 0 {
+			n = len(text)
+		}
+		s := text[:n]
+		if unescape {
+			s, _ = decodeString(s, pos, "", false)
+		}
+		l.emit(token.String, s, pos)
+		text, pos = text[n:], pos+n
+	}
+}
+
+// lexDelimiter lexes the word after a `<<` operator, which marks the end of
+// the here-doc's body. Quotes are removed from the delimiter, but as in other
+// shells, any quoting at all disables variable expansion in the body.
+func lexDelimiter(l *lexer, line string, pos int, stripTabs bool) stateFn {
+	right := strings.TrimLeft(line, whitespace)
+	if left := line[0 : len(line)-len(right)]; left != "" {
+		l.emit(token.Whitespace, left, pos)
+	}
+	pos += len(line) - len(right)
+	line = right
+	var delimiter strings.Builder
+	var quote rune
+	quoted := false
+	escaped := false
+	end := len(line)
+	for i, r := range line {
+		if escaped {
+			escaped = false
+			delimiter.WriteRune(r)
+		} else if r == '\\' && quote != '\'' {
+			escaped = true
+			quoted = true
+		} else if r == quote {
+			quote = 0
+		} else if quote != 0 {
+			delimiter.WriteRune(r)
+		} else if strings.ContainsRune(quotes, r) {
+			quote = r
+			quoted = true
+		} else if strings.ContainsRune(special+whitespace, r) {
+			end = i
+			break
+		} else {
+			delimiter.WriteRune(r)
+		}
+	}
+	if end == 0 {
+		// There's no delimiter, which the parser will report.
 		return lexStart(l, line, pos)
 	}
-	index := strings.IndexFunc(line[size:], func(r rune) bool {
-		return !strings.ContainsRune(digits+lowercase+uppercase+"_", r)
+	l.emit(token.String, delimiter.String(), pos)
+	l.hereDocs = append(l.hereDocs, hereDoc{
+		delimiter: delimiter.String(),
+		quoted:    quoted,
+		stripTabs: stripTabs,
+	})
+	return lexStart(l, line[end:], pos+end)
+}
+
+// lexHereDoc lexes a line of a here-doc's body. Each line of the body is
+// terminated by a newline, except for the line containing the delimiter.
+func lexHereDoc(l *lexer, line string, pos int) stateFn {
+	h := l.hereDocs[0]
+	if h.stripTabs {
+		trimmed := strings.TrimLeft(line, "\t")
+		pos += len(line) - len(trimmed)
+		line = trimmed
+	}
+	if line == h.delimiter {
+		l.emit(token.HereDocEnd, line, pos)
+		l.hereDocs = l.hereDocs[1:]
+		if len(l.hereDocs) > 0 {
+			return lexHereDoc
+		}
+		return lexStart
+	} else if h.quoted {
+		if line != "" {
+			l.emit(token.HereDocBody, line, pos)
+		}
+		l.emit(token.Newline, "", len(l.text))
+		return lexHereDoc
+	}
+	lexHereDocLine(l, line, pos)
+	l.emit(token.Newline, "", len(l.text))
+	return lexHereDoc
+}
+
+// lexHereDocLine lexes a line of a here-doc's body in which variables are
+// expanded, i.e., where the delimiter wasn't quoted.
+func lexHereDocLine(l *lexer, line string, pos int) {
+	var text strings.Builder
+	start := pos
+	for line != "" {
+		r, width := utf8.DecodeRuneInString(line)
+		next, nextWidth := utf8.DecodeRuneInString(line[width:])
+		if r == '\\' && (next == '$' || next == '\\') {
+			// Only a backslash before `$` or another backslash is
+			// an escape; any other backslash is literal.
+			text.WriteRune(next)
+			line = line[width+nextWidth:]
+			pos += width + nextWidth
+		} else if r == '$' && isIdentifierStart(next) {
+			if text.Len() > 0 {
+				body := text.String()
+				l.emit(token.HereDocBody, body, start)
+				text.Reset()
+			}
+			l.emit(token.Dollar, string(r), pos)
+			line = line[width:]
+			pos += width
+			n := identifierLength(line)
+			l.emit(token.Identifier, line[:n], pos)
+			line = line[n:]
+			pos += n
+			start = pos
+		} else {
+			text.WriteRune(r)
+			line = line[width:]
+			pos += width
+		}
+	}
+	if text.Len() > 0 {
+		l.emit(token.HereDocBody, text.String(), start)
+	}
+}
+
+// isIdentifierStart reports whether an identifier can start with r, i.e.
+// whether it's a letter, including any Unicode letter, or `_`.
+func isIdentifierStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// isIdentifierRune reports whether r can appear in an identifier after the
+// first rune, i.e. whether it's a letter, a digit or `_`.
+func isIdentifierRune(r rune) bool {
+	return isIdentifierStart(r) || unicode.IsDigit(r)
+}
+
+// paramLength returns the length in bytes of the name of the variable at the
+// start of s, or zero if there isn't one. Besides identifiers, the name may be
+// a single digit, for a positional parameter such as $1, or one of the
+// special parameters `#` and `@`.
+func paramLength(s string) int {
+	if s == "" {
+		return 0
+	} else if strings.IndexByte(digits+"#@$!?", s[0]) >= 0 {
+		return 1
+	} else if r, _ := utf8.DecodeRuneInString(s); isIdentifierStart(r) {
+		return identifierLength(s)
+	}
+	return 0
+}
+
+func isIdentifier(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return isIdentifierStart(r) && identifierLength(s) == len(s)
+}
+
+// identifierLength returns the length in bytes of the identifier at the start
+// of s.
+func identifierLength(s string) int {
+	index := strings.IndexFunc(s, func(r rune) bool {
+		return !isIdentifierRune(r)
 	})
 	if index == -1 {
-		// The identifier runs to the end of the line; let lexStart()
-		// emit the newline token and finish up.
-		l.lexemes <- lexeme{token.Identifier, line}
-		return lexStart(l, "", pos+len(line))
+		return len(s)
 	}
-	l.lexemes <- lexeme{token.Identifier, line[0 : size+index]}
-	return lexStart(l, line[size+index:], pos+size+index)
+	return index
 }
 
 func lexSingleQuoted(l *lexer, line string, pos int) stateFn {
@@ -121,36 +718,124 @@ func lexDoubleQuoted(l *lexer, line string, pos int) stateFn {
 }
 
 func quoted(l *lexer, line string, pos int, quote rune, next stateFn) stateFn {
-	text, size := decodeString(line, pos, string(quote))
+	start := pos
+	if start > 0 {
+		// Point at the opening quote, unless the string continues from
+		// an earlier line, in which case there isn't one.
+		start--
+	}
+	text, size := decodeString(line, pos, string(quote), false)
 	line = line[size:]
 	pos += size
 	if r, _ := utf8.DecodeRuneInString(line); r != quote {
-		l.lexemes <- lexeme{token.SubString, text}
-		l.lexemes <- lexeme{token.Newline, line}
+		l.emit(token.SubString, text, start)
+		l.emit(token.Newline, line, pos)
+		l.quote = quote
 		return next
 	}
-	l.lexemes <- lexeme{token.String, text}
+	l.quote = 0
+	l.emit(token.String, text, start)
 	return lexStart(l, line[1:], pos+1)
 }
 
 func lexUnquoted(l *lexer, line string, pos int) stateFn {
-	text, size := decodeString(line, pos, special+whitespace)
+	start := pos
+	text, size := decodeString(line, pos, special+whitespace, !l.posix)
+	for l.extglob {
+		n := extglobLength(line[:size], line[size:])
+		if n == 0 {
+			break
+		}
+		// The group is part of the pattern, so its text doesn't need
+		// decoding. Nothing in it is expanded.
+		more, m := decodeString(
+			line[size+n:], pos+size+n, special+whitespace, !l.posix)
+		text += line[size:size+n] + more
+		size += n + m
+	}
+	raw := line[:size]
 	line = line[size:]
 	pos += size
 	if line == "\\" {
-		l.lexemes <- lexeme{token.SubString, text}
-		l.lexemes <- lexeme{token.Newline, line}
+		l.emit(token.SubString, text, start)
+		l.emit(token.Newline, line, pos)
 		return lexUnquoted
 	}
-	l.lexemes <- lexeme{token.String, text}
+	if isGlob(raw) {
+		// The pattern keeps its backslashes, to escape the
+		// characters which would otherwise match other text.
+		l.emit(token.Glob, raw, start)
+	} else {
+		l.emit(token.String, text, start)
+	}
 	return lexStart(l, line, pos)
 }
 
-func decodeString(line string, pos int, delimiter string) (string, int) {
+// extglobLength returns the length of the group of an extended glob at the
+// start of after, e.g. the `(a|b)` in `@(a|b)`, if the text before it ends in
+// an unescaped operator, `@`, `*`, `+`, `?` or `!`. It's 0 if there's no such
+// group, or if it has no closing parenthesis on the same line.
+func extglobLength(before, after string) int {
+	n := len(before) - 1
+	if n < 0 || !strings.HasPrefix(after, "(") ||
+		strings.IndexByte("@*+?!", before[n]) == -1 {
+		return 0
+	}
+	escapes := len(before[:n]) - len(strings.TrimRight(before[:n], "\\"))
+	if escapes%2 == 1 {
+		return 0
+	}
+	depth := 0
+	for i := 0; i < len(after); i++ {
+		switch after[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// isGlob reports whether unquoted text is a pattern, i.e. whether it has a
+// `*` or `?`, or a `[` with a `]` after it, which isn't escaped. An unescaped
+// `(` can only be the start of an extended glob's group.
+func isGlob(text string) bool {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '*', '?', '(':
+			return true
+		case '[':
+			if unescapedIndex(text[i+1:], ']') > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeString decodes the text at the start of line up to the first of the
+// delimiters, returning it along with the number of bytes it took up. If
+// tildes is set, the text also ends before any `~` after a `:`, so that
+// `~` is expanded in values like `~/bin:~/go/bin`, as it is in other shells
+// (although they only do so in assignments).
+func decodeString(
+	line string, pos int, delimiter string, tildes bool,
+) (string, int) {
 	escaped := false
+	colon := false
 	start := 0
 	var text strings.Builder
 	for i, r := range line {
+		afterColon := colon
+		colon = false
 		if escaped {
 			escaped = false
 			start = i + utf8.RuneLen(r)
@@ -163,10 +848,12 @@ func decodeString(line string, pos int, delimiter string) (string, int) {
 			escaped = true
 			text.WriteString(line[start:i])
 			continue
-		} else if strings.ContainsRune(delimiter, r) {
+		} else if strings.ContainsRune(delimiter, r) ||
+			(tildes && afterColon && r == '~') {
 			text.WriteString(line[start:i])
 			return text.String(), i
 		}
+		colon = r == ':'
 	}
 	if escaped {
 		return text.String(), len(line) - 1
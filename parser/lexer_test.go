@@ -28,6 +28,33 @@ func TestLexemeString(t *testing.T) {
 	assert.Equal(t, `SubString("mesh")`, l.String())
 }
 
+func TestLexerPositions(t *testing.T) {
+	lex := newLexer(t.Name())
+	go func() {
+		lex.lex("é 'b")
+		lex.lex("c' | d")
+	}()
+	var items []item
+	for len(items) < 10 {
+		items = append(items, <-lex.lexemes...)
+	}
+	for n, want := range []position{
+		{1, 1, "é 'b"},
+		{1, 2, "é 'b"},
+		{1, 3, "é 'b"},
+		{1, 5, "é 'b"},
+		{2, 1, "c' | d"},
+		{2, 3, "c' | d"},
+		{2, 4, "c' | d"},
+		{2, 5, "c' | d"},
+		{2, 6, "c' | d"},
+		{2, 7, "c' | d"},
+	} {
+		got := items[n]
+		assert.Equal(t, want, got.pos, "%v", got.lexeme)
+	}
+}
+
 type lexerTest struct {
 	name    string
 	inputs  []string
@@ -47,10 +74,18 @@ func (test *lexerTest) run(t *testing.T) {
 	assertsDone := make(chan struct{})
 	go func() {
 		defer close(assertsDone)
+		var items []item
 		for _, want := range test.outputs {
-			got := <-lex.lexemes
+			for len(items) == 0 {
+				items = <-lex.lexemes
+			}
+			got := items[0].lexeme
+			items = items[1:]
 			assert.Equal(t, want, got, "want %v, got %v", want, got)
 		}
+		for _, i := range items {
+			t.Errorf("unexpected lexeme: %v", i.lexeme)
+		}
 	}()
 	timeoutDuration := 100 * time.Millisecond
 	timeout := time.After(timeoutDuration)
@@ -66,8 +101,8 @@ func (test *lexerTest) run(t *testing.T) {
 		select {
 		case <-lexerDone:
 			break
-		case l := <-lex.lexemes:
-			t.Fatalf("unexpected lexeme: %v", l)
+		case items := <-lex.lexemes:
+			t.Fatalf("unexpected lexemes: %v", items)
 		case <-timeout:
 			t.Fatal("timed out waiting for lexer")
 		}
@@ -178,6 +213,51 @@ func TestLexerMultiLineStrings(t *testing.T) {
 				{token.String, "bar"},
 				{token.Newline, ""},
 			},
+		}, {
+			"HashInQuotes",
+			[]string{"echo 'a #b", "#c' \"#d", "e#\""},
+			[]lexeme{
+				{token.String, "echo"},
+				{token.Whitespace, " "},
+				{token.SubString, "a #b\n"},
+				{token.Newline, ""},
+				{token.String, "#c"},
+				{token.Whitespace, " "},
+				{token.SubString, "#d\n"},
+				{token.Newline, ""},
+				{token.String, "e#"},
+				{token.Newline, ""},
+			},
+		}, {
+			// Unlike in a here-doc, a backslash at the end of a
+			// quoted line joins it to the next, without a newline.
+			"BackslashAtEndOfQuotedLine",
+			[]string{"echo 'a\\", "b'"},
+			[]lexeme{
+				{token.String, "echo"},
+				{token.Whitespace, " "},
+				{token.SubString, "a"},
+				{token.Newline, "\\"},
+				{token.String, "b"},
+				{token.Newline, ""},
+			},
+		}, {
+			"HereDocAfterQuoteOverTwoLines",
+			[]string{"cat <<EOF 'a", "b'", "c", "EOF"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.String, "EOF"},
+				{token.Whitespace, " "},
+				{token.SubString, "a\n"},
+				{token.Newline, ""},
+				{token.String, "b"},
+				{token.Newline, ""},
+				{token.HereDocBody, "c"},
+				{token.Newline, ""},
+				{token.HereDocEnd, "EOF"},
+			},
 		},
 	} {
 		t.Run(test.name, test.run)
@@ -238,6 +318,234 @@ func TestLexerVariables(t *testing.T) {
 				{token.String, "/X"},
 				{token.Newline, ""},
 			},
+		}, {
+			"Index",
+			[]string{"$a[1]x $b[i$j$]"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.Identifier, "a"},
+				{token.LBracket, "["},
+				{token.String, "1"},
+				{token.RBracket, "]"},
+				{token.String, "x"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "b"},
+				{token.LBracket, "["},
+				{token.String, "i"},
+				{token.Dollar, "$"},
+				{token.Identifier, "j"},
+				{token.String, "$"},
+				{token.RBracket, "]"},
+				{token.Newline, ""},
+			},
+		}, {
+			"UnclosedIndex",
+			[]string{"$a[1"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.Identifier, "a"},
+				{token.String, "[1"},
+				{token.Newline, ""},
+			},
+		}, {
+			"Braces",
+			[]string{"${a}b ${c[@]}"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "a"},
+				{token.RBrace, "}"},
+				{token.String, "b"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "c"},
+				{token.LBracket, "["},
+				{token.String, "@"},
+				{token.RBracket, "]"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
+		}, {
+			"UnicodeIdentifiers",
+			[]string{"$café/${переменная}x $_x٣ $١"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.Identifier, "café"},
+				{token.String, "/"},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "переменная"},
+				{token.RBrace, "}"},
+				{token.String, "x"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "_x٣"},
+				{token.Whitespace, " "},
+				// An identifier can't start with a digit.
+				{token.Dollar, "$"},
+				{token.String, "١"},
+				{token.Newline, ""},
+			},
+		}, {
+			"ParamOps",
+			[]string{"${a%.txt} ${b##*/$c} ${d#\\}} ${e%%} ${f%g"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "a"},
+				{token.ParamOp, "%"},
+				{token.String, ".txt"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "b"},
+				{token.ParamOp, "##"},
+				{token.String, "*/"},
+				{token.Dollar, "$"},
+				{token.Identifier, "c"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "d"},
+				{token.ParamOp, "#"},
+				// The backslash is kept, to escape the `}`
+				// in the pattern.
+				{token.String, "\\}"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "e"},
+				{token.ParamOp, "%%"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				// Without a closing brace, the operator is
+				// left for the parser to complain about.
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "f"},
+				{token.String, "%g"},
+				{token.Newline, ""},
+			},
+		}, {
+			"Substitution",
+			[]string{"${a/\\/$b/\\/$c\\$d} ${e/#f}"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "a"},
+				{token.ParamOp, "/"},
+				{token.String, "\\/"},
+				{token.Dollar, "$"},
+				{token.Identifier, "b"},
+				{token.ParamOp, "/"},
+				// Unlike the pattern, the replacement is
+				// unescaped.
+				{token.String, "/"},
+				{token.Dollar, "$"},
+				{token.Identifier, "c"},
+				{token.String, "$d"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "e"},
+				{token.ParamOp, "/#"},
+				{token.String, "f"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
+		}, {
+			"Substring",
+			[]string{"${a:1:$b} ${c: -2} ${d:-e}"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "a"},
+				{token.ParamOp, ":"},
+				{token.String, "1"},
+				{token.ParamOp, ":"},
+				{token.Dollar, "$"},
+				{token.Identifier, "b"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "c"},
+				{token.ParamOp, ":"},
+				{token.String, " -2"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				// Without a space, `:-` gives a default value
+				// rather than an offset.
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "d"},
+				{token.ParamOp, ":-"},
+				{token.String, "e"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
+		}, {
+			"Defaults",
+			[]string{"${a-$b\\}} ${c:?d e}"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "a"},
+				{token.ParamOp, "-"},
+				{token.Dollar, "$"},
+				{token.Identifier, "b"},
+				{token.String, "}"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "c"},
+				{token.ParamOp, ":?"},
+				{token.String, "d e"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
+		}, {
+			"CaseConversion",
+			[]string{"${a^^} ${b,[ab]}"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "a"},
+				{token.ParamOp, "^^"},
+				{token.RBrace, "}"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "b"},
+				{token.ParamOp, ","},
+				{token.String, "[ab]"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
+		}, {
+			"SpecialParameters",
+			[]string{"$12 $# ${@}"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.Identifier, "1"},
+				{token.String, "2"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "#"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "@"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
 		},
 	} {
 		t.Run(test.name, test.run)
@@ -272,7 +580,8 @@ func TestLexerTildes(t *testing.T) {
 			// a traditional suffix for backup files (e.g., see
 			// https://unix.stackexchange.com/q/76189). So, Mesh
 			// follows Unix tradition here and only treats `~` as a
-			// special character if it is at the start of a word.
+			// special character if it is at the start of a word, or
+			// after a `:`, as in `PATH` (see below).
 			"TildeAtMiddleAndEndOfWordIsNotSpecial",
 			[]string{"cd /~/~"},
 			[]lexeme{
@@ -281,12 +590,111 @@ func TestLexerTildes(t *testing.T) {
 				{token.String, "/~/~"},
 				{token.Newline, ""},
 			},
+		}, {
+			"TildeWithUserName",
+			[]string{"cd ~sam/bin ~sam"},
+			[]lexeme{
+				{token.String, "cd"},
+				{token.Whitespace, " "},
+				{token.Tilde, "~sam"},
+				{token.String, "/bin"},
+				{token.Whitespace, " "},
+				{token.Tilde, "~sam"},
+				{token.Newline, ""},
+			},
+		}, {
+			"TildeAfterColon",
+			[]string{"PATH=a:~/x:~user/y:\\:~:b~"},
+			[]lexeme{
+				{token.String, "PATH=a:"},
+				{token.Tilde, "~"},
+				{token.String, "/x:"},
+				{token.Tilde, "~user"},
+				{token.String, "/y::~:b~"},
+				{token.Newline, ""},
+			},
 		},
 	} {
 		t.Run(test.name, test.run)
 	}
 }
 
+func TestLexerGlobs(t *testing.T) {
+	for _, test := range []lexerTest{
+		{
+			"Globs",
+			[]string{"ls *.go a?c [ab] x\\*?"},
+			[]lexeme{
+				{token.String, "ls"},
+				{token.Whitespace, " "},
+				{token.Glob, "*.go"},
+				{token.Whitespace, " "},
+				{token.Glob, "a?c"},
+				{token.Whitespace, " "},
+				{token.Glob, "[ab]"},
+				{token.Whitespace, " "},
+				// The backslash is kept, to escape the `*`.
+				{token.Glob, "x\\*?"},
+				{token.Newline, ""},
+			},
+		}, {
+			"NotGlobs",
+			[]string{"[ a ] \\* '*' $x[1] [] a]["},
+			[]lexeme{
+				{token.String, "["},
+				{token.Whitespace, " "},
+				{token.String, "a"},
+				{token.Whitespace, " "},
+				{token.String, "]"},
+				{token.Whitespace, " "},
+				{token.String, "*"},
+				{token.Whitespace, " "},
+				{token.String, "*"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "x"},
+				{token.LBracket, "["},
+				{token.String, "1"},
+				{token.RBracket, "]"},
+				{token.Whitespace, " "},
+				{token.String, "[]"},
+				{token.Whitespace, " "},
+				{token.String, "a]["},
+				{token.Newline, ""},
+			},
+		}, {
+			"GlobAfterVariable",
+			[]string{"$x*"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.Identifier, "x"},
+				{token.Glob, "*"},
+				{token.Newline, ""},
+			},
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+// TestLexerOperatorsEndWords checks that every operator ends an unquoted word
+// directly before it, rather than being lexed as part of it.
+func TestLexerOperatorsEndWords(t *testing.T) {
+	for _, op := range operators {
+		test := lexerTest{
+			op.text,
+			[]string{"a" + op.text + "b"},
+			[]lexeme{
+				{token.String, "a"},
+				{op.tok, op.text},
+				{token.String, "b"},
+				{token.Newline, ""},
+			},
+		}
+		t.Run(test.name, test.run)
+	}
+}
+
 func TestLexerMultipleCommands(t *testing.T) {
 	for _, test := range []lexerTest{
 		{
@@ -298,6 +706,70 @@ func TestLexerMultipleCommands(t *testing.T) {
 				{token.String, "ls"},
 				{token.Newline, ""},
 			},
+		}, {
+			"Bang",
+			[]string{"! a !b c! !"},
+			[]lexeme{
+				{token.Bang, "!"},
+				{token.Whitespace, " "},
+				{token.String, "a"},
+				{token.Whitespace, " "},
+				{token.String, "!b"},
+				{token.Whitespace, " "},
+				{token.String, "c!"},
+				{token.Whitespace, " "},
+				{token.Bang, "!"},
+				{token.Newline, ""},
+			},
+		}, {
+			"Background",
+			[]string{"sleep 1&ls"},
+			[]lexeme{
+				{token.String, "sleep"},
+				{token.Whitespace, " "},
+				{token.String, "1"},
+				{token.Ampersand, "&"},
+				{token.String, "ls"},
+				{token.Newline, ""},
+			},
+		}, {
+			"AndOr",
+			[]string{"a&&b||c"},
+			[]lexeme{
+				{token.String, "a"},
+				{token.And, "&&"},
+				{token.String, "b"},
+				{token.Or, "||"},
+				{token.String, "c"},
+				{token.Newline, ""},
+			},
+		}, {
+			"Subshell",
+			[]string{"(cd x)"},
+			[]lexeme{
+				{token.LParen, "("},
+				{token.String, "cd"},
+				{token.Whitespace, " "},
+				{token.String, "x"},
+				{token.RParen, ")"},
+				{token.Newline, ""},
+			},
+		}, {
+			"CaseTerminators",
+			[]string{"a;;b;&c;;&d; ;"},
+			[]lexeme{
+				{token.String, "a"},
+				{token.CaseBreak, ";;"},
+				{token.String, "b"},
+				{token.CaseFallthrough, ";&"},
+				{token.String, "c"},
+				{token.CaseContinue, ";;&"},
+				{token.String, "d"},
+				{token.Semicolon, ";"},
+				{token.Whitespace, " "},
+				{token.Semicolon, ";"},
+				{token.Newline, ""},
+			},
 		}, {
 			"Pipeline",
 			[]string{"sort|uniq"},
@@ -312,3 +784,348 @@ func TestLexerMultipleCommands(t *testing.T) {
 		t.Run(test.name, test.run)
 	}
 }
+
+func TestLexerHereDocs(t *testing.T) {
+	for _, test := range []lexerTest{
+		{
+			"HashInBody",
+			[]string{"cat <<EOF", "# $x #", "EOF"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.String, "EOF"},
+				{token.Newline, ""},
+				{token.HereDocBody, "# "},
+				{token.Dollar, "$"},
+				{token.Identifier, "x"},
+				{token.HereDocBody, " #"},
+				{token.Newline, ""},
+				{token.HereDocEnd, "EOF"},
+			},
+		}, {
+			"BackslashAtEndOfBodyLine",
+			[]string{"cat <<EOF", "a \\", "EOF"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.String, "EOF"},
+				{token.Newline, ""},
+				{token.HereDocBody, "a \\"},
+				{token.Newline, ""},
+				{token.HereDocEnd, "EOF"},
+			},
+		}, {
+			"BackslashAndHashInQuotedHereDoc",
+			[]string{"cat <<'EOF'", "a \\", "#b", "EOF"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.String, "EOF"},
+				{token.Newline, ""},
+				{token.HereDocBody, "a \\"},
+				{token.Newline, ""},
+				{token.HereDocBody, "#b"},
+				{token.Newline, ""},
+				{token.HereDocEnd, "EOF"},
+			},
+		}, {
+			"HereDoc",
+			[]string{"cat <<EOF", "x $Y", "EOF"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.String, "EOF"},
+				{token.Newline, ""},
+				{token.HereDocBody, "x "},
+				{token.Dollar, "$"},
+				{token.Identifier, "Y"},
+				{token.Newline, ""},
+				{token.HereDocEnd, "EOF"},
+			},
+		}, {
+			"QuotedDelimiter",
+			[]string{`cat << "E"O'F'`, "x $Y", "EOF"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.Whitespace, " "},
+				{token.String, "EOF"},
+				{token.Newline, ""},
+				{token.HereDocBody, "x $Y"},
+				{token.Newline, ""},
+				{token.HereDocEnd, "EOF"},
+			},
+		}, {
+			"StripTabs",
+			[]string{"cat <<-EOF;ls", "\t\tx", "", "\tEOF", "ls"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<-"},
+				{token.String, "EOF"},
+				{token.Semicolon, ";"},
+				{token.String, "ls"},
+				{token.Newline, ""},
+				{token.HereDocBody, "x"},
+				{token.Newline, ""},
+				{token.Newline, ""},
+				{token.HereDocEnd, "EOF"},
+				{token.String, "ls"},
+				{token.Newline, ""},
+			},
+		}, {
+			"TwoHereDocs",
+			[]string{"cat <<A <<B", "A", "B"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.String, "A"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.String, "B"},
+				{token.Newline, ""},
+				{token.HereDocEnd, "A"},
+				{token.HereDocEnd, "B"},
+			},
+		}, {
+			"NoDelimiter",
+			[]string{"cat <<"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereDoc, "<<"},
+				{token.Newline, ""},
+			},
+		}, {
+			"RedirectIn",
+			[]string{"cat<file"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.RedirectIn, "<"},
+				{token.String, "file"},
+				{token.Newline, ""},
+			},
+		}, {
+			"RedirectOut",
+			[]string{"echo>a >| b"},
+			[]lexeme{
+				{token.String, "echo"},
+				{token.RedirectOut, ">"},
+				{token.String, "a"},
+				{token.Whitespace, " "},
+				{token.RedirectClobber, ">|"},
+				{token.Whitespace, " "},
+				{token.String, "b"},
+				{token.Newline, ""},
+			},
+		}, {
+			"ProcessIDs",
+			[]string{"echo $$ $! ${!}"},
+			[]lexeme{
+				{token.String, "echo"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "$"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "!"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "!"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
+		}, {
+			"Status",
+			[]string{"echo $?? ${?}"},
+			[]lexeme{
+				{token.String, "echo"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "?"},
+				{token.Glob, "?"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "?"},
+				{token.RBrace, "}"},
+				{token.Newline, ""},
+			},
+		}, {
+			"HereString",
+			[]string{"cat <<<$x"},
+			[]lexeme{
+				{token.String, "cat"},
+				{token.Whitespace, " "},
+				{token.HereString, "<<<"},
+				{token.Dollar, "$"},
+				{token.Identifier, "x"},
+				{token.Newline, ""},
+			},
+		}, {
+			"RedirectAll",
+			[]string{"a&>b &>>c>>d"},
+			[]lexeme{
+				{token.String, "a"},
+				{token.RedirectAll, "&>"},
+				{token.String, "b"},
+				{token.Whitespace, " "},
+				{token.RedirectAllAppend, "&>>"},
+				{token.String, "c"},
+				{token.RedirectAppend, ">>"},
+				{token.String, "d"},
+				{token.Newline, ""},
+			},
+		}, {
+			"FileDescriptors",
+			[]string{"a 2>&1 10<b x1>c ${y}2<d"},
+			[]lexeme{
+				{token.String, "a"},
+				{token.Whitespace, " "},
+				{token.IONumber, "2"},
+				{token.RedirectDupOut, ">&"},
+				{token.String, "1"},
+				{token.Whitespace, " "},
+				{token.IONumber, "10"},
+				{token.RedirectIn, "<"},
+				{token.String, "b"},
+				{token.Whitespace, " "},
+				{token.String, "x1"},
+				{token.RedirectOut, ">"},
+				{token.String, "c"},
+				{token.Whitespace, " "},
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "y"},
+				{token.RBrace, "}"},
+				{token.String, "2"},
+				{token.RedirectIn, "<"},
+				{token.String, "d"},
+				{token.Newline, ""},
+			},
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestLexerArithFor(t *testing.T) {
+	for _, test := range []lexerTest{
+		{
+			"ArithFor",
+			[]string{"for ((i=(1); i<$n;)) >f"},
+			[]lexeme{
+				{token.String, "for"},
+				{token.Whitespace, " "},
+				{token.LArith, "(("},
+				{token.String, "i=(1)"},
+				{token.Semicolon, ";"},
+				{token.String, "i<"},
+				{token.Dollar, "$"},
+				{token.Identifier, "n"},
+				{token.Semicolon, ";"},
+				{token.RArith, "))"},
+				{token.Whitespace, " "},
+				{token.RedirectOut, ">"},
+				{token.String, "f"},
+				{token.Newline, ""},
+			},
+		}, {
+			// `((` is only special after `for` by itself.
+			"NotArithFor",
+			[]string{"${x}for ((b))"},
+			[]lexeme{
+				{token.Dollar, "$"},
+				{token.LBrace, "{"},
+				{token.Identifier, "x"},
+				{token.RBrace, "}"},
+				{token.String, "for"},
+				{token.Whitespace, " "},
+				{token.LParen, "("},
+				{token.LParen, "("},
+				{token.String, "b"},
+				{token.RParen, ")"},
+				{token.RParen, ")"},
+				{token.Newline, ""},
+			},
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func TestLexerRegexp(t *testing.T) {
+	for _, test := range []lexerTest{
+		{
+			"Groups",
+			[]string{"[[ a =~ (b|c)( $d) ]]"},
+			[]lexeme{
+				{token.String, "[["},
+				{token.Whitespace, " "},
+				{token.String, "a"},
+				{token.Whitespace, " "},
+				{token.String, "=~"},
+				{token.Whitespace, " "},
+				{token.String, "("},
+				{token.String, "b"},
+				{token.String, "|"},
+				{token.String, "c"},
+				{token.String, ")"},
+				{token.String, "("},
+				{token.String, " "},
+				{token.Dollar, "$"},
+				{token.Identifier, "d"},
+				{token.String, ")"},
+				{token.Whitespace, " "},
+				{token.String, "]]"},
+				{token.Newline, ""},
+			},
+		}, {
+			// A closing parenthesis which wasn't opened in the
+			// expression ends it.
+			"Parenthesised",
+			[]string{"[[ (a =~ b) ]]"},
+			[]lexeme{
+				{token.String, "[["},
+				{token.Whitespace, " "},
+				{token.LParen, "("},
+				{token.String, "a"},
+				{token.Whitespace, " "},
+				{token.String, "=~"},
+				{token.Whitespace, " "},
+				{token.String, "b"},
+				{token.RParen, ")"},
+				{token.Whitespace, " "},
+				{token.String, "]]"},
+				{token.Newline, ""},
+			},
+		}, {
+			// Only the word after `=~` is a regular expression.
+			"NextWord",
+			[]string{"a=~ (b) =~c (d)"},
+			[]lexeme{
+				{token.String, "a=~"},
+				{token.Whitespace, " "},
+				{token.LParen, "("},
+				{token.String, "b"},
+				{token.RParen, ")"},
+				{token.Whitespace, " "},
+				{token.String, "=~c"},
+				{token.Whitespace, " "},
+				{token.LParen, "("},
+				{token.String, "d"},
+				{token.RParen, ")"},
+				{token.Newline, ""},
+			},
+		},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
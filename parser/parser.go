@@ -15,34 +15,99 @@
 package parser
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/meshshell/mesh/ast"
 	"github.com/meshshell/mesh/token"
 )
 
-type parserError struct {
-	msg string
+// Error is a syntax error found while parsing.
+type Error struct {
+	Filename string
+	// Line and Col are where the error was found, counting from one. Col
+	// counts runes, not bytes.
+	Line int
+	Col  int
+	// Source is the whole line on which the error was found.
+	Source string
+	// Token is the token at which the error was found.
+	Token token.Token
+	Msg   string
+	// Incomplete is set if the input ended part way through a statement,
+	// i.e. the error might go away given more input.
+	Incomplete bool
 }
 
-func newParserError(format string, a ...interface{}) parserError {
-	return parserError{fmt.Sprintf(format, a...)}
+// newParserError returns an error for a problem at the given lexeme.
+// pos returns the position of a lexeme, for the node which starts with it.
+func (p *Parser) pos(l *item) ast.Pos {
+	return ast.Pos{File: p.lex.name, Line: l.pos.line, Col: l.pos.col}
 }
 
-func (pe parserError) Error() string {
-	return "parser: " + pe.msg
+func (p *Parser) newParserError(
+	l *item, format string, a ...interface{},
+) *Error {
+	return &Error{
+		Filename: p.lex.name,
+		Line:     l.pos.line,
+		Col:      l.pos.col,
+		Source:   l.pos.text,
+		Token:    l.tok,
+		Msg:      fmt.Sprintf(format, a...),
+	}
+}
+
+// Error formats the error as "file:line:col: msg", followed by the offending
+// line and a caret pointing at the column.
+func (e *Error) Error() string {
+	var caret strings.Builder
+	for i, r := range []rune(e.Source) {
+		if i >= e.Col-1 {
+			break
+		} else if r == '\t' {
+			// Keep tabs, so that the caret lines up with the text.
+			caret.WriteRune(r)
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return fmt.Sprintf("%s:%d:%d: %s\n%s\n%s",
+		e.Filename, e.Line, e.Col, e.Msg, e.Source, caret.String())
 }
 
+// Parser parses statements. A parser created by NewParser is given its input
+// a line at a time by calling Parse, and runs in a goroutine of its own
+// between calls, so that it can stop part way through a statement to wait for
+// the next line. One created by NewSyncParser reads the input itself instead,
+// without any goroutines, for when the whole input is available up front.
 type Parser struct {
-	lex    *lexer
-	done   chan bool
-	lock   sync.Mutex
-	locked bool
-	stmt   ast.Stmt
-	err    error
-	curr   *lexeme
+	lex *lexer
+	// readLine reads the next line of input, for a parser created by
+	// NewSyncParser, and is nil otherwise.
+	readLine func() (string, error)
+	// eof is set once readLine has reached the end of the input, and
+	// readErr if that was because it failed.
+	eof     bool
+	readErr error
+	done    chan bool
+	lock    sync.Mutex
+	locked  bool
+	stmt    ast.Stmt
+	err     error
+	curr    *item
+	// pending holds the lexemes received from the lexer which haven't
+	// been looked at yet.
+	pending []item
+	// hereDocs are the here-doc redirections on the current line, whose
+	// bodies are parsed once the line has ended.
+	hereDocs []*ast.Redirect
 }
 
 func NewParser(filename string) *Parser {
@@ -52,12 +117,58 @@ func NewParser(filename string) *Parser {
 	}
 }
 
+// SetPosix turns off mesh's extensions to POSIX syntax which would change the
+// meaning of a portable script, from the next line on: a variable can't be
+// indexed without braces, so `$x[1]` is `${x}[1]`, and a `~` after a `:` is
+// left as it is, as in `a:~/b`.
+func (p *Parser) SetPosix(on bool) {
+	p.lex.posix = on
+}
+
+// SetExtglob turns on extended globs, from the next line on, so that a group
+// such as the `(a|b)` in `@(a|b)` is part of the pattern it follows, rather
+// than a subshell or the end of a case pattern.
+func (p *Parser) SetExtglob(on bool) {
+	p.lex.extglob = on
+}
+
+// NewSyncParser returns a parser which reads each line of its input by calling
+// readLine, which returns io.EOF at the end of the input. Statements are read
+// by calling Next, rather than Parse.
+func NewSyncParser(
+	filename string, readLine func() (string, error),
+) *Parser {
+	lex := newLexer(filename)
+	lex.lexemes = nil
+	return &Parser{lex: lex, readLine: readLine}
+}
+
 func (p *Parser) Parse(line string) bool {
-	if !p.locked {
+	if p.readLine != nil {
+		panic("parser: Parser.Parse() called on a synchronous parser")
+	} else if !p.locked {
 		go p.parseStmtList()
 	}
 	p.lex.lex(line)
-	return <-p.done
+	done := <-p.done
+	if done && p.err != nil {
+		// Don't let a syntax error in one statement leave the lexer
+		// in a state that breaks the next one.
+		p.lex.reset()
+	}
+	return done
+}
+
+// EOF tells the parser that the input has ended. If it ends part way through
+// a statement, EOF returns an *Error with Incomplete set.
+func (p *Parser) EOF() error {
+	if !p.locked {
+		return nil
+	}
+	p.lex.eof()
+	<-p.done
+	p.lex.reset()
+	return p.err
 }
 
 func (p *Parser) Result() (ast.Stmt, error) {
@@ -67,6 +178,82 @@ func (p *Parser) Result() (ast.Stmt, error) {
 	return p.stmt, p.err
 }
 
+// Next parses the statements on the next line of input, along with any later
+// lines which they continue onto, for a parser created by NewSyncParser. Once
+// the input has ended, it returns io.EOF. If reading the input fails, Next
+// returns the error, and the input is treated as having ended.
+func (p *Parser) Next() (ast.Stmt, error) {
+	if p.readLine == nil {
+		panic("parser: Parser.Next() called on an asynchronous parser")
+	} else if p.eof {
+		return nil, io.EOF
+	} else if len(p.pending) == 0 && !p.fill() {
+		if p.readErr != nil {
+			return nil, p.readErr
+		}
+		return nil, io.EOF
+	}
+	p.parseLine()
+	if p.readErr != nil {
+		// The statement was cut short by the error, so report that
+		// rather than the statement being incomplete.
+		return nil, p.readErr
+	} else if p.err != nil {
+		p.lex.reset()
+	}
+	return p.stmt, p.err
+}
+
+// fill reads and lexes the next line of input, for a parser created by
+// NewSyncParser. It returns false at the end of the input.
+func (p *Parser) fill() bool {
+	line, err := p.readLine()
+	if err != nil {
+		p.eof = true
+		if err != io.EOF {
+			p.readErr = err
+		}
+		p.lex.eof()
+	} else {
+		p.lex.lex(line)
+	}
+	p.pending, p.lex.items = p.lex.items, nil
+	return err == nil
+}
+
+// more is called when the statement continues onto the next line. A parser
+// created by NewParser waits for the next call to Parse, while one created by
+// NewSyncParser reads the line itself once it needs it.
+func (p *Parser) more() {
+	if p.readLine == nil {
+		p.done <- false
+	}
+}
+
+// ParseAll parses the whole of r, returning every top-level statement in it,
+// or the first syntax error.
+func ParseAll(filename string, r io.Reader) ([]ast.Stmt, error) {
+	b := bufio.NewReader(r)
+	p := NewSyncParser(filename, func() (string, error) {
+		line, err := b.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		line = strings.TrimSuffix(line, "\n")
+		return strings.TrimSuffix(line, "\r"), err
+	})
+	var stmts []ast.Stmt
+	for {
+		stmt, err := p.Next()
+		if err == io.EOF {
+			return stmts, nil
+		} else if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt.(*ast.StmtList).Stmts...)
+	}
+}
+
 // accept consumes the current token, so that the accept call to peek() or
 // trim() will return a new token
 func (p *Parser) accept() {
@@ -81,21 +268,39 @@ func (p *Parser) accept() {
 }
 
 // peek returns the current token, retrieving it from the lexer if necessary
-func (p *Parser) peek() *lexeme {
+func (p *Parser) peek() *item {
 	if p.curr == nil {
-		l := <-p.lex.lexemes
-		p.curr = &l
+		for len(p.pending) == 0 {
+			if p.readLine != nil {
+				p.fill()
+			} else {
+				p.pending = <-p.lex.lexemes
+			}
+		}
+		i := p.pending[0]
+		p.pending = p.pending[1:]
+		p.curr = &i
+		if i.tok == token.EOF {
+			err := p.newParserError(&i, "unexpected end of input")
+			if p.lex.quote != 0 {
+				err.Msg += fmt.Sprintf(
+					" while looking for matching %c",
+					p.lex.quote)
+			}
+			err.Incomplete = true
+			panic(err)
+		}
 	}
 	return p.curr
 }
 
 // trim is like peek(), except that it consumes any whitespace before returning
 // the current token
-func (p *Parser) trim() *lexeme {
+func (p *Parser) trim() *item {
 	for {
 		switch p.peek().tok {
 		case token.EscapedNewline:
-			p.done <- false
+			p.more()
 			fallthrough
 		case token.Whitespace:
 			p.accept()
@@ -106,141 +311,1077 @@ func (p *Parser) trim() *lexeme {
 	}
 }
 
+// parseStmtList runs in a goroutine for each statement parsed by a parser
+// created by NewParser, signalling p.done once the statement is complete.
 func (p *Parser) parseStmtList() {
 	p.lock.Lock()
 	p.locked = true
-	p.stmt, p.err, p.curr = nil, nil, nil
+	defer func() {
+		p.locked = false
+		p.done <- true
+		p.lock.Unlock()
+	}()
+	p.parseLine()
+}
+
+// parseLine parses the statements on a line, and any later lines which they
+// continue onto, setting either p.stmt or p.err.
+func (p *Parser) parseLine() {
+	p.stmt, p.err, p.curr, p.hereDocs = nil, nil, nil, nil
 	defer func() {
 		if r := recover(); r != nil {
-			err, ok := r.(parserError)
+			err, ok := r.(*Error)
 			if !ok {
 				panic(r)
 			}
 			p.err = err
+			if p.readLine != nil {
+				// The rest of the line has already been lexed,
+				// so just skip it.
+				p.curr, p.pending = nil, nil
+				return
+			}
 			// If the parser panics before parsing the current line,
 			// the lexer will still continue to run. So we need to
 			// drain the p.lexemes channel of all tokens until the
 			// end of the line, so that the lexer doesn't block.
-			for p.peek().tok != token.Newline &&
+			// There's nothing left to drain after the end of input.
+			for !err.Incomplete &&
+				p.peek().tok != token.Newline &&
 				p.peek().tok != token.EscapedNewline {
 				p.accept()
 			}
-			p.accept()
+			if !err.Incomplete {
+				p.accept()
+			}
 		}
-		p.locked = false
-		p.done <- true
-		p.lock.Unlock()
 	}()
-	var stmts []ast.Stmt
+	list := &ast.StmtList{Pos: p.pos(p.trim())}
 	for {
 		switch l := p.trim(); l.tok {
 		case token.Newline:
-			p.accept()
-			p.stmt = &ast.StmtList{Stmts: stmts}
+			p.parseNewline()
+			p.stmt = list
 			return
-		case token.Semicolon:
+		case token.Semicolon, token.CaseBreak:
+			// Outside a case statement, `;;` is just two
+			// separators.
 			p.accept()
 			continue
 		default:
-			stmts = append(stmts, p.parseStmt())
+			list.Stmts = append(list.Stmts, p.parseStmt())
 		}
 	}
 }
 
+// parseStmts parses a list of statements up to the closing lexeme, which is
+// left for the caller to consume. Unlike at the top level, newlines only
+// separate statements, so the list can continue over several lines. The list
+// starts where its first statement does.
+func (p *Parser) parseStmts(closing func(l *item) bool) *ast.StmtList {
+	list := &ast.StmtList{}
+	for {
+		l := p.trim()
+		if closing(l) {
+			return list
+		}
+		switch l.tok {
+		case token.Newline:
+			p.parseNewline()
+			p.more()
+		case token.Semicolon, token.CaseBreak:
+			p.accept()
+		default:
+			if len(list.Stmts) == 0 {
+				list.Pos = p.pos(l)
+			}
+			list.Stmts = append(list.Stmts, p.parseStmt())
+		}
+	}
+}
+
+// skipNewlines consumes any newlines, e.g. after a `|` or `&&` operator,
+// where the statement must continue on the next line.
+func (p *Parser) skipNewlines() {
+	for p.trim().tok == token.Newline {
+		p.parseNewline()
+		p.more()
+	}
+}
+
 func (p *Parser) parseStmt() ast.Stmt {
+	start := p.pos(p.trim())
+	stmt := p.parseAndOr()
 	switch l := p.trim(); l.tok {
-	case token.Dollar:
-		panic(newParserError("assignment stmt not yet implemented"))
-	case token.String, token.SubString, token.Tilde:
-		return p.parsePipeline()
-	case token.Semicolon, token.Newline:
-		return &ast.Cmd{Argv: []ast.Expr{}}
+	case token.Ampersand:
+		// Like `;`, `&` separates this statement from the next.
+		p.accept()
+		return &ast.Background{Pos: start, Stmt: stmt}
+	case token.Semicolon, token.Newline, token.RParen, token.CaseBreak,
+		token.CaseContinue, token.CaseFallthrough:
+		return stmt
 	default:
-		panic(newParserError("unexpected token: %v", l))
+		panic(p.newParserError(l, "unexpected token: %v", l))
 	}
 }
 
-func (p *Parser) parsePipeline() *ast.Pipeline {
-	stmts := []ast.Stmt{p.parseCmd()}
+func (p *Parser) parseAndOr() ast.Stmt {
+	start := p.pos(p.trim())
+	stmt := p.parseNot()
 	for {
 		switch l := p.trim(); l.tok {
-		case token.Pipe:
+		case token.And, token.Or:
 			p.accept()
-		case token.Semicolon, token.Newline:
-			return &ast.Pipeline{Stmts: stmts}
+			p.skipNewlines()
+			stmt = &ast.AndOr{
+				Pos:   start,
+				Left:  stmt,
+				Op:    l.text,
+				Right: p.parseNot(),
+			}
 		default:
-			stmts = append(stmts, p.parseCmd())
+			return stmt
+		}
+	}
+}
+
+// parseNot parses a pipeline, which may be negated by a `!` or timed by the
+// `time` keyword before it.
+func (p *Parser) parseNot() ast.Stmt {
+	switch l := p.trim(); {
+	case l.tok == token.Bang:
+		p.accept()
+		return &ast.Not{Pos: p.pos(l), Stmt: p.parseNot()}
+	case l.tok == token.String && l.text == "time":
+		// `time` is only a keyword as a word by itself, and not in an
+		// assignment such as `time = 1`.
+		word := p.parseWord()
+		if !isText(word, "time") || p.trim().text == "=" {
+			return p.parsePipeline(p.pos(l), p.parseCmd(word))
+		}
+		return &ast.Time{Pos: p.pos(l), Stmt: p.parseNot()}
+	default:
+		return p.parsePipeline(p.pos(l), p.parseCommand())
+	}
+}
+
+// parsePipeline parses the rest of a pipeline, given its first command, which
+// starts at start.
+func (p *Parser) parsePipeline(start ast.Pos, first ast.Stmt) *ast.Pipeline {
+	pipeline := &ast.Pipeline{Pos: start, Stmts: []ast.Stmt{first}}
+	for p.trim().tok == token.Pipe {
+		p.accept()
+		p.skipNewlines()
+		pipeline.Stmts = append(pipeline.Stmts, p.parseCommand())
+	}
+	return pipeline
+}
+
+func (p *Parser) parseCommand() ast.Stmt {
+	switch l := p.trim(); l.tok {
+	case token.LParen:
+		return p.parseSubshell()
+	case token.String:
+		switch l.text {
+		case "{":
+			return p.parseGroup()
+		case "}":
+			panic(p.newParserError(l, "unexpected token: %v", l))
+		case "case":
+			return p.parseCase()
+		case "select":
+			return p.parseSelect()
+		case "for":
+			return p.parseFor()
+		case "coproc":
+			return p.parseCoproc()
+		case "[[":
+			return p.parseCondExpr()
+		}
+		return p.parseCmd(nil)
+	case token.SubString, token.Glob, token.Dollar, token.Tilde:
+		return p.parseCmd(nil)
+	default:
+		if isRedirectStart(l.tok) {
+			return p.parseCmd(nil)
+		}
+		panic(p.newParserError(l, "unexpected token: %v", l))
+	}
+}
+
+func (p *Parser) parseSubshell() *ast.Subshell {
+	start := p.pos(p.curr)
+	p.accept()
+	body := p.parseStmts(func(l *item) bool {
+		return l.tok == token.RParen
+	})
+	if len(body.Stmts) == 0 {
+		panic(p.newParserError(p.curr, "empty subshell"))
+	}
+	p.accept()
+	return &ast.Subshell{
+		Pos:       start,
+		Body:      body,
+		Redirects: p.parseRedirects(),
+	}
+}
+
+// parseGroup parses statements grouped by braces. Unlike parentheses, braces
+// are only special as whole words at the start of a command, so the closing
+// brace must follow a `;` or newline, e.g. `{ a; b; }`.
+func (p *Parser) parseGroup() *ast.Group {
+	start := p.pos(p.curr)
+	p.accept()
+	body := p.parseStmts(func(l *item) bool {
+		return l.tok == token.String && l.text == "}"
+	})
+	if len(body.Stmts) == 0 {
+		panic(p.newParserError(p.curr, "empty group"))
+	}
+	p.accept()
+	return &ast.Group{
+		Pos:       start,
+		Body:      body,
+		Redirects: p.parseRedirects(),
+	}
+}
+
+// parseCoproc parses a coprocess, e.g. `coproc P { cat; }`. The word after
+// `coproc` only names it if a group or subshell follows, since otherwise it's
+// the command to run, as in `coproc cat`. Like `case`, `coproc` is only a
+// keyword as a word by itself.
+func (p *Parser) parseCoproc() ast.Stmt {
+	start := p.pos(p.curr)
+	word := p.parseWord()
+	if !isText(word, "coproc") || p.trim().text == "=" {
+		return p.parseCmd(word)
+	}
+	c := &ast.Coproc{Pos: start, Name: "COPROC"}
+	if l := p.trim(); !isWordStart(l.tok) || isCompoundStart(l) {
+		c.Stmt = p.parseCommand()
+		return c
+	}
+	word = p.parseWord()
+	s, ok := word.SubExprs[0].(ast.String)
+	if len(word.SubExprs) == 1 && ok && isIdentifier(s.Text) &&
+		isCompoundStart(p.trim()) {
+		c.Name = s.Text
+		c.Stmt = p.parseCommand()
+	} else {
+		c.Stmt = p.parseCmd(word)
+	}
+	return c
+}
+
+// isCompoundStart reports whether a lexeme starts a group or subshell.
+func isCompoundStart(l *item) bool {
+	return l.tok == token.LParen || l.tok == token.String && l.text == "{"
+}
+
+// parseCase parses a case statement, e.g. `case $x in a | b) echo ab;; esac`.
+// Like `time`, `case` is only a keyword as a word by itself, so e.g.
+// `case = 1` is parsed as an assignment instead.
+func (p *Parser) parseCase() ast.Stmt {
+	start := p.pos(p.curr)
+	word := p.parseWord()
+	if !isText(word, "case") || p.trim().text == "=" {
+		return p.parseCmd(word)
+	}
+	c := &ast.Case{Pos: start}
+	if l := p.trim(); !isWordStart(l.tok) {
+		panic(p.newParserError(l, "unexpected token: %v", l))
+	}
+	c.Word = p.parseWord()
+	p.skipNewlines()
+	p.expectKeyword("in")
+	for {
+		p.skipNewlines()
+		if l := p.trim(); l.tok == token.String && l.text == "esac" {
+			p.accept()
+			break
+		}
+		clause := p.parseCaseClause()
+		c.Clauses = append(c.Clauses, clause)
+		if clause.Terminator == "" {
+			// Only the last clause may leave out its terminator.
+			clause.Terminator = ";;"
+			p.skipNewlines()
+			p.expectKeyword("esac")
+			break
+		}
+	}
+	c.Redirects = p.parseRedirects()
+	return c
+}
+
+// parseSelect parses a select loop, e.g. `select x in a b; do echo $x;
+// done`. The words after `in` run up to a `;` or newline, and without `in`,
+// the menu is made of the positional parameters. Like `case`, `select` is
+// only a keyword as a word by itself.
+func (p *Parser) parseSelect() ast.Stmt {
+	start := p.pos(p.curr)
+	word := p.parseWord()
+	if !isText(word, "select") || p.trim().text == "=" {
+		return p.parseCmd(word)
+	}
+	l := p.trim()
+	if !isWordStart(l.tok) {
+		panic(p.newParserError(l, "unexpected token: %v", l))
+	}
+	name := p.parseWord()
+	s, ok := name.SubExprs[0].(ast.String)
+	if len(name.SubExprs) != 1 || !ok || !isIdentifier(s.Text) {
+		panic(p.newParserError(
+			l, "`%s': not a valid identifier", ast.Unparse(name)))
+	}
+	sel := &ast.Select{Pos: start, Name: s.Text}
+	p.skipNewlines()
+	if l := p.trim(); l.tok == token.String && l.text == "in" {
+		p.accept()
+		sel.Words = []ast.Expr{}
+		for isWordStart(p.trim().tok) {
+			sel.Words = append(sel.Words, p.parseWord())
 		}
 	}
+	sel.Body = p.parseLoopBody()
+	sel.Redirects = p.parseRedirects()
+	return sel
 }
 
-func (p *Parser) parseCmd() *ast.Cmd {
-	var argv []ast.Expr
+// parseFor parses an arithmetic for loop, e.g. `for ((i = 0; i < 3; i++)); do
+// echo $i; done`. Like `case`, `for` is only a keyword as a word by itself,
+// and only before `((`, as there's no other kind of for loop.
+func (p *Parser) parseFor() ast.Stmt {
+	start := p.pos(p.curr)
+	word := p.parseWord()
+	if !isText(word, "for") || p.trim().tok != token.LArith {
+		return p.parseCmd(word)
+	}
+	p.accept()
+	// Each expression is followed by a `;`, except for the last, which
+	// is followed by the `))`.
+	expr := func(end token.Token) ast.Expr {
+		e := p.parseVarsOnly()
+		if l := p.peek(); l.tok != end {
+			panic(p.newParserError(
+				l, "unexpected token in arithmetic for: %v", l))
+		}
+		p.accept()
+		return e
+	}
+	f := &ast.ArithFor{
+		Pos:    start,
+		Init:   expr(token.Semicolon),
+		Cond:   expr(token.Semicolon),
+		Update: expr(token.RArith),
+	}
+	f.Body = p.parseLoopBody()
+	f.Redirects = p.parseRedirects()
+	return f
+}
+
+// parseLoopBody parses the body of a loop, e.g. `do echo $x; done`, after an
+// optional `;` and any newlines.
+func (p *Parser) parseLoopBody() *ast.StmtList {
+	if p.trim().tok == token.Semicolon {
+		p.accept()
+	}
+	p.skipNewlines()
+	p.expectKeyword("do")
+	body := p.parseStmts(func(l *item) bool {
+		return l.tok == token.String && l.text == "done"
+	})
+	if len(body.Stmts) == 0 {
+		panic(p.newParserError(p.curr, "empty loop body"))
+	}
+	p.accept()
+	return body
+}
+
+// parseCaseClause parses one clause of a case statement, e.g. `a | b) echo
+// ab;;`. The patterns may start with an optional `(`, which lets a pattern be
+// the word `esac`, and the terminator may be left out of the last clause.
+func (p *Parser) parseCaseClause() *ast.CaseClause {
+	clause := &ast.CaseClause{Pos: p.pos(p.trim())}
+	if p.curr.tok == token.LParen {
+		p.accept()
+	}
+	for {
+		if l := p.trim(); !isWordStart(l.tok) {
+			panic(p.newParserError(l, "unexpected token: %v", l))
+		}
+		clause.Patterns = append(clause.Patterns, p.parseWord())
+		l := p.trim()
+		if l.tok == token.RParen {
+			p.accept()
+			break
+		} else if l.tok != token.Pipe {
+			panic(p.newParserError(l, "unexpected token: %v", l))
+		}
+		p.accept()
+	}
+	clause.Body = p.parseStmts(func(l *item) bool {
+		return isCaseTerminator(l.tok) ||
+			l.tok == token.String && l.text == "esac"
+	})
+	if l := p.trim(); isCaseTerminator(l.tok) {
+		clause.Terminator = l.text
+		p.accept()
+	}
+	return clause
+}
+
+func isCaseTerminator(tok token.Token) bool {
+	return tok == token.CaseBreak || tok == token.CaseContinue ||
+		tok == token.CaseFallthrough
+}
+
+// expectKeyword consumes a keyword such as `in`, which must come next.
+func (p *Parser) expectKeyword(keyword string) {
+	l := p.trim()
+	if l.tok != token.String || l.text != keyword {
+		panic(p.newParserError(
+			l, "expected '%s', got %v", keyword, l))
+	}
+	p.accept()
+}
+
+// condUnaryOps and condBinaryOps list the operators in conditional
+// expressions, besides `<` and `>`, which are lexed as redirections.
+var (
+	condUnaryOps  = []string{"-n", "-z", "-e", "-f", "-d", "-s"}
+	condBinaryOps = []string{
+		"==", "=", "!=", "=~", "-eq", "-ne", "-lt", "-le", "-gt", "-ge",
+		"-nt", "-ot", "-ef",
+	}
+)
+
+// parseCondExpr parses a conditional expression in double brackets, e.g.
+// `[[ $x == a* && -f $y ]]`. Like braces, the brackets are only special as
+// whole words, and the expression may continue onto the next line after
+// `&&` or `||`.
+func (p *Parser) parseCondExpr() *ast.CondExpr {
+	p.accept()
+	c := p.parseCondOr()
+	p.expectKeyword("]]")
+	return c
+}
+
+func (p *Parser) parseCondOr() *ast.CondExpr {
+	start := p.pos(p.trim())
+	c := p.parseCondAnd()
+	for p.trim().tok == token.Or {
+		p.accept()
+		p.skipNewlines()
+		c = &ast.CondExpr{
+			Pos: start, Op: "||", X: c, Y: p.parseCondAnd(),
+		}
+	}
+	return c
+}
+
+func (p *Parser) parseCondAnd() *ast.CondExpr {
+	start := p.pos(p.trim())
+	c := p.parseCondNot()
+	for p.trim().tok == token.And {
+		p.accept()
+		p.skipNewlines()
+		c = &ast.CondExpr{
+			Pos: start, Op: "&&", X: c, Y: p.parseCondNot(),
+		}
+	}
+	return c
+}
+
+// parseCondNot parses a negated or parenthesised conditional expression, or a
+// single test, e.g. `-f $x` or `$x == a*`.
+func (p *Parser) parseCondNot() *ast.CondExpr {
+	l := p.trim()
+	switch {
+	case l.tok == token.Bang:
+		p.accept()
+		return &ast.CondExpr{
+			Pos: p.pos(l), Op: "!", X: p.parseCondNot(),
+		}
+	case l.tok == token.LParen:
+		p.accept()
+		c := p.parseCondOr()
+		if l := p.trim(); l.tok != token.RParen {
+			panic(p.newParserError(l, "unexpected token: %v", l))
+		}
+		p.accept()
+		return c
+	case !isCondWordStart(l):
+		panic(p.newParserError(l, "unexpected token: %v", l))
+	}
+	c := &ast.CondExpr{Pos: p.pos(l)}
+	word := p.parseWord()
+	if op, ok := condOp(word, condUnaryOps); ok &&
+		isCondWordStart(p.trim()) {
+		c.Op, c.Args = op, []ast.Expr{p.parseWord()}
+		return c
+	}
+	c.Args = []ast.Expr{word}
+	switch l := p.trim(); {
+	case l.tok == token.RedirectIn && l.text == "<",
+		l.tok == token.RedirectOut && l.text == ">":
+		p.accept()
+		c.Op = l.text
+	case l.tok == token.String && isOneOf(l.text, condBinaryOps):
+		if op, ok := condOp(p.parseWord(), condBinaryOps); ok {
+			c.Op = op
+		} else {
+			panic(p.newParserError(l, "unexpected token: %v", l))
+		}
+	default:
+		// A single word tests whether it's non-empty.
+		return c
+	}
+	if l := p.trim(); !isCondWordStart(l) {
+		panic(p.newParserError(l, "unexpected token: %v", l))
+	}
+	c.Args = append(c.Args, p.parseWord())
+	return c
+}
+
+// isCondWordStart reports whether a lexeme starts a word in a conditional
+// expression, where `]]` ends the expression instead.
+func isCondWordStart(l *item) bool {
+	return isWordStart(l.tok) && !(l.tok == token.String && l.text == "]]")
+}
+
+// condOp returns the operator which a word consists of, if it's one of ops.
+func condOp(word *ast.Word, ops []string) (string, bool) {
+	for _, op := range ops {
+		if isText(word, op) {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func isOneOf(s string, list []string) bool {
+	for _, item := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRedirects parses the redirections after a compound command.
+func (p *Parser) parseRedirects() []*ast.Redirect {
+	var redirects []*ast.Redirect
+	for isRedirectStart(p.trim().tok) {
+		redirects = append(redirects, p.parseRedirect())
+	}
+	return redirects
+}
+
+// parseCmd parses a simple command, or an assignment statement or function
+// definition. If the first word has already been parsed, it's passed in.
+func (p *Parser) parseCmd(word *ast.Word) ast.Stmt {
+	cmd := &ast.Cmd{}
+	if word == nil && isWordStart(p.trim().tok) {
+		word = p.parseWord()
+	}
+	if word == nil {
+		cmd.Pos = p.pos(p.trim())
+	} else {
+		cmd.Pos = word.Pos
+		if a, ok := p.parseAssign(word); ok {
+			return a
+		} else if f, ok := p.parseFunc(word); ok {
+			return f
+		}
+		addWord(cmd, word)
+	}
 	for {
 		switch l := p.trim(); l.tok {
-		case token.String, token.SubString, token.Dollar, token.Tilde:
-			argv = append(argv, p.parseWord())
+		case token.String, token.SubString, token.Glob, token.Dollar,
+			token.Tilde, token.Bang:
+			addWord(cmd, p.parseWord())
 			continue
 		default:
-			break
+			if isRedirectStart(l.tok) {
+				cmd.Redirects = append(
+					cmd.Redirects, p.parseRedirect())
+				continue
+			}
+		}
+		return cmd
+	}
+}
+
+// addWord adds a word to a command, either as an assignment such as
+// `NAME=value`, which are only recognised before the command name, or as an
+// argument.
+func addWord(cmd *ast.Cmd, word *ast.Word) {
+	if a, ok := assignment(word); ok && len(cmd.Argv) == 0 {
+		cmd.Assignments = append(cmd.Assignments, a)
+	} else {
+		cmd.Argv = append(cmd.Argv, word)
+	}
+}
+
+// parseAssign parses the rest of an assignment statement such as `x = value`,
+// given its first word. It returns false if the statement isn't one.
+func (p *Parser) parseAssign(target *ast.Word) (*ast.Assign, bool) {
+	name, index, ok := assignTarget(target)
+	if l := p.trim(); !ok || l.tok != token.String || l.text != "=" {
+		return nil, false
+	}
+	p.accept()
+	a := &ast.Assign{Pos: target.Pos, Name: name, Index: index}
+	switch l := p.trim(); {
+	case l.tok == token.LParen && index == nil:
+		a.Array = p.parseArray()
+	case isWordStart(l.tok):
+		a.Value = p.parseWord()
+	case l.tok == token.LParen:
+		panic(p.newParserError(
+			l, "%s: cannot assign an array to an element", name))
+	default:
+		// A missing value, e.g. `x =`, sets the variable to "".
+		a.Value = &ast.Word{Pos: p.pos(l)}
+	}
+	return a, true
+}
+
+// assignTarget returns the variable name from the target of an assignment
+// statement, e.g. `x`, along with the index if there is one, e.g. `x[1]`.
+func assignTarget(w *ast.Word) (string, ast.Expr, bool) {
+	n := len(w.SubExprs)
+	if n == 0 {
+		return "", nil, false
+	}
+	first, ok := literal(w.SubExprs[0])
+	if !ok {
+		return "", nil, false
+	} else if n == 1 && isIdentifier(first.Text) {
+		return first.Text, nil, true
+	}
+	open := strings.IndexByte(first.Text, '[')
+	last, ok := literal(w.SubExprs[n-1])
+	if open <= 0 || !isIdentifier(first.Text[:open]) ||
+		!ok || !strings.HasSuffix(last.Text, "]") {
+		return "", nil, false
+	}
+	// The index is everything between the brackets, which may include
+	// variables, e.g. `x[$i]`.
+	var texts []string
+	if n == 1 {
+		texts = []string{first.Text[open+1 : len(first.Text)-1]}
+	} else {
+		texts = []string{
+			first.Text[open+1:],
+			strings.TrimSuffix(last.Text, "]"),
+		}
+	}
+	index := &ast.Word{Pos: advance(first.Pos, first.Text[:open+1])}
+	if texts[0] != "" {
+		index.SubExprs = append(index.SubExprs,
+			ast.String{Pos: index.Pos, Text: texts[0]})
+	}
+	if n > 1 {
+		index.SubExprs = append(index.SubExprs, w.SubExprs[1:n-1]...)
+		if texts[1] != "" {
+			index.SubExprs = append(index.SubExprs,
+				ast.String{Pos: last.Pos, Text: texts[1]})
+		}
+	}
+	if len(index.SubExprs) == 0 {
+		return "", nil, false
+	}
+	return first.Text[:open], index, true
+}
+
+// parseFunc parses the rest of a function definition such as `f() { body; }`,
+// given its first word. It returns false if the statement isn't one. As in
+// other shells, the body may be any compound command.
+func (p *Parser) parseFunc(name *ast.Word) (*ast.Func, bool) {
+	if p.trim().tok != token.LParen || len(name.SubExprs) != 1 {
+		return nil, false
+	}
+	s, ok := name.SubExprs[0].(ast.String)
+	if !ok {
+		return nil, false
+	}
+	p.accept()
+	if l := p.trim(); l.tok != token.RParen {
+		panic(p.newParserError(
+			l, "unexpected token after %q: %v", "(", l))
+	}
+	p.accept()
+	p.skipNewlines()
+	f := &ast.Func{Pos: name.Pos, Name: s.Text}
+	switch l := p.trim(); {
+	case l.tok == token.LParen:
+		f.Body = p.parseSubshell()
+	case l.tok == token.String && l.text == "{":
+		f.Body = p.parseGroup()
+	default:
+		panic(p.newParserError(
+			l, "%s: function body must be a group or subshell",
+			s.Text))
+	}
+	return f, true
+}
+
+// parseArray parses an array literal, e.g. `(a b c)`, which may continue
+// over several lines.
+func (p *Parser) parseArray() *ast.Array {
+	array := &ast.Array{Pos: p.pos(p.curr)}
+	p.accept()
+	for {
+		switch l := p.trim(); {
+		case l.tok == token.RParen:
+			p.accept()
+			return array
+		case l.tok == token.Newline:
+			p.parseNewline()
+			p.more()
+		case isWordStart(l.tok):
+			array.Elems = append(array.Elems, p.parseWord())
+		default:
+			panic(p.newParserError(
+				l, "unexpected token in array: %v", l))
+		}
+	}
+}
+
+// parseRedirect parses a redirection, including the number of the file
+// descriptor it redirects, if one is given, as in `2>err`.
+func (p *Parser) parseRedirect() *ast.Redirect {
+	start := p.pos(p.trim())
+	fd := -1
+	if n := p.trim(); n.tok == token.IONumber {
+		var err error
+		if fd, err = strconv.Atoi(n.text); err != nil {
+			panic(p.newParserError(
+				n, "bad file descriptor: %s", n.text))
+		}
+		p.accept()
+	}
+	op := p.trim()
+	p.accept()
+	if fd < 0 {
+		fd = ast.DefaultFd(op.text)
+	}
+	switch l := p.trim(); {
+	case op.tok == token.HereDoc && l.tok == token.String:
+		// The delimiter has already been handled by the lexer, so
+		// there's nothing left to do with it here. The body of the
+		// here-doc will be filled in once the current line ends.
+		p.accept()
+		r := &ast.Redirect{Pos: start, Fd: fd, Op: "<<"}
+		p.hereDocs = append(p.hereDocs, r)
+		return r
+	case op.tok != token.HereDoc && isWordStart(l.tok):
+		return &ast.Redirect{
+			Pos:    start,
+			Fd:     fd,
+			Op:     op.text,
+			Target: p.parseWord(),
+		}
+	default:
+		panic(p.newParserError(
+			l, "unexpected token after %q: %v", op.text, l))
+	}
+}
+
+// assignment converts a word of the form `NAME=value` into an assignment.
+func assignment(w *ast.Word) (*ast.Assignment, bool) {
+	if len(w.SubExprs) == 0 {
+		return nil, false
+	}
+	s, ok := literal(w.SubExprs[0])
+	if !ok {
+		return nil, false
+	}
+	index := strings.IndexByte(s.Text, '=')
+	if index <= 0 || !isIdentifier(s.Text[:index]) {
+		return nil, false
+	}
+	value := &ast.Word{Pos: advance(s.Pos, s.Text[:index+1])}
+	if rest := s.Text[index+1:]; rest != "" {
+		value.SubExprs = append(value.SubExprs,
+			ast.String{Pos: value.Pos, Text: rest})
+	}
+	value.SubExprs = append(value.SubExprs, w.SubExprs[1:]...)
+	a := &ast.Assignment{Pos: w.Pos, Name: s.Text[:index], Value: value}
+	return a, true
+}
+
+// advance returns the position after some text which starts at pos, e.g. to
+// find where the value starts in `NAME=value`. The text must be on one line.
+func advance(pos ast.Pos, text string) ast.Pos {
+	pos.Col += utf8.RuneCountInString(text)
+	return pos
+}
+
+// literal returns an expression as a string if it's only text. A pattern is
+// too, where it's in a word such as `x[1]` in `x[1] = a`, which is never
+// expanded.
+func literal(e ast.Expr) (ast.String, bool) {
+	switch e := e.(type) {
+	case ast.String:
+		return e, true
+	case ast.Glob:
+		text, _ := decodeString(e.Text, 0, "", false)
+		return ast.String{Pos: e.Pos, Text: text}, true
+	default:
+		return ast.String{}, false
+	}
+}
+
+// isText reports whether a word is just the given text.
+func isText(w *ast.Word, text string) bool {
+	if len(w.SubExprs) != 1 {
+		return false
+	}
+	s, ok := w.SubExprs[0].(ast.String)
+	return ok && s.Text == text
+}
+
+func isWordStart(tok token.Token) bool {
+	switch tok {
+	case token.String, token.SubString, token.Glob, token.Dollar,
+		token.Tilde, token.Bang:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRedirectStart reports whether a token starts a redirection.
+func isRedirectStart(tok token.Token) bool {
+	switch tok {
+	case token.IONumber, token.HereDoc, token.HereString, token.RedirectIn,
+		token.RedirectOut, token.RedirectAppend, token.RedirectAll,
+		token.RedirectAllAppend, token.RedirectClobber,
+		token.RedirectDupIn, token.RedirectDupOut:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseNewline consumes the newline at the end of a line, followed by the
+// bodies of any here-docs that were started on that line.
+func (p *Parser) parseNewline() {
+	p.accept()
+	for _, r := range p.hereDocs {
+		p.more()
+		r.Target = p.parseHereDocBody()
+	}
+	p.hereDocs = nil
+}
+
+func (p *Parser) parseHereDocBody() *ast.Word {
+	body := &ast.Word{Pos: p.pos(p.peek())}
+	for {
+		switch l := p.peek(); l.tok {
+		case token.HereDocBody:
+			body.SubExprs = append(body.SubExprs,
+				ast.String{Pos: p.pos(l), Text: l.text})
+			p.accept()
+		case token.Dollar:
+			p.accept()
+			body.SubExprs = append(body.SubExprs, p.parseVar(l))
+		case token.Newline:
+			body.SubExprs = append(body.SubExprs,
+				ast.String{Pos: p.pos(l), Text: "\n"})
+			p.more()
+			p.accept()
+		case token.HereDocEnd:
+			p.accept()
+			return body
+		default:
+			panic(p.newParserError(
+				l, "unexpected token in here-doc: %v", l))
 		}
-		return &ast.Cmd{Argv: argv}
 	}
 }
 
 func (p *Parser) parseWord() *ast.Word {
-	var exprs []ast.Expr
+	word := &ast.Word{Pos: p.pos(p.peek())}
 	var str strings.Builder
+	// A string which continues over several lines starts on the first.
+	var strStart *item
 	for {
 		switch l := p.peek(); l.tok {
 		case token.Newline:
-			if str.Len() > 0 {
+			if strStart != nil {
 				// We're inside a multi-line string, and expect
 				// more of the string on the next line.
-				p.done <- false
+				p.more()
 				p.accept()
 			} else {
-				return &ast.Word{SubExprs: exprs}
+				return word
+			}
+		case token.String, token.Bang:
+			// A `!` anywhere but the start of a command is just
+			// text, e.g. in `test ! -f x`.
+			if strStart == nil {
+				strStart = l
 			}
-		case token.String:
 			str.WriteString(l.text)
-			exprs = append(exprs, ast.String{Text: str.String()})
+			word.SubExprs = append(word.SubExprs, ast.String{
+				Pos:  p.pos(strStart),
+				Text: str.String(),
+			})
 			str.Reset()
+			strStart = nil
 			p.accept()
 		case token.SubString:
+			if strStart == nil {
+				strStart = l
+			}
 			str.WriteString(l.text)
 			p.accept()
+		case token.Glob:
+			word.SubExprs = append(word.SubExprs,
+				ast.Glob{Pos: p.pos(l), Text: l.text})
+			p.accept()
 		case token.Dollar:
 			p.accept()
-			v := p.parseVar()
-			if v == nil {
+			if p.peek().tok == token.LParen {
+				word.SubExprs = append(word.SubExprs,
+					p.parseCmdSubst(l))
+			} else if v := p.parseVar(l); v != nil {
+				word.SubExprs = append(word.SubExprs, v)
+			} else {
 				// The `$` was not followed by a valid
 				// identifier, so just treat it as literal text.
-				exprs = append(exprs, ast.String{Text: l.text})
-			} else {
-				exprs = append(exprs, v)
+				word.SubExprs = append(word.SubExprs,
+					ast.String{Pos: p.pos(l), Text: l.text})
 			}
 		case token.Tilde:
-			exprs = append(exprs, ast.Tilde{Text: l.text})
+			word.SubExprs = append(word.SubExprs,
+				ast.Tilde{Pos: p.pos(l), Text: l.text})
 			p.accept()
 		default:
-			if str.Len() > 0 {
-				panic(newParserError(
-					"parser: unexpected token %v", l))
+			if strStart != nil {
+				panic(p.newParserError(
+					l, "unexpected token: %v", l))
 			} else {
-				return &ast.Word{SubExprs: exprs}
+				return word
 			}
 		}
 	}
 }
 
-func (p *Parser) parseVar() *ast.Var {
-	// TODO: Allow arrays to be indexed, and maps to be looked up.
+// parseVar parses a variable after the `$` which starts it, or returns nil if
+// the `$` isn't followed by one.
+func (p *Parser) parseVar(dollar *item) *ast.Var {
 	switch l := p.peek(); l.tok {
 	case token.Identifier:
 		p.accept()
-		return &ast.Var{Identifier: l.text}
+		return &ast.Var{
+			Pos:        p.pos(dollar),
+			Identifier: l.text,
+			Index:      p.parseIndex(),
+		}
+	case token.LBrace:
+		p.accept()
+		id := p.peek()
+		if id.tok != token.Identifier {
+			panic(p.newParserError(id, "bad substitution: %v", id))
+		}
+		p.accept()
+		v := &ast.Var{
+			Pos:        p.pos(dollar),
+			Identifier: id.text,
+			Index:      p.parseIndex(),
+		}
+		if op := p.peek(); op.tok == token.ParamOp {
+			p.accept()
+			v.Op = op.text
+			v.Args = append(v.Args, p.parseParamArg())
+			// The `/` operators have a replacement too, and
+			// `:` can have a length.
+			if sep := p.peek(); sep.tok == token.ParamOp {
+				p.accept()
+				v.Args = append(v.Args, p.parseParamArg())
+			}
+		}
+		if r := p.peek(); r.tok != token.RBrace {
+			panic(p.newParserError(r, "missing `}`: %v", r))
+		}
+		p.accept()
+		return v
 	default:
 		return nil
 	}
 }
+
+// parseCmdSubst parses the rest of a command substitution after the `$`, e.g.
+// the `(date)` in `$(date)`. The statements in it may continue onto more
+// lines, up to the closing parenthesis.
+func (p *Parser) parseCmdSubst(dollar *item) ast.Expr {
+	p.accept()
+	if p.trim().tok == token.RedirectIn {
+		return p.parseFileContents(dollar)
+	}
+	body := p.parseStmts(func(l *item) bool {
+		return l.tok == token.RParen
+	})
+	p.accept()
+	return ast.CmdSubst{Pos: p.pos(dollar), Body: body}
+}
+
+// parseFileContents parses the rest of `$(< file)` after the `(`, which reads
+// the file rather than running a command.
+func (p *Parser) parseFileContents(dollar *item) ast.FileContents {
+	p.accept()
+	if l := p.trim(); !isWordStart(l.tok) {
+		panic(p.newParserError(l, "unexpected token: %v", l))
+	}
+	path := p.parseWord()
+	if l := p.trim(); l.tok != token.RParen {
+		panic(p.newParserError(l, "missing `)`: %v", l))
+	}
+	p.accept()
+	return ast.FileContents{Pos: p.pos(dollar), Path: path}
+}
+
+// parseParamArg parses an argument to an operator in `${...}`, e.g. the `.txt`
+// in `${x%.txt}`, in which only variables are expanded.
+func (p *Parser) parseParamArg() ast.Expr {
+	return p.parseVarsOnly()
+}
+
+// parseVarsOnly parses text in which only variables are expanded, such as an
+// index, up to the first lexeme which isn't part of it.
+func (p *Parser) parseVarsOnly() *ast.Word {
+	word := &ast.Word{Pos: p.pos(p.peek())}
+	for {
+		switch l := p.peek(); l.tok {
+		case token.String:
+			word.SubExprs = append(word.SubExprs,
+				ast.String{Pos: p.pos(l), Text: l.text})
+			p.accept()
+		case token.Dollar:
+			p.accept()
+			word.SubExprs = append(word.SubExprs, p.parseVar(l))
+		default:
+			return word
+		}
+	}
+}
+
+// parseIndex parses the index after a variable name, e.g. the `[1]` in
+// `$x[1]`, or returns nil if there isn't one.
+func (p *Parser) parseIndex() ast.Expr {
+	if p.peek().tok != token.LBracket {
+		return nil
+	}
+	p.accept()
+	index := p.parseVarsOnly()
+	if l := p.peek(); l.tok != token.RBracket {
+		panic(p.newParserError(l, "unexpected token in index: %v", l))
+	}
+	p.accept()
+	return index
+}
@@ -15,10 +15,17 @@
 package parser
 
 import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/ast"
+	"github.com/meshshell/mesh/token"
 )
 
 func TestParserResultWhileLocked(t *testing.T) {
@@ -26,3 +33,964 @@ func TestParserResultWhileLocked(t *testing.T) {
 	require.False(t, p.Parse("echo 'unterminated string"))
 	assert.Panics(t, func() { p.Result() })
 }
+
+func parse(t *testing.T, lines ...string) (ast.Stmt, error) {
+	p := NewParser(t.Name())
+	for i, line := range lines {
+		done := p.Parse(line)
+		require.Equal(t, i == len(lines)-1, done, "line %d", i)
+	}
+	stmt, err := p.Result()
+	clearPos(&stmt)
+	return stmt, err
+}
+
+// clearPos clears the position of every node in a tree, or in a slice of
+// them, so that the tree can be compared with one written out by hand.
+func clearPos(tree interface{}) {
+	clearPosValue(reflect.ValueOf(tree))
+}
+
+func clearPosValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			clearPosValue(v.Elem())
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		// The value in an interface can't be changed in place, so
+		// change a copy of it and put that back instead.
+		e := reflect.New(v.Elem().Type()).Elem()
+		e.Set(v.Elem())
+		clearPosValue(e)
+		v.Set(e)
+	case reflect.Slice:
+		for n := 0; n < v.Len(); n++ {
+			clearPosValue(v.Index(n))
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(ast.Pos{}) {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		for n := 0; n < v.NumField(); n++ {
+			clearPosValue(v.Field(n))
+		}
+	}
+}
+
+func cmd(argv ...string) *ast.Cmd {
+	c := &ast.Cmd{}
+	for _, arg := range argv {
+		c.Argv = append(c.Argv, &ast.Word{
+			SubExprs: []ast.Expr{ast.String{Text: arg}},
+		})
+	}
+	return c
+}
+
+func pipeline(stmts ...ast.Stmt) *ast.Pipeline {
+	return &ast.Pipeline{Stmts: stmts}
+}
+
+func TestParserSeparators(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		line  string
+		stmts []ast.Stmt
+	}{
+		{"Empty", "", nil},
+		{"OnlySemicolons", "; ;; ;", nil},
+		{"LeadingSemicolon", "; echo foo", []ast.Stmt{
+			pipeline(cmd("echo", "foo")),
+		}},
+		{"TrailingSemicolon", "echo foo ;", []ast.Stmt{
+			pipeline(cmd("echo", "foo")),
+		}},
+		{"DoubledSemicolon", "echo foo ;; echo bar", []ast.Stmt{
+			pipeline(cmd("echo", "foo")),
+			pipeline(cmd("echo", "bar")),
+		}},
+		{"SpacedSemicolons", "echo foo ; ; echo bar", []ast.Stmt{
+			pipeline(cmd("echo", "foo")),
+			pipeline(cmd("echo", "bar")),
+		}},
+		{"Background", "sleep 1 &", []ast.Stmt{
+			&ast.Background{Stmt: pipeline(cmd("sleep", "1"))},
+		}},
+		{"BackgroundThenSemicolon", "sleep 1 &; echo foo", []ast.Stmt{
+			&ast.Background{Stmt: pipeline(cmd("sleep", "1"))},
+			pipeline(cmd("echo", "foo")),
+		}},
+		{"BackgroundThenCommand", "sleep 1 & echo foo", []ast.Stmt{
+			&ast.Background{Stmt: pipeline(cmd("sleep", "1"))},
+			pipeline(cmd("echo", "foo")),
+		}},
+		{"BackgroundPipeline", "yes | head -1 &", []ast.Stmt{
+			&ast.Background{Stmt: pipeline(
+				cmd("yes"), cmd("head", "-1"),
+			)},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stmt, err := parse(t, test.line)
+			require.NoError(t, err)
+			assert.Equal(t, &ast.StmtList{Stmts: test.stmts}, stmt)
+		})
+	}
+}
+
+func TestParserSeparatorErrors(t *testing.T) {
+	for _, line := range []string{"&", "& echo foo", "echo foo & &"} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserErrorPosition(t *testing.T) {
+	_, err := parse(t, "(echo a", "\techo b |)")
+	require.Error(t, err)
+	assert.Equal(t,
+		t.Name()+`:2:10: unexpected token: RParen(")")`+"\n"+
+			"\techo b |)\n"+
+			"\t        ^",
+		err.Error())
+}
+
+func TestParserIncomplete(t *testing.T) {
+	p := NewParser(t.Name())
+	assert.NoError(t, p.EOF())
+
+	require.False(t, p.Parse("echo 'a"))
+	err := p.EOF()
+	var perr *Error
+	require.True(t, errors.As(err, &perr))
+	assert.True(t, perr.Incomplete)
+	assert.Equal(t, token.EOF, perr.Token)
+	assert.Equal(t, 1, perr.Line)
+	assert.Equal(t, 8, perr.Col)
+	assert.Equal(t,
+		"unexpected end of input while looking for matching '",
+		perr.Msg)
+
+	// The parser starts afresh after the end of the input.
+	require.True(t, p.Parse("echo b"))
+	stmt, err := p.Result()
+	require.NoError(t, err)
+	clearPos(&stmt)
+	assert.Equal(t,
+		&ast.StmtList{Stmts: []ast.Stmt{pipeline(cmd("echo", "b"))}},
+		stmt)
+
+	require.False(t, p.Parse("echo a |"))
+	err = p.EOF()
+	require.True(t, errors.As(err, &perr))
+	assert.Equal(t, "unexpected end of input", perr.Msg)
+
+	_, err = parse(t, "echo a )")
+	require.True(t, errors.As(err, &perr))
+	assert.False(t, perr.Incomplete)
+	assert.Equal(t, token.RParen, perr.Token)
+}
+
+func TestParseAll(t *testing.T) {
+	script := "a; b\n\n(c\nd) | e\ncat <<EOF\nx\nEOF\n"
+	stmts, err := ParseAll(t.Name(), strings.NewReader(script))
+	require.NoError(t, err)
+	clearPos(stmts)
+	c := cmd("cat")
+	c.Redirects = []*ast.Redirect{{
+		Fd: 0,
+		Op: "<<",
+		Target: &ast.Word{SubExprs: []ast.Expr{
+			ast.String{Text: "x"},
+			ast.String{Text: "\n"},
+		}},
+	}}
+	assert.Equal(t, []ast.Stmt{
+		pipeline(cmd("a")),
+		pipeline(cmd("b")),
+		pipeline(
+			&ast.Subshell{Body: &ast.StmtList{Stmts: []ast.Stmt{
+				pipeline(cmd("c")),
+				pipeline(cmd("d")),
+			}}},
+			cmd("e"),
+		),
+		pipeline(c),
+	}, stmts)
+
+	for _, script := range []string{"a\n)\nb\n", "a |\n"} {
+		t.Run(script, func(t *testing.T) {
+			r := strings.NewReader(script)
+			stmts, err := ParseAll(t.Name(), r)
+			assert.Error(t, err)
+			assert.Nil(t, stmts)
+		})
+	}
+
+	// Lines may be longer than a bufio.Scanner's default buffer.
+	long := strings.Repeat("x", 100000)
+	stmts, err = ParseAll(t.Name(), strings.NewReader("echo "+long))
+	require.NoError(t, err)
+	clearPos(stmts)
+	assert.Equal(t, []ast.Stmt{pipeline(cmd("echo", long))}, stmts)
+}
+
+func TestParserPositions(t *testing.T) {
+	script := "a\n  echo x$y >out |\n\tb; X=é"
+	stmts, err := ParseAll(t.Name(), strings.NewReader(script))
+	require.NoError(t, err)
+	require.Len(t, stmts, 3)
+	at := func(line, col int) ast.Pos {
+		return ast.Pos{File: t.Name(), Line: line, Col: col}
+	}
+	assert.Equal(t, at(1, 1), stmts[0].(*ast.Pipeline).Pos)
+
+	p := stmts[1].(*ast.Pipeline)
+	assert.Equal(t, at(2, 3), p.Pos)
+	echo := p.Stmts[0].(*ast.Cmd)
+	assert.Equal(t, at(2, 3), echo.Pos)
+	arg := echo.Argv[1].(*ast.Word)
+	assert.Equal(t, at(2, 8), arg.Pos)
+	assert.Equal(t, at(2, 8), arg.SubExprs[0].(ast.String).Pos)
+	assert.Equal(t, at(2, 9), arg.SubExprs[1].(*ast.Var).Pos)
+	assert.Equal(t, at(2, 12), echo.Redirects[0].Pos)
+	assert.Equal(t, at(2, 13), echo.Redirects[0].Target.(*ast.Word).Pos)
+	assert.Equal(t, at(3, 2), p.Stmts[1].(*ast.Cmd).Pos)
+
+	assign := stmts[2].(*ast.Pipeline).Stmts[0].(*ast.Cmd).Assignments[0]
+	assert.Equal(t, at(3, 5), assign.Pos)
+	value := assign.Value.(*ast.Word)
+	assert.Equal(t, at(3, 7), value.Pos)
+	assert.Equal(t, "TestParserPositions:3:7", value.Pos.String())
+	assert.Equal(t, "-", ast.Pos{}.String())
+}
+
+func TestParserAssignments(t *testing.T) {
+	stmt, err := parse(t, "A=1 B= C=x$D =c d=e")
+	require.NoError(t, err)
+	c := cmd("=c", "d=e")
+	c.Assignments = []*ast.Assignment{
+		{Name: "A", Value: &ast.Word{SubExprs: []ast.Expr{
+			ast.String{Text: "1"},
+		}}},
+		{Name: "B", Value: &ast.Word{}},
+		{Name: "C", Value: &ast.Word{SubExprs: []ast.Expr{
+			ast.String{Text: "x"},
+			&ast.Var{Identifier: "D"},
+		}}},
+	}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{pipeline(c)}}, stmt)
+
+	stmt, err = parse(t, "A=1 <x")
+	require.NoError(t, err)
+	c = &ast.Cmd{
+		Assignments: []*ast.Assignment{
+			{Name: "A", Value: &ast.Word{SubExprs: []ast.Expr{
+				ast.String{Text: "1"},
+			}}},
+		},
+		Redirects: []*ast.Redirect{{
+			Fd: 0,
+			Op: "<",
+			Target: &ast.Word{SubExprs: []ast.Expr{
+				ast.String{Text: "x"},
+			}},
+		}},
+	}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{pipeline(c)}}, stmt)
+}
+
+func TestParserRedirectFd(t *testing.T) {
+	stmt, err := parse(t, "a 2>&1 <&3 3<b")
+	require.NoError(t, err)
+	word := func(text string) *ast.Word {
+		return &ast.Word{SubExprs: []ast.Expr{ast.String{Text: text}}}
+	}
+	c := cmd("a")
+	c.Redirects = []*ast.Redirect{
+		{Fd: 2, Op: ">&", Target: word("1")},
+		{Fd: 0, Op: "<&", Target: word("3")},
+		{Fd: 3, Op: "<", Target: word("b")},
+	}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{pipeline(c)}}, stmt)
+}
+
+func TestParserAssign(t *testing.T) {
+	word := func(exprs ...ast.Expr) *ast.Word {
+		return &ast.Word{SubExprs: exprs}
+	}
+	str := func(text string) ast.String {
+		return ast.String{Text: text}
+	}
+	for _, test := range []struct {
+		name  string
+		lines []string
+		stmt  ast.Stmt
+	}{
+		{
+			"Scalar", []string{"x = $y/z"},
+			&ast.Assign{
+				Name: "x",
+				Value: word(
+					&ast.Var{Identifier: "y"},
+					str("/z"),
+				),
+			},
+		}, {
+			"Empty", []string{"x ="},
+			&ast.Assign{Name: "x", Value: word()},
+		}, {
+			"Element", []string{"x[$i] = a"},
+			&ast.Assign{
+				Name:  "x",
+				Index: word(&ast.Var{Identifier: "i"}),
+				Value: word(str("a")),
+			},
+		}, {
+			"Array", []string{"x = (a", "b) "},
+			&ast.Assign{Name: "x", Array: &ast.Array{
+				Elems: []ast.Expr{
+					word(str("a")),
+					word(str("b")),
+				},
+			}},
+		}, {
+			"NotAnAssignment", []string{"x =y"},
+			cmd("x", "=y"),
+		}, {
+			"IndexedVar", []string{"echo ${x[-1]}$y[@]"},
+			&ast.Cmd{Argv: []ast.Expr{
+				word(str("echo")),
+				word(&ast.Var{
+					Identifier: "x",
+					Index:      word(str("-1")),
+				}, &ast.Var{
+					Identifier: "y",
+					Index:      word(str("@")),
+				}),
+			}},
+		}, {
+			"ParamOp", []string{"echo ${x%.$y} ${z[@]##}"},
+			&ast.Cmd{Argv: []ast.Expr{
+				word(str("echo")),
+				word(&ast.Var{
+					Identifier: "x",
+					Op:         "%",
+					Args: []ast.Expr{word(
+						str("."),
+						&ast.Var{Identifier: "y"},
+					)},
+				}),
+				word(&ast.Var{
+					Identifier: "z",
+					Index:      word(str("@")),
+					Op:         "##",
+					Args:       []ast.Expr{&ast.Word{}},
+				}),
+			}},
+		}, {
+			"Glob", []string{"echo ~/*.go $x?"},
+			&ast.Cmd{Argv: []ast.Expr{
+				word(str("echo")),
+				word(
+					ast.Tilde{Text: "~"},
+					ast.Glob{Text: "/*.go"},
+				),
+				word(
+					&ast.Var{Identifier: "x"},
+					ast.Glob{Text: "?"},
+				),
+			}},
+		}, {
+			"Substring", []string{"echo ${x:1:$n}"},
+			&ast.Cmd{Argv: []ast.Expr{
+				word(str("echo")),
+				word(&ast.Var{
+					Identifier: "x",
+					Op:         ":",
+					Args: []ast.Expr{
+						word(str("1")),
+						word(&ast.Var{Identifier: "n"}),
+					},
+				}),
+			}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stmt, err := parse(t, test.lines...)
+			require.NoError(t, err)
+			assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+				pipeline(test.stmt),
+			}}, stmt)
+		})
+	}
+
+	for _, line := range []string{
+		"x = a b", "x[1] = (a)", "x = (a;)", "echo ${x", "echo ${",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserHereDocs(t *testing.T) {
+	stmt, err := parse(t, "cat <<EOF", "x $Y", "", "EOF")
+	require.NoError(t, err)
+	c := cmd("cat")
+	c.Redirects = []*ast.Redirect{{
+		Fd: 0,
+		Op: "<<",
+		Target: &ast.Word{SubExprs: []ast.Expr{
+			ast.String{Text: "x "},
+			&ast.Var{Identifier: "Y"},
+			ast.String{Text: "\n"},
+			ast.String{Text: "\n"},
+		}},
+	}}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{pipeline(c)}}, stmt)
+
+	// A syntax error on the same line as a here-doc discards the rest of
+	// the line, so the following line is parsed as a new statement.
+	p := NewParser(t.Name())
+	require.True(t, p.Parse("cat <<EOF <"))
+	_, err = p.Result()
+	assert.Error(t, err)
+	require.True(t, p.Parse("echo foo"))
+	stmt, err = p.Result()
+	require.NoError(t, err)
+	clearPos(&stmt)
+	assert.Equal(t,
+		&ast.StmtList{Stmts: []ast.Stmt{pipeline(cmd("echo", "foo"))}},
+		stmt)
+}
+
+func TestParserAndOr(t *testing.T) {
+	stmt, err := parse(t, "a && b || c | d &")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		&ast.Background{Stmt: &ast.AndOr{
+			Left: &ast.AndOr{
+				Left:  pipeline(cmd("a")),
+				Op:    "&&",
+				Right: pipeline(cmd("b")),
+			},
+			Op:    "||",
+			Right: pipeline(cmd("c"), cmd("d")),
+		}},
+	}}, stmt)
+
+	// A statement can continue on the next line after an operator.
+	stmt, err = parse(t, "a &&", "", "b |", "c")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		&ast.AndOr{
+			Left:  pipeline(cmd("a")),
+			Op:    "&&",
+			Right: pipeline(cmd("b"), cmd("c")),
+		},
+	}}, stmt)
+}
+
+func TestParserNot(t *testing.T) {
+	stmt, err := parse(t, "! a | b && ! ! c !")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		&ast.AndOr{
+			Left: &ast.Not{Stmt: pipeline(cmd("a"), cmd("b"))},
+			Op:   "&&",
+			Right: &ast.Not{Stmt: &ast.Not{
+				Stmt: pipeline(cmd("c", "!")),
+			}},
+		},
+	}}, stmt)
+
+	for _, line := range []string{"!", "a && !", "! && a", "a | ! b"} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserTime(t *testing.T) {
+	stmt, err := parse(t, "time a | b; time ! c; time = 1; echo time")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		&ast.Time{Stmt: pipeline(cmd("a"), cmd("b"))},
+		&ast.Time{Stmt: &ast.Not{Stmt: pipeline(cmd("c"))}},
+		pipeline(&ast.Assign{Name: "time", Value: &ast.Word{
+			SubExprs: []ast.Expr{ast.String{Text: "1"}},
+		}}),
+		pipeline(cmd("echo", "time")),
+	}}, stmt)
+}
+
+func TestParserSubshell(t *testing.T) {
+	stmt, err := parse(t, "(a; b) | (c", "d &", ") && e")
+	require.NoError(t, err)
+	left := &ast.Subshell{Body: &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("a")),
+		pipeline(cmd("b")),
+	}}}
+	right := &ast.Subshell{Body: &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("c")),
+		&ast.Background{Stmt: pipeline(cmd("d"))},
+	}}}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		&ast.AndOr{
+			Left:  pipeline(left, right),
+			Op:    "&&",
+			Right: pipeline(cmd("e")),
+		},
+	}}, stmt)
+
+	for _, line := range []string{"()", "(a))", ")", "a (b)", "a && ;"} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserCmdSubst(t *testing.T) {
+	stmt, err := parse(t, "echo a$(b | c; d", ")e $()")
+	require.NoError(t, err)
+	body := &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("b"), cmd("c")),
+		pipeline(cmd("d")),
+	}}
+	echo := cmd("echo")
+	echo.Argv = append(echo.Argv, &ast.Word{SubExprs: []ast.Expr{
+		ast.String{Text: "a"},
+		ast.CmdSubst{Body: body},
+		ast.String{Text: "e"},
+	}}, &ast.Word{SubExprs: []ast.Expr{
+		ast.CmdSubst{Body: &ast.StmtList{}},
+	}})
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{pipeline(echo)}}, stmt)
+
+	for _, line := range []string{"echo $(a))", "echo $(|)"} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserExtglob(t *testing.T) {
+	p := NewParser(t.Name())
+	p.SetExtglob(true)
+	require.True(t, p.Parse("ls @(a|b).go x!(y|(z))"))
+	stmt, err := p.Result()
+	require.NoError(t, err)
+	clearPos(&stmt)
+	word := func(expr ast.Expr) *ast.Word {
+		return &ast.Word{SubExprs: []ast.Expr{expr}}
+	}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(&ast.Cmd{Argv: []ast.Expr{
+			word(ast.String{Text: "ls"}),
+			word(ast.Glob{Text: "@(a|b).go"}),
+			word(ast.Glob{Text: "x!(y|(z))"}),
+		}}),
+	}}, stmt)
+
+	// Otherwise the `(` isn't part of the word, which is an error.
+	for _, line := range []string{"ls \\@(a)", "ls @(a", "ls a(b)"} {
+		t.Run(line, func(t *testing.T) {
+			p := NewParser(t.Name())
+			p.SetExtglob(true)
+			p.Parse(line)
+			_, err := p.Result()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserFunc(t *testing.T) {
+	stmt, err := parse(t, "f() { a; }; g ()", "(b)")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(&ast.Func{
+			Name: "f",
+			Body: &ast.Group{Body: &ast.StmtList{Stmts: []ast.Stmt{
+				pipeline(cmd("a")),
+			}}},
+		}),
+		pipeline(&ast.Func{
+			Name: "g",
+			Body: &ast.Subshell{Body: &ast.StmtList{
+				Stmts: []ast.Stmt{pipeline(cmd("b"))},
+			}},
+		}),
+	}}, stmt)
+
+	for _, line := range []string{
+		"f(", "f(x) { a; }", "f() a", "$f() { a; }",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserGroup(t *testing.T) {
+	stmt, err := parse(t, "{ a; b }", "} && { c", "}")
+	require.NoError(t, err)
+	left := &ast.Group{Body: &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("a")),
+		pipeline(cmd("b", "}")),
+	}}}
+	right := &ast.Group{Body: &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("c")),
+	}}}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		&ast.AndOr{
+			Left:  pipeline(left),
+			Op:    "&&",
+			Right: pipeline(right),
+		},
+	}}, stmt)
+
+	for _, line := range []string{"{ }", "{ a; } }", "}", "{ a; } b"} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserCase(t *testing.T) {
+	stmt, err := parse(t,
+		"case $x in",
+		"a | 'b c') echo a;&",
+		"* ) ;;&",
+		"(esac) echo b",
+		"esac >f")
+	require.NoError(t, err)
+	word := func(e ast.Expr) *ast.Word {
+		return &ast.Word{SubExprs: []ast.Expr{e}}
+	}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(&ast.Case{
+			Word: word(&ast.Var{Identifier: "x"}),
+			Clauses: []*ast.CaseClause{{
+				Patterns: []ast.Expr{
+					word(ast.String{Text: "a"}),
+					word(ast.String{Text: "b c"}),
+				},
+				Body: &ast.StmtList{Stmts: []ast.Stmt{
+					pipeline(cmd("echo", "a")),
+				}},
+				Terminator: ";&",
+			}, {
+				Patterns: []ast.Expr{
+					word(ast.Glob{Text: "*"}),
+				},
+				Body:       &ast.StmtList{},
+				Terminator: ";;&",
+			}, {
+				Patterns: []ast.Expr{
+					word(ast.String{Text: "esac"}),
+				},
+				Body: &ast.StmtList{Stmts: []ast.Stmt{
+					pipeline(cmd("echo", "b")),
+				}},
+				Terminator: ";;",
+			}},
+			Redirects: []*ast.Redirect{{
+				Fd:     1,
+				Op:     ">",
+				Target: word(ast.String{Text: "f"}),
+			}},
+		}),
+	}}, stmt)
+
+	// `case` is only a keyword by itself at the start of a command.
+	stmt, err = parse(t, "echo case in; case = 1")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("echo", "case", "in")),
+		pipeline(&ast.Assign{
+			Name:  "case",
+			Value: word(ast.String{Text: "1"}),
+		}),
+	}}, stmt)
+
+	for _, line := range []string{
+		"case x in a) b;; c",
+		"case x a) b;; esac",
+		"case x in a b) c;; esac",
+		"case x in a) b;; esac c",
+		"case x in a) b; esac; ;&",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserSelect(t *testing.T) {
+	stmt, err := parse(t,
+		"select x in a $y",
+		"do echo a; done >f; select y do b; done")
+	require.NoError(t, err)
+	word := func(e ast.Expr) *ast.Word {
+		return &ast.Word{SubExprs: []ast.Expr{e}}
+	}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(&ast.Select{
+			Name: "x",
+			Words: []ast.Expr{
+				word(ast.String{Text: "a"}),
+				word(&ast.Var{Identifier: "y"}),
+			},
+			Body: &ast.StmtList{Stmts: []ast.Stmt{
+				pipeline(cmd("echo", "a")),
+			}},
+			Redirects: []*ast.Redirect{{
+				Fd:     1,
+				Op:     ">",
+				Target: word(ast.String{Text: "f"}),
+			}},
+		}),
+		pipeline(&ast.Select{
+			Name: "y",
+			Body: &ast.StmtList{Stmts: []ast.Stmt{
+				pipeline(cmd("b")),
+			}},
+		}),
+	}}, stmt)
+
+	for _, line := range []string{
+		"select x in a; b; done",
+		"select x in a; do done",
+		"select x in a; do b; done c",
+		"select $x in a; do b; done",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserArithFor(t *testing.T) {
+	stmt, err := parse(t,
+		"for ((i = 0; i < $n; i++))",
+		"do a; done >f; for ((;;)) do b; done")
+	require.NoError(t, err)
+	word := func(exprs ...ast.Expr) *ast.Word {
+		return &ast.Word{SubExprs: exprs}
+	}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(&ast.ArithFor{
+			Init: word(ast.String{Text: "i = 0"}),
+			Cond: word(
+				ast.String{Text: "i < "},
+				&ast.Var{Identifier: "n"},
+			),
+			Update: word(ast.String{Text: "i++"}),
+			Body: &ast.StmtList{Stmts: []ast.Stmt{
+				pipeline(cmd("a")),
+			}},
+			Redirects: []*ast.Redirect{{
+				Fd:     1,
+				Op:     ">",
+				Target: word(ast.String{Text: "f"}),
+			}},
+		}),
+		pipeline(&ast.ArithFor{
+			Init:   word(),
+			Cond:   word(),
+			Update: word(),
+			Body: &ast.StmtList{Stmts: []ast.Stmt{
+				pipeline(cmd("b")),
+			}},
+		}),
+	}}, stmt)
+
+	// Without `((`, `for` is an ordinary command.
+	stmt, err = parse(t, "for x in a")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("for", "x", "in", "a")),
+	}}, stmt)
+
+	for _, line := range []string{
+		"for ((i; j)); do a; done",
+		"for ((i; j; k; l)); do a; done",
+		"for ((;;)); do done",
+		"for ((;;)) a; done",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParserCondExpr(t *testing.T) {
+	stmt, err := parse(t,
+		"[[ ! -f $x && ( a < b || $y == *.go ) ||", "c ]]")
+	require.NoError(t, err)
+	word := func(e ast.Expr) *ast.Word {
+		return &ast.Word{SubExprs: []ast.Expr{e}}
+	}
+	isFile := &ast.CondExpr{
+		Op:   "-f",
+		Args: []ast.Expr{word(&ast.Var{Identifier: "x"})},
+	}
+	less := &ast.CondExpr{Op: "<", Args: []ast.Expr{
+		word(ast.String{Text: "a"}),
+		word(ast.String{Text: "b"}),
+	}}
+	match := &ast.CondExpr{Op: "==", Args: []ast.Expr{
+		word(&ast.Var{Identifier: "y"}),
+		word(ast.Glob{Text: "*.go"}),
+	}}
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(&ast.CondExpr{
+			Op: "||",
+			X: &ast.CondExpr{
+				Op: "&&",
+				X:  &ast.CondExpr{Op: "!", X: isFile},
+				Y:  &ast.CondExpr{Op: "||", X: less, Y: match},
+			},
+			Y: &ast.CondExpr{
+				Args: []ast.Expr{word(ast.String{Text: "c"})},
+			},
+		}),
+	}}, stmt)
+
+	// An operator with nothing to apply to is just a word.
+	stmt, err = parse(t, "[[ -f ]] && [[ -eq ]]")
+	require.NoError(t, err)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		&ast.AndOr{
+			Left: pipeline(&ast.CondExpr{
+				Args: []ast.Expr{word(ast.String{Text: "-f"})},
+			}),
+			Op: "&&",
+			Right: pipeline(&ast.CondExpr{
+				Args: []ast.Expr{word(ast.String{Text: "-eq"})},
+			}),
+		},
+	}}, stmt)
+
+	for _, line := range []string{
+		"[[ ]]",
+		"[[ a",
+		"[[ a b ]]",
+		"[[ a == ]]",
+		"[[ ( a ]]",
+		"[[ a && ]]",
+		"[[ a ]] b",
+		"[[ a ==b ]]",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parse(t, line)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func BenchmarkParseLongLine(b *testing.B) {
+	line := strings.Repeat("echo a$b 'c d' >f && ", 500) + "e"
+	b.SetBytes(int64(len(line)))
+	p := NewParser(b.Name())
+	for n := 0; n < b.N; n++ {
+		if done := p.Parse(line); !done {
+			b.Fatal("incomplete statement")
+		} else if _, err := p.Result(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSyncParser(t *testing.T) {
+	lines := []string{"a; b", "(c", "d) |", "", "e", "f )", "g 'h"}
+	p := NewSyncParser(t.Name(), func() (string, error) {
+		if len(lines) == 0 {
+			return "", io.EOF
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, nil
+	})
+	assert.Panics(t, func() { p.Parse("x") })
+
+	stmt, err := p.Next()
+	require.NoError(t, err)
+	clearPos(&stmt)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{
+		pipeline(cmd("a")),
+		pipeline(cmd("b")),
+	}}, stmt)
+
+	stmt, err = p.Next()
+	require.NoError(t, err)
+	clearPos(&stmt)
+	assert.Equal(t, &ast.StmtList{Stmts: []ast.Stmt{pipeline(
+		&ast.Subshell{Body: &ast.StmtList{Stmts: []ast.Stmt{
+			pipeline(cmd("c")),
+			pipeline(cmd("d")),
+		}}},
+		cmd("e"),
+	)}}, stmt)
+
+	// A syntax error only skips the rest of its line.
+	_, err = p.Next()
+	var perr *Error
+	require.True(t, errors.As(err, &perr))
+	assert.False(t, perr.Incomplete)
+	assert.Equal(t, 6, perr.Line)
+
+	_, err = p.Next()
+	require.True(t, errors.As(err, &perr))
+	assert.True(t, perr.Incomplete)
+	assert.Equal(t, 7, perr.Line)
+
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSyncParserReadError(t *testing.T) {
+	readErr := errors.New("read error")
+	for _, test := range []struct {
+		name  string
+		lines []string
+	}{
+		{"BetweenStatements", []string{"a"}},
+		{"MidStatement", []string{"a", "b |"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lines := test.lines
+			p := NewSyncParser(t.Name(), func() (string, error) {
+				if len(lines) == 0 {
+					return "", readErr
+				}
+				line := lines[0]
+				lines = lines[1:]
+				return line, nil
+			})
+			_, err := p.Next()
+			require.NoError(t, err)
+			_, err = p.Next()
+			assert.Equal(t, readErr, err)
+			_, err = p.Next()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
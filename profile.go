@@ -0,0 +1,43 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// systemProfile is sourced by every login shell, before the user's profile.
+var systemProfile = "/etc/profile"
+
+// startupFiles returns the files which a shell sources before it reads any
+// commands, in order. A login shell sources the system's profile and then
+// ~/.mesh_profile, while an interactive shell sources ~/.meshrc, after the
+// profiles if it's also a login shell.
+func startupFiles(login, interactive bool) []string {
+	var files []string
+	home, err := os.UserHomeDir()
+	if login {
+		files = append(files, systemProfile)
+		if err == nil {
+			files = append(files,
+				filepath.Join(home, ".mesh_profile"))
+		}
+	}
+	if interactive && err == nil {
+		files = append(files, filepath.Join(home, ".meshrc"))
+	}
+	return files
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Sam Uong
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meshshell/mesh/mesh"
+)
+
+// fakeHome sets $HOME to a temporary directory holding a profile and an rc
+// file, and points systemProfile at another profile, each of which echoes its
+// name.
+func fakeHome(t *testing.T) {
+	home, err := ioutil.TempDir("", "mesh")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(home) })
+	oldHome, oldProfile := os.Getenv("HOME"), systemProfile
+	t.Cleanup(func() {
+		os.Setenv("HOME", oldHome)
+		systemProfile = oldProfile
+	})
+	os.Setenv("HOME", home)
+	systemProfile = filepath.Join(home, "system")
+	for name, contents := range map[string]string{
+		"system":        "echo system\n",
+		".mesh_profile": "echo profile\n",
+		".meshrc":       "echo rc\n",
+	} {
+		path := filepath.Join(home, name)
+		err := ioutil.WriteFile(path, []byte(contents), 0666)
+		require.NoError(t, err)
+	}
+}
+
+func TestStartupFiles(t *testing.T) {
+	fakeHome(t)
+	for _, test := range []struct {
+		name        string
+		login       bool
+		interactive bool
+		stdout      string
+	}{
+		{"Login", true, false, "system\nprofile\nmain\n"},
+		{"LoginInteractive", true, true, "system\nprofile\nrc\nmain\n"},
+		{"Interactive", false, true, "rc\nmain\n"},
+		{"Neither", false, false, "main\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			status, err := mesh.Run(mesh.Config{
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Script: strings.NewReader("echo main\n"),
+				Startup: startupFiles(
+					test.login, test.interactive),
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 0, status)
+			assert.Equal(t, test.stdout, stdout.String())
+			assert.Empty(t, stderr.String())
+		})
+	}
+}
+
+func TestLoginShell(t *testing.T) {
+	fakeHome(t)
+	for _, test := range []struct {
+		name string
+		cmd  string
+		args []string
+	}{
+		{"Dash", "-mesh", []string{"-c", "echo main"}},
+		{"Flag", "mesh", []string{"-l", "-c", "echo main"}},
+		{"LongFlag", "mesh", []string{"--login", "-c", "echo main"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stdin := mustOpen(t, os.DevNull)
+			var stdout, stderr strings.Builder
+			std := &stdio{stdin, &stdout, &stderr}
+			status := run(test.cmd, test.args, std)
+			assert.Equal(t, 0, status)
+			assert.Equal(t,
+				"system\nprofile\nmain\n", stdout.String())
+			assert.Empty(t, stderr.String())
+		})
+	}
+}
@@ -21,18 +21,49 @@ import (
 type Token int
 
 const (
-	tokenBegin = iota
+	tokenBegin Token = iota
 
+	EOF
 	Newline
 	EscapedNewline
 	Whitespace
 
 	Identifier
+	IONumber
 	String
 	SubString
+	Glob
+	HereDocBody
+	HereDocEnd
 
+	Ampersand
+	And
+	Bang
+	CaseBreak
+	CaseContinue
+	CaseFallthrough
 	Dollar
+	HereDoc
+	HereString
+	LArith
+	LBrace
+	LBracket
+	LParen
+	Or
+	ParamOp
 	Pipe
+	RArith
+	RBrace
+	RBracket
+	RParen
+	RedirectAll
+	RedirectAllAppend
+	RedirectAppend
+	RedirectClobber
+	RedirectDupIn
+	RedirectDupOut
+	RedirectIn
+	RedirectOut
 	Semicolon
 	Tilde
 
@@ -41,6 +72,8 @@ const (
 
 func (t Token) String() string {
 	switch t {
+	case EOF:
+		return "EOF"
 	case Newline:
 		return "Newline"
 	case EscapedNewline:
@@ -49,14 +82,74 @@ func (t Token) String() string {
 		return "Whitespace"
 	case Identifier:
 		return "Identifier"
+	case IONumber:
+		return "IONumber"
 	case String:
 		return "String"
 	case SubString:
 		return "SubString"
+	case Glob:
+		return "Glob"
+	case HereDocBody:
+		return "HereDocBody"
+	case HereDocEnd:
+		return "HereDocEnd"
+	case Ampersand:
+		return "Ampersand"
+	case And:
+		return "And"
+	case Bang:
+		return "Bang"
+	case CaseBreak:
+		return "CaseBreak"
+	case CaseContinue:
+		return "CaseContinue"
+	case CaseFallthrough:
+		return "CaseFallthrough"
 	case Dollar:
 		return "Dollar"
+	case HereDoc:
+		return "HereDoc"
+	case HereString:
+		return "HereString"
+	case LArith:
+		return "LArith"
+	case LBrace:
+		return "LBrace"
+	case LBracket:
+		return "LBracket"
+	case LParen:
+		return "LParen"
+	case Or:
+		return "Or"
+	case ParamOp:
+		return "ParamOp"
 	case Pipe:
 		return "Pipe"
+	case RArith:
+		return "RArith"
+	case RBrace:
+		return "RBrace"
+	case RBracket:
+		return "RBracket"
+	case RParen:
+		return "RParen"
+	case RedirectAll:
+		return "RedirectAll"
+	case RedirectAllAppend:
+		return "RedirectAllAppend"
+	case RedirectAppend:
+		return "RedirectAppend"
+	case RedirectClobber:
+		return "RedirectClobber"
+	case RedirectDupIn:
+		return "RedirectDupIn"
+	case RedirectDupOut:
+		return "RedirectDupOut"
+	case RedirectIn:
+		return "RedirectIn"
+	case RedirectOut:
+		return "RedirectOut"
 	case Semicolon:
 		return "Semicolon"
 	case Tilde: